@@ -0,0 +1,200 @@
+// Command li-chat-tui is a terminal client for li-chat. It logs in once,
+// keeps the tokens in the user's config directory and refreshes them as
+// needed, then shows the chat full-screen.
+//
+//	li-chat-tui [-server URL] [-user NAME] [-logout]
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/gdamore/tcell/v2"
+	"golang.org/x/term"
+
+	"li-chat/pkg/client"
+)
+
+func main() {
+	defaultServer := os.Getenv("LICHAT_SERVER")
+	if defaultServer == "" {
+		defaultServer = "http://localhost:8080"
+	}
+	server := flag.String("server", defaultServer, "li-chat base URL (env LICHAT_SERVER)")
+	user := flag.String("user", "", "log in as this user instead of the saved one")
+	logout := flag.Bool("logout", false, "forget the saved session for -server and exit")
+	sessionFile := flag.String("session-file", defaultSessionFile(), "where tokens are kept between runs")
+	flag.Parse()
+
+	if err := run(*server, *user, *sessionFile, *logout); err != nil {
+		fmt.Fprintln(os.Stderr, "li-chat-tui:", err)
+		os.Exit(1)
+	}
+}
+
+func run(server, user, sessionFile string, logout bool) error {
+	server = strings.TrimRight(server, "/")
+	sessions, err := loadSessions(sessionFile)
+	if err != nil {
+		return err
+	}
+	// A failed save only costs a login on the next run, and there is
+	// nowhere to report it once the screen is up
+	save := func(t client.Tokens) {
+		sessions[server] = t
+		saveSessions(sessionFile, sessions)
+	}
+
+	if logout {
+		delete(sessions, server)
+		return saveSessions(sessionFile, sessions)
+	}
+
+	tokens := sessions[server]
+	if user != "" && user != tokens.Username {
+		tokens = client.Tokens{Username: user}
+	}
+	c, err := client.New(server, client.WithTokens(tokens), client.WithTokenHook(save))
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Log in up front if there is no session or it can't be refreshed, so
+	// the password prompt happens before the screen is taken over
+	if _, err := c.Token(ctx); err != nil {
+		if !errors.Is(err, client.ErrSessionExpired) {
+			return err
+		}
+		if err := promptLogin(ctx, c, tokens.Username); err != nil {
+			return err
+		}
+	}
+
+	screen, err := tcell.NewScreen()
+	if err != nil {
+		return err
+	}
+	if err := screen.Init(); err != nil {
+		return err
+	}
+	screen.EnablePaste()
+
+	sess := c.Connect(ctx)
+	defer sess.Close()
+	go forward(screen, sess)
+
+	err = newUI(screen, server, c.Username(), sess).loop()
+	screen.Fini()
+	if errors.Is(err, client.ErrSessionExpired) {
+		delete(sessions, server)
+		saveSessions(sessionFile, sessions)
+		return fmt.Errorf("%w; run li-chat-tui again", err)
+	}
+	return err
+}
+
+// forward posts the session's messages and states to the UI loop. Messages
+// that are already waiting go as one batch, so a page of history is drawn
+// once rather than a hundred times.
+func forward(screen tcell.Screen, sess *client.Session) {
+	post := func(ev any) { screen.PostEvent(tcell.NewEventInterrupt(ev)) }
+	go func() {
+		for st := range sess.States() {
+			post(st)
+		}
+	}()
+	for m := range sess.Messages() {
+		batch := []client.Message{m}
+		for len(sess.Messages()) > 0 {
+			batch = append(batch, <-sess.Messages())
+		}
+		post(batch)
+	}
+}
+
+// promptLogin asks for credentials on the terminal, offering username as
+// the default
+func promptLogin(ctx context.Context, c *client.Client, username string) error {
+	in := bufio.NewReader(os.Stdin)
+	if username == "" {
+		fmt.Fprint(os.Stderr, "Username: ")
+		line, err := in.ReadString('\n')
+		if err != nil {
+			return err
+		}
+		username = strings.TrimSpace(line)
+	}
+
+	fmt.Fprintf(os.Stderr, "Password for %s: ", username)
+	var password string
+	if fd := int(os.Stdin.Fd()); term.IsTerminal(fd) {
+		b, err := term.ReadPassword(fd)
+		fmt.Fprintln(os.Stderr)
+		if err != nil {
+			return err
+		}
+		password = string(b)
+	} else {
+		line, err := in.ReadString('\n')
+		if err != nil {
+			return err
+		}
+		password = strings.TrimRight(line, "\r\n")
+	}
+
+	if err := c.Login(ctx, username, password); err != nil {
+		return fmt.Errorf("login: %w", err)
+	}
+	return nil
+}
+
+func defaultSessionFile() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ".li-chat-tui.json"
+	}
+	return filepath.Join(dir, "li-chat", "tui-sessions.json")
+}
+
+// loadSessions reads the saved sessions keyed by server URL
+func loadSessions(path string) (map[string]client.Tokens, error) {
+	sessions := map[string]client.Tokens{}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return sessions, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &sessions); err != nil {
+		return nil, fmt.Errorf("session file %s: %w", path, err)
+	}
+	return sessions, nil
+}
+
+// saveSessions writes the file readable by the owner only; it holds tokens
+func saveSessions(path string, sessions map[string]client.Tokens) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(sessions, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"hash/fnv"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/uniseg"
+
+	"li-chat/pkg/client"
+)
+
+var (
+	styleDefault = tcell.StyleDefault
+	styleHeader  = styleDefault.Reverse(true)
+	styleDim     = styleDefault.Dim(true)
+	styleOwnName = styleDefault.Foreground(tcell.ColorGreen).Bold(true)
+	styleCode    = styleDefault.Background(tcell.Color236).Foreground(tcell.ColorSilver)
+	styleCodeTag = styleCode.Foreground(tcell.ColorGray).Italic(true)
+	styleError   = styleDefault.Foreground(tcell.ColorRed)
+	styleNotice  = styleDim.Italic(true)
+	styleBot     = styleDim.Reverse(true)
+
+	// nameColors gives every other user a stable colour
+	nameColors = []tcell.Color{
+		tcell.ColorTeal, tcell.ColorOlive, tcell.ColorPurple, tcell.ColorNavy,
+		tcell.ColorMaroon, tcell.ColorDarkCyan, tcell.ColorFuchsia, tcell.ColorBlue,
+	}
+)
+
+// codeBlock matches fenced code the same way the web UI's formatMessage does
+var codeBlock = regexp.MustCompile("(?s)```(\\w+)?\\n?(.*?)```")
+
+// span is a run of text in one style
+type span struct {
+	text  string
+	style tcell.Style
+}
+
+// line is one screen row of the message list
+type line []span
+
+// layout turns messages into screen rows no wider than width
+func layout(messages []client.Message, width int, me string) []line {
+	var lines []line
+	for _, m := range messages {
+		if m.Notice {
+			for _, row := range wrap(m.Content, max(width-2, 1)) {
+				lines = append(lines, line{{"  " + row, styleNotice}})
+			}
+			continue
+		}
+		nameStyle := styleOwnName
+		if m.Username != me {
+			h := fnv.New32a()
+			h.Write([]byte(m.Username))
+			nameStyle = styleDefault.Foreground(nameColors[h.Sum32()%uint32(len(nameColors))]).Bold(true)
+		}
+		header := line{
+			{formatTime(m.CreatedAt) + " ", styleDim},
+			{m.Username, nameStyle},
+		}
+		if m.Bot {
+			header = append(header, span{" ", styleDefault}, span{"BOT", styleBot})
+		}
+		lines = append(lines, header)
+		lines = append(lines, layoutContent(m.Content, width)...)
+	}
+	return lines
+}
+
+// layoutContent renders text paragraphs indented and wrapped, and code
+// blocks on a shaded background, unwrapped but cut to the width
+func layoutContent(content string, width int) []line {
+	const indent = "  "
+	textWidth := max(width-len(indent), 1)
+
+	var lines []line
+	addText := func(text string) {
+		text = strings.Trim(text, "\n")
+		if strings.TrimSpace(text) == "" {
+			return
+		}
+		for _, row := range wrap(text, textWidth) {
+			lines = append(lines, line{{indent + row, styleDefault}})
+		}
+	}
+
+	rest := content
+	for _, loc := range codeBlock.FindAllStringSubmatchIndex(content, -1) {
+		addText(content[len(content)-len(rest) : loc[0]])
+		rest = content[loc[1]:]
+
+		lang := ""
+		if loc[2] >= 0 {
+			lang = content[loc[2]:loc[3]]
+		}
+		code := strings.Trim(content[loc[4]:loc[5]], "\n")
+		if lang != "" {
+			lines = append(lines, line{{indent, styleDefault}, {pad(" "+lang, textWidth), styleCodeTag}})
+		}
+		for _, row := range strings.Split(code, "\n") {
+			row = strings.ReplaceAll(row, "\t", "    ")
+			lines = append(lines, line{{indent, styleDefault}, {pad(" "+row, textWidth), styleCode}})
+		}
+	}
+	addText(rest)
+	return lines
+}
+
+// wrap breaks text into rows of at most width cells, at spaces where it
+// can and mid-word where it must
+func wrap(text string, width int) []string {
+	var rows []string
+	for _, para := range strings.Split(text, "\n") {
+		var row strings.Builder
+		rowWidth := 0
+		for _, word := range strings.Fields(para) {
+			w := uniseg.StringWidth(word)
+			if rowWidth > 0 && rowWidth+1+w > width {
+				rows = append(rows, row.String())
+				row.Reset()
+				rowWidth = 0
+			}
+			if rowWidth > 0 {
+				row.WriteByte(' ')
+				rowWidth++
+			}
+			for w > width-rowWidth {
+				head, headWidth := cut(word, width-rowWidth)
+				if headWidth == 0 {
+					break
+				}
+				row.WriteString(head)
+				rows = append(rows, row.String())
+				row.Reset()
+				rowWidth = 0
+				word = word[len(head):]
+				w -= headWidth
+			}
+			row.WriteString(word)
+			rowWidth += w
+		}
+		rows = append(rows, row.String())
+	}
+	return rows
+}
+
+// cut returns the longest prefix of s at most width cells wide
+func cut(s string, width int) (string, int) {
+	g := uniseg.NewGraphemes(s)
+	end, w := 0, 0
+	for g.Next() {
+		gw := g.Width()
+		if w+gw > width {
+			break
+		}
+		_, end = g.Positions()
+		w += gw
+	}
+	return s[:end], w
+}
+
+// pad cuts or space-fills s to exactly width cells
+func pad(s string, width int) string {
+	s, w := cut(s, width)
+	return s + strings.Repeat(" ", width-w)
+}
+
+// formatTime shows today's messages as 15:04 and older ones with the date.
+// Anything unparseable is shown as sent.
+func formatTime(raw string) string {
+	t, err := time.Parse(time.RFC3339, raw)
+	if err != nil {
+		return raw
+	}
+	t = t.Local()
+	if y, m, d := t.Date(); y == time.Now().Year() && m == time.Now().Month() && d == time.Now().Day() {
+		return t.Format("15:04")
+	}
+	return t.Format("Jan 02 15:04")
+}
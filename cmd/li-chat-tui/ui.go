@@ -0,0 +1,313 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/uniseg"
+
+	"li-chat/pkg/client"
+)
+
+// maxMessages bounds the scrollback kept in memory
+const maxMessages = 2000
+
+const helpText = "Enter send · Ctrl-J newline · F2 code · PgUp/PgDn scroll · Ctrl-C quit"
+
+// ui is the full-screen view: a header, the message list, a status line and
+// the input line. All of it runs on the goroutine that calls loop.
+type ui struct {
+	screen tcell.Screen
+	server string
+	me     string
+	sess   *client.Session
+
+	messages []client.Message
+	seen     map[int64]bool
+
+	input    []rune
+	cursor   int
+	codeMode bool
+	// pasting is set inside a bracketed paste, where Enter is a newline
+	pasting bool
+	// scroll is how many rows the view is scrolled up from the newest
+	scroll int
+
+	connected bool
+	retryAt   time.Time
+	lastErr   error
+	version   string
+}
+
+func newUI(screen tcell.Screen, server, me string, s *client.Session) *ui {
+	return &ui{screen: screen, server: server, me: me, sess: s, seen: map[int64]bool{}}
+}
+
+// loop handles input and connection events until the user quits or the
+// connection fails for good, returning the reason in the latter case
+func (u *ui) loop() error {
+	// Redraw once a second so the reconnect countdown moves
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	go func() {
+		for range ticker.C {
+			u.screen.PostEvent(tcell.NewEventInterrupt(nil))
+		}
+	}()
+
+	for {
+		u.draw()
+		switch ev := u.screen.PollEvent().(type) {
+		case nil:
+			return nil
+		case *tcell.EventResize:
+			u.screen.Sync()
+		case *tcell.EventPaste:
+			u.pasting = ev.Start()
+		case *tcell.EventKey:
+			if quit := u.key(ev); quit {
+				return nil
+			}
+		case *tcell.EventInterrupt:
+			switch data := ev.Data().(type) {
+			case []client.Message:
+				u.add(data)
+			case client.State:
+				if data.State == client.Closed {
+					return data.Err
+				}
+				u.connected = data.State == client.Connected
+				u.retryAt, u.lastErr = data.RetryAt, data.Err
+				if data.Server != nil {
+					u.version = data.Server.Version
+				}
+			}
+		}
+	}
+}
+
+// add merges messages into the list in ID order, skipping ones already
+// shown; a resume may overlap with what arrived live
+func (u *ui) add(messages []client.Message) {
+	for _, m := range messages {
+		if m.ID != 0 {
+			if u.seen[m.ID] {
+				continue
+			}
+			u.seen[m.ID] = true
+		}
+		i := len(u.messages)
+		for i > 0 && m.ID != 0 && u.messages[i-1].ID > m.ID {
+			i--
+		}
+		u.messages = slices.Insert(u.messages, i, m)
+	}
+	if over := len(u.messages) - maxMessages; over > 0 {
+		for _, m := range u.messages[:over] {
+			delete(u.seen, m.ID)
+		}
+		u.messages = slices.Delete(u.messages, 0, over)
+	}
+}
+
+func (u *ui) key(ev *tcell.EventKey) (quit bool) {
+	_, height := u.screen.Size()
+	page := max((height-3)/2, 1)
+
+	switch ev.Key() {
+	case tcell.KeyCtrlC, tcell.KeyEscape:
+		return true
+	case tcell.KeyCtrlD:
+		if len(u.input) == 0 {
+			return true
+		}
+		u.deleteAt(u.cursor)
+	case tcell.KeyEnter:
+		if u.pasting {
+			u.insert('\n')
+		} else {
+			u.submit()
+		}
+	case tcell.KeyCtrlJ:
+		u.insert('\n')
+	case tcell.KeyF2:
+		u.codeMode = !u.codeMode
+	case tcell.KeyBackspace, tcell.KeyBackspace2:
+		if u.cursor > 0 {
+			u.cursor--
+			u.deleteAt(u.cursor)
+		}
+	case tcell.KeyDelete:
+		u.deleteAt(u.cursor)
+	case tcell.KeyLeft:
+		u.cursor = max(u.cursor-1, 0)
+	case tcell.KeyRight:
+		u.cursor = min(u.cursor+1, len(u.input))
+	case tcell.KeyHome, tcell.KeyCtrlA:
+		u.cursor = 0
+	case tcell.KeyEnd, tcell.KeyCtrlE:
+		u.cursor = len(u.input)
+	case tcell.KeyCtrlU:
+		u.input = u.input[u.cursor:]
+		u.cursor = 0
+	case tcell.KeyCtrlK:
+		u.input = u.input[:u.cursor]
+	case tcell.KeyCtrlW:
+		start := u.cursor
+		for start > 0 && unicode.IsSpace(u.input[start-1]) {
+			start--
+		}
+		for start > 0 && !unicode.IsSpace(u.input[start-1]) {
+			start--
+		}
+		u.input = slices.Delete(u.input, start, u.cursor)
+		u.cursor = start
+	case tcell.KeyPgUp:
+		u.scroll += page
+	case tcell.KeyPgDn:
+		u.scroll = max(u.scroll-page, 0)
+	case tcell.KeyUp:
+		u.scroll++
+	case tcell.KeyDown:
+		u.scroll = max(u.scroll-1, 0)
+	case tcell.KeyCtrlL:
+		u.screen.Sync()
+	case tcell.KeyTab:
+		u.insert(' ')
+		u.insert(' ')
+	case tcell.KeyRune:
+		u.insert(ev.Rune())
+	}
+	return false
+}
+
+func (u *ui) insert(r rune) {
+	u.input = slices.Insert(u.input, u.cursor, r)
+	u.cursor++
+}
+
+func (u *ui) deleteAt(i int) {
+	if i < len(u.input) {
+		u.input = slices.Delete(u.input, i, i+1)
+	}
+}
+
+// submit sends the input line, fenced as code in code mode like the web
+// UI's Code toggle
+func (u *ui) submit() {
+	content := strings.TrimSpace(string(u.input))
+	if content == "" {
+		return
+	}
+	if u.codeMode {
+		content = "```\n" + strings.Trim(string(u.input), "\n") + "\n```"
+		u.codeMode = false
+	}
+	u.sess.Send(content)
+	u.input, u.cursor, u.scroll = nil, 0, 0
+}
+
+func (u *ui) draw() {
+	s := u.screen
+	s.Clear()
+	width, height := s.Size()
+	if width < 10 || height < 4 {
+		s.Show()
+		return
+	}
+
+	// Header
+	header := " li-chat · " + u.me + " @ " + u.server
+	if u.version != "" {
+		header += " · server " + u.version
+	}
+	u.put(0, 0, pad(header, width), styleHeader)
+
+	// Messages, bottom-aligned, scrolled up by u.scroll rows
+	view := height - 3
+	lines := layout(u.messages, width, u.me)
+	u.scroll = min(u.scroll, max(len(lines)-view, 0))
+	end := len(lines) - u.scroll
+	start := max(end-view, 0)
+	y := 1 + view - (end - start)
+	for _, l := range lines[start:end] {
+		x := 0
+		for _, sp := range l {
+			x += u.put(x, y, sp.text, sp.style)
+		}
+		y++
+	}
+
+	// Status line
+	status, style := u.status()
+	hint := helpText
+	if u.scroll > 0 {
+		hint = fmt.Sprintf("↑ %d more rows below · PgDn to return", u.scroll)
+	}
+	right := uniseg.StringWidth(hint)
+	u.put(0, height-2, pad(status, width), style)
+	if left := uniseg.StringWidth(status); left+right+2 <= width {
+		u.put(width-right, height-2, hint, styleDim)
+	}
+
+	// Input line; newlines show as ↵ and the text scrolls to keep the
+	// cursor in view
+	prompt := "> "
+	if u.codeMode {
+		prompt = "code> "
+	}
+	shown := strings.ReplaceAll(string(u.input), "\n", "↵")
+	before := uniseg.StringWidth(strings.ReplaceAll(string(u.input[:u.cursor]), "\n", "↵"))
+	avail := width - len(prompt) - 1
+	offset := 0
+	if before > avail {
+		offset = before - avail
+	}
+	u.put(0, height-1, prompt, styleDim)
+	u.put(len(prompt), height-1, skipCells(shown, offset), styleDefault)
+	s.ShowCursor(len(prompt)+before-offset, height-1)
+	s.Show()
+}
+
+func (u *ui) status() (string, tcell.Style) {
+	queued := ""
+	if n := u.sess.Queued(); n > 0 {
+		queued = fmt.Sprintf(" · %d queued", n)
+	}
+	switch {
+	case u.connected:
+		return " ● connected" + queued, styleOwnName.Bold(false)
+	case !u.retryAt.IsZero():
+		wait := max(time.Until(u.retryAt).Round(time.Second), 0)
+		msg := fmt.Sprintf(" ○ disconnected, retrying in %s%s", wait, queued)
+		if u.lastErr != nil {
+			msg += " (" + u.lastErr.Error() + ")"
+		}
+		return msg, styleError
+	default:
+		return " ○ connecting…" + queued, styleDim
+	}
+}
+
+// put draws text from x, clipped to the screen, and returns its width
+func (u *ui) put(x, y int, text string, style tcell.Style) int {
+	width, _ := u.screen.Size()
+	start := x
+	g := uniseg.NewGraphemes(text)
+	for g.Next() && x < width {
+		runes := g.Runes()
+		u.screen.SetContent(x, y, runes[0], runes[1:], style)
+		x += max(g.Width(), 1)
+	}
+	return x - start
+}
+
+// skipCells drops the first n cells of s
+func skipCells(s string, n int) string {
+	head, _ := cut(s, n)
+	return s[len(head):]
+}
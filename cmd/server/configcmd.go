@@ -0,0 +1,28 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+const configUsage = "usage: li-chat config print [flags]"
+
+// runConfig implements `li-chat config print`, showing the effective
+// configuration after every source is applied, with secrets redacted
+func runConfig(args []string) int {
+	if len(args) == 0 || args[0] != "print" {
+		fmt.Fprintln(os.Stderr, configUsage)
+		return 2
+	}
+
+	cfg, rest := loadConfig(args[1:])
+	if len(rest) > 0 {
+		fmt.Fprintln(os.Stderr, configUsage)
+		return 2
+	}
+	if err := cfg.Print(os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, "print config:", err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"time"
+)
+
+const healthcheckUsage = `usage: li-chat healthcheck [flags] [ready|live]
+
+Probes the server at server.addr on this host and exits 0 when it is healthy,
+1 otherwise. "ready" (the default) calls /readyz, "live" calls /healthz.
+Meant for container HEALTHCHECK and exec probes, where curl may be missing.`
+
+const healthcheckTimeout = 5 * time.Second
+
+// runHealthcheck implements `li-chat healthcheck` and returns the process
+// exit code
+func runHealthcheck(args []string) int {
+	cfg, args := loadConfig(args)
+	path := "/readyz"
+	switch {
+	case len(args) == 0:
+	case len(args) == 1 && args[0] == "ready":
+	case len(args) == 1 && args[0] == "live":
+		path = "/healthz"
+	default:
+		fmt.Fprintln(os.Stderr, healthcheckUsage)
+		return 2
+	}
+
+	url, err := probeURL(cfg.Server.Addr, path)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "healthcheck:", err)
+		return 1
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), healthcheckTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "healthcheck:", err)
+		return 1
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "healthcheck:", err)
+		return 1
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode != http.StatusOK {
+		fmt.Fprintf(os.Stderr, "healthcheck: %s returned %s\n%s", url, resp.Status, body)
+		return 1
+	}
+	fmt.Printf("%s", body)
+	return 0
+}
+
+// probeURL turns a listen address into a URL this host can reach; wildcard
+// and empty hosts become the loopback address
+func probeURL(addr, path string) (string, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", fmt.Errorf("server.addr %q: %w", addr, err)
+	}
+	if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+		host = "127.0.0.1"
+	}
+	return "http://" + net.JoinHostPort(host, port) + path, nil
+}
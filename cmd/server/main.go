@@ -2,44 +2,112 @@ package main
 
 import (
 	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
 	"os"
 	"os/signal"
 	"strings"
 	"syscall"
+	"time"
 
+	"li-chat/internal/auth"
 	"li-chat/internal/config"
 	"li-chat/internal/db"
 	"li-chat/internal/httpserver"
+	"li-chat/internal/metrics"
+	"li-chat/internal/tracing"
+	"li-chat/internal/webhook"
 	"li-chat/internal/websocket"
 	"li-chat/pkg/logger"
+	"li-chat/pkg/version"
 
 	"go.uber.org/zap"
 )
 
+const usage = `usage: li-chat [serve] [flags]
+       li-chat migrate [flags] up|down|status
+       li-chat user [flags] create|list|disable|enable|reset-password|set-role ...
+       li-chat messages [flags] export|purge ...
+       li-chat healthcheck [flags] [ready|live]
+       li-chat config print [flags]
+       li-chat version [-json]
+
+Run "li-chat serve -h" to list every flag.`
+
 func main() {
-	cfg := config.Load()
+	args := os.Args[1:]
+	command := "serve"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		command, args = args[0], args[1:]
+	}
 
-	logger.Debug("Initializing logger")
-	logger.Init(logger.Config{
-		Level:      "debug",
-		Filename:   "logs/chat-app.log",
-		MaxSizeMB:  50,
-		MaxBackups: 10,
-		MaxAgeDays: 30,
-		Compress:   true,
-	})
+	switch command {
+	case "serve":
+		serve(args)
+	case "migrate":
+		os.Exit(runMigrate(args))
+	case "user":
+		os.Exit(runUser(args))
+	case "messages":
+		os.Exit(runMessages(args))
+	case "healthcheck":
+		os.Exit(runHealthcheck(args))
+	case "config":
+		os.Exit(runConfig(args))
+	case "version":
+		os.Exit(runVersion(args))
+	default:
+		fmt.Fprintln(os.Stderr, usage)
+		os.Exit(2)
+	}
+}
+
+// loadConfig builds the effective config or exits with the validation errors
+func loadConfig(args []string) (*config.Config, []string) {
+	cfg, rest, err := config.Load(args)
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "invalid configuration:")
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	auth.Configure(cfg.JWT.Secret, cfg.JWT.AccessTTL, cfg.JWT.RefreshTTL)
+	return cfg, rest
+}
+
+func serve(args []string) {
+	cfg, rest := loadConfig(args)
+	if len(rest) > 0 {
+		fmt.Fprintf(os.Stderr, "unexpected arguments: %v\n%s\n", rest, usage)
+		os.Exit(2)
+	}
+	rt := config.NewRuntime(cfg, args)
+
+	initLogger(cfg)
 	defer logger.Sync()
 
-	logger.Debug("Initiating database connection")
-	dbConnStr := os.Getenv("DATABASE_URL")
-	if dbConnStr == "" {
-		logger.Error("DATABASE_URL environment variable is not set")
-		panic("DATABASE_URL is required")
-	}
-	// Remove quotes if present (sometimes environment variables are quoted)
-	dbConnStr = strings.Trim(dbConnStr, `"`)
-	logger.Debug("Database URL loaded from environment")
-	repo, err := db.NewRepository(dbConnStr)
+	build := version.Get()
+	logger.Info("Starting li-chat",
+		zap.String("version", build.Version),
+		zap.String("commit", build.Commit),
+		zap.String("build_date", build.BuildDate),
+		zap.String("go_version", build.GoVersion))
+
+	shutdownTracing, err := tracing.Setup(context.Background(), cfg.Tracing)
+	if err != nil {
+		logger.Error("Failed to initialize tracing", zap.Error(err))
+		panic(err)
+	}
+
+	if cfg.JWT.Secret == auth.SecretKey {
+		logger.Warn("Using the built-in development JWT secret because jwt.allow_dev_secret is set; never do this in production")
+	}
+
+	repo, err := openStore(cfg)
 	if err != nil {
 		logger.Error("Failed to initialize database repository", zap.Error(err))
 		logger.Warn("Application cannot start without database connection")
@@ -47,39 +115,168 @@ func main() {
 	}
 	logger.Info("Logger initialized successfully")
 
+	if migrator, ok := repo.(db.Migrator); ok && cfg.Database.AutoMigrate {
+		logger.Debug("Applying pending schema migrations")
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		_, err := migrator.MigrateUp(ctx)
+		cancel()
+		if err != nil {
+			logger.Error("Failed to apply schema migrations", zap.Error(err))
+			panic(err)
+		}
+	}
+
+	if instrumented, ok := repo.(db.Instrumented); ok {
+		if err := metrics.Register(instrumented.Collector()); err != nil {
+			logger.Warn("Failed to register database pool metrics", zap.Error(err))
+		}
+	}
+
 	logger.Debug("Creating and starting WebSocket hub")
-	hub := websocket.NewHub(repo)
-	go hub.Run()
+	hub := websocket.NewHub(repo, websocket.Limits{
+		MaxMessageBytes: cfg.WebSocket.MaxMessageBytes,
+		SendBuffer:      cfg.WebSocket.SendBuffer,
+		WriteWait:       cfg.WebSocket.WriteWait,
+		PongWait:        cfg.WebSocket.PongWait,
+		PingPeriod:      cfg.WebSocket.PingInterval,
+		RecheckInterval: cfg.WebSocket.RecheckInterval,
+	})
+	hub.SetPolicy(hubPolicy(cfg))
+
+	dispatcher := webhook.NewDispatcher(repo, webhook.Options{
+		MaxAttempts:  cfg.Webhooks.MaxAttempts,
+		Timeout:      cfg.Webhooks.Timeout,
+		PollInterval: cfg.Webhooks.PollInterval,
+	})
+	hub.SetOutbox(dispatcher)
+
+	hubCtx, stopHub := context.WithCancel(context.Background())
+	defer stopHub()
+	go hub.Run(hubCtx)
+	go dispatcher.Run(hubCtx)
 
 	logger.Debug("Setting up HTTP routes and handlers")
-	router := httpserver.NewRouter(hub, repo)
+	health := httpserver.NewHealth(hub, repo)
+	router := httpserver.NewRouter(rt, hub, repo, health, dispatcher)
 	server := httpserver.New(cfg, router)
-	logger.Info("HTTP server initialized", zap.String("port", cfg.Port))
+	logger.Info("HTTP server initialized", zap.String("addr", cfg.Server.Addr))
 
 	logger.Debug("Starting HTTP server in background")
 	go func() {
-		logger.Info("Server starting", zap.String("port", cfg.Port))
-		if err := server.Start(); err != nil {
+		logger.Info("Server starting", zap.String("addr", cfg.Server.Addr))
+		if err := server.Start(); err != nil && !errors.Is(err, http.ErrServerClosed) {
 			logger.Error("Server encountered error", zap.Error(err))
 			logger.Warn("HTTP server stopped")
 		}
 	}()
 	logger.Debug("Application is running. Press Ctrl+C to shutdown")
 
+	rt.OnReload(func(c *config.Config) {
+		if err := logger.SetLevel(c.Log.Level); err != nil {
+			logger.Error("Failed to apply log level", zap.Error(err))
+		}
+		if err := logger.SetPackageLevels(c.Log.PackageLevels()); err != nil {
+			logger.Error("Failed to apply package log levels", zap.Error(err))
+		}
+		logger.SetContentLogging(c.Log.Content)
+		hub.SetPolicy(hubPolicy(c))
+	})
+
+	reload := make(chan os.Signal, 1)
+	signal.Notify(reload, syscall.SIGHUP)
+	go func() {
+		for range reload {
+			logger.Info("SIGHUP received, reloading configuration")
+			rt.Reload()
+		}
+	}()
+
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 	<-quit
+	signal.Stop(reload)
 
 	logger.Info("Shutdown signal received")
-	logger.Info("Initiating graceful shutdown")
-	ctx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	shutdown(cfg.Server.DrainDelay, cfg.Server.ShutdownTimeout, health, server, hub, stopHub, repo, shutdownTracing)
+}
+
+// shutdown winds the server down in dependency order, all within timeout.
+// /readyz first reports draining for drainDelay so load balancers stop
+// routing here while the listener still accepts; then stop taking
+// traffic, close WebSocket clients with a reconnect hint once their
+// in-flight messages are saved, then release the database and flush
+// spans. Whatever is still running at the deadline is cancelled.
+func shutdown(drainDelay, timeout time.Duration, health *httpserver.Health, server *httpserver.Server, hub *websocket.Hub,
+	stopHub context.CancelFunc, repo db.Store, shutdownTracing func(context.Context) error) {
+	logger.Info("Initiating graceful shutdown",
+		zap.Duration("drain_delay", drainDelay), zap.Duration("timeout", timeout))
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
 	defer cancel()
 
+	health.SetDraining()
+	if drainDelay > 0 {
+		logger.Debug("Waiting for load balancers to observe draining")
+		select {
+		case <-time.After(drainDelay):
+		case <-ctx.Done():
+		}
+	}
+
 	logger.Debug("Closing HTTP server gracefully")
 	if err := server.Shutdown(ctx); err != nil {
-		logger.Error("Error during server shutdown", zap.Error(err))
 		logger.Warn("Server shutdown with errors")
-	} else {
-		logger.Info("Server shutdown completed successfully")
 	}
+
+	logger.Debug("Closing WebSocket connections")
+	if err := hub.Shutdown(ctx); err != nil {
+		logger.Warn("WebSocket hub did not drain in time, aborting remaining connections", zap.Error(err))
+	}
+	stopHub()
+
+	logger.Debug("Closing database")
+	if err := repo.Close(); err != nil {
+		logger.Error("Failed to close database", zap.Error(err))
+	}
+
+	logger.Debug("Flushing trace spans")
+	if err := shutdownTracing(ctx); err != nil {
+		logger.Warn("Failed to flush trace spans", zap.Error(err))
+	}
+	logger.Info("Shutdown complete")
+}
+
+// hubPolicy extracts the runtime-adjustable hub settings from cfg
+func hubPolicy(cfg *config.Config) websocket.Policy {
+	return websocket.Policy{
+		RateLimit:      cfg.WebSocket.RateLimit,
+		RateBurst:      cfg.WebSocket.RateBurst,
+		AllowedOrigins: cfg.Server.AllowedOrigins,
+		BlockedWords:   cfg.Moderation.BlockedWords,
+		Admins:         cfg.Admin.Usernames,
+	}
+}
+
+func initLogger(cfg *config.Config) {
+	logger.Debug("Initializing logger")
+	logger.Init(logger.Config{
+		Level:      cfg.Log.Level,
+		Filename:   cfg.Log.File,
+		MaxSizeMB:  cfg.Log.MaxSizeMB,
+		MaxBackups: cfg.Log.MaxBackups,
+		MaxAgeDays: cfg.Log.MaxAgeDays,
+		Compress:   cfg.Log.Compress,
+		Format:     cfg.Log.Format,
+		Packages:   cfg.Log.PackageLevels(),
+		RedactKeys: cfg.Log.RedactKeys,
+		LogContent: cfg.Log.Content,
+	})
+	if cfg.Log.Content {
+		logger.Warn("Chat message content is being written to the logs; disable log.content outside debugging")
+	}
+}
+
+func openStore(cfg *config.Config) (db.Store, error) {
+	logger.Debug("Initiating database connection")
+	return db.Open(cfg.Database.URL, db.Options{QueryTimeout: cfg.Database.QueryTimeout})
 }
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"li-chat/internal/config"
+	"li-chat/internal/db"
+	"li-chat/internal/httpserver"
+	"li-chat/internal/websocket"
+)
+
+func TestShutdownDrainsBeforeClosing(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	store := db.NewMemoryStore()
+	hub := websocket.NewHub(store, websocket.Limits{})
+	hubCtx, stopHub := context.WithCancel(context.Background())
+	defer stopHub()
+	go hub.Run(hubCtx)
+	health := httpserver.NewHealth(hub, store)
+	cfg := config.Default()
+	cfg.Server.Addr = addr
+	server := httpserver.New(cfg, httpserver.NewRouter(config.NewRuntime(cfg, nil), hub, store, health, nil))
+	go server.Start()
+
+	readyz := func() (int, error) {
+		resp, err := http.Get("http://" + addr + "/readyz")
+		if err != nil {
+			return 0, err
+		}
+		resp.Body.Close()
+		return resp.StatusCode, nil
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		code, err := readyz()
+		if err == nil && code == http.StatusOK {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("server never became ready: %d %v", code, err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		shutdown(500*time.Millisecond, 5*time.Second, health, server, hub, stopHub, store, func(context.Context) error { return nil })
+		close(done)
+	}()
+
+	// Within the drain delay the listener still answers, but not ready
+	time.Sleep(100 * time.Millisecond)
+	code, err := readyz()
+	if err != nil {
+		t.Fatalf("/readyz while draining: %v", err)
+	}
+	if code != http.StatusServiceUnavailable {
+		t.Errorf("/readyz while draining = %d, want 503", code)
+	}
+	select {
+	case <-done:
+		t.Fatal("shutdown finished before the drain delay")
+	default:
+	}
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("shutdown didn't finish")
+	}
+	if _, err := readyz(); err == nil {
+		t.Error("listener still open after shutdown")
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"time"
+
+	"li-chat/internal/db"
+	"li-chat/internal/model"
+)
+
+const messagesUsage = `usage: li-chat messages [flags] export [-since WHEN] [-format jsonl|csv] [-o FILE]
+       li-chat messages [flags] purge -before WHEN | -all
+
+WHEN is an RFC 3339 time, a date (2006-01-02, UTC), or an age such as
+90m, 12h or 30d counted back from now.`
+
+// runMessages implements `li-chat messages` and returns the process exit code
+func runMessages(args []string) int {
+	cfg, args := loadConfig(args)
+	if len(args) == 0 || (args[0] != "export" && args[0] != "purge") {
+		fmt.Fprintln(os.Stderr, messagesUsage)
+		return 2
+	}
+
+	fs := flag.NewFlagSet("messages "+args[0], flag.ContinueOnError)
+	var since, before, format, output string
+	var all bool
+	if args[0] == "export" {
+		fs.StringVar(&since, "since", "", "only messages created at or after WHEN")
+		fs.StringVar(&format, "format", "jsonl", "jsonl or csv")
+		fs.StringVar(&output, "o", "-", "output file, - for stdout")
+	} else {
+		fs.StringVar(&before, "before", "", "delete messages created before WHEN")
+		fs.BoolVar(&all, "all", false, "delete every message")
+	}
+	if err := fs.Parse(args[1:]); err != nil {
+		return 2
+	}
+	if fs.NArg() != 0 || (format != "" && format != "jsonl" && format != "csv") {
+		fmt.Fprintln(os.Stderr, messagesUsage)
+		return 2
+	}
+
+	store, err := openStore(cfg)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "open database:", err)
+		return 1
+	}
+	defer store.Close()
+
+	// Exports can be long; let Ctrl+C abort them cleanly
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	if args[0] == "purge" {
+		// -all purges before a cutoff no message can reach
+		cutoff := time.Date(9999, time.December, 31, 0, 0, 0, 0, time.UTC)
+		switch {
+		case all == (before != ""):
+			fmt.Fprintln(os.Stderr, "purge needs exactly one of -before or -all")
+			return 2
+		case before != "":
+			if cutoff, err = parseWhen(before); err != nil {
+				fmt.Fprintln(os.Stderr, "invalid -before:", err)
+				return 2
+			}
+		}
+		n, err := store.PurgeMessages(ctx, cutoff)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "purge messages:", err)
+			return 1
+		}
+		fmt.Printf("purged %d message(s)\n", n)
+		return 0
+	}
+
+	var from time.Time
+	if since != "" {
+		if from, err = parseWhen(since); err != nil {
+			fmt.Fprintln(os.Stderr, "invalid -since:", err)
+			return 2
+		}
+	}
+
+	out := io.Writer(os.Stdout)
+	if output != "-" {
+		f, err := os.Create(output)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "create output:", err)
+			return 1
+		}
+		defer f.Close()
+		out = f
+	}
+	buf := bufio.NewWriter(out)
+
+	n, err := exportMessages(ctx, store, from, format, buf)
+	if err == nil {
+		err = buf.Flush()
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "export messages:", err)
+		return 1
+	}
+	fmt.Fprintf(os.Stderr, "exported %d message(s)\n", n)
+	return 0
+}
+
+func exportMessages(ctx context.Context, store db.MessageArchive, since time.Time, format string, w io.Writer) (int, error) {
+	var n int
+	if format == "csv" {
+		cw := csv.NewWriter(w)
+		cw.Write([]string{"id", "user_id", "username", "content", "created_at"})
+		err := store.ExportMessages(ctx, since, func(rec model.MessageRecord) error {
+			n++
+			return cw.Write([]string{
+				strconv.FormatInt(rec.ID, 10),
+				strconv.FormatInt(rec.UserID, 10),
+				rec.Username,
+				rec.Content,
+				rec.CreatedAt.UTC().Format(time.RFC3339),
+			})
+		})
+		cw.Flush()
+		if err == nil {
+			err = cw.Error()
+		}
+		return n, err
+	}
+
+	enc := json.NewEncoder(w)
+	err := store.ExportMessages(ctx, since, func(rec model.MessageRecord) error {
+		n++
+		return enc.Encode(rec)
+	})
+	return n, err
+}
+
+// parseWhen accepts an RFC 3339 time, a YYYY-MM-DD date or an age back
+// from now, which may use a d suffix for days
+func parseWhen(s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse(time.DateOnly, s); err == nil {
+		return t, nil
+	}
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n < 0 {
+			return time.Time{}, fmt.Errorf("%q is not a number of days", s)
+		}
+		return time.Now().AddDate(0, 0, -n), nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return time.Time{}, errors.New("want an RFC 3339 time, a date, or an age like 12h or 30d")
+	}
+	return time.Now().Add(-d), nil
+}
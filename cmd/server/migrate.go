@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"li-chat/internal/db"
+	"li-chat/pkg/logger"
+)
+
+const migrateUsage = "usage: li-chat migrate [flags] up|down|status"
+
+// runMigrate implements `li-chat migrate` and returns the process exit code
+func runMigrate(args []string) int {
+	cfg, args := loadConfig(args)
+	if len(args) != 1 || (args[0] != "up" && args[0] != "down" && args[0] != "status") {
+		fmt.Fprintln(os.Stderr, migrateUsage)
+		return 2
+	}
+
+	initLogger(cfg)
+	defer logger.Sync()
+
+	store, err := openStore(cfg)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "open database:", err)
+		return 1
+	}
+	defer store.Close()
+	migrator, ok := store.(db.Migrator)
+	if !ok {
+		fmt.Fprintln(os.Stderr, "this database backend has no schema to migrate")
+		return 1
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	switch args[0] {
+	case "up":
+		n, err := migrator.MigrateUp(ctx)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "migrate up:", err)
+			return 1
+		}
+		fmt.Printf("applied %d migration(s)\n", n)
+	case "down":
+		if err := migrator.MigrateDown(ctx); err != nil {
+			fmt.Fprintln(os.Stderr, "migrate down:", err)
+			return 1
+		}
+		fmt.Println("rolled back latest migration")
+	case "status":
+		status, err := migrator.MigrationStatus(ctx)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "migrate status:", err)
+			return 1
+		}
+		for _, s := range status {
+			state := "pending"
+			if s.Applied {
+				state = "applied " + s.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Printf("%04d  %-32s %s\n", s.Version, s.Name, state)
+		}
+	}
+	return 0
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"golang.org/x/term"
+
+	"li-chat/internal/auth"
+	"li-chat/internal/db"
+	"li-chat/internal/model"
+)
+
+const userUsage = `usage: li-chat user [flags] create [-role user|admin] NAME
+       li-chat user [flags] list [-json]
+       li-chat user [flags] disable|enable NAME
+       li-chat user [flags] reset-password NAME
+       li-chat user [flags] set-role NAME user|admin
+
+Passwords are prompted for on a terminal, otherwise read from the first
+line of stdin. Disabled accounts cannot log in or use tokens already
+issued, and a running server closes their connections within
+websocket.recheck_interval.`
+
+// runUser implements `li-chat user` and returns the process exit code
+func runUser(args []string) int {
+	cfg, args := loadConfig(args)
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, userUsage)
+		return 2
+	}
+
+	// The logger is left uninitialised so that nothing but the command's
+	// own output reaches stdout
+	store, err := openStore(cfg)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "open database:", err)
+		return 1
+	}
+	defer store.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	command, args := args[0], args[1:]
+	switch command {
+	case "create":
+		return userCreate(ctx, store, args)
+	case "list":
+		return userList(ctx, store, args)
+	case "disable", "enable":
+		if len(args) != 1 {
+			break
+		}
+		if err := store.SetUserDisabled(ctx, args[0], command == "disable"); err != nil {
+			return userError(command, args[0], err)
+		}
+		fmt.Printf("%sd %s\n", command, args[0])
+		return 0
+	case "reset-password":
+		if len(args) != 1 {
+			break
+		}
+		hash, err := readPassword()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "read password:", err)
+			return 1
+		}
+		if err := store.SetPassword(ctx, args[0], hash); err != nil {
+			return userError(command, args[0], err)
+		}
+		fmt.Println("password reset for", args[0])
+		return 0
+	case "set-role":
+		if len(args) != 2 || !validRole(args[1]) {
+			break
+		}
+		if err := store.SetRole(ctx, args[0], args[1]); err != nil {
+			return userError(command, args[0], err)
+		}
+		fmt.Printf("%s is now %s\n", args[0], args[1])
+		return 0
+	}
+	fmt.Fprintln(os.Stderr, userUsage)
+	return 2
+}
+
+func userCreate(ctx context.Context, store db.Store, args []string) int {
+	fs := flag.NewFlagSet("user create", flag.ContinueOnError)
+	role := fs.String("role", model.RoleUser, "user or admin")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 1 || fs.Arg(0) == "" || !validRole(*role) {
+		fmt.Fprintln(os.Stderr, userUsage)
+		return 2
+	}
+	username := fs.Arg(0)
+
+	hash, err := readPassword()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "read password:", err)
+		return 1
+	}
+	if err := store.CreateUser(ctx, username, hash); err != nil {
+		return userError("create", username, err)
+	}
+	if *role != model.RoleUser {
+		if err := store.SetRole(ctx, username, *role); err != nil {
+			return userError("set-role", username, err)
+		}
+	}
+	fmt.Printf("created %s (%s)\n", username, *role)
+	return 0
+}
+
+func userList(ctx context.Context, store db.Store, args []string) int {
+	fs := flag.NewFlagSet("user list", flag.ContinueOnError)
+	asJSON := fs.Bool("json", false, "print a JSON array")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 0 {
+		fmt.Fprintln(os.Stderr, userUsage)
+		return 2
+	}
+
+	accounts, err := store.ListUsers(ctx)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "list users:", err)
+		return 1
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(accounts)
+		return 0
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tUSERNAME\tROLE\tKIND\tSTATUS")
+	for _, a := range accounts {
+		kind := "person"
+		if a.Bot {
+			kind = "bot"
+		}
+		status := "active"
+		if a.Disabled {
+			status = "disabled"
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\n", a.ID, a.Username, a.Role, kind, status)
+	}
+	tw.Flush()
+	return 0
+}
+
+func validRole(role string) bool {
+	return role == model.RoleUser || role == model.RoleAdmin
+}
+
+// userError reports a failed account operation with a friendlier message
+// for the expected sentinels
+func userError(op, username string, err error) int {
+	switch {
+	case errors.Is(err, db.ErrNotFound):
+		fmt.Fprintf(os.Stderr, "%s: no user named %q\n", op, username)
+	case errors.Is(err, db.ErrUserExists):
+		fmt.Fprintf(os.Stderr, "%s: user %q already exists\n", op, username)
+	default:
+		fmt.Fprintf(os.Stderr, "%s %s: %v\n", op, username, err)
+	}
+	return 1
+}
+
+// readPassword prompts twice on a terminal, or takes the first line of a
+// piped stdin, and returns the bcrypt hash
+func readPassword() (string, error) {
+	var password string
+	if fd := int(os.Stdin.Fd()); term.IsTerminal(fd) {
+		fmt.Fprint(os.Stderr, "Password: ")
+		first, err := term.ReadPassword(fd)
+		fmt.Fprintln(os.Stderr)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprint(os.Stderr, "Confirm password: ")
+		second, err := term.ReadPassword(fd)
+		fmt.Fprintln(os.Stderr)
+		if err != nil {
+			return "", err
+		}
+		if string(first) != string(second) {
+			return "", errors.New("passwords do not match")
+		}
+		password = string(first)
+	} else {
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return "", err
+		}
+		password = strings.TrimRight(line, "\r\n")
+	}
+
+	if password == "" {
+		return "", errors.New("password must not be empty")
+	}
+	return auth.HashPassword(password)
+}
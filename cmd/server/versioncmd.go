@@ -0,0 +1,28 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"li-chat/pkg/version"
+)
+
+const versionUsage = "usage: li-chat version [-json]"
+
+// runVersion implements `li-chat version`
+func runVersion(args []string) int {
+	info := version.Get()
+	switch {
+	case len(args) == 0:
+		fmt.Println(info)
+	case len(args) == 1 && (args[0] == "-json" || args[0] == "--json"):
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(info)
+	default:
+		fmt.Fprintln(os.Stderr, versionUsage)
+		return 2
+	}
+	return 0
+}
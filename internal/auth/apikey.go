@@ -0,0 +1,46 @@
+package auth
+
+import (
+	"crypto/rand"
+	"slices"
+	"strings"
+)
+
+// APIKeyPrefix starts every API key, telling them apart from JWTs
+const APIKeyPrefix = "lck_"
+
+// apiKeyPrefixLen is how much of a key is kept to recognize it by
+const apiKeyPrefixLen = len(APIKeyPrefix) + 8
+
+// Scopes an API key can be limited to
+const (
+	// ScopeRead allows reading history and receiving messages over /ws
+	ScopeRead = "messages:read"
+	// ScopeWrite allows posting messages over /ws
+	ScopeWrite = "messages:write"
+	// ScopeAdmin allows the admin API, for a bot that also has the admin role
+	ScopeAdmin = "admin"
+	// ScopeMetrics allows scraping /metrics and nothing else
+	ScopeMetrics = "metrics"
+)
+
+// Scopes lists every scope an API key may have
+var Scopes = []string{ScopeRead, ScopeWrite, ScopeAdmin, ScopeMetrics}
+
+// GenerateAPIKey returns a new random API key and the prefix shown in
+// listings. Only HashToken(key) is stored.
+func GenerateAPIKey() (key, prefix string) {
+	key = APIKeyPrefix + rand.Text()
+	return key, key[:apiKeyPrefixLen]
+}
+
+// IsAPIKey reports whether a bearer token is an API key rather than a JWT
+func IsAPIKey(token string) bool {
+	return strings.HasPrefix(token, APIKeyPrefix)
+}
+
+// Allows reports whether the caller may act within scope. Logins with a
+// password have every scope.
+func (id *Identity) Allows(scope string) bool {
+	return id.Scopes == nil || slices.Contains(id.Scopes, scope)
+}
@@ -0,0 +1,37 @@
+package auth
+
+import (
+	"context"
+	"time"
+)
+
+// Identity is the authenticated caller attached to a request context
+type Identity struct {
+	UserID   int64
+	Username string
+	// Scopes limits a caller using an API key; nil means unrestricted
+	Scopes []string
+	// Bot is set for callers using an API key, which only bots have
+	Bot bool
+	// SessionID is set for callers signed in with a session cookie
+	SessionID string
+	// ExpiresAt is when the credential lapses: the access token's or the
+	// session's expiry. It is zero for API keys, which last until revoked.
+	ExpiresAt time.Time
+	// APIKeyID is set for callers using an API key, so connections opened
+	// with it can be closed when it is revoked
+	APIKeyID int64
+}
+
+type identityKey struct{}
+
+// WithIdentity returns a copy of ctx carrying the given identity
+func WithIdentity(ctx context.Context, id *Identity) context.Context {
+	return context.WithValue(ctx, identityKey{}, id)
+}
+
+// IdentityFromContext returns the identity stored by the auth middleware, if any
+func IdentityFromContext(ctx context.Context) (*Identity, bool) {
+	id, ok := ctx.Value(identityKey{}).(*Identity)
+	return id, ok && id != nil
+}
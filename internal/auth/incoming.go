@@ -0,0 +1,13 @@
+package auth
+
+import "crypto/rand"
+
+// IncomingTokenPrefix starts every incoming webhook token, so the logger
+// can recognize and mask them
+const IncomingTokenPrefix = "whk_"
+
+// GenerateIncomingToken returns a new random incoming webhook token, the
+// last segment of its /hooks/ URL. Only HashToken(token) is stored.
+func GenerateIncomingToken() string {
+	return IncomingTokenPrefix + rand.Text()
+}
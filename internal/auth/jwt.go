@@ -14,16 +14,41 @@ const (
 	RefreshTokenExpiresIn = 6 * time.Hour
 )
 
+// Token types, carried in the token_type claim so that one kind of token
+// can't be passed off as the other. Both are signed with the same key.
+const (
+	accessTokenType  = "access"
+	refreshTokenType = "refresh"
+)
+
+// Active signing settings, defaulting to the constants above until
+// Configure is called at startup
+var (
+	signingKey      = []byte(SecretKey)
+	accessTokenTTL  = ExpiresIn
+	refreshTokenTTL = RefreshTokenExpiresIn
+)
+
+// Configure replaces the signing key and token lifetimes. It must be called
+// before the server starts issuing or validating tokens.
+func Configure(secret string, accessTTL, refreshTTL time.Duration) {
+	signingKey = []byte(secret)
+	accessTokenTTL = accessTTL
+	refreshTokenTTL = refreshTTL
+}
+
 // Claims for access tokens
 type Claims struct {
-	UserID   int64  `json:"user_id"`
-	Username string `json:"username"`
+	UserID    int64  `json:"user_id"`
+	Username  string `json:"username"`
+	TokenType string `json:"token_type"`
 	jwt.RegisteredClaims
 }
 
 // Claims for refresh tokens
 type RefreshClaims struct {
-	UserID int64 `json:"user_id"`
+	UserID    int64  `json:"user_id"`
+	TokenType string `json:"token_type"`
 	// Refresh tokens typically contain minimal information,
 	// just enough to identify the user for re-issuing an access token.
 	// You might also add a 'jti' (JWT ID) for token revocation.
@@ -33,16 +58,17 @@ type RefreshClaims struct {
 // GenerateToken creates a JWT token for a user
 func GenerateToken(userID int64, username string) (string, error) {
 	claims := Claims{
-		UserID:   userID,
-		Username: username,
+		UserID:    userID,
+		Username:  username,
+		TokenType: accessTokenType,
 		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(ExpiresIn)),
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(accessTokenTTL)),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
 		},
 	}
 
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	tokenString, err := token.SignedString([]byte(SecretKey))
+	tokenString, err := token.SignedString(signingKey)
 	if err != nil {
 		return "", err
 	}
@@ -53,9 +79,10 @@ func GenerateToken(userID int64, username string) (string, error) {
 // GenerateRefreshToken creates a JWT refresh token for a user
 func GenerateRefreshToken(userID int64) (string, error) {
 	claims := RefreshClaims{
-		UserID: userID,
+		UserID:    userID,
+		TokenType: refreshTokenType,
 		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(RefreshTokenExpiresIn)),
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(refreshTokenTTL)),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
 			// JTI (JWT ID) can be useful for unique identification and revocation
 			// ID:        uuid.New().String(), // Requires "github.com/google/uuid"
@@ -63,7 +90,7 @@ func GenerateRefreshToken(userID int64) (string, error) {
 	}
 
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	tokenString, err := token.SignedString([]byte(SecretKey))
+	tokenString, err := token.SignedString(signingKey)
 	if err != nil {
 		return "", err
 	}
@@ -71,7 +98,8 @@ func GenerateRefreshToken(userID int64) (string, error) {
 	return tokenString, nil
 }
 
-// ValidateToken verifies and parses a JWT token
+// ValidateToken verifies and parses a JWT access token. Refresh tokens
+// are rejected.
 func ValidateToken(tokenString string) (*Claims, error) {
 	claims := &Claims{}
 
@@ -79,7 +107,7 @@ func ValidateToken(tokenString string) (*Claims, error) {
 		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
 			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
 		}
-		return []byte(SecretKey), nil
+		return signingKey, nil
 	})
 
 	if err != nil {
@@ -90,10 +118,15 @@ func ValidateToken(tokenString string) (*Claims, error) {
 		return nil, fmt.Errorf("invalid token")
 	}
 
+	if claims.TokenType != accessTokenType {
+		return nil, fmt.Errorf("not an access token")
+	}
+
 	return claims, nil
 }
 
-// ValidateRefreshToken verifies and parses a refresh token
+// ValidateRefreshToken verifies and parses a refresh token. Access tokens
+// are rejected.
 func ValidateRefreshToken(tokenString string) (*RefreshClaims, error) {
 	claims := &RefreshClaims{}
 
@@ -101,7 +134,7 @@ func ValidateRefreshToken(tokenString string) (*RefreshClaims, error) {
 		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
 			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
 		}
-		return []byte(SecretKey), nil
+		return signingKey, nil
 	})
 
 	if err != nil {
@@ -112,6 +145,10 @@ func ValidateRefreshToken(tokenString string) (*RefreshClaims, error) {
 		return nil, fmt.Errorf("invalid refresh token")
 	}
 
+	if claims.TokenType != refreshTokenType {
+		return nil, fmt.Errorf("not a refresh token")
+	}
+
 	return claims, nil
 }
 
@@ -0,0 +1,26 @@
+package auth
+
+import "crypto/subtle"
+
+// Browser sessions are a server-side session ID in an HttpOnly cookie. As
+// the browser sends it on its own, requests that change something must
+// also echo the CSRF cookie's value in CSRFHeader, which other sites can't
+// read.
+const (
+	SessionCookie = "lichat_session"
+	CSRFCookie    = "lichat_csrf"
+	CSRFHeader    = "X-CSRF-Token"
+)
+
+// CSRFToken is the CSRF cookie value for a session. Deriving it from the
+// session ID means a cookie planted by a sibling subdomain doesn't match,
+// and it gives nothing away about the ID itself.
+func CSRFToken(sessionID string) string {
+	return HashToken("csrf:" + sessionID)
+}
+
+// CheckCSRF reports whether token is the CSRF token for sessionID, in
+// constant time
+func CheckCSRF(sessionID, token string) bool {
+	return subtle.ConstantTimeCompare([]byte(token), []byte(CSRFToken(sessionID))) == 1
+}
@@ -0,0 +1,19 @@
+package auth
+
+import (
+	"crypto/rand"
+	"time"
+)
+
+// TicketPrefix starts every WebSocket ticket
+const TicketPrefix = "wst_"
+
+// TicketTTL is how long a WebSocket ticket can be redeemed. It only has to
+// outlast the round trip between asking for it and connecting.
+const TicketTTL = 30 * time.Second
+
+// GenerateTicket returns a new random WebSocket ticket. Only
+// HashToken(ticket) is stored.
+func GenerateTicket() string {
+	return TicketPrefix + rand.Text()
+}
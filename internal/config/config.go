@@ -1,32 +1,191 @@
 package config
 
 import (
+	"strings"
 	"time"
 
-	"li-chat/pkg/logger"
-
-	"go.uber.org/zap"
+	"li-chat/internal/auth"
 )
 
+// Config is the full set of settings for one li-chat process. Values are
+// layered by Load in this order, later sources winning:
+//
+//	built-in defaults < config file < environment < command-line flags
+//
+// Every leaf field is addressed by its dotted yaml path (e.g. server.addr),
+// which is also its flag name. The environment variable is the path upper
+// cased with dots replaced by underscores and an LICHAT_ prefix
+// (LICHAT_SERVER_ADDR), plus any legacy names listed in the env tag.
+//
+// Fields tagged reload:"true" are picked up by Runtime.Reload without a
+// restart; changes to any other field are ignored until the next start.
 type Config struct {
-	Port            string
-	ReadTimeout     time.Duration
-	WriteTimeout    time.Duration
-	ShutdownTimeout time.Duration
-}
-
-func Load() *Config {
-	logger.Debug("Loading application configuration")
-	cfg := &Config{
-		Port:            ":8080",
-		ReadTimeout:     10 * time.Second,
-		WriteTimeout:    10 * time.Second,
-		ShutdownTimeout: 5 * time.Second,
+	Server     ServerConfig     `yaml:"server"`
+	Database   DatabaseConfig   `yaml:"database"`
+	JWT        JWTConfig        `yaml:"jwt"`
+	Sessions   SessionConfig    `yaml:"sessions"`
+	Log        LogConfig        `yaml:"log"`
+	WebSocket  WebSocketConfig  `yaml:"websocket"`
+	Moderation ModerationConfig `yaml:"moderation"`
+	Admin      AdminConfig      `yaml:"admin"`
+	Features   FeatureConfig    `yaml:"features"`
+	Metrics    MetricsConfig    `yaml:"metrics"`
+	Tracing    TracingConfig    `yaml:"tracing"`
+	Webhooks   WebhookConfig    `yaml:"webhooks"`
+}
+
+type ServerConfig struct {
+	Addr            string        `yaml:"addr" usage:"HTTP listen address"`
+	ReadTimeout     time.Duration `yaml:"read_timeout" usage:"maximum duration for reading a request"`
+	WriteTimeout    time.Duration `yaml:"write_timeout" usage:"maximum duration for writing a response"`
+	DrainDelay      time.Duration `yaml:"drain_delay" usage:"how long /readyz reports draining before the listener closes on shutdown"`
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout" usage:"deadline for the whole shutdown, drain delay included"`
+	AllowedOrigins  []string      `yaml:"allowed_origins" reload:"true" usage:"comma-separated origins allowed for CORS and WebSocket upgrades; empty allows any"`
+}
+
+type DatabaseConfig struct {
+	URL          string        `yaml:"url" env:"DATABASE_URL" secret:"url" usage:"postgres://, sqlite:///path or memory://"`
+	QueryTimeout time.Duration `yaml:"query_timeout" usage:"upper bound for a single database statement"`
+	AutoMigrate  bool          `yaml:"auto_migrate" usage:"apply pending schema migrations at startup"`
+}
+
+type JWTConfig struct {
+	Secret     string        `yaml:"secret" secret:"true" usage:"HMAC key for signing tokens, at least 32 bytes"`
+	AccessTTL  time.Duration `yaml:"access_ttl" usage:"lifetime of access tokens"`
+	RefreshTTL time.Duration `yaml:"refresh_ttl" usage:"lifetime of refresh tokens"`
+	// AllowDevSecret lets the default secret, which is public in the
+	// source, through Validate for local development
+	AllowDevSecret bool `yaml:"allow_dev_secret" usage:"accept the built-in development secret when jwt.secret is unset; never in production"`
+}
+
+// SessionConfig covers the cookie sessions the web UI signs in with; API
+// clients use the JWTs from /login instead
+type SessionConfig struct {
+	TTL           time.Duration `yaml:"ttl" usage:"lifetime of a browser session"`
+	SecureCookies bool          `yaml:"secure_cookies" reload:"true" usage:"always mark session cookies Secure; otherwise only requests over HTTPS, directly or per X-Forwarded-Proto, get Secure cookies"`
+}
+
+type LogConfig struct {
+	Level      string `yaml:"level" reload:"true" usage:"debug, info, warn or error"`
+	File       string `yaml:"file" usage:"rotated log file path"`
+	MaxSizeMB  int    `yaml:"max_size_mb" usage:"rotate the log file after this many megabytes"`
+	MaxBackups int    `yaml:"max_backups" usage:"rotated files to keep"`
+	MaxAgeDays int    `yaml:"max_age_days" usage:"days to keep rotated files"`
+	Compress   bool   `yaml:"compress" usage:"gzip rotated files"`
+	// Format only affects stdout; the log file is always JSON
+	Format   string   `yaml:"format" usage:"stdout encoding: json, or console for human-readable development output"`
+	Packages []string `yaml:"packages" reload:"true" usage:"comma-separated per-package levels, e.g. websocket=debug,db=info"`
+	// RedactKeys extends the built-in list of masked field keys
+	RedactKeys []string `yaml:"redact_keys" usage:"comma-separated extra log field keys whose values are masked"`
+	Content    bool     `yaml:"content" reload:"true" usage:"log chat message text verbatim instead of a hash and length (debugging only)"`
+}
+
+// PackageLevels turns the package=level entries into a map. Validate has
+// already rejected malformed entries.
+func (c LogConfig) PackageLevels() map[string]string {
+	levels := make(map[string]string, len(c.Packages))
+	for _, entry := range c.Packages {
+		pkg, lvl, _ := strings.Cut(entry, "=")
+		levels[strings.TrimSpace(pkg)] = strings.TrimSpace(lvl)
+	}
+	return levels
+}
+
+type WebSocketConfig struct {
+	MaxMessageBytes int64         `yaml:"max_message_bytes" usage:"largest inbound frame a client may send"`
+	SendBuffer      int           `yaml:"send_buffer" usage:"outbound frames queued per client before it counts as slow"`
+	WriteWait       time.Duration `yaml:"write_wait" usage:"deadline for writing one frame"`
+	PongWait        time.Duration `yaml:"pong_wait" usage:"how long a silent client is kept before disconnecting"`
+	PingInterval    time.Duration `yaml:"ping_interval" usage:"keepalive ping period, must be below pong_wait"`
+	RecheckInterval time.Duration `yaml:"recheck_interval" usage:"how often open connections are checked for disabled accounts and revoked API keys"`
+	RateLimit       float64       `yaml:"rate_limit" reload:"true" usage:"messages per second each client may send, 0 disables the limit"`
+	RateBurst       int           `yaml:"rate_burst" reload:"true" usage:"messages a client may send in a burst above rate_limit"`
+	// TicketBindIP compares the peer address, so it only helps when clients
+	// reach the server directly or through a proxy that keeps one address
+	TicketBindIP bool `yaml:"ticket_bind_ip" reload:"true" usage:"only redeem a WebSocket ticket from the address that requested it"`
+}
+
+type ModerationConfig struct {
+	BlockedWords []string `yaml:"blocked_words" reload:"true" usage:"comma-separated words masked out of chat messages"`
+}
+
+type AdminConfig struct {
+	Usernames []string `yaml:"usernames" reload:"true" usage:"comma-separated accounts allowed to call /api/admin endpoints and admin chat commands such as /mute, in addition to those with the admin role"`
+}
+
+type MetricsConfig struct {
+	Enabled bool `yaml:"enabled" reload:"true" usage:"serve Prometheus metrics on /metrics to API keys with the metrics scope and to admins"`
+}
+
+type TracingConfig struct {
+	Exporter    string  `yaml:"exporter" usage:"none, stdout, or otlp (OTLP over HTTP)"`
+	Endpoint    string  `yaml:"endpoint" usage:"OTLP collector host:port; empty uses OTEL_EXPORTER_OTLP_ENDPOINT or localhost:4318"`
+	Insecure    bool    `yaml:"insecure" usage:"send OTLP over plain HTTP instead of HTTPS"`
+	SampleRatio float64 `yaml:"sample_ratio" usage:"fraction of new traces recorded, 0 to 1"`
+}
+
+type WebhookConfig struct {
+	MaxAttempts  int           `yaml:"max_attempts" usage:"delivery attempts before a webhook event is moved to the dead letters"`
+	Timeout      time.Duration `yaml:"timeout" usage:"how long a receiver has to answer one delivery"`
+	PollInterval time.Duration `yaml:"poll_interval" usage:"how often the delivery outbox is checked for retries; new events go out at once"`
+}
+
+type FeatureConfig struct {
+	Registration bool `yaml:"registration" reload:"true" usage:"allow new accounts via /register"`
+}
+
+// Default returns the built-in configuration
+func Default() *Config {
+	return &Config{
+		Server: ServerConfig{
+			Addr:            ":8080",
+			ReadTimeout:     10 * time.Second,
+			WriteTimeout:    10 * time.Second,
+			DrainDelay:      5 * time.Second,
+			ShutdownTimeout: 10 * time.Second,
+		},
+		Database: DatabaseConfig{
+			QueryTimeout: 5 * time.Second,
+			AutoMigrate:  true,
+		},
+		JWT: JWTConfig{
+			Secret:     auth.SecretKey,
+			AccessTTL:  auth.ExpiresIn,
+			RefreshTTL: auth.RefreshTokenExpiresIn,
+		},
+		Sessions: SessionConfig{
+			TTL: 24 * time.Hour,
+		},
+		Log: LogConfig{
+			Level:      "info",
+			File:       "logs/chat-app.log",
+			MaxSizeMB:  50,
+			MaxBackups: 10,
+			MaxAgeDays: 30,
+			Compress:   true,
+			Format:     "json",
+		},
+		WebSocket: WebSocketConfig{
+			MaxMessageBytes: 64 << 10,
+			SendBuffer:      256,
+			WriteWait:       10 * time.Second,
+			PongWait:        60 * time.Second,
+			PingInterval:    54 * time.Second,
+			RecheckInterval: time.Minute,
+			RateLimit:       5,
+			RateBurst:       10,
+		},
+		Features: FeatureConfig{
+			Registration: true,
+		},
+		Tracing: TracingConfig{
+			Exporter:    "none",
+			SampleRatio: 1,
+		},
+		Webhooks: WebhookConfig{
+			MaxAttempts:  8,
+			Timeout:      10 * time.Second,
+			PollInterval: 5 * time.Second,
+		},
 	}
-	logger.Debug("Configuration loaded",
-		zap.String("port", cfg.Port),
-		zap.Duration("read_timeout", cfg.ReadTimeout),
-		zap.Duration("write_timeout", cfg.WriteTimeout),
-		zap.Duration("shutdown_timeout", cfg.ShutdownTimeout))
-	return cfg
 }
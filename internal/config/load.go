@@ -0,0 +1,214 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+	"gopkg.in/yaml.v3"
+
+	"li-chat/pkg/logger"
+)
+
+const envPrefix = "LICHAT_"
+
+// ConfigFileEnv names the config file when -config is not given
+const ConfigFileEnv = envPrefix + "CONFIG"
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// setting is one leaf of Config as seen by the loaders
+type setting struct {
+	path   string // dotted yaml path, also the flag name
+	envs   []string
+	secret string // "", "true" or "url"
+	reload bool
+	usage  string
+	value  reflect.Value
+}
+
+// settings walks cfg and returns its leaves in declaration order
+func settings(cfg *Config) []setting {
+	var out []setting
+	var walk func(v reflect.Value, prefix string)
+	walk = func(v reflect.Value, prefix string) {
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			name, _, _ := strings.Cut(f.Tag.Get("yaml"), ",")
+			path := name
+			if prefix != "" {
+				path = prefix + "." + name
+			}
+			if f.Type.Kind() == reflect.Struct && f.Type != durationType {
+				walk(v.Field(i), path)
+				continue
+			}
+
+			envs := []string{envPrefix + strings.ToUpper(strings.ReplaceAll(path, ".", "_"))}
+			if legacy := f.Tag.Get("env"); legacy != "" {
+				envs = append(envs, legacy)
+			}
+			out = append(out, setting{
+				path:   path,
+				envs:   envs,
+				secret: f.Tag.Get("secret"),
+				reload: f.Tag.Get("reload") == "true",
+				usage:  f.Tag.Get("usage"),
+				value:  v.Field(i),
+			})
+		}
+	}
+	walk(reflect.ValueOf(cfg).Elem(), "")
+	return out
+}
+
+// set parses raw into the setting's type
+func (s setting) set(raw string) error {
+	v := s.value
+	switch {
+	case v.Type() == durationType:
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return fmt.Errorf("%s: %q is not a duration (e.g. 10s, 5m)", s.path, raw)
+		}
+		v.SetInt(int64(d))
+	case v.Kind() == reflect.String:
+		v.SetString(raw)
+	case v.Kind() == reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return fmt.Errorf("%s: %q is not a boolean", s.path, raw)
+		}
+		v.SetBool(b)
+	case v.Kind() == reflect.Int || v.Kind() == reflect.Int64:
+		n, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return fmt.Errorf("%s: %q is not an integer", s.path, raw)
+		}
+		v.SetInt(n)
+	case v.Kind() == reflect.Float64:
+		f, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return fmt.Errorf("%s: %q is not a number", s.path, raw)
+		}
+		v.SetFloat(f)
+	case v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.String:
+		var items []string
+		for _, item := range strings.Split(raw, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		v.Set(reflect.ValueOf(items))
+	default:
+		return fmt.Errorf("%s: unsupported setting type %s", s.path, v.Type())
+	}
+	return nil
+}
+
+// Load builds the effective configuration from defaults, the config file,
+// the environment and args (command-line flags), then validates it.
+// Positional arguments left after the flags are returned for subcommands.
+func Load(args []string) (*Config, []string, error) {
+	cfg := Default()
+	all := settings(cfg)
+
+	// Flags are parsed first but applied last, so they win over the file
+	// and environment without the file needing to know about them
+	fs := flag.NewFlagSet("li-chat", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	configPath := fs.String("config", os.Getenv(ConfigFileEnv), "YAML config file (env "+ConfigFileEnv+")")
+	var flagValues []func() error
+	for _, s := range all {
+		s := s
+		record := func(raw string) error {
+			flagValues = append(flagValues, func() error { return s.set(raw) })
+			return nil
+		}
+		if s.value.Kind() == reflect.Bool {
+			fs.BoolFunc(s.path, s.usage, record)
+		} else {
+			fs.Func(s.path, s.usage, record)
+		}
+	}
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			fs.SetOutput(os.Stderr)
+			fs.PrintDefaults()
+		}
+		return nil, nil, err
+	}
+
+	if *configPath != "" {
+		if err := loadFile(cfg, *configPath); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	var errs []error
+	for _, s := range all {
+		for _, env := range s.envs {
+			raw, ok := os.LookupEnv(env)
+			if !ok {
+				continue
+			}
+			// Values are sometimes exported with their quotes still on
+			if err := s.set(strings.Trim(raw, `"`)); err != nil {
+				errs = append(errs, fmt.Errorf("env %s: %w", env, err))
+			}
+			break
+		}
+	}
+	for _, apply := range flagValues {
+		if err := apply(); err != nil {
+			errs = append(errs, fmt.Errorf("flag -%w", err))
+		}
+	}
+	if len(errs) > 0 {
+		return nil, nil, errors.Join(errs...)
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, nil, err
+	}
+
+	logger.Debug("Configuration loaded",
+		zap.String("config_file", *configPath),
+		zap.String("addr", cfg.Server.Addr),
+		zap.Duration("drain_delay", cfg.Server.DrainDelay),
+		zap.Duration("shutdown_timeout", cfg.Server.ShutdownTimeout),
+		zap.Duration("db_query_timeout", cfg.Database.QueryTimeout))
+	return cfg, fs.Args(), nil
+}
+
+// loadFile overlays a YAML file onto cfg. Unknown keys are rejected so a
+// typo doesn't silently fall back to the default.
+func loadFile(cfg *Config, path string) error {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+	default:
+		return fmt.Errorf("config file %s: only .yaml and .yml files are supported", path)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("config file: %w", err)
+	}
+
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("config file %s: %w", path, err)
+	}
+	return nil
+}
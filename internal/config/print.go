@@ -0,0 +1,52 @@
+package config
+
+import (
+	"io"
+	"net/url"
+
+	"gopkg.in/yaml.v3"
+)
+
+const redacted = "[REDACTED]"
+
+// Redacted returns a copy with secrets masked, safe to print or log
+func (c *Config) Redacted() *Config {
+	cp := *c
+	for _, s := range settings(&cp) {
+		switch s.secret {
+		case "true":
+			if s.value.String() != "" {
+				s.value.SetString(redacted)
+			}
+		case "url":
+			s.value.SetString(redactURL(s.value.String()))
+		}
+	}
+	return &cp
+}
+
+// redactURL masks the password in a connection URL but keeps the rest,
+// which is usually what an operator needs to check
+func redactURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		if raw == "" {
+			return ""
+		}
+		return redacted
+	}
+	if _, ok := u.User.Password(); ok {
+		u.User = url.UserPassword(u.User.Username(), "xxxxx")
+	}
+	return u.String()
+}
+
+// Print writes the effective configuration as YAML with secrets redacted
+func (c *Config) Print(w io.Writer) error {
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(c.Redacted()); err != nil {
+		return err
+	}
+	return enc.Close()
+}
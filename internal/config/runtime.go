@@ -0,0 +1,97 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+	"sync/atomic"
+
+	"go.uber.org/zap"
+
+	"li-chat/pkg/logger"
+)
+
+// Runtime holds the live configuration of a running server. Readers call
+// Current on each use; Reload swaps in a new snapshot as a single pointer
+// store, so a reader never observes half of a reload.
+type Runtime struct {
+	current atomic.Pointer[Config]
+	args    []string
+
+	mu        sync.Mutex // serialises Reload and guards listeners
+	listeners []func(*Config)
+}
+
+// ReloadResult lists the settings that changed and those that were
+// edited but need a restart to take effect
+type ReloadResult struct {
+	Applied []string `json:"applied"`
+	Ignored []string `json:"ignored"`
+}
+
+// NewRuntime wraps the startup config. args are the original command-line
+// flags, re-applied on every reload so they keep their precedence.
+func NewRuntime(cfg *Config, args []string) *Runtime {
+	rt := &Runtime{args: args}
+	rt.current.Store(cfg)
+	return rt
+}
+
+// Current returns the active snapshot. Callers must not modify it.
+func (rt *Runtime) Current() *Config {
+	return rt.current.Load()
+}
+
+// OnReload registers fn to run after each successful reload, in
+// registration order, with the newly active config
+func (rt *Runtime) OnReload(fn func(*Config)) {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	rt.listeners = append(rt.listeners, fn)
+}
+
+// Reload re-reads the config file and environment, validates the result and
+// applies the reloadable fields. On any error the running config is kept.
+func (rt *Runtime) Reload() (ReloadResult, error) {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+
+	result := ReloadResult{Applied: []string{}, Ignored: []string{}}
+	loaded, _, err := Load(rt.args)
+	if err != nil {
+		logger.Error("Configuration reload rejected", zap.Error(err))
+		return result, fmt.Errorf("reload rejected: %w", err)
+	}
+
+	old := rt.Current()
+	next := *old
+	oldSettings := settings(old)
+	loadedSettings := settings(loaded)
+	nextSettings := settings(&next)
+	for i, s := range oldSettings {
+		if reflect.DeepEqual(s.value.Interface(), loadedSettings[i].value.Interface()) {
+			continue
+		}
+		if !s.reload {
+			result.Ignored = append(result.Ignored, s.path)
+			continue
+		}
+		nextSettings[i].value.Set(loadedSettings[i].value)
+		result.Applied = append(result.Applied, s.path)
+	}
+
+	if len(result.Ignored) > 0 {
+		logger.Warn("Configuration changes need a restart to take effect", zap.Strings("settings", result.Ignored))
+	}
+	if len(result.Applied) == 0 {
+		logger.Info("Configuration reloaded, nothing to apply")
+		return result, nil
+	}
+
+	rt.current.Store(&next)
+	for _, fn := range rt.listeners {
+		fn(&next)
+	}
+	logger.Info("Configuration reloaded", zap.Strings("applied", result.Applied))
+	return result, nil
+}
@@ -0,0 +1,107 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"go.uber.org/zap/zapcore"
+
+	"li-chat/internal/auth"
+)
+
+// Validate reports every invalid value at once so a bad deployment can be
+// fixed in one pass
+func (c *Config) Validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	if _, _, err := net.SplitHostPort(c.Server.Addr); err != nil {
+		errs = append(errs, fmt.Errorf("server.addr: %q is not host:port (%v)", c.Server.Addr, err))
+	}
+	check(c.Server.ReadTimeout > 0, "server.read_timeout must be positive")
+	check(c.Server.WriteTimeout > 0, "server.write_timeout must be positive")
+	check(c.Server.DrainDelay >= 0, "server.drain_delay must not be negative")
+	check(c.Server.ShutdownTimeout > 0, "server.shutdown_timeout must be positive")
+	check(c.Server.DrainDelay < c.Server.ShutdownTimeout, "server.drain_delay must be shorter than server.shutdown_timeout, which includes it")
+	for _, origin := range c.Server.AllowedOrigins {
+		if origin == "*" {
+			continue
+		}
+		u, err := url.Parse(origin)
+		if err != nil || u.Scheme == "" || u.Host == "" || (u.Path != "" && u.Path != "/") {
+			errs = append(errs, fmt.Errorf("server.allowed_origins: %q is not an origin like https://chat.example.com", origin))
+		}
+	}
+
+	if c.Database.URL == "" {
+		errs = append(errs, errors.New("database.url is required (or set DATABASE_URL)"))
+	} else {
+		// Only the scheme is quoted back, the URL may hold a password
+		scheme, _, ok := strings.Cut(c.Database.URL, "://")
+		switch strings.ToLower(scheme) {
+		case "postgres", "postgresql", "sqlite", "sqlite3", "memory":
+		default:
+			if !ok {
+				errs = append(errs, errors.New("database.url has no scheme such as postgres:// or sqlite://"))
+				break
+			}
+			errs = append(errs, fmt.Errorf("database.url: unsupported scheme %q", scheme))
+		}
+	}
+	check(c.Database.QueryTimeout > 0, "database.query_timeout must be positive")
+
+	check(len(c.JWT.Secret) >= 32, "jwt.secret must be at least 32 bytes")
+	check(c.JWT.Secret != auth.SecretKey || c.JWT.AllowDevSecret,
+		"jwt.secret is unset, and the built-in development secret is public; set jwt.secret (or LICHAT_JWT_SECRET), or jwt.allow_dev_secret for local use")
+	check(c.JWT.AccessTTL > 0, "jwt.access_ttl must be positive")
+	check(c.JWT.RefreshTTL > c.JWT.AccessTTL, "jwt.refresh_ttl must be longer than jwt.access_ttl")
+	check(c.Sessions.TTL >= time.Minute, "sessions.ttl must be at least 1m")
+
+	if _, err := zapcore.ParseLevel(c.Log.Level); err != nil {
+		errs = append(errs, fmt.Errorf("log.level: %q is not a level (debug, info, warn, error)", c.Log.Level))
+	}
+	check(c.Log.Format == "json" || c.Log.Format == "console", "log.format must be json or console")
+	for _, entry := range c.Log.Packages {
+		pkg, lvl, ok := strings.Cut(entry, "=")
+		if _, err := zapcore.ParseLevel(strings.TrimSpace(lvl)); !ok || strings.TrimSpace(pkg) == "" || err != nil {
+			errs = append(errs, fmt.Errorf("log.packages: %q is not package=level", entry))
+		}
+	}
+	check(c.Log.File != "", "log.file is required")
+	check(c.Log.MaxSizeMB > 0, "log.max_size_mb must be positive")
+	check(c.Log.MaxBackups >= 0, "log.max_backups cannot be negative")
+	check(c.Log.MaxAgeDays >= 0, "log.max_age_days cannot be negative")
+
+	check(c.WebSocket.MaxMessageBytes > 0, "websocket.max_message_bytes must be positive")
+	check(c.WebSocket.SendBuffer > 0, "websocket.send_buffer must be positive")
+	check(c.WebSocket.WriteWait > 0, "websocket.write_wait must be positive")
+	check(c.WebSocket.PingInterval > 0 && c.WebSocket.PingInterval < c.WebSocket.PongWait,
+		"websocket.ping_interval must be positive and below websocket.pong_wait")
+	check(c.WebSocket.RecheckInterval > 0, "websocket.recheck_interval must be positive")
+	check(c.WebSocket.RateLimit >= 0, "websocket.rate_limit cannot be negative")
+	check(c.WebSocket.RateLimit == 0 || c.WebSocket.RateBurst >= 1, "websocket.rate_burst must be at least 1 when rate_limit is set")
+	for _, w := range c.Moderation.BlockedWords {
+		check(strings.TrimSpace(w) != "", "moderation.blocked_words cannot contain empty entries")
+	}
+
+	switch c.Tracing.Exporter {
+	case "none", "stdout", "otlp":
+	default:
+		errs = append(errs, fmt.Errorf("tracing.exporter: %q is not none, stdout or otlp", c.Tracing.Exporter))
+	}
+	check(c.Tracing.SampleRatio >= 0 && c.Tracing.SampleRatio <= 1, "tracing.sample_ratio must be between 0 and 1")
+
+	check(c.Webhooks.MaxAttempts >= 1, "webhooks.max_attempts must be at least 1")
+	check(c.Webhooks.Timeout > 0, "webhooks.timeout must be positive")
+	check(c.Webhooks.PollInterval > 0, "webhooks.poll_interval must be positive")
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,137 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"li-chat/internal/model"
+)
+
+func (r *Repository) ListUsers(ctx context.Context) ([]model.Account, error) {
+	ctx, done := startQuery(ctx, "list_users")
+	defer done()
+	ctx, cancel := r.opts.queryContext(ctx)
+	defer cancel()
+
+	rows, err := r.pool.Query(ctx, "SELECT id, username, role, disabled, bot FROM users ORDER BY id")
+	if err != nil {
+		return nil, mapPgError(err)
+	}
+	defer rows.Close()
+
+	accounts := []model.Account{}
+	for rows.Next() {
+		var a model.Account
+		if err := rows.Scan(&a.ID, &a.Username, &a.Role, &a.Disabled, &a.Bot); err != nil {
+			return nil, mapPgError(err)
+		}
+		accounts = append(accounts, a)
+	}
+	return accounts, mapPgError(rows.Err())
+}
+
+func (r *Repository) GetAccount(ctx context.Context, username string) (model.Account, error) {
+	ctx, done := startQuery(ctx, "get_account")
+	defer done()
+	ctx, cancel := r.opts.queryContext(ctx)
+	defer cancel()
+
+	var a model.Account
+	err := r.pool.QueryRow(ctx,
+		"SELECT id, username, role, disabled, bot FROM users WHERE username = $1",
+		username,
+	).Scan(&a.ID, &a.Username, &a.Role, &a.Disabled, &a.Bot)
+	return a, mapPgError(err)
+}
+
+func (r *Repository) SetUserDisabled(ctx context.Context, username string, disabled bool) error {
+	return r.updateUser(ctx, "set_user_disabled", "UPDATE users SET disabled = $2 WHERE username = $1", username, disabled)
+}
+
+func (r *Repository) SetPassword(ctx context.Context, username, passwordHash string) error {
+	return r.updateUser(ctx, "set_password", "UPDATE users SET password_hash = $2 WHERE username = $1", username, passwordHash)
+}
+
+func (r *Repository) SetRole(ctx context.Context, username, role string) error {
+	return r.updateUser(ctx, "set_role", "UPDATE users SET role = $2 WHERE username = $1", username, role)
+}
+
+// updateUser runs a single-row UPDATE keyed by username
+func (r *Repository) updateUser(ctx context.Context, op, query, username string, value any) error {
+	ctx, done := startQuery(ctx, op)
+	defer done()
+	ctx, cancel := r.opts.queryContext(ctx)
+	defer cancel()
+
+	tag, err := r.pool.Exec(ctx, query, username, value)
+	if err != nil {
+		return mapPgError(err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// ExportMessages streams rows from a single query, so it is bounded by the
+// caller's context rather than the per-statement QueryTimeout
+func (r *Repository) ExportMessages(ctx context.Context, since time.Time, fn func(model.MessageRecord) error) error {
+	ctx, done := startQuery(ctx, "export_messages")
+	defer done()
+
+	rows, err := r.pool.Query(ctx, `
+		SELECT m.id, m.user_id, u.username, m.content, m.created_at
+		FROM messages m
+		LEFT JOIN users u ON u.id = m.user_id
+		WHERE m.created_at >= $1
+		ORDER BY m.created_at, m.id
+	`, since.UTC())
+	if err != nil {
+		return mapPgError(err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var rec model.MessageRecord
+		var userID *int64
+		var username *string
+		if err := rows.Scan(&rec.ID, &userID, &username, &rec.Content, &rec.CreatedAt); err != nil {
+			return mapPgError(err)
+		}
+		if userID != nil {
+			rec.UserID = *userID
+		}
+		if username != nil {
+			rec.Username = *username
+		}
+		if err := fn(rec); err != nil {
+			return err
+		}
+	}
+	return mapPgError(rows.Err())
+}
+
+func (r *Repository) PurgeMessages(ctx context.Context, before time.Time) (int64, error) {
+	ctx, done := startQuery(ctx, "purge_messages")
+	defer done()
+
+	tag, err := r.pool.Exec(ctx, "DELETE FROM messages WHERE created_at < $1", before.UTC())
+	if err != nil {
+		return 0, mapPgError(err)
+	}
+	return tag.RowsAffected(), nil
+}
+
+func (r *Repository) CreateBot(ctx context.Context, username string) (int64, error) {
+	ctx, done := startQuery(ctx, "create_bot")
+	defer done()
+	ctx, cancel := r.opts.queryContext(ctx)
+	defer cancel()
+
+	var id int64
+	err := r.pool.QueryRow(ctx,
+		"INSERT INTO users(username, password_hash, bot) VALUES ($1, '', TRUE) RETURNING id",
+		username,
+	).Scan(&id)
+	return id, userConflict(mapPgError(err))
+}
@@ -0,0 +1,82 @@
+package db
+
+import (
+	"context"
+	"strings"
+
+	"li-chat/internal/model"
+)
+
+// apiKeyQuery selects the columns scanAPIKey expects
+const apiKeyQuery = `
+	SELECT k.id, k.user_id, u.username, k.name, k.prefix, k.scopes, k.created_at, k.revoked_at, u.disabled
+	FROM api_keys k
+	JOIN users u ON u.id = k.user_id`
+
+// scanAPIKey also reports whether the key's account is disabled
+func scanAPIKey(row rowScanner) (model.APIKey, bool, error) {
+	var k model.APIKey
+	var scopes string
+	var disabled bool
+	err := row.Scan(&k.ID, &k.UserID, &k.Username, &k.Name, &k.Prefix, &scopes, &k.CreatedAt, &k.RevokedAt, &disabled)
+	k.Scopes = splitList(scopes)
+	return k, disabled, err
+}
+
+func (r *Repository) CreateAPIKey(ctx context.Context, key model.APIKey, keyHash string) (int64, error) {
+	ctx, done := startQuery(ctx, "create_api_key")
+	defer done()
+	ctx, cancel := r.opts.queryContext(ctx)
+	defer cancel()
+
+	var id int64
+	err := r.pool.QueryRow(ctx,
+		"INSERT INTO api_keys(user_id, name, prefix, key_hash, scopes) VALUES ($1, $2, $3, $4, $5) RETURNING id",
+		key.UserID, key.Name, key.Prefix, keyHash, strings.Join(key.Scopes, ","),
+	).Scan(&id)
+	return id, mapPgError(err)
+}
+
+func (r *Repository) ListAPIKeys(ctx context.Context) ([]model.APIKey, error) {
+	ctx, done := startQuery(ctx, "list_api_keys")
+	defer done()
+	ctx, cancel := r.opts.queryContext(ctx)
+	defer cancel()
+
+	rows, err := r.pool.Query(ctx, apiKeyQuery+" ORDER BY k.id")
+	if err != nil {
+		return nil, mapPgError(err)
+	}
+	defer rows.Close()
+
+	keys := []model.APIKey{}
+	for rows.Next() {
+		k, _, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, mapPgError(err)
+		}
+		keys = append(keys, k)
+	}
+	return keys, mapPgError(rows.Err())
+}
+
+func (r *Repository) GetAPIKeyByHash(ctx context.Context, keyHash string) (model.APIKey, error) {
+	ctx, done := startQuery(ctx, "get_api_key")
+	defer done()
+	ctx, cancel := r.opts.queryContext(ctx)
+	defer cancel()
+
+	k, disabled, err := scanAPIKey(r.pool.QueryRow(ctx, apiKeyQuery+" WHERE k.key_hash = $1 AND k.revoked_at IS NULL", keyHash))
+	if err != nil {
+		return model.APIKey{}, mapPgError(err)
+	}
+	if disabled {
+		return model.APIKey{}, ErrDisabled
+	}
+	return k, nil
+}
+
+func (r *Repository) RevokeAPIKey(ctx context.Context, id int64) error {
+	return r.execOne(ctx, "revoke_api_key",
+		"UPDATE api_keys SET revoked_at = CURRENT_TIMESTAMP WHERE id = $1 AND revoked_at IS NULL", id)
+}
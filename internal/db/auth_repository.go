@@ -1,46 +1,57 @@
 package db
 
-import (
-	"context"
-	"time"
-)
+import "context"
 
-func (r *Repository) CreateUser(username, passwordHash string) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+func (r *Repository) CreateUser(ctx context.Context, username, passwordHash string) error {
+	ctx, done := startQuery(ctx, "create_user")
+	defer done()
+	ctx, cancel := r.opts.queryContext(ctx)
 	defer cancel()
 
 	_, err := r.pool.Exec(ctx,
 		"INSERT INTO users(username, password_hash) VALUES ($1, $2)",
 		username, passwordHash,
 	)
-	return err
+	return userConflict(mapPgError(err))
 }
 
-func (r *Repository) GetUserForLogin(username string) (int64, string, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+func (r *Repository) GetUserForLogin(ctx context.Context, username string) (int64, string, error) {
+	ctx, done := startQuery(ctx, "get_user_for_login")
+	defer done()
+	ctx, cancel := r.opts.queryContext(ctx)
 	defer cancel()
 
 	var id int64
 	var hash string
+	var disabled bool
 
 	err := r.pool.QueryRow(ctx,
-		"SELECT id, password_hash FROM users WHERE username = $1",
+		"SELECT id, password_hash, disabled FROM users WHERE username = $1",
 		username,
-	).Scan(&id, &hash)
+	).Scan(&id, &hash, &disabled)
+	if err == nil && disabled {
+		return 0, "", ErrDisabled
+	}
 
-	return id, hash, err
+	return id, hash, mapPgError(err)
 }
 
-func (r *Repository) GetUserByID(userID int64) (string, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+func (r *Repository) GetUserByID(ctx context.Context, userID int64) (string, error) {
+	ctx, done := startQuery(ctx, "get_user_by_id")
+	defer done()
+	ctx, cancel := r.opts.queryContext(ctx)
 	defer cancel()
 
 	var username string
+	var disabled bool
 
 	err := r.pool.QueryRow(ctx,
-		"SELECT username FROM users WHERE id = $1",
+		"SELECT username, disabled FROM users WHERE id = $1",
 		userID,
-	).Scan(&username)
+	).Scan(&username, &disabled)
+	if err == nil && disabled {
+		return "", ErrDisabled
+	}
 
-	return username, err
+	return username, mapPgError(err)
 }
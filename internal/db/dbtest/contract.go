@@ -0,0 +1,887 @@
+// Package dbtest holds the behavioural contract every db.Store backend must
+// satisfy. Backends call RunStoreContract from their own tests with a
+// factory that returns a fresh, empty store.
+package dbtest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"li-chat/internal/db"
+	"li-chat/internal/model"
+)
+
+// Factory returns an empty store. Cleanup should be registered on t.
+type Factory func(t *testing.T) db.Store
+
+func RunStoreContract(t *testing.T, newStore Factory) {
+	t.Run("CreateUserAndLogin", func(t *testing.T) { testCreateUserAndLogin(t, newStore(t)) })
+	t.Run("DuplicateUser", func(t *testing.T) { testDuplicateUser(t, newStore(t)) })
+	t.Run("UnknownUser", func(t *testing.T) { testUnknownUser(t, newStore(t)) })
+	t.Run("GetOrCreateUser", func(t *testing.T) { testGetOrCreateUser(t, newStore(t)) })
+	t.Run("MessageHistory", func(t *testing.T) { testMessageHistory(t, newStore(t)) })
+	t.Run("MessagesAfter", func(t *testing.T) { testMessagesAfter(t, newStore(t)) })
+	t.Run("ConcurrentWrites", func(t *testing.T) { testConcurrentWrites(t, newStore(t)) })
+	t.Run("CancelledContext", func(t *testing.T) { testCancelledContext(t, newStore(t)) })
+	t.Run("Accounts", func(t *testing.T) { testAccounts(t, newStore(t)) })
+	t.Run("DisabledUser", func(t *testing.T) { testDisabledUser(t, newStore(t)) })
+	t.Run("ExportAndPurge", func(t *testing.T) { testExportAndPurge(t, newStore(t)) })
+	t.Run("Webhooks", func(t *testing.T) { testWebhooks(t, newStore(t)) })
+	t.Run("WebhookDeliveries", func(t *testing.T) { testWebhookDeliveries(t, newStore(t)) })
+	t.Run("MessageWithDeliveries", func(t *testing.T) { testMessageWithDeliveries(t, newStore(t)) })
+	t.Run("IncomingWebhooks", func(t *testing.T) { testIncomingWebhooks(t, newStore(t)) })
+	t.Run("Bots", func(t *testing.T) { testBots(t, newStore(t)) })
+	t.Run("APIKeys", func(t *testing.T) { testAPIKeys(t, newStore(t)) })
+	t.Run("Sessions", func(t *testing.T) { testSessions(t, newStore(t)) })
+	t.Run("Tickets", func(t *testing.T) { testTickets(t, newStore(t)) })
+}
+
+func testCreateUserAndLogin(t *testing.T, s db.Store) {
+	ctx := context.Background()
+
+	if err := s.CreateUser(ctx, "alice", "hash-a"); err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+
+	id, hash, err := s.GetUserForLogin(ctx, "alice")
+	if err != nil {
+		t.Fatalf("GetUserForLogin: %v", err)
+	}
+	if hash != "hash-a" {
+		t.Errorf("password hash = %q, want %q", hash, "hash-a")
+	}
+
+	name, err := s.GetUserByID(ctx, id)
+	if err != nil {
+		t.Fatalf("GetUserByID: %v", err)
+	}
+	if name != "alice" {
+		t.Errorf("username = %q, want %q", name, "alice")
+	}
+}
+
+func testDuplicateUser(t *testing.T, s db.Store) {
+	ctx := context.Background()
+
+	if err := s.CreateUser(ctx, "bob", "hash-1"); err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	err := s.CreateUser(ctx, "bob", "hash-2")
+	if !errors.Is(err, db.ErrUserExists) {
+		t.Fatalf("second CreateUser error = %v, want ErrUserExists", err)
+	}
+	if !errors.Is(err, db.ErrConflict) {
+		t.Errorf("ErrUserExists does not match ErrConflict: %v", err)
+	}
+
+	_, hash, err := s.GetUserForLogin(ctx, "bob")
+	if err != nil {
+		t.Fatalf("GetUserForLogin: %v", err)
+	}
+	if hash != "hash-1" {
+		t.Errorf("duplicate insert overwrote hash: got %q", hash)
+	}
+}
+
+func testUnknownUser(t *testing.T, s db.Store) {
+	ctx := context.Background()
+
+	if _, _, err := s.GetUserForLogin(ctx, "nobody"); !errors.Is(err, db.ErrNotFound) {
+		t.Errorf("GetUserForLogin on unknown user error = %v, want ErrNotFound", err)
+	}
+	if _, err := s.GetUserByID(ctx, 424242); !errors.Is(err, db.ErrNotFound) {
+		t.Errorf("GetUserByID on unknown id error = %v, want ErrNotFound", err)
+	}
+}
+
+func testGetOrCreateUser(t *testing.T, s db.Store) {
+	ctx := context.Background()
+
+	first, err := s.GetOrCreateUser(ctx, "carol")
+	if err != nil {
+		t.Fatalf("GetOrCreateUser: %v", err)
+	}
+	second, err := s.GetOrCreateUser(ctx, "carol")
+	if err != nil {
+		t.Fatalf("GetOrCreateUser (existing): %v", err)
+	}
+	if first != second {
+		t.Errorf("GetOrCreateUser returned ids %d then %d", first, second)
+	}
+
+	// Several first posts by a new name at once all get its one ID
+	const racers = 8
+	ids := make([]int64, racers)
+	errs := make([]error, racers)
+	var wg sync.WaitGroup
+	for i := range racers {
+		wg.Go(func() { ids[i], errs[i] = s.GetOrCreateUser(ctx, "dave") })
+	}
+	wg.Wait()
+	for i := range racers {
+		if errs[i] != nil {
+			t.Errorf("concurrent GetOrCreateUser: %v", errs[i])
+		} else if ids[i] != ids[0] {
+			t.Errorf("concurrent GetOrCreateUser returned ids %d and %d", ids[0], ids[i])
+		}
+	}
+}
+
+func testMessageHistory(t *testing.T, s db.Store) {
+	ctx := context.Background()
+
+	msgs, err := s.GetMessages(ctx, 10)
+	if err != nil {
+		t.Fatalf("GetMessages on empty store: %v", err)
+	}
+	if len(msgs) != 0 {
+		t.Fatalf("empty store returned %d messages", len(msgs))
+	}
+
+	id, err := s.GetOrCreateUser(ctx, "dave")
+	if err != nil {
+		t.Fatalf("GetOrCreateUser: %v", err)
+	}
+	for i := 1; i <= 5; i++ {
+		if _, err := s.SaveMessage(ctx, id, fmt.Sprintf("msg-%d", i)); err != nil {
+			t.Fatalf("SaveMessage %d: %v", i, err)
+		}
+	}
+
+	msgs, err = s.GetMessages(ctx, 3)
+	if err != nil {
+		t.Fatalf("GetMessages: %v", err)
+	}
+	want := []string{"msg-3", "msg-4", "msg-5"}
+	if len(msgs) != len(want) {
+		t.Fatalf("GetMessages(3) returned %d messages, want %d", len(msgs), len(want))
+	}
+	for i, m := range msgs {
+		if m.Content != want[i] {
+			t.Errorf("message %d = %q, want %q", i, m.Content, want[i])
+		}
+		if m.Username != "dave" {
+			t.Errorf("message %d username = %q, want %q", i, m.Username, "dave")
+		}
+		if m.CreatedAt == "" {
+			t.Errorf("message %d has no created_at", i)
+		}
+		if i > 0 && m.ID <= msgs[i-1].ID {
+			t.Errorf("message %d id %d does not follow %d", i, m.ID, msgs[i-1].ID)
+		}
+	}
+}
+
+func testMessagesAfter(t *testing.T, s db.Store) {
+	ctx := context.Background()
+
+	uid, err := s.GetOrCreateUser(ctx, "judy")
+	if err != nil {
+		t.Fatalf("GetOrCreateUser: %v", err)
+	}
+	var ids []int64
+	for i := 1; i <= 5; i++ {
+		id, err := s.SaveMessage(ctx, uid, fmt.Sprintf("msg-%d", i))
+		if err != nil {
+			t.Fatalf("SaveMessage %d: %v", i, err)
+		}
+		if len(ids) > 0 && id <= ids[len(ids)-1] {
+			t.Fatalf("SaveMessage returned id %d after %d", id, ids[len(ids)-1])
+		}
+		ids = append(ids, id)
+	}
+
+	msgs, err := s.GetMessagesAfter(ctx, ids[1], 2)
+	if err != nil {
+		t.Fatalf("GetMessagesAfter: %v", err)
+	}
+	if len(msgs) != 2 || msgs[0].ID != ids[2] || msgs[1].ID != ids[3] {
+		t.Fatalf("GetMessagesAfter(%d, 2) = %+v, want ids %v", ids[1], msgs, ids[2:4])
+	}
+	if msgs[0].Content != "msg-3" || msgs[0].Username != "judy" {
+		t.Errorf("first message = %+v, want judy's msg-3", msgs[0])
+	}
+
+	msgs, err = s.GetMessagesAfter(ctx, ids[4], 10)
+	if err != nil {
+		t.Fatalf("GetMessagesAfter (caught up): %v", err)
+	}
+	if msgs == nil || len(msgs) != 0 {
+		t.Errorf("GetMessagesAfter past the newest = %+v, want an empty slice", msgs)
+	}
+}
+
+func testConcurrentWrites(t *testing.T, s db.Store) {
+	ctx := context.Background()
+
+	const writers = 8
+	const perWriter = 10
+
+	var wg sync.WaitGroup
+	errs := make(chan error, writers*perWriter)
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			id, err := s.GetOrCreateUser(ctx, fmt.Sprintf("writer-%d", w))
+			if err != nil {
+				errs <- err
+				return
+			}
+			for i := 0; i < perWriter; i++ {
+				if _, err := s.SaveMessage(ctx, id, fmt.Sprintf("w%d-%d", w, i)); err != nil {
+					errs <- err
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Errorf("concurrent write: %v", err)
+	}
+
+	msgs, err := s.GetMessages(ctx, writers * perWriter * 2)
+	if err != nil {
+		t.Fatalf("GetMessages: %v", err)
+	}
+	if len(msgs) != writers*perWriter {
+		t.Errorf("stored %d messages, want %d", len(msgs), writers*perWriter)
+	}
+}
+
+func testCancelledContext(t *testing.T, s db.Store) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if err := s.CreateUser(ctx, "erin", "hash"); !errors.Is(err, context.Canceled) {
+		t.Errorf("CreateUser with cancelled context error = %v, want context.Canceled", err)
+	}
+	if _, err := s.GetMessages(ctx, 10); !errors.Is(err, context.Canceled) {
+		t.Errorf("GetMessages with cancelled context error = %v, want context.Canceled", err)
+	}
+}
+
+func testAccounts(t *testing.T, s db.Store) {
+	ctx := context.Background()
+
+	for _, name := range []string{"frank", "grace"} {
+		if err := s.CreateUser(ctx, name, "hash"); err != nil {
+			t.Fatalf("CreateUser %s: %v", name, err)
+		}
+	}
+
+	accounts, err := s.ListUsers(ctx)
+	if err != nil {
+		t.Fatalf("ListUsers: %v", err)
+	}
+	if len(accounts) != 2 || accounts[0].Username != "frank" || accounts[1].Username != "grace" {
+		t.Fatalf("ListUsers = %+v, want frank then grace", accounts)
+	}
+	if accounts[0].Role != model.RoleUser || accounts[0].Disabled {
+		t.Errorf("new account = %+v, want role %q and enabled", accounts[0], model.RoleUser)
+	}
+
+	if err := s.SetRole(ctx, "grace", model.RoleAdmin); err != nil {
+		t.Fatalf("SetRole: %v", err)
+	}
+	if err := s.SetPassword(ctx, "grace", "hash-2"); err != nil {
+		t.Fatalf("SetPassword: %v", err)
+	}
+	a, err := s.GetAccount(ctx, "grace")
+	if err != nil {
+		t.Fatalf("GetAccount: %v", err)
+	}
+	if a.Role != model.RoleAdmin {
+		t.Errorf("role = %q, want %q", a.Role, model.RoleAdmin)
+	}
+	if _, hash, _ := s.GetUserForLogin(ctx, "grace"); hash != "hash-2" {
+		t.Errorf("password hash = %q after SetPassword, want %q", hash, "hash-2")
+	}
+
+	if _, err := s.GetAccount(ctx, "nobody"); !errors.Is(err, db.ErrNotFound) {
+		t.Errorf("GetAccount on unknown user error = %v, want ErrNotFound", err)
+	}
+	if err := s.SetRole(ctx, "nobody", model.RoleAdmin); !errors.Is(err, db.ErrNotFound) {
+		t.Errorf("SetRole on unknown user error = %v, want ErrNotFound", err)
+	}
+}
+
+func testDisabledUser(t *testing.T, s db.Store) {
+	ctx := context.Background()
+
+	if err := s.CreateUser(ctx, "heidi", "hash"); err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	id, _, err := s.GetUserForLogin(ctx, "heidi")
+	if err != nil {
+		t.Fatalf("GetUserForLogin: %v", err)
+	}
+
+	if err := s.SetUserDisabled(ctx, "heidi", true); err != nil {
+		t.Fatalf("SetUserDisabled: %v", err)
+	}
+	if _, _, err := s.GetUserForLogin(ctx, "heidi"); !errors.Is(err, db.ErrDisabled) {
+		t.Errorf("GetUserForLogin on disabled user error = %v, want ErrDisabled", err)
+	}
+	if _, err := s.GetUserByID(ctx, id); !errors.Is(err, db.ErrDisabled) {
+		t.Errorf("GetUserByID on disabled user error = %v, want ErrDisabled", err)
+	}
+
+	if err := s.SetUserDisabled(ctx, "heidi", false); err != nil {
+		t.Fatalf("SetUserDisabled(false): %v", err)
+	}
+	if _, _, err := s.GetUserForLogin(ctx, "heidi"); err != nil {
+		t.Errorf("GetUserForLogin after re-enabling: %v", err)
+	}
+}
+
+func testExportAndPurge(t *testing.T, s db.Store) {
+	ctx := context.Background()
+
+	id, err := s.GetOrCreateUser(ctx, "ivan")
+	if err != nil {
+		t.Fatalf("GetOrCreateUser: %v", err)
+	}
+	for i := 1; i <= 3; i++ {
+		if _, err := s.SaveMessage(ctx, id, fmt.Sprintf("msg-%d", i)); err != nil {
+			t.Fatalf("SaveMessage %d: %v", i, err)
+		}
+	}
+
+	var got []model.MessageRecord
+	err = s.ExportMessages(ctx, time.Time{}, func(rec model.MessageRecord) error {
+		got = append(got, rec)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("ExportMessages: %v", err)
+	}
+	if len(got) != 3 {
+		t.Fatalf("ExportMessages returned %d records, want 3", len(got))
+	}
+	for i, rec := range got {
+		if want := fmt.Sprintf("msg-%d", i+1); rec.Content != want {
+			t.Errorf("record %d = %q, want %q", i, rec.Content, want)
+		}
+		if rec.Username != "ivan" || rec.UserID != id || rec.ID == 0 || rec.CreatedAt.IsZero() {
+			t.Errorf("record %d = %+v, want ivan's message with an id and timestamp", i, rec)
+		}
+	}
+
+	stop := errors.New("stop")
+	calls := 0
+	err = s.ExportMessages(ctx, time.Time{}, func(model.MessageRecord) error {
+		calls++
+		return stop
+	})
+	if !errors.Is(err, stop) || calls != 1 {
+		t.Errorf("ExportMessages with failing callback = %v after %d calls, want stop after 1", err, calls)
+	}
+
+	future := time.Now().Add(time.Hour)
+	got = nil
+	_ = s.ExportMessages(ctx, future, func(rec model.MessageRecord) error {
+		got = append(got, rec)
+		return nil
+	})
+	if len(got) != 0 {
+		t.Errorf("ExportMessages since the future returned %d records", len(got))
+	}
+
+	if n, err := s.PurgeMessages(ctx, time.Now().Add(-time.Hour)); err != nil || n != 0 {
+		t.Errorf("PurgeMessages before an hour ago = %d, %v; want 0", n, err)
+	}
+	n, err := s.PurgeMessages(ctx, future)
+	if err != nil {
+		t.Fatalf("PurgeMessages: %v", err)
+	}
+	if n != 3 {
+		t.Errorf("PurgeMessages removed %d messages, want 3", n)
+	}
+	msgs, err := s.GetMessages(ctx, 10)
+	if err != nil {
+		t.Fatalf("GetMessages: %v", err)
+	}
+	if len(msgs) != 0 {
+		t.Errorf("%d messages left after purge", len(msgs))
+	}
+}
+
+func testWebhooks(t *testing.T, s db.Store) {
+	ctx := context.Background()
+
+	id, err := s.CreateWebhook(ctx, model.Webhook{URL: "http://example.test/hook", Secret: "s3cret", Events: []string{"message.created", "mention"}})
+	if err != nil {
+		t.Fatalf("CreateWebhook: %v", err)
+	}
+	hook, err := s.GetWebhook(ctx, id)
+	if err != nil {
+		t.Fatalf("GetWebhook: %v", err)
+	}
+	if hook.URL != "http://example.test/hook" || hook.Secret != "s3cret" || !hook.Active || hook.CreatedAt.IsZero() {
+		t.Errorf("GetWebhook = %+v, want the active hook just created", hook)
+	}
+	if len(hook.Events) != 2 || hook.Events[0] != "message.created" || hook.Events[1] != "mention" {
+		t.Errorf("events = %q, want [message.created mention]", hook.Events)
+	}
+
+	if err := s.SetWebhookActive(ctx, id, false); err != nil {
+		t.Fatalf("SetWebhookActive: %v", err)
+	}
+	hooks, err := s.ListWebhooks(ctx)
+	if err != nil {
+		t.Fatalf("ListWebhooks: %v", err)
+	}
+	if len(hooks) != 1 || hooks[0].ID != id || hooks[0].Active {
+		t.Errorf("ListWebhooks = %+v, want the one hook, inactive", hooks)
+	}
+
+	if err := s.DeleteWebhook(ctx, id); err != nil {
+		t.Fatalf("DeleteWebhook: %v", err)
+	}
+	if _, err := s.GetWebhook(ctx, id); !errors.Is(err, db.ErrNotFound) {
+		t.Errorf("GetWebhook after delete = %v, want ErrNotFound", err)
+	}
+	if err := s.DeleteWebhook(ctx, id); !errors.Is(err, db.ErrNotFound) {
+		t.Errorf("second DeleteWebhook = %v, want ErrNotFound", err)
+	}
+	if err := s.SetWebhookActive(ctx, id, true); !errors.Is(err, db.ErrNotFound) {
+		t.Errorf("SetWebhookActive on a deleted hook = %v, want ErrNotFound", err)
+	}
+}
+
+func testWebhookDeliveries(t *testing.T, s db.Store) {
+	ctx := context.Background()
+	now := time.Now().Truncate(time.Second)
+
+	id, err := s.CreateWebhook(ctx, model.Webhook{URL: "http://example.test/hook", Secret: "s", Events: []string{"mention"}})
+	if err != nil {
+		t.Fatalf("CreateWebhook: %v", err)
+	}
+	err = s.EnqueueDeliveries(ctx, []model.WebhookDelivery{
+		{WebhookID: id, Event: "mention", Payload: []byte(`{"n":1}`), NextAttemptAt: now},
+		{WebhookID: id, Event: "mention", Payload: []byte(`{"n":2}`), NextAttemptAt: now},
+		{WebhookID: id, Event: "mention", Payload: []byte(`{"n":3}`), NextAttemptAt: now.Add(time.Hour)},
+	})
+	if err != nil {
+		t.Fatalf("EnqueueDeliveries: %v", err)
+	}
+
+	lease := now.Add(time.Minute)
+	claimed, err := s.ClaimDeliveries(ctx, now, lease, 10)
+	if err != nil {
+		t.Fatalf("ClaimDeliveries: %v", err)
+	}
+	if len(claimed) != 2 || string(claimed[0].Payload) != `{"n":1}` || string(claimed[1].Payload) != `{"n":2}` {
+		t.Fatalf("ClaimDeliveries = %+v, want the two due deliveries in order", claimed)
+	}
+	if again, _ := s.ClaimDeliveries(ctx, now, lease, 10); len(again) != 0 {
+		t.Errorf("second claim within the lease returned %d deliveries", len(again))
+	}
+
+	if err := s.CompleteDelivery(ctx, claimed[0].ID); err != nil {
+		t.Fatalf("CompleteDelivery: %v", err)
+	}
+	if err := s.FailDelivery(ctx, claimed[1].ID, "503 Service Unavailable", now.Add(2*time.Minute)); err != nil {
+		t.Fatalf("FailDelivery: %v", err)
+	}
+	retried, _ := s.ClaimDeliveries(ctx, now.Add(3*time.Minute), now.Add(4*time.Minute), 10)
+	if len(retried) != 1 || retried[0].ID != claimed[1].ID || retried[0].Attempts != 1 || retried[0].LastError != "503 Service Unavailable" {
+		t.Fatalf("claim after the retry time = %+v, want the failed delivery with 1 attempt", retried)
+	}
+
+	if err := s.FailDelivery(ctx, retried[0].ID, "gave up", time.Time{}); err != nil {
+		t.Fatalf("FailDelivery without a next attempt: %v", err)
+	}
+	dead, err := s.DeadDeliveries(ctx, 10)
+	if err != nil {
+		t.Fatalf("DeadDeliveries: %v", err)
+	}
+	if len(dead) != 1 || dead[0].ID != retried[0].ID || dead[0].Attempts != 2 || dead[0].LastError != "gave up" {
+		t.Fatalf("DeadDeliveries = %+v, want the delivery that gave up", dead)
+	}
+	later, _ := s.ClaimDeliveries(ctx, now.Add(time.Hour), now.Add(2*time.Hour), 10)
+	if len(later) != 1 || later[0].ID == dead[0].ID {
+		t.Fatalf("claim an hour later = %+v, want only the scheduled delivery", later)
+	}
+	if err := s.CompleteDelivery(ctx, later[0].ID); err != nil {
+		t.Fatalf("CompleteDelivery: %v", err)
+	}
+
+	if err := s.RetryDelivery(ctx, claimed[0].ID, now); !errors.Is(err, db.ErrNotFound) {
+		t.Errorf("RetryDelivery of a completed delivery = %v, want ErrNotFound", err)
+	}
+	if err := s.RetryDelivery(ctx, dead[0].ID, now.Add(2*time.Hour)); err != nil {
+		t.Fatalf("RetryDelivery: %v", err)
+	}
+	if dead, _ := s.DeadDeliveries(ctx, 10); len(dead) != 0 {
+		t.Errorf("%d dead deliveries after retry", len(dead))
+	}
+	revived, _ := s.ClaimDeliveries(ctx, now.Add(2*time.Hour), now.Add(3*time.Hour), 10)
+	if len(revived) != 1 || revived[0].Attempts != 0 {
+		t.Errorf("claim after retry = %+v, want the revived delivery with its attempts reset", revived)
+	}
+
+	if err := s.SetWebhookActive(ctx, id, false); err != nil {
+		t.Fatalf("SetWebhookActive: %v", err)
+	}
+	_ = s.EnqueueDeliveries(ctx, []model.WebhookDelivery{{WebhookID: id, Event: "mention", Payload: []byte(`{}`), NextAttemptAt: now}})
+	if paused, _ := s.ClaimDeliveries(ctx, now.Add(5*time.Hour), now.Add(6*time.Hour), 10); len(paused) != 0 {
+		t.Errorf("claimed %d deliveries for an inactive hook", len(paused))
+	}
+
+	if err := s.DeleteWebhook(ctx, id); err != nil {
+		t.Fatalf("DeleteWebhook: %v", err)
+	}
+	err = s.EnqueueDeliveries(ctx, []model.WebhookDelivery{{WebhookID: id, Event: "mention", Payload: []byte(`{}`), NextAttemptAt: now}})
+	if !errors.Is(err, db.ErrConflict) {
+		t.Errorf("EnqueueDeliveries for a deleted hook = %v, want ErrConflict", err)
+	}
+}
+
+func testMessageWithDeliveries(t *testing.T, s db.Store) {
+	ctx := context.Background()
+	now := time.Now().Truncate(time.Second)
+
+	userID, err := s.GetOrCreateUser(ctx, "alice")
+	if err != nil {
+		t.Fatalf("GetOrCreateUser: %v", err)
+	}
+	hook, err := s.CreateWebhook(ctx, model.Webhook{URL: "http://example.test/hook", Secret: "s", Events: []string{"message.created"}})
+	if err != nil {
+		t.Fatalf("CreateWebhook: %v", err)
+	}
+	gone, err := s.CreateWebhook(ctx, model.Webhook{URL: "http://example.test/gone", Secret: "s", Events: []string{"message.created"}})
+	if err != nil {
+		t.Fatalf("CreateWebhook: %v", err)
+	}
+	if err := s.DeleteWebhook(ctx, gone); err != nil {
+		t.Fatalf("DeleteWebhook: %v", err)
+	}
+
+	var seen int64
+	id, err := s.SaveMessageWithDeliveries(ctx, userID, "hello", func(id int64) ([]model.WebhookDelivery, error) {
+		seen = id
+		payload := []byte(fmt.Sprintf(`{"id":%d}`, id))
+		return []model.WebhookDelivery{
+			{WebhookID: hook, Event: "message.created", Payload: payload, NextAttemptAt: now},
+			{WebhookID: gone, Event: "message.created", Payload: payload, NextAttemptAt: now},
+		}, nil
+	})
+	if err != nil {
+		t.Fatalf("SaveMessageWithDeliveries: %v", err)
+	}
+	if id == 0 || id != seen {
+		t.Fatalf("SaveMessageWithDeliveries = %d, outbox saw %d", id, seen)
+	}
+	claimed, err := s.ClaimDeliveries(ctx, now, now.Add(time.Minute), 10)
+	if err != nil {
+		t.Fatalf("ClaimDeliveries: %v", err)
+	}
+	if len(claimed) != 1 || claimed[0].WebhookID != hook || string(claimed[0].Payload) != fmt.Sprintf(`{"id":%d}`, id) {
+		t.Fatalf("ClaimDeliveries = %+v, want the one delivery for the live hook", claimed)
+	}
+
+	// A failing outbox saves neither the message nor its deliveries
+	errOutbox := errors.New("outbox failed")
+	_, err = s.SaveMessageWithDeliveries(ctx, userID, "lost", func(id int64) ([]model.WebhookDelivery, error) {
+		return nil, errOutbox
+	})
+	if !errors.Is(err, errOutbox) {
+		t.Fatalf("SaveMessageWithDeliveries with a failing outbox = %v, want its error", err)
+	}
+	messages, err := s.GetMessages(ctx, 10)
+	if err != nil {
+		t.Fatalf("GetMessages: %v", err)
+	}
+	if len(messages) != 1 || messages[0].Content != "hello" {
+		t.Errorf("GetMessages = %+v, want only the committed message", messages)
+	}
+
+	next, err := s.SaveMessage(ctx, userID, "after")
+	if err != nil {
+		t.Fatalf("SaveMessage: %v", err)
+	}
+	if next <= id {
+		t.Errorf("SaveMessage after a rollback = %d, want above %d", next, id)
+	}
+}
+
+func testIncomingWebhooks(t *testing.T, s db.Store) {
+	ctx := context.Background()
+
+	id, err := s.CreateIncomingWebhook(ctx, model.IncomingWebhook{Name: "ci"}, "hash-1")
+	if err != nil {
+		t.Fatalf("CreateIncomingWebhook: %v", err)
+	}
+	if _, err := s.CreateIncomingWebhook(ctx, model.IncomingWebhook{Name: "other"}, "hash-1"); !errors.Is(err, db.ErrConflict) {
+		t.Errorf("CreateIncomingWebhook with a used token = %v, want ErrConflict", err)
+	}
+
+	hook, err := s.GetIncomingWebhookByToken(ctx, "hash-1")
+	if err != nil {
+		t.Fatalf("GetIncomingWebhookByToken: %v", err)
+	}
+	if hook.ID != id || hook.Name != "ci" || hook.CreatedAt.IsZero() {
+		t.Errorf("GetIncomingWebhookByToken = %+v, want the ci webhook", hook)
+	}
+	if _, err := s.GetIncomingWebhookByToken(ctx, "hash-2"); !errors.Is(err, db.ErrNotFound) {
+		t.Errorf("GetIncomingWebhookByToken with an unknown token = %v, want ErrNotFound", err)
+	}
+
+	hooks, err := s.ListIncomingWebhooks(ctx)
+	if err != nil {
+		t.Fatalf("ListIncomingWebhooks: %v", err)
+	}
+	if len(hooks) != 1 || hooks[0].ID != id {
+		t.Errorf("ListIncomingWebhooks = %+v, want the ci webhook", hooks)
+	}
+
+	if err := s.DeleteIncomingWebhook(ctx, id); err != nil {
+		t.Fatalf("DeleteIncomingWebhook: %v", err)
+	}
+	if _, err := s.GetIncomingWebhookByToken(ctx, "hash-1"); !errors.Is(err, db.ErrNotFound) {
+		t.Errorf("token still works after delete: %v", err)
+	}
+	if err := s.DeleteIncomingWebhook(ctx, id); !errors.Is(err, db.ErrNotFound) {
+		t.Errorf("second DeleteIncomingWebhook = %v, want ErrNotFound", err)
+	}
+}
+
+func testBots(t *testing.T, s db.Store) {
+	ctx := context.Background()
+
+	id, err := s.CreateBot(ctx, "deploybot")
+	if err != nil {
+		t.Fatalf("CreateBot: %v", err)
+	}
+	if _, err := s.CreateBot(ctx, "deploybot"); !errors.Is(err, db.ErrUserExists) {
+		t.Errorf("second CreateBot = %v, want ErrUserExists", err)
+	}
+	a, err := s.GetAccount(ctx, "deploybot")
+	if err != nil {
+		t.Fatalf("GetAccount: %v", err)
+	}
+	if a.ID != id || !a.Bot {
+		t.Errorf("GetAccount = %+v, want bot %d", a, id)
+	}
+	if _, hash, _ := s.GetUserForLogin(ctx, "deploybot"); hash != "" {
+		t.Errorf("bot has password hash %q, want none", hash)
+	}
+
+	if err := s.CreateUser(ctx, "ivan", "hash"); err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	if _, err := s.SaveMessage(ctx, id, "deployed"); err != nil {
+		t.Fatalf("SaveMessage: %v", err)
+	}
+	human, _, _ := s.GetUserForLogin(ctx, "ivan")
+	if _, err := s.SaveMessage(ctx, human, "thanks"); err != nil {
+		t.Fatalf("SaveMessage: %v", err)
+	}
+	msgs, err := s.GetMessages(ctx, 10)
+	if err != nil {
+		t.Fatalf("GetMessages: %v", err)
+	}
+	if len(msgs) != 2 || !msgs[0].Bot || msgs[1].Bot {
+		t.Errorf("GetMessages = %+v, want the bot's message flagged and ivan's not", msgs)
+	}
+}
+
+func testAPIKeys(t *testing.T, s db.Store) {
+	ctx := context.Background()
+
+	bot, err := s.CreateBot(ctx, "ci")
+	if err != nil {
+		t.Fatalf("CreateBot: %v", err)
+	}
+	key := model.APIKey{UserID: bot, Name: "deploys", Prefix: "lck_ABCD", Scopes: []string{"messages:read", "messages:write"}}
+	id, err := s.CreateAPIKey(ctx, key, "key-hash-1")
+	if err != nil {
+		t.Fatalf("CreateAPIKey: %v", err)
+	}
+	if _, err := s.CreateAPIKey(ctx, key, "key-hash-1"); !errors.Is(err, db.ErrConflict) {
+		t.Errorf("CreateAPIKey with a used hash = %v, want ErrConflict", err)
+	}
+	if _, err := s.CreateAPIKey(ctx, model.APIKey{UserID: bot + 100, Name: "x"}, "key-hash-2"); !errors.Is(err, db.ErrConflict) {
+		t.Errorf("CreateAPIKey for an unknown user = %v, want ErrConflict", err)
+	}
+
+	got, err := s.GetAPIKeyByHash(ctx, "key-hash-1")
+	if err != nil {
+		t.Fatalf("GetAPIKeyByHash: %v", err)
+	}
+	if got.ID != id || got.UserID != bot || got.Username != "ci" || got.Name != "deploys" || got.Prefix != "lck_ABCD" ||
+		len(got.Scopes) != 2 || got.Scopes[1] != "messages:write" || got.CreatedAt.IsZero() || got.RevokedAt != nil {
+		t.Errorf("GetAPIKeyByHash = %+v, want the deploys key", got)
+	}
+	if _, err := s.GetAPIKeyByHash(ctx, "key-hash-3"); !errors.Is(err, db.ErrNotFound) {
+		t.Errorf("GetAPIKeyByHash with an unknown hash = %v, want ErrNotFound", err)
+	}
+
+	if err := s.SetUserDisabled(ctx, "ci", true); err != nil {
+		t.Fatalf("SetUserDisabled: %v", err)
+	}
+	if _, err := s.GetAPIKeyByHash(ctx, "key-hash-1"); !errors.Is(err, db.ErrDisabled) {
+		t.Errorf("GetAPIKeyByHash for a disabled bot = %v, want ErrDisabled", err)
+	}
+	if err := s.SetUserDisabled(ctx, "ci", false); err != nil {
+		t.Fatalf("SetUserDisabled: %v", err)
+	}
+
+	if err := s.RevokeAPIKey(ctx, id); err != nil {
+		t.Fatalf("RevokeAPIKey: %v", err)
+	}
+	if _, err := s.GetAPIKeyByHash(ctx, "key-hash-1"); !errors.Is(err, db.ErrNotFound) {
+		t.Errorf("GetAPIKeyByHash after revoke = %v, want ErrNotFound", err)
+	}
+	if err := s.RevokeAPIKey(ctx, id); !errors.Is(err, db.ErrNotFound) {
+		t.Errorf("second RevokeAPIKey = %v, want ErrNotFound", err)
+	}
+
+	keys, err := s.ListAPIKeys(ctx)
+	if err != nil {
+		t.Fatalf("ListAPIKeys: %v", err)
+	}
+	if len(keys) != 1 || keys[0].ID != id || keys[0].Username != "ci" || keys[0].RevokedAt == nil {
+		t.Errorf("ListAPIKeys = %+v, want the revoked deploys key", keys)
+	}
+}
+
+func testSessions(t *testing.T, s db.Store) {
+	ctx := context.Background()
+
+	if err := s.CreateUser(ctx, "judy", "hash"); err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	userID, _, _ := s.GetUserForLogin(ctx, "judy")
+
+	live, err := s.CreateSession(ctx, userID, time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatalf("CreateSession: %v", err)
+	}
+	other, err := s.CreateSession(ctx, userID, time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatalf("CreateSession: %v", err)
+	}
+	if live.ID == "" || live.ID == other.ID {
+		t.Errorf("session IDs %q and %q, want distinct ones", live.ID, other.ID)
+	}
+	expired, err := s.CreateSession(ctx, userID, time.Now().Add(-time.Minute))
+	if err != nil {
+		t.Fatalf("CreateSession: %v", err)
+	}
+
+	got, err := s.GetSession(ctx, live.ID)
+	if err != nil {
+		t.Fatalf("GetSession: %v", err)
+	}
+	if got.ID != live.ID || got.UserID != userID || got.Username != "judy" || got.ExpiresAt.Before(time.Now()) {
+		t.Errorf("GetSession = %+v, want judy's live session", got)
+	}
+	if _, err := s.GetSession(ctx, expired.ID); !errors.Is(err, db.ErrNotFound) {
+		t.Errorf("GetSession on an expired session = %v, want ErrNotFound", err)
+	}
+	if _, err := s.GetSession(ctx, "sess_unknown"); !errors.Is(err, db.ErrNotFound) {
+		t.Errorf("GetSession on an unknown session = %v, want ErrNotFound", err)
+	}
+
+	if err := s.SetUserDisabled(ctx, "judy", true); err != nil {
+		t.Fatalf("SetUserDisabled: %v", err)
+	}
+	if _, err := s.GetSession(ctx, live.ID); !errors.Is(err, db.ErrDisabled) {
+		t.Errorf("GetSession for a disabled user = %v, want ErrDisabled", err)
+	}
+	if err := s.SetUserDisabled(ctx, "judy", false); err != nil {
+		t.Fatalf("SetUserDisabled: %v", err)
+	}
+
+	if n, err := s.DeleteExpiredSessions(ctx, time.Now()); err != nil || n != 1 {
+		t.Errorf("DeleteExpiredSessions = %d, %v, want 1", n, err)
+	}
+	if err := s.DeleteSession(ctx, live.ID); err != nil {
+		t.Fatalf("DeleteSession: %v", err)
+	}
+	if _, err := s.GetSession(ctx, live.ID); !errors.Is(err, db.ErrNotFound) {
+		t.Errorf("GetSession after delete = %v, want ErrNotFound", err)
+	}
+	if err := s.DeleteSession(ctx, live.ID); !errors.Is(err, db.ErrNotFound) {
+		t.Errorf("second DeleteSession = %v, want ErrNotFound", err)
+	}
+	if _, err := s.GetSession(ctx, other.ID); err != nil {
+		t.Errorf("GetSession on the other session: %v", err)
+	}
+}
+
+func testTickets(t *testing.T, s db.Store) {
+	ctx := context.Background()
+
+	if err := s.CreateUser(ctx, "kim", "hash"); err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	userID, _, _ := s.GetUserForLogin(ctx, "kim")
+
+	tokenExpiry := time.Now().Add(time.Hour).Truncate(time.Second)
+	login := model.WSTicket{UserID: userID, Username: "kim", ExpiresAt: time.Now().Add(time.Minute), TokenExpiresAt: tokenExpiry}
+	if err := s.CreateTicket(ctx, login, "hash-login"); err != nil {
+		t.Fatalf("CreateTicket: %v", err)
+	}
+	bot := model.WSTicket{UserID: userID, Username: "kim", Scopes: []string{"messages:read"}, Bot: true, ClientIP: "192.0.2.1", ExpiresAt: time.Now().Add(time.Minute), APIKeyID: 42}
+	if err := s.CreateTicket(ctx, bot, "hash-bot"); err != nil {
+		t.Fatalf("CreateTicket: %v", err)
+	}
+	if err := s.CreateTicket(ctx, model.WSTicket{UserID: userID, Username: "kim", ExpiresAt: time.Now().Add(-time.Minute)}, "hash-expired"); err != nil {
+		t.Fatalf("CreateTicket: %v", err)
+	}
+
+	got, err := s.RedeemTicket(ctx, "hash-login", time.Now())
+	if err != nil {
+		t.Fatalf("RedeemTicket: %v", err)
+	}
+	if got.UserID != userID || got.Username != "kim" || got.Scopes != nil || got.Bot || got.ClientIP != "" || !got.TokenExpiresAt.Equal(tokenExpiry) || got.APIKeyID != 0 {
+		t.Errorf("RedeemTicket = %+v, want kim's unrestricted ticket", got)
+	}
+	if _, err := s.RedeemTicket(ctx, "hash-login", time.Now()); !errors.Is(err, db.ErrNotFound) {
+		t.Errorf("second RedeemTicket = %v, want ErrNotFound", err)
+	}
+
+	got, err = s.RedeemTicket(ctx, "hash-bot", time.Now())
+	if err != nil {
+		t.Fatalf("RedeemTicket: %v", err)
+	}
+	if !slices.Equal(got.Scopes, bot.Scopes) || !got.Bot || got.ClientIP != "192.0.2.1" || !got.TokenExpiresAt.IsZero() || got.APIKeyID != 42 {
+		t.Errorf("RedeemTicket = %+v, want %+v", got, bot)
+	}
+
+	if _, err := s.RedeemTicket(ctx, "hash-expired", time.Now()); !errors.Is(err, db.ErrNotFound) {
+		t.Errorf("RedeemTicket on an expired ticket = %v, want ErrNotFound", err)
+	}
+	if _, err := s.RedeemTicket(ctx, "hash-unknown", time.Now()); !errors.Is(err, db.ErrNotFound) {
+		t.Errorf("RedeemTicket on an unknown ticket = %v, want ErrNotFound", err)
+	}
+
+	// Only one of several concurrent redemptions wins
+	if err := s.CreateTicket(ctx, login, "hash-race"); err != nil {
+		t.Fatalf("CreateTicket: %v", err)
+	}
+	var wg sync.WaitGroup
+	var won atomic.Int32
+	for range 8 {
+		wg.Go(func() {
+			if _, err := s.RedeemTicket(ctx, "hash-race", time.Now()); err == nil {
+				won.Add(1)
+			}
+		})
+	}
+	wg.Wait()
+	if n := won.Load(); n != 1 {
+		t.Errorf("%d concurrent redemptions succeeded, want 1", n)
+	}
+}
@@ -0,0 +1,77 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/mattn/go-sqlite3"
+)
+
+// Backend-neutral errors. Every Store returns these (possibly wrapping the
+// driver error) so callers can use errors.Is without knowing the driver.
+var (
+	ErrNotFound = errors.New("not found")
+	ErrConflict = errors.New("conflict")
+
+	// ErrUserExists is a conflict on the unique username
+	ErrUserExists = fmt.Errorf("%w: user already exists", ErrConflict)
+
+	// ErrDisabled is returned when looking up an account an operator has
+	// disabled
+	ErrDisabled = errors.New("account disabled")
+)
+
+// Postgres SQLSTATE codes, see https://www.postgresql.org/docs/current/errcodes-appendix.html
+const (
+	pgUniqueViolation     = "23505"
+	pgForeignKeyViolation = "23503"
+)
+
+// mapPgError converts pgx errors into the sentinels above
+func mapPgError(err error) error {
+	if err == nil {
+		return nil
+	}
+	if errors.Is(err, pgx.ErrNoRows) {
+		return fmt.Errorf("%w: %w", ErrNotFound, err)
+	}
+
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		switch pgErr.Code {
+		case pgUniqueViolation, pgForeignKeyViolation:
+			return fmt.Errorf("%w: %w", ErrConflict, err)
+		}
+	}
+	return err
+}
+
+// mapSQLiteError converts database/sql and go-sqlite3 errors into the sentinels above
+func mapSQLiteError(err error) error {
+	if err == nil {
+		return nil
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("%w: %w", ErrNotFound, err)
+	}
+
+	var liteErr sqlite3.Error
+	if errors.As(err, &liteErr) {
+		switch liteErr.ExtendedCode {
+		case sqlite3.ErrConstraintUnique, sqlite3.ErrConstraintPrimaryKey, sqlite3.ErrConstraintForeignKey:
+			return fmt.Errorf("%w: %w", ErrConflict, err)
+		}
+	}
+	return err
+}
+
+// userConflict narrows a generic conflict on user insert to ErrUserExists
+func userConflict(err error) error {
+	if errors.Is(err, ErrConflict) && !errors.Is(err, ErrUserExists) {
+		return fmt.Errorf("%w: %w", ErrUserExists, err)
+	}
+	return err
+}
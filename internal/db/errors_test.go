@@ -0,0 +1,64 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/mattn/go-sqlite3"
+)
+
+func TestMapDriverErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want error
+	}{
+		{"pg no rows", mapPgError(pgx.ErrNoRows), ErrNotFound},
+		{"pg unique violation", mapPgError(&pgconn.PgError{Code: pgUniqueViolation}), ErrConflict},
+		{"pg foreign key violation", mapPgError(fmt.Errorf("insert: %w", &pgconn.PgError{Code: pgForeignKeyViolation})), ErrConflict},
+		{"pg duplicate username", userConflict(mapPgError(&pgconn.PgError{Code: pgUniqueViolation})), ErrUserExists},
+		{"pg timeout", mapPgError(context.DeadlineExceeded), context.DeadlineExceeded},
+		{"sqlite no rows", mapSQLiteError(sql.ErrNoRows), ErrNotFound},
+		{"sqlite unique", mapSQLiteError(sqlite3.Error{Code: sqlite3.ErrConstraint, ExtendedCode: sqlite3.ErrConstraintUnique}), ErrConflict},
+		{"sqlite primary key", mapSQLiteError(sqlite3.Error{Code: sqlite3.ErrConstraint, ExtendedCode: sqlite3.ErrConstraintPrimaryKey}), ErrConflict},
+		{"sqlite foreign key", mapSQLiteError(sqlite3.Error{Code: sqlite3.ErrConstraint, ExtendedCode: sqlite3.ErrConstraintForeignKey}), ErrConflict},
+		{"sqlite duplicate username", userConflict(mapSQLiteError(sqlite3.Error{Code: sqlite3.ErrConstraint, ExtendedCode: sqlite3.ErrConstraintUnique})), ErrUserExists},
+		{"sqlite timeout", mapSQLiteError(fmt.Errorf("query: %w", context.DeadlineExceeded)), context.DeadlineExceeded},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if !errors.Is(tt.err, tt.want) {
+				t.Errorf("got %v, want it to match %v", tt.err, tt.want)
+			}
+		})
+	}
+}
+
+func TestMapDriverErrorsUnmapped(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+	}{
+		{"pg check violation", mapPgError(&pgconn.PgError{Code: "23514"})},
+		{"pg other", mapPgError(errors.New("connection reset"))},
+		{"sqlite busy", mapSQLiteError(sqlite3.Error{Code: sqlite3.ErrBusy})},
+		{"sqlite not null", mapSQLiteError(sqlite3.Error{Code: sqlite3.ErrConstraint, ExtendedCode: sqlite3.ErrConstraintNotNull})},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, sentinel := range []error{ErrNotFound, ErrConflict, ErrUserExists, ErrDisabled} {
+				if errors.Is(tt.err, sentinel) {
+					t.Errorf("%v matches %v", tt.err, sentinel)
+				}
+			}
+		})
+	}
+	if mapPgError(nil) != nil || mapSQLiteError(nil) != nil {
+		t.Error("nil error mapped to non-nil")
+	}
+}
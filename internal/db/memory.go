@@ -0,0 +1,388 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"li-chat/internal/model"
+)
+
+type memoryUser struct {
+	id           int64
+	username     string
+	passwordHash string
+	role         string
+	disabled     bool
+	bot          bool
+}
+
+type memoryMessage struct {
+	id        int64
+	userID    int64
+	content   string
+	createdAt time.Time
+}
+
+// MemoryStore is a thread-safe in-process Store, intended for tests and
+// throwaway local runs. Nothing survives a restart. Calls fail fast with the
+// context's error once it is cancelled, like the SQL backends would.
+type MemoryStore struct {
+	mu       sync.RWMutex
+	nextID   int64
+	nextMsg  int64
+	users    map[int64]*memoryUser
+	byName   map[string]*memoryUser
+	messages []memoryMessage
+
+	nextHook     int64
+	nextDelivery int64
+	webhooks     map[int64]*model.Webhook
+	deliveries   map[int64]*memoryDelivery
+	nextIncoming int64
+	incoming     map[int64]*memoryIncomingWebhook
+	nextKey      int64
+	apiKeys      map[int64]*memoryAPIKey
+	sessions     map[string]model.Session
+	tickets      map[string]model.WSTicket
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		users:      make(map[int64]*memoryUser),
+		byName:     make(map[string]*memoryUser),
+		webhooks:   make(map[int64]*model.Webhook),
+		deliveries: make(map[int64]*memoryDelivery),
+		incoming:   make(map[int64]*memoryIncomingWebhook),
+		apiKeys:    make(map[int64]*memoryAPIKey),
+		sessions:   make(map[string]model.Session),
+		tickets:    make(map[string]model.WSTicket),
+	}
+}
+
+// insertUser must be called with mu held for writing
+func (m *MemoryStore) insertUser(username, passwordHash string) (*memoryUser, error) {
+	if _, ok := m.byName[username]; ok {
+		return nil, ErrUserExists
+	}
+	m.nextID++
+	u := &memoryUser{id: m.nextID, username: username, passwordHash: passwordHash, role: model.RoleUser}
+	m.users[u.id] = u
+	m.byName[username] = u
+	return u, nil
+}
+
+// Close is a no-op; the data is discarded with the process
+func (m *MemoryStore) Close() error {
+	return nil
+}
+
+// Ping always succeeds; there is no connection to lose
+func (m *MemoryStore) Ping(ctx context.Context) error {
+	return ctx.Err()
+}
+
+func (m *MemoryStore) CreateUser(ctx context.Context, username, passwordHash string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	_, err := m.insertUser(username, passwordHash)
+	return err
+}
+
+func (m *MemoryStore) GetUserForLogin(ctx context.Context, username string) (int64, string, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, "", err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	u, ok := m.byName[username]
+	if !ok {
+		return 0, "", ErrNotFound
+	}
+	if u.disabled {
+		return 0, "", ErrDisabled
+	}
+	return u.id, u.passwordHash, nil
+}
+
+func (m *MemoryStore) GetUserByID(ctx context.Context, userID int64) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	u, ok := m.users[userID]
+	if !ok {
+		return "", ErrNotFound
+	}
+	if u.disabled {
+		return "", ErrDisabled
+	}
+	return u.username, nil
+}
+
+func (m *MemoryStore) GetOrCreateUser(ctx context.Context, username string) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if u, ok := m.byName[username]; ok {
+		return u.id, nil
+	}
+	u, err := m.insertUser(username, "")
+	if err != nil {
+		return 0, err
+	}
+	return u.id, nil
+}
+
+func (m *MemoryStore) SaveMessage(ctx context.Context, userID int64, content string) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.users[userID]; !ok {
+		return 0, fmt.Errorf("%w: user %d does not exist", ErrConflict, userID)
+	}
+	m.nextMsg++
+	m.messages = append(m.messages, memoryMessage{
+		id:        m.nextMsg,
+		userID:    userID,
+		content:   content,
+		createdAt: time.Now(),
+	})
+	return m.nextMsg, nil
+}
+
+func (m *MemoryStore) SaveMessageWithDeliveries(ctx context.Context, userID int64, content string, outbox func(id int64) ([]model.WebhookDelivery, error)) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.users[userID]; !ok {
+		return 0, fmt.Errorf("%w: user %d does not exist", ErrConflict, userID)
+	}
+	id := m.nextMsg + 1
+	deliveries, err := outbox(id)
+	if err != nil {
+		return 0, err
+	}
+
+	m.nextMsg = id
+	m.messages = append(m.messages, memoryMessage{
+		id:        id,
+		userID:    userID,
+		content:   content,
+		createdAt: time.Now(),
+	})
+	for _, d := range deliveries {
+		if _, ok := m.webhooks[d.WebhookID]; !ok {
+			continue
+		}
+		m.addDelivery(d)
+	}
+	return id, nil
+}
+
+func (m *MemoryStore) GetMessages(ctx context.Context, limit int) ([]model.Message, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	start := 0
+	if limit >= 0 && len(m.messages) > limit {
+		start = len(m.messages) - limit
+	}
+
+	messages := make([]model.Message, 0, len(m.messages)-start)
+	for _, msg := range m.messages[start:] {
+		messages = append(messages, m.message(msg))
+	}
+	return messages, nil
+}
+
+func (m *MemoryStore) GetMessagesAfter(ctx context.Context, afterID int64, limit int) ([]model.Message, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	// IDs are assigned in append order, so the slice is sorted by ID
+	start := sort.Search(len(m.messages), func(i int) bool { return m.messages[i].id > afterID })
+	messages := []model.Message{}
+	for _, msg := range m.messages[start:] {
+		if len(messages) == limit {
+			break
+		}
+		messages = append(messages, m.message(msg))
+	}
+	return messages, nil
+}
+
+// message must be called with mu held
+func (m *MemoryStore) message(msg memoryMessage) model.Message {
+	return model.Message{
+		ID:        msg.id,
+		Username:  m.users[msg.userID].username,
+		Content:   msg.content,
+		CreatedAt: msg.createdAt.Format(time.RFC3339),
+		Bot:       m.users[msg.userID].bot,
+	}
+}
+
+func (m *MemoryStore) ListUsers(ctx context.Context) ([]model.Account, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	accounts := make([]model.Account, 0, len(m.users))
+	for id := int64(1); id <= m.nextID; id++ {
+		if u, ok := m.users[id]; ok {
+			accounts = append(accounts, u.account())
+		}
+	}
+	return accounts, nil
+}
+
+func (m *MemoryStore) GetAccount(ctx context.Context, username string) (model.Account, error) {
+	if err := ctx.Err(); err != nil {
+		return model.Account{}, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	u, ok := m.byName[username]
+	if !ok {
+		return model.Account{}, ErrNotFound
+	}
+	return u.account(), nil
+}
+
+func (m *MemoryStore) SetUserDisabled(ctx context.Context, username string, disabled bool) error {
+	return m.updateUser(ctx, username, func(u *memoryUser) { u.disabled = disabled })
+}
+
+func (m *MemoryStore) SetPassword(ctx context.Context, username, passwordHash string) error {
+	return m.updateUser(ctx, username, func(u *memoryUser) { u.passwordHash = passwordHash })
+}
+
+func (m *MemoryStore) SetRole(ctx context.Context, username, role string) error {
+	return m.updateUser(ctx, username, func(u *memoryUser) { u.role = role })
+}
+
+func (m *MemoryStore) CreateBot(ctx context.Context, username string) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	u, err := m.insertUser(username, "")
+	if err != nil {
+		return 0, err
+	}
+	u.bot = true
+	return u.id, nil
+}
+
+func (m *MemoryStore) updateUser(ctx context.Context, username string, update func(*memoryUser)) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	u, ok := m.byName[username]
+	if !ok {
+		return ErrNotFound
+	}
+	update(u)
+	return nil
+}
+
+func (u *memoryUser) account() model.Account {
+	return model.Account{ID: u.id, Username: u.username, Role: u.role, Disabled: u.disabled, Bot: u.bot}
+}
+
+func (m *MemoryStore) ExportMessages(ctx context.Context, since time.Time, fn func(model.MessageRecord) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	// Copy out under the lock so fn may take as long as it likes
+	m.mu.RLock()
+	var records []model.MessageRecord
+	for _, msg := range m.messages {
+		if msg.createdAt.Before(since) {
+			continue
+		}
+		records = append(records, model.MessageRecord{
+			ID:        msg.id,
+			UserID:    msg.userID,
+			Username:  m.users[msg.userID].username,
+			Content:   msg.content,
+			CreatedAt: msg.createdAt,
+		})
+	}
+	m.mu.RUnlock()
+
+	for _, rec := range records {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := fn(rec); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *MemoryStore) PurgeMessages(ctx context.Context, before time.Time) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	kept := m.messages[:0]
+	for _, msg := range m.messages {
+		if !msg.createdAt.Before(before) {
+			kept = append(kept, msg)
+		}
+	}
+	purged := int64(len(m.messages) - len(kept))
+	m.messages = kept
+	return purged, nil
+}
@@ -0,0 +1,102 @@
+package db
+
+import (
+	"context"
+	"slices"
+	"time"
+
+	"li-chat/internal/model"
+)
+
+type memoryAPIKey struct {
+	model.APIKey
+	keyHash string
+}
+
+func (m *MemoryStore) CreateAPIKey(ctx context.Context, key model.APIKey, keyHash string) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.users[key.UserID]; !ok {
+		return 0, ErrConflict
+	}
+	for _, k := range m.apiKeys {
+		if k.keyHash == keyHash {
+			return 0, ErrConflict
+		}
+	}
+	m.nextKey++
+	key.ID = m.nextKey
+	key.Key = ""
+	key.Scopes = slices.Clone(key.Scopes)
+	key.CreatedAt = time.Now().UTC()
+	key.RevokedAt = nil
+	m.apiKeys[key.ID] = &memoryAPIKey{APIKey: key, keyHash: keyHash}
+	return key.ID, nil
+}
+
+func (m *MemoryStore) ListAPIKeys(ctx context.Context) ([]model.APIKey, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	keys := []model.APIKey{}
+	for id := int64(1); id <= m.nextKey; id++ {
+		if k, ok := m.apiKeys[id]; ok {
+			keys = append(keys, m.apiKey(k))
+		}
+	}
+	return keys, nil
+}
+
+func (m *MemoryStore) GetAPIKeyByHash(ctx context.Context, keyHash string) (model.APIKey, error) {
+	if err := ctx.Err(); err != nil {
+		return model.APIKey{}, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, k := range m.apiKeys {
+		if k.keyHash != keyHash || k.RevokedAt != nil {
+			continue
+		}
+		if m.users[k.UserID].disabled {
+			return model.APIKey{}, ErrDisabled
+		}
+		return m.apiKey(k), nil
+	}
+	return model.APIKey{}, ErrNotFound
+}
+
+func (m *MemoryStore) RevokeAPIKey(ctx context.Context, id int64) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	k, ok := m.apiKeys[id]
+	if !ok || k.RevokedAt != nil {
+		return ErrNotFound
+	}
+	now := time.Now().UTC()
+	k.RevokedAt = &now
+	return nil
+}
+
+// apiKey must be called with mu held
+func (m *MemoryStore) apiKey(k *memoryAPIKey) model.APIKey {
+	key := k.APIKey
+	key.Username = m.users[k.UserID].username
+	key.Scopes = slices.Clone(k.Scopes)
+	return key
+}
@@ -0,0 +1,77 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"li-chat/internal/model"
+)
+
+func (m *MemoryStore) CreateSession(ctx context.Context, userID int64, expiresAt time.Time) (model.Session, error) {
+	if err := ctx.Err(); err != nil {
+		return model.Session{}, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.users[userID]; !ok {
+		return model.Session{}, ErrConflict
+	}
+	s := model.Session{ID: generateSessionID(), UserID: userID, ExpiresAt: expiresAt.UTC()}
+	m.sessions[s.ID] = s
+	return s, nil
+}
+
+func (m *MemoryStore) GetSession(ctx context.Context, id string) (model.Session, error) {
+	if err := ctx.Err(); err != nil {
+		return model.Session{}, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	s, ok := m.sessions[id]
+	if !ok || !s.ExpiresAt.After(time.Now()) {
+		return model.Session{}, ErrNotFound
+	}
+	u := m.users[s.UserID]
+	if u.disabled {
+		return model.Session{}, ErrDisabled
+	}
+	s.Username = u.username
+	return s, nil
+}
+
+func (m *MemoryStore) DeleteSession(ctx context.Context, id string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.sessions[id]; !ok {
+		return ErrNotFound
+	}
+	delete(m.sessions, id)
+	return nil
+}
+
+func (m *MemoryStore) DeleteExpiredSessions(ctx context.Context, now time.Time) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var n int64
+	for id, s := range m.sessions {
+		if !s.ExpiresAt.After(now) {
+			delete(m.sessions, id)
+			n++
+		}
+	}
+	return n, nil
+}
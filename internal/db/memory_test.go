@@ -0,0 +1,16 @@
+package db_test
+
+import (
+	"testing"
+
+	"li-chat/internal/db"
+	"li-chat/internal/db/dbtest"
+)
+
+func TestMemoryStoreContract(t *testing.T) {
+	dbtest.RunStoreContract(t, func(t *testing.T) db.Store {
+		s := db.NewMemoryStore()
+		t.Cleanup(func() { s.Close() })
+		return s
+	})
+}
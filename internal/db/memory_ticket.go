@@ -0,0 +1,54 @@
+package db
+
+import (
+	"context"
+	"slices"
+	"time"
+
+	"li-chat/internal/model"
+)
+
+func (m *MemoryStore) CreateTicket(ctx context.Context, t model.WSTicket, ticketHash string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.users[t.UserID]; !ok {
+		return ErrConflict
+	}
+	if _, ok := m.tickets[ticketHash]; ok {
+		return ErrConflict
+	}
+	now := time.Now()
+	for hash, old := range m.tickets {
+		if !old.ExpiresAt.After(now) {
+			delete(m.tickets, hash)
+		}
+	}
+	t.Scopes = slices.Clone(t.Scopes)
+	t.ExpiresAt = t.ExpiresAt.UTC()
+	if !t.TokenExpiresAt.IsZero() {
+		t.TokenExpiresAt = t.TokenExpiresAt.UTC()
+	}
+	m.tickets[ticketHash] = t
+	return nil
+}
+
+func (m *MemoryStore) RedeemTicket(ctx context.Context, ticketHash string, now time.Time) (model.WSTicket, error) {
+	if err := ctx.Err(); err != nil {
+		return model.WSTicket{}, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	t, ok := m.tickets[ticketHash]
+	if !ok || !t.ExpiresAt.After(now) {
+		return model.WSTicket{}, ErrNotFound
+	}
+	delete(m.tickets, ticketHash)
+	return t, nil
+}
@@ -0,0 +1,311 @@
+package db
+
+import (
+	"cmp"
+	"context"
+	"slices"
+	"time"
+
+	"li-chat/internal/model"
+)
+
+type memoryDelivery struct {
+	model.WebhookDelivery
+	dead bool
+}
+
+func (m *MemoryStore) CreateWebhook(ctx context.Context, hook model.Webhook) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.nextHook++
+	hook.ID = m.nextHook
+	hook.Events = slices.Clone(hook.Events)
+	hook.Active = true
+	hook.CreatedAt = time.Now().UTC()
+	m.webhooks[hook.ID] = &hook
+	return hook.ID, nil
+}
+
+func (m *MemoryStore) ListWebhooks(ctx context.Context) ([]model.Webhook, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	hooks := make([]model.Webhook, 0, len(m.webhooks))
+	for _, h := range m.webhooks {
+		hooks = append(hooks, webhookCopy(h))
+	}
+	slices.SortFunc(hooks, func(a, b model.Webhook) int { return cmp.Compare(a.ID, b.ID) })
+	return hooks, nil
+}
+
+func (m *MemoryStore) GetWebhook(ctx context.Context, id int64) (model.Webhook, error) {
+	if err := ctx.Err(); err != nil {
+		return model.Webhook{}, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	h, ok := m.webhooks[id]
+	if !ok {
+		return model.Webhook{}, ErrNotFound
+	}
+	return webhookCopy(h), nil
+}
+
+func webhookCopy(h *model.Webhook) model.Webhook {
+	c := *h
+	c.Events = slices.Clone(h.Events)
+	return c
+}
+
+func (m *MemoryStore) SetWebhookActive(ctx context.Context, id int64, active bool) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	h, ok := m.webhooks[id]
+	if !ok {
+		return ErrNotFound
+	}
+	h.Active = active
+	return nil
+}
+
+func (m *MemoryStore) DeleteWebhook(ctx context.Context, id int64) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.webhooks[id]; !ok {
+		return ErrNotFound
+	}
+	delete(m.webhooks, id)
+	for did, d := range m.deliveries {
+		if d.WebhookID == id {
+			delete(m.deliveries, did)
+		}
+	}
+	return nil
+}
+
+func (m *MemoryStore) EnqueueDeliveries(ctx context.Context, deliveries []model.WebhookDelivery) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, d := range deliveries {
+		if _, ok := m.webhooks[d.WebhookID]; !ok {
+			return ErrConflict
+		}
+	}
+	for _, d := range deliveries {
+		m.addDelivery(d)
+	}
+	return nil
+}
+
+// addDelivery must be called with mu held for writing
+func (m *MemoryStore) addDelivery(d model.WebhookDelivery) {
+	m.nextDelivery++
+	d.ID = m.nextDelivery
+	d.Attempts = 0
+	d.LastError = ""
+	d.CreatedAt = time.Now().UTC()
+	d.Payload = slices.Clone(d.Payload)
+	m.deliveries[d.ID] = &memoryDelivery{WebhookDelivery: d}
+}
+
+func (m *MemoryStore) ClaimDeliveries(ctx context.Context, now, leaseUntil time.Time, limit int) ([]model.WebhookDelivery, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	due := []*memoryDelivery{}
+	for _, d := range m.deliveries {
+		if !d.dead && m.webhooks[d.WebhookID].Active && !d.NextAttemptAt.After(now) {
+			due = append(due, d)
+		}
+	}
+	slices.SortFunc(due, func(a, b *memoryDelivery) int { return cmp.Compare(a.ID, b.ID) })
+	if len(due) > limit {
+		due = due[:limit]
+	}
+
+	claimed := make([]model.WebhookDelivery, len(due))
+	for i, d := range due {
+		d.NextAttemptAt = leaseUntil
+		claimed[i] = d.WebhookDelivery
+	}
+	return claimed, nil
+}
+
+func (m *MemoryStore) CompleteDelivery(ctx context.Context, id int64) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.deliveries[id]; !ok {
+		return ErrNotFound
+	}
+	delete(m.deliveries, id)
+	return nil
+}
+
+func (m *MemoryStore) FailDelivery(ctx context.Context, id int64, lastError string, next time.Time) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	d, ok := m.deliveries[id]
+	if !ok {
+		return ErrNotFound
+	}
+	d.Attempts++
+	d.LastError = lastError
+	if next.IsZero() {
+		d.dead = true
+	} else {
+		d.NextAttemptAt = next
+	}
+	return nil
+}
+
+func (m *MemoryStore) DeadDeliveries(ctx context.Context, limit int) ([]model.WebhookDelivery, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	dead := []model.WebhookDelivery{}
+	for _, d := range m.deliveries {
+		if d.dead {
+			dead = append(dead, d.WebhookDelivery)
+		}
+	}
+	slices.SortFunc(dead, func(a, b model.WebhookDelivery) int { return cmp.Compare(b.ID, a.ID) })
+	if len(dead) > limit {
+		dead = dead[:limit]
+	}
+	return dead, nil
+}
+
+func (m *MemoryStore) RetryDelivery(ctx context.Context, id int64, now time.Time) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	d, ok := m.deliveries[id]
+	if !ok || !d.dead {
+		return ErrNotFound
+	}
+	d.dead = false
+	d.Attempts = 0
+	d.NextAttemptAt = now
+	return nil
+}
+
+type memoryIncomingWebhook struct {
+	model.IncomingWebhook
+	tokenHash string
+}
+
+func (m *MemoryStore) CreateIncomingWebhook(ctx context.Context, hook model.IncomingWebhook, tokenHash string) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, h := range m.incoming {
+		if h.tokenHash == tokenHash {
+			return 0, ErrConflict
+		}
+	}
+	m.nextIncoming++
+	hook.ID = m.nextIncoming
+	hook.Token = ""
+	hook.CreatedAt = time.Now().UTC()
+	m.incoming[hook.ID] = &memoryIncomingWebhook{IncomingWebhook: hook, tokenHash: tokenHash}
+	return hook.ID, nil
+}
+
+func (m *MemoryStore) ListIncomingWebhooks(ctx context.Context) ([]model.IncomingWebhook, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	hooks := make([]model.IncomingWebhook, 0, len(m.incoming))
+	for _, h := range m.incoming {
+		hooks = append(hooks, h.IncomingWebhook)
+	}
+	slices.SortFunc(hooks, func(a, b model.IncomingWebhook) int { return cmp.Compare(a.ID, b.ID) })
+	return hooks, nil
+}
+
+func (m *MemoryStore) GetIncomingWebhookByToken(ctx context.Context, tokenHash string) (model.IncomingWebhook, error) {
+	if err := ctx.Err(); err != nil {
+		return model.IncomingWebhook{}, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, h := range m.incoming {
+		if h.tokenHash == tokenHash {
+			return h.IncomingWebhook, nil
+		}
+	}
+	return model.IncomingWebhook{}, ErrNotFound
+}
+
+func (m *MemoryStore) DeleteIncomingWebhook(ctx context.Context, id int64) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.incoming[id]; !ok {
+		return ErrNotFound
+	}
+	delete(m.incoming, id)
+	return nil
+}
@@ -0,0 +1,294 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+//go:embed migrations/*/*.sql
+var migrationFS embed.FS
+
+const (
+	dialectPostgres = "postgres"
+	dialectSQLite   = "sqlite"
+
+	// Arbitrary key serialising concurrent migrators on one Postgres database
+	migrationLockKey = 7362514
+)
+
+var (
+	migrationFile = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+	placeholder   = regexp.MustCompile(`\?`)
+)
+
+// Migration is one numbered schema change with its rollback
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// MigrationStatus reports whether a known migration has been applied
+type MigrationStatus struct {
+	Version   int
+	Name      string
+	Applied   bool
+	AppliedAt time.Time
+}
+
+// Migrator is implemented by backends with a versioned schema. The
+// in-memory store has no schema and does not implement it.
+type Migrator interface {
+	MigrateUp(ctx context.Context) (int, error)
+	MigrateDown(ctx context.Context) error
+	MigrationStatus(ctx context.Context) ([]MigrationStatus, error)
+}
+
+// loadMigrations reads the embedded scripts for one dialect, sorted by version
+func loadMigrations(dialect string) ([]Migration, error) {
+	dir := path.Join("migrations", dialect)
+	entries, err := fs.ReadDir(migrationFS, dir)
+	if err != nil {
+		return nil, fmt.Errorf("read %s migrations: %w", dialect, err)
+	}
+
+	byVersion := make(map[int]*Migration)
+	for _, e := range entries {
+		m := migrationFile.FindStringSubmatch(e.Name())
+		if m == nil {
+			return nil, fmt.Errorf("unexpected migration file %s/%s", dir, e.Name())
+		}
+		version, _ := strconv.Atoi(m[1])
+		body, err := fs.ReadFile(migrationFS, path.Join(dir, e.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		mig, ok := byVersion[version]
+		if !ok {
+			mig = &Migration{Version: version, Name: m[2]}
+			byVersion[version] = mig
+		} else if mig.Name != m[2] {
+			return nil, fmt.Errorf("migration %d has conflicting names %q and %q", version, mig.Name, m[2])
+		}
+		if m[3] == "up" {
+			mig.Up = string(body)
+		} else {
+			mig.Down = string(body)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, mig := range byVersion {
+		if mig.Up == "" || mig.Down == "" {
+			return nil, fmt.Errorf("migration %04d_%s needs both up and down scripts", mig.Version, mig.Name)
+		}
+		migrations = append(migrations, *mig)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// sqlMigrator applies embedded migrations over database/sql, which both the
+// SQLite driver and pgx (via its stdlib adapter) provide
+type sqlMigrator struct {
+	db      *sql.DB
+	dialect string
+}
+
+// bind rewrites ? placeholders for dialects that number them
+func (m *sqlMigrator) bind(query string) string {
+	if m.dialect != dialectPostgres {
+		return query
+	}
+	n := 0
+	return placeholder.ReplaceAllStringFunc(query, func(string) string {
+		n++
+		return "$" + strconv.Itoa(n)
+	})
+}
+
+// withConn runs fn on a single connection holding the migration lock
+func (m *sqlMigrator) withConn(ctx context.Context, fn func(conn *sql.Conn) error) error {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if m.dialect == dialectPostgres {
+		if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", migrationLockKey); err != nil {
+			return fmt.Errorf("acquire migration lock: %w", err)
+		}
+		defer conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1)", migrationLockKey)
+	}
+
+	if _, err := conn.ExecContext(ctx, `
+	CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		name TEXT NOT NULL,
+		applied_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+	)`); err != nil {
+		return fmt.Errorf("create schema_migrations: %w", err)
+	}
+	return fn(conn)
+}
+
+// querier is satisfied by *sql.DB and *sql.Conn
+type querier interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
+func (m *sqlMigrator) applied(ctx context.Context, q querier) (map[int]time.Time, error) {
+	rows, err := q.QueryContext(ctx, "SELECT version, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := make(map[int]time.Time)
+	for rows.Next() {
+		var version int
+		var at time.Time
+		if err := rows.Scan(&version, &at); err != nil {
+			return nil, err
+		}
+		applied[version] = at
+	}
+	return applied, rows.Err()
+}
+
+// step runs one script and updates schema_migrations in the same transaction
+func (m *sqlMigrator) step(ctx context.Context, conn *sql.Conn, script, record string, args ...any) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, script); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, m.bind(record), args...); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func (m *sqlMigrator) Up(ctx context.Context) (int, error) {
+	migrations, err := loadMigrations(m.dialect)
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	err = m.withConn(ctx, func(conn *sql.Conn) error {
+		applied, err := m.applied(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for _, mig := range migrations {
+			if _, ok := applied[mig.Version]; ok {
+				continue
+			}
+			log.InfoContext(ctx, "Applying migration", zap.Int("version", mig.Version), zap.String("name", mig.Name), zap.String("dialect", m.dialect))
+			err := m.step(ctx, conn, mig.Up,
+				"INSERT INTO schema_migrations(version, name) VALUES (?, ?)", mig.Version, mig.Name)
+			if err != nil {
+				log.ErrorContext(ctx, "Migration failed", zap.Int("version", mig.Version), zap.Error(err))
+				return fmt.Errorf("migration %04d_%s: %w", mig.Version, mig.Name, err)
+			}
+			count++
+		}
+		return nil
+	})
+	if err == nil {
+		log.InfoContext(ctx, "Database schema is up to date", zap.Int("applied", count))
+	}
+	return count, err
+}
+
+// Down rolls back the most recently applied migration
+func (m *sqlMigrator) Down(ctx context.Context) error {
+	migrations, err := loadMigrations(m.dialect)
+	if err != nil {
+		return err
+	}
+
+	return m.withConn(ctx, func(conn *sql.Conn) error {
+		applied, err := m.applied(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for i := len(migrations) - 1; i >= 0; i-- {
+			mig := migrations[i]
+			if _, ok := applied[mig.Version]; !ok {
+				continue
+			}
+			log.InfoContext(ctx, "Rolling back migration", zap.Int("version", mig.Version), zap.String("name", mig.Name), zap.String("dialect", m.dialect))
+			err := m.step(ctx, conn, mig.Down,
+				"DELETE FROM schema_migrations WHERE version = ?", mig.Version)
+			if err != nil {
+				return fmt.Errorf("rollback %04d_%s: %w", mig.Version, mig.Name, err)
+			}
+			return nil
+		}
+		log.WarnContext(ctx, "No applied migrations to roll back")
+		return nil
+	})
+}
+
+// Status only reads, without the migration lock, so readiness probes
+// neither wait for a running migration nor create the table. Before the
+// first migration every one is pending.
+func (m *sqlMigrator) Status(ctx context.Context) ([]MigrationStatus, error) {
+	migrations, err := loadMigrations(m.dialect)
+	if err != nil {
+		return nil, err
+	}
+
+	applied := map[int]time.Time{}
+	exists, err := m.hasMigrationsTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if exists {
+		if applied, err = m.applied(ctx, m.db); err != nil {
+			return nil, err
+		}
+	}
+
+	status := make([]MigrationStatus, 0, len(migrations))
+	for _, mig := range migrations {
+		at, ok := applied[mig.Version]
+		status = append(status, MigrationStatus{
+			Version:   mig.Version,
+			Name:      mig.Name,
+			Applied:   ok,
+			AppliedAt: at,
+		})
+	}
+	return status, nil
+}
+
+// hasMigrationsTable reports whether schema_migrations has been created
+func (m *sqlMigrator) hasMigrationsTable(ctx context.Context) (bool, error) {
+	query := "SELECT COUNT(*) > 0 FROM sqlite_master WHERE type = 'table' AND name = 'schema_migrations'"
+	if m.dialect == dialectPostgres {
+		query = "SELECT to_regclass('schema_migrations') IS NOT NULL"
+	}
+	var exists bool
+	err := m.db.QueryRowContext(ctx, query).Scan(&exists)
+	return exists, err
+}
@@ -0,0 +1,2 @@
+-- Expired sessions are swept by expiry
+CREATE INDEX IF NOT EXISTS idx_sessions_expires_at ON sessions(expires_at);
@@ -0,0 +1,2 @@
+-- When the credential a ticket was minted with lapses; NULL for API keys
+ALTER TABLE ws_tickets ADD COLUMN IF NOT EXISTS token_expires_at TIMESTAMP;
@@ -0,0 +1 @@
+ALTER TABLE ws_tickets DROP COLUMN IF EXISTS api_key_id;
@@ -0,0 +1,3 @@
+-- The API key a ticket was minted with, so revoking the key can end the
+-- connection; NULL for logins
+ALTER TABLE ws_tickets ADD COLUMN IF NOT EXISTS api_key_id BIGINT;
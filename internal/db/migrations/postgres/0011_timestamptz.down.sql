@@ -0,0 +1,10 @@
+ALTER TABLE ws_tickets ALTER COLUMN token_expires_at TYPE TIMESTAMP USING token_expires_at AT TIME ZONE 'UTC';
+ALTER TABLE ws_tickets ALTER COLUMN expires_at TYPE TIMESTAMP USING expires_at AT TIME ZONE 'UTC';
+ALTER TABLE api_keys ALTER COLUMN revoked_at TYPE TIMESTAMP;
+ALTER TABLE api_keys ALTER COLUMN created_at TYPE TIMESTAMP;
+ALTER TABLE incoming_webhooks ALTER COLUMN created_at TYPE TIMESTAMP;
+ALTER TABLE webhook_deliveries ALTER COLUMN created_at TYPE TIMESTAMP;
+ALTER TABLE webhook_deliveries ALTER COLUMN next_attempt_at TYPE TIMESTAMP USING next_attempt_at AT TIME ZONE 'UTC';
+ALTER TABLE webhooks ALTER COLUMN created_at TYPE TIMESTAMP;
+ALTER TABLE messages ALTER COLUMN created_at TYPE TIMESTAMP;
+ALTER TABLE sessions ALTER COLUMN expires_at TYPE TIMESTAMP USING expires_at AT TIME ZONE 'UTC';
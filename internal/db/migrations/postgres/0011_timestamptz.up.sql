@@ -0,0 +1,14 @@
+-- Timestamps are compared with instants bound from Go, so they must not
+-- depend on the server's TimeZone. Columns Go writes hold UTC wall-clock
+-- times; CURRENT_TIMESTAMP defaults were stored in the session's TimeZone,
+-- which the plain cast assumes.
+ALTER TABLE sessions ALTER COLUMN expires_at TYPE TIMESTAMPTZ USING expires_at AT TIME ZONE 'UTC';
+ALTER TABLE messages ALTER COLUMN created_at TYPE TIMESTAMPTZ;
+ALTER TABLE webhooks ALTER COLUMN created_at TYPE TIMESTAMPTZ;
+ALTER TABLE webhook_deliveries ALTER COLUMN next_attempt_at TYPE TIMESTAMPTZ USING next_attempt_at AT TIME ZONE 'UTC';
+ALTER TABLE webhook_deliveries ALTER COLUMN created_at TYPE TIMESTAMPTZ;
+ALTER TABLE incoming_webhooks ALTER COLUMN created_at TYPE TIMESTAMPTZ;
+ALTER TABLE api_keys ALTER COLUMN created_at TYPE TIMESTAMPTZ;
+ALTER TABLE api_keys ALTER COLUMN revoked_at TYPE TIMESTAMPTZ;
+ALTER TABLE ws_tickets ALTER COLUMN expires_at TYPE TIMESTAMPTZ USING expires_at AT TIME ZONE 'UTC';
+ALTER TABLE ws_tickets ALTER COLUMN token_expires_at TYPE TIMESTAMPTZ USING token_expires_at AT TIME ZONE 'UTC';
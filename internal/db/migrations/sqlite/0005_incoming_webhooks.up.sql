@@ -0,0 +1,8 @@
+-- Only a hash of each token is kept; the token itself is shown once
+CREATE TABLE IF NOT EXISTS incoming_webhooks (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	-- the integration user messages are posted as
+	name TEXT NOT NULL,
+	token_hash TEXT NOT NULL UNIQUE,
+	created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
@@ -0,0 +1,15 @@
+ALTER TABLE users ADD COLUMN bot BOOLEAN NOT NULL DEFAULT 0;
+
+-- Only a hash of each key is kept; prefix is its first characters, enough
+-- to tell keys apart in a list
+CREATE TABLE IF NOT EXISTS api_keys (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	name TEXT NOT NULL,
+	prefix TEXT NOT NULL,
+	key_hash TEXT NOT NULL UNIQUE,
+	-- comma-separated scope names
+	scopes TEXT NOT NULL,
+	created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	revoked_at DATETIME
+);
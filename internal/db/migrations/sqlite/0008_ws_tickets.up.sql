@@ -0,0 +1,14 @@
+-- Single-use tickets for the WebSocket upgrade, stored by hash. scopes is
+-- NULL for a ticket with every scope, as for a login.
+CREATE TABLE IF NOT EXISTS ws_tickets (
+	ticket_hash TEXT PRIMARY KEY,
+	user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	username TEXT NOT NULL,
+	scopes TEXT,
+	bot BOOLEAN NOT NULL DEFAULT 0,
+	-- empty when the ticket isn't bound to an address
+	client_ip TEXT NOT NULL DEFAULT '',
+	expires_at DATETIME NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_ws_tickets_expires_at ON ws_tickets(expires_at);
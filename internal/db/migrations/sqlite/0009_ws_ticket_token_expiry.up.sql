@@ -0,0 +1,2 @@
+-- When the credential a ticket was minted with lapses; NULL for API keys
+ALTER TABLE ws_tickets ADD COLUMN token_expires_at DATETIME;
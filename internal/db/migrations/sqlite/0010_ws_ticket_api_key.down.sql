@@ -0,0 +1 @@
+ALTER TABLE ws_tickets DROP COLUMN api_key_id;
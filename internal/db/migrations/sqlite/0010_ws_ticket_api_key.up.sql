@@ -0,0 +1,3 @@
+-- The API key a ticket was minted with, so revoking the key can end the
+-- connection; NULL for logins
+ALTER TABLE ws_tickets ADD COLUMN api_key_id INTEGER;
@@ -0,0 +1,24 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/trace"
+
+	"li-chat/internal/metrics"
+)
+
+var tracer = otel.Tracer("li-chat/internal/db")
+
+// startQuery opens a span for one store operation. The returned func ends
+// the span and records the operation's latency.
+func startQuery(ctx context.Context, op string) (context.Context, func()) {
+	start := time.Now()
+	ctx, span := tracer.Start(ctx, "db."+op, trace.WithSpanKind(trace.SpanKindClient))
+	return ctx, func() {
+		span.End()
+		metrics.ObserveQuery(op, start)
+	}
+}
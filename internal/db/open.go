@@ -0,0 +1,73 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	"li-chat/pkg/logger"
+)
+
+// log is the package logger; its level can be set apart from the rest
+// with log.packages=db=<level>
+var log = logger.Named("db")
+
+// Options tunes behaviour shared by all SQL backends
+type Options struct {
+	// QueryTimeout caps every statement, on top of whatever deadline the
+	// caller's context already carries. Zero means DefaultQueryTimeout.
+	QueryTimeout time.Duration
+}
+
+const DefaultQueryTimeout = 5 * time.Second
+
+// queryContext bounds one statement by QueryTimeout while still honouring
+// cancellation and deadlines from the caller's context
+func (o Options) queryContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	timeout := o.QueryTimeout
+	if timeout <= 0 {
+		timeout = DefaultQueryTimeout
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// Open picks a backend from the DATABASE_URL scheme:
+//
+//	postgres://... or postgresql://...   pgx connection pool
+//	sqlite:///var/lib/li-chat/chat.db    SQLite file (WAL mode)
+//	memory://                            in-process store, lost on restart
+func Open(databaseURL string, opts Options) (Store, error) {
+	scheme, rest, ok := strings.Cut(databaseURL, "://")
+	if !ok {
+		// The URL itself may hold a password, so it isn't quoted
+		return nil, fmt.Errorf("database url has no scheme")
+	}
+
+	log.Debug("Selecting database backend", zap.String("scheme", scheme))
+	switch strings.ToLower(scheme) {
+	case "postgres", "postgresql":
+		repo, err := NewRepository(databaseURL, opts)
+		if err != nil {
+			return nil, err
+		}
+		return repo, nil
+	case "sqlite", "sqlite3":
+		path, _, _ := strings.Cut(rest, "?")
+		if path == "" {
+			return nil, fmt.Errorf("sqlite url %q has no file path", databaseURL)
+		}
+		repo, err := NewSQLiteRepository(path, opts)
+		if err != nil {
+			return nil, err
+		}
+		return repo, nil
+	case "memory":
+		log.Warn("Using in-memory store, data will not survive a restart")
+		return NewMemoryStore(), nil
+	default:
+		return nil, fmt.Errorf("unsupported database scheme %q", scheme)
+	}
+}
@@ -0,0 +1,67 @@
+package db
+
+import (
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+)
+
+// poolCollector reads pgxpool statistics at scrape time
+type poolCollector struct {
+	pool *pgxpool.Pool
+
+	acquired, idle, total, max *prometheus.Desc
+	acquires, emptyAcquires    *prometheus.Desc
+	canceledAcquires           *prometheus.Desc
+	acquireDuration            *prometheus.Desc
+}
+
+func newPoolCollector(pool *pgxpool.Pool) *poolCollector {
+	desc := func(name, help string) *prometheus.Desc {
+		return prometheus.NewDesc("lichat_db_pool_"+name, help, nil, nil)
+	}
+	return &poolCollector{
+		pool:             pool,
+		acquired:         desc("acquired_conns", "Connections currently checked out of the pool."),
+		idle:             desc("idle_conns", "Idle connections in the pool."),
+		total:            desc("total_conns", "Open connections, acquired, idle and being established."),
+		max:              desc("max_conns", "Configured pool size."),
+		acquires:         desc("acquires_total", "Successful connection acquires."),
+		emptyAcquires:    desc("empty_acquires_total", "Acquires that had to wait because the pool was empty."),
+		canceledAcquires: desc("canceled_acquires_total", "Acquires cancelled by their context."),
+		acquireDuration:  desc("acquire_duration_seconds_total", "Total time spent waiting to acquire connections."),
+	}
+}
+
+func (c *poolCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.acquired
+	ch <- c.idle
+	ch <- c.total
+	ch <- c.max
+	ch <- c.acquires
+	ch <- c.emptyAcquires
+	ch <- c.canceledAcquires
+	ch <- c.acquireDuration
+}
+
+func (c *poolCollector) Collect(ch chan<- prometheus.Metric) {
+	s := c.pool.Stat()
+	ch <- prometheus.MustNewConstMetric(c.acquired, prometheus.GaugeValue, float64(s.AcquiredConns()))
+	ch <- prometheus.MustNewConstMetric(c.idle, prometheus.GaugeValue, float64(s.IdleConns()))
+	ch <- prometheus.MustNewConstMetric(c.total, prometheus.GaugeValue, float64(s.TotalConns()))
+	ch <- prometheus.MustNewConstMetric(c.max, prometheus.GaugeValue, float64(s.MaxConns()))
+	ch <- prometheus.MustNewConstMetric(c.acquires, prometheus.CounterValue, float64(s.AcquireCount()))
+	ch <- prometheus.MustNewConstMetric(c.emptyAcquires, prometheus.CounterValue, float64(s.EmptyAcquireCount()))
+	ch <- prometheus.MustNewConstMetric(c.canceledAcquires, prometheus.CounterValue, float64(s.CanceledAcquireCount()))
+	ch <- prometheus.MustNewConstMetric(c.acquireDuration, prometheus.CounterValue, s.AcquireDuration().Seconds())
+}
+
+// Collector exports the pgx pool statistics
+func (r *Repository) Collector() prometheus.Collector {
+	return newPoolCollector(r.pool)
+}
+
+// Collector exports database/sql pool statistics
+func (r *SQLiteRepository) Collector() prometheus.Collector {
+	return collectors.NewDBStatsCollector(r.db, "sqlite")
+}
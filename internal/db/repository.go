@@ -2,30 +2,33 @@ package db
 
 import (
 	"context"
-	"fmt"
+	"crypto/rand"
 	"net"
 	"time"
 
-	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/jackc/pgx/v5/stdlib"
 	"go.uber.org/zap"
 
+	"li-chat/internal/model"
 	"li-chat/pkg/logger"
 )
 
 type Repository struct {
-	pool *pgxpool.Pool
+	pool     *pgxpool.Pool
+	migrator *sqlMigrator
+	opts     Options
 }
 
-func NewRepository(connStr string) (*Repository, error) {
-	logger.Info("Initializing database repository")
+func NewRepository(connStr string, opts Options) (*Repository, error) {
+	log.Info("Initializing database repository")
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
 	// Parse config to support both IPv4 and IPv6 with smart fallback
 	config, err := pgxpool.ParseConfig(connStr)
 	if err != nil {
-		logger.Error("Failed to parse connection string", zap.Error(err))
+		log.Error("Failed to parse connection string", zap.Error(err))
 		return nil, err
 	}
 
@@ -43,166 +46,253 @@ func NewRepository(connStr string) (*Repository, error) {
 
 	pool, err := pgxpool.NewWithConfig(ctx, config)
 	if err != nil {
-		logger.Error("Failed to create connection pool", zap.Error(err))
-		logger.Warn("Repository initialization failed, database operations will not be available")
+		log.Error("Failed to create connection pool", zap.Error(err))
+		log.Warn("Repository initialization failed, database operations will not be available")
 		return nil, err
 	}
 
 	if err := pool.Ping(ctx); err != nil {
-		logger.Error("Failed to ping database", zap.Error(err))
+		log.Error("Failed to ping database", zap.Error(err))
 		pool.Close()
 		return nil, err
 	}
-	logger.Debug("PostgreSQL connection pool established")
-
-	logger.Debug("Creating database schema")
-	_, err = pool.Exec(ctx, `
-	CREATE TABLE IF NOT EXISTS users (
-		id SERIAL PRIMARY KEY,
-		username TEXT UNIQUE NOT NULL,
-		password_hash TEXT NOT NULL
-	);
-
-	CREATE TABLE IF NOT EXISTS messages (
-		id SERIAL PRIMARY KEY,
-		user_id INTEGER,
-		content TEXT NOT NULL,
-		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
-	);
-	`)
-	if err != nil {
-		logger.Error("Failed to create schema", zap.Error(err))
-		logger.Warn("Schema creation error, database tables may not exist")
-		pool.Close()
-		return nil, err
-	}
-	logger.Debug("All tables created or already exist")
-	logger.Info("Repository initialized successfully")
+	log.Debug("PostgreSQL connection pool established")
+
+	log.Info("Repository initialized successfully")
 
-	return &Repository{pool: pool}, nil
+	return &Repository{
+		pool:     pool,
+		migrator: &sqlMigrator{db: stdlib.OpenDBFromPool(pool), dialect: dialectPostgres},
+		opts:     opts,
+	}, nil
 }
 
-func (r *Repository) GetOrCreateUser(username string) (int64, error) {
-	logger.Info("Getting or creating user", zap.String("username", username))
-	logger.Debug("Querying database for existing user", zap.String("username", username))
+// Close waits for acquired connections to be released and closes the pool
+func (r *Repository) Close() error {
+	log.Info("Closing PostgreSQL connection pool")
+	r.migrator.db.Close()
+	r.pool.Close()
+	return nil
+}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+// Ping checks that a pooled connection can reach the server
+func (r *Repository) Ping(ctx context.Context) error {
+	ctx, cancel := r.opts.queryContext(ctx)
 	defer cancel()
+	return r.pool.Ping(ctx)
+}
 
-	var id int64
-	err := r.pool.QueryRow(ctx,
-		"SELECT id FROM users WHERE username = $1",
-		username,
-	).Scan(&id)
+func (r *Repository) MigrateUp(ctx context.Context) (int, error) {
+	return r.migrator.Up(ctx)
+}
 
-	if err == pgx.ErrNoRows {
-		logger.Debug("User not found in database", zap.String("username", username))
-		logger.Debug("Creating new user record", zap.String("username", username))
+func (r *Repository) MigrateDown(ctx context.Context) error {
+	return r.migrator.Down(ctx)
+}
 
-		var userID int64
-		err := r.pool.QueryRow(ctx,
-			"INSERT INTO users(username) VALUES($1) RETURNING id",
-			username,
-		).Scan(&userID)
-		if err != nil {
-			logger.Error("Failed to create user record", zap.String("username", username), zap.Error(err))
-			logger.Warn("User creation failed - possible duplicate username or database error")
-			return 0, err
-		}
+func (r *Repository) MigrationStatus(ctx context.Context) ([]MigrationStatus, error) {
+	return r.migrator.Status(ctx)
+}
 
-		logger.Debug("New user inserted", zap.String("username", username), zap.Int64("user_id", userID))
-		logger.Info("New user created successfully", zap.String("username", username), zap.Int64("user_id", userID))
-		return userID, nil
-	}
+func (r *Repository) GetOrCreateUser(ctx context.Context, username string) (int64, error) {
+	ctx, done := startQuery(ctx, "get_or_create_user")
+	defer done()
+	log.DebugContext(ctx, "Getting or creating user", zap.String("username", username))
 
+	ctx, cancel := r.opts.queryContext(ctx)
+	defer cancel()
+
+	// Insert-if-absent then select keeps this race free: a concurrent
+	// insert of the same name waits for the other to commit, and the select
+	// then sees whichever row won
+	tag, err := r.pool.Exec(ctx,
+		"INSERT INTO users(username, password_hash) VALUES ($1, '') ON CONFLICT (username) DO NOTHING",
+		username,
+	)
 	if err != nil {
-		logger.Error("Failed to query user from database", zap.String("username", username), zap.Error(err))
-		logger.Warn("Database query error occurred while retrieving user")
-		return 0, err
+		log.ErrorContext(ctx, "Failed to create user record", zap.String("username", username), zap.Error(err))
+		return 0, mapPgError(err)
 	}
 
-	logger.Debug("Existing user found", zap.String("username", username), zap.Int64("user_id", id))
-	logger.Info("User already exists in database", zap.String("username", username), zap.Int64("user_id", id))
-	return id, err
+	var id int64
+	err = r.pool.QueryRow(ctx,
+		"SELECT id FROM users WHERE username = $1",
+		username,
+	).Scan(&id)
+	if err != nil {
+		log.ErrorContext(ctx, "Failed to query user from database", zap.String("username", username), zap.Error(err))
+		return 0, mapPgError(err)
+	}
+	if tag.RowsAffected() == 1 {
+		log.InfoContext(ctx, "New user created successfully", zap.String("username", username), zap.Int64("user_id", id))
+	}
+	return id, nil
 }
 
-func (r *Repository) SaveMessage(userID int64, content string) error {
-	logger.Info("Saving new message", zap.Int64("user_id", userID))
-	logger.Debug("Message details", zap.Int64("user_id", userID), zap.Int("content_length", len(content)))
+func (r *Repository) SaveMessage(ctx context.Context, userID int64, content string) (int64, error) {
+	ctx, done := startQuery(ctx, "save_message")
+	defer done()
+	log.InfoContext(ctx, "Saving new message", zap.Int64("user_id", userID))
+	log.DebugContext(ctx, "Message details", zap.Int64("user_id", userID), logger.Content("content", content))
 
 	if content == "" {
-		logger.Warn("Empty message content provided", zap.Int64("user_id", userID))
+		log.WarnContext(ctx, "Empty message content provided", zap.Int64("user_id", userID))
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := r.opts.queryContext(ctx)
 	defer cancel()
 
-	logger.Debug("Executing INSERT query for message")
-	_, err := r.pool.Exec(ctx,
-		"INSERT INTO messages(user_id, content) VALUES($1, $2)",
+	log.DebugContext(ctx, "Executing INSERT query for message")
+	var id int64
+	err := r.pool.QueryRow(ctx,
+		"INSERT INTO messages(user_id, content) VALUES($1, $2) RETURNING id",
 		userID,
 		content,
-	)
+	).Scan(&id)
 	if err != nil {
-		logger.Error("Failed to save message", zap.Int64("user_id", userID), zap.Error(err))
-		logger.Warn("Message insertion failed - database may be unavailable or corrupted")
-		return err
+		log.ErrorContext(ctx, "Failed to save message", zap.Int64("user_id", userID), zap.Error(err))
+		log.WarnContext(ctx, "Message insertion failed - database may be unavailable or corrupted")
+		return 0, mapPgError(err)
 	}
 
-	logger.Debug("Message record inserted successfully into database")
-	logger.Info("Message saved successfully", zap.Int64("user_id", userID), zap.Int("content_size", len(content)))
-	return nil
+	log.DebugContext(ctx, "Message record inserted successfully into database", zap.Int64("message_id", id))
+	log.InfoContext(ctx, "Message saved successfully", zap.Int64("user_id", userID), logger.Content("content", content))
+	return id, nil
 }
 
-func (r *Repository) GetMessages(limit int) ([]interface{}, error) {
-	logger.Info("[DB::MSG] Fetching message history with limit: %d", zap.Int("limit", limit))
-	logger.Debug("[DB::MSG] Executing SELECT query for recent messages...")
+func (r *Repository) SaveMessageWithDeliveries(ctx context.Context, userID int64, content string, outbox func(id int64) ([]model.WebhookDelivery, error)) (int64, error) {
+	ctx, done := startQuery(ctx, "save_message_with_deliveries")
+	defer done()
+	log.DebugContext(ctx, "Saving new message with webhook deliveries", zap.Int64("user_id", userID), logger.Content("content", content))
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := r.opts.queryContext(ctx)
 	defer cancel()
 
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return 0, mapPgError(err)
+	}
+	defer tx.Rollback(ctx)
+
+	var id int64
+	err = tx.QueryRow(ctx, "INSERT INTO messages(user_id, content) VALUES($1, $2) RETURNING id", userID, content).Scan(&id)
+	if err != nil {
+		log.ErrorContext(ctx, "Failed to save message", zap.Int64("user_id", userID), zap.Error(err))
+		return 0, mapPgError(err)
+	}
+	deliveries, err := outbox(id)
+	if err != nil {
+		return 0, err
+	}
+	for _, d := range deliveries {
+		_, err := tx.Exec(ctx, `
+			INSERT INTO webhook_deliveries(webhook_id, event, payload, next_attempt_at)
+			SELECT $1, $2, $3, $4 WHERE EXISTS (SELECT 1 FROM webhooks WHERE id = $1)`,
+			d.WebhookID, d.Event, string(d.Payload), d.NextAttemptAt.UTC(),
+		)
+		if err != nil {
+			return 0, mapPgError(err)
+		}
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return 0, mapPgError(err)
+	}
+	return id, nil
+}
+
+func (r *Repository) GetMessages(ctx context.Context, limit int) ([]model.Message, error) {
+	ctx, done := startQuery(ctx, "get_messages")
+	defer done()
+	log.DebugContext(ctx, "[DB::MSG] Fetching message history", zap.Int("limit", limit))
+
+	ctx, cancel := r.opts.queryContext(ctx)
+	defer cancel()
+
+	// Take the newest rows, then flip them back into chronological order
 	rows, err := r.pool.Query(ctx, `
-		SELECT u.username, m.content, m.created_at
-		FROM messages m
-		JOIN users u ON u.id = m.user_id
-		ORDER BY m.created_at ASC
-		LIMIT $1
+		SELECT id, username, content, created_at, bot FROM (
+			SELECT m.id, u.username, m.content, m.created_at, u.bot
+			FROM messages m
+			JOIN users u ON u.id = m.user_id
+			ORDER BY m.created_at DESC, m.id DESC
+			LIMIT $1
+		) recent
+		ORDER BY created_at ASC, id ASC
 	`, limit)
 	if err != nil {
-		logger.Error("[DB::MSG] Failed to fetch messages: %v", zap.Error(err))
-		logger.Warn("[DB::MSG] Message retrieval failed - database query error")
-		return nil, err
+		log.ErrorContext(ctx, "[DB::MSG] Failed to fetch messages", zap.Error(err))
+		return nil, mapPgError(err)
 	}
 	defer rows.Close()
 
-	var messages []interface{}
+	messages := []model.Message{}
 	for rows.Next() {
-		var username, content, createdAt string
+		var id int64
+		var username, content string
+		var createdAt time.Time
+		var bot bool
 
-		err := rows.Scan(&username, &content, &createdAt)
+		err := rows.Scan(&id, &username, &content, &createdAt, &bot)
 		if err != nil {
-			logger.Error("[DB::MSG] Failed to scan message row: %v", zap.Error(err))
+			log.ErrorContext(ctx, "[DB::MSG] Failed to scan message row", zap.Error(err))
 			continue
 		}
 
-		messages = append(messages, map[string]string{
-			"username":   username,
-			"content":    content,
-			"created_at": createdAt,
+		messages = append(messages, model.Message{
+			ID:        id,
+			Username:  username,
+			Content:   content,
+			CreatedAt: createdAt.Format(time.RFC3339),
+			Bot:       bot,
 		})
 	}
 
 	if err = rows.Err(); err != nil {
-		logger.Error("[DB::MSG] Error iterating message rows: %v", zap.Error(err))
-		return nil, err
+		log.ErrorContext(ctx, "[DB::MSG] Error iterating message rows", zap.Error(err))
+		return nil, mapPgError(err)
 	}
 
-	logger.Debug("[DB::MSG] Retrieved %d messages from database", zap.Int("messages", len(messages)))
-	logger.Info("[DB::MSG] Message history loaded successfully (count: %d)",  zap.Int("messages", len(messages)))
+	log.DebugContext(ctx, "[DB::MSG] Message history loaded", zap.Int("messages", len(messages)))
 	return messages, nil
 }
 
+func (r *Repository) GetMessagesAfter(ctx context.Context, afterID int64, limit int) ([]model.Message, error) {
+	ctx, done := startQuery(ctx, "get_messages_after")
+	defer done()
+	log.DebugContext(ctx, "[DB::MSG] Fetching messages after id", zap.Int64("after_id", afterID), zap.Int("limit", limit))
+
+	ctx, cancel := r.opts.queryContext(ctx)
+	defer cancel()
+
+	rows, err := r.pool.Query(ctx, `
+		SELECT m.id, u.username, m.content, m.created_at, u.bot
+		FROM messages m
+		JOIN users u ON u.id = m.user_id
+		WHERE m.id > $1
+		ORDER BY m.id
+		LIMIT $2
+	`, afterID, limit)
+	if err != nil {
+		log.ErrorContext(ctx, "[DB::MSG] Failed to fetch messages", zap.Error(err))
+		return nil, mapPgError(err)
+	}
+	defer rows.Close()
+
+	messages := []model.Message{}
+	for rows.Next() {
+		var m model.Message
+		var createdAt time.Time
+		if err := rows.Scan(&m.ID, &m.Username, &m.Content, &createdAt, &m.Bot); err != nil {
+			return nil, mapPgError(err)
+		}
+		m.CreatedAt = createdAt.Format(time.RFC3339)
+		messages = append(messages, m)
+	}
+	return messages, mapPgError(rows.Err())
+}
+
+// generateSessionID returns an unguessable session ID, 128 bits from
+// crypto/rand
 func generateSessionID() string {
-	// Simple session ID generation (in production, use crypto/rand with UUID)
-	return fmt.Sprintf("sess_%d", time.Now().UnixNano())
+	return "sess_" + rand.Text()
 }
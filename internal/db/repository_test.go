@@ -0,0 +1,66 @@
+package db_test
+
+import (
+	"context"
+	"crypto/rand"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+
+	"li-chat/internal/db"
+	"li-chat/internal/db/dbtest"
+)
+
+// TestPostgresContract runs against the server in LICHAT_TEST_POSTGRES_URL,
+// giving every subtest a schema of its own that is dropped afterwards
+func TestPostgresContract(t *testing.T) {
+	dsn := os.Getenv("LICHAT_TEST_POSTGRES_URL")
+	if dsn == "" {
+		t.Skip("LICHAT_TEST_POSTGRES_URL not set")
+	}
+
+	dbtest.RunStoreContract(t, func(t *testing.T) db.Store {
+		ctx := context.Background()
+		schema := "contract_" + strings.ToLower(rand.Text())
+		conn, err := pgx.Connect(ctx, dsn)
+		if err != nil {
+			t.Fatalf("connect: %v", err)
+		}
+		defer conn.Close(ctx)
+		if _, err := conn.Exec(ctx, "CREATE SCHEMA "+schema); err != nil {
+			t.Fatalf("create schema: %v", err)
+		}
+		t.Cleanup(func() {
+			conn, err := pgx.Connect(ctx, dsn)
+			if err != nil {
+				t.Errorf("connect: %v", err)
+				return
+			}
+			defer conn.Close(ctx)
+			if _, err := conn.Exec(ctx, "DROP SCHEMA "+schema+" CASCADE"); err != nil {
+				t.Errorf("drop schema: %v", err)
+			}
+		})
+
+		u, err := url.Parse(dsn)
+		if err != nil {
+			t.Fatalf("parse LICHAT_TEST_POSTGRES_URL: %v", err)
+		}
+		q := u.Query()
+		q.Set("search_path", schema)
+		u.RawQuery = q.Encode()
+
+		r, err := db.NewRepository(u.String(), db.Options{})
+		if err != nil {
+			t.Fatalf("NewRepository: %v", err)
+		}
+		t.Cleanup(func() { r.Close() })
+		if _, err := r.MigrateUp(ctx); err != nil {
+			t.Fatalf("MigrateUp: %v", err)
+		}
+		return r
+	})
+}
@@ -0,0 +1,63 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"li-chat/internal/model"
+)
+
+func (r *Repository) CreateSession(ctx context.Context, userID int64, expiresAt time.Time) (model.Session, error) {
+	ctx, done := startQuery(ctx, "create_session")
+	defer done()
+	ctx, cancel := r.opts.queryContext(ctx)
+	defer cancel()
+
+	s := model.Session{ID: generateSessionID(), UserID: userID, ExpiresAt: expiresAt.UTC()}
+	_, err := r.pool.Exec(ctx, "INSERT INTO sessions(id, user_id, expires_at) VALUES ($1, $2, $3)", s.ID, s.UserID, s.ExpiresAt)
+	if err != nil {
+		return model.Session{}, mapPgError(err)
+	}
+	return s, nil
+}
+
+func (r *Repository) GetSession(ctx context.Context, id string) (model.Session, error) {
+	ctx, done := startQuery(ctx, "get_session")
+	defer done()
+	ctx, cancel := r.opts.queryContext(ctx)
+	defer cancel()
+
+	var s model.Session
+	var disabled bool
+	err := r.pool.QueryRow(ctx, `
+		SELECT s.id, s.user_id, u.username, s.expires_at, u.disabled
+		FROM sessions s
+		JOIN users u ON u.id = s.user_id
+		WHERE s.id = $1 AND s.expires_at > $2`,
+		id, time.Now().UTC(),
+	).Scan(&s.ID, &s.UserID, &s.Username, &s.ExpiresAt, &disabled)
+	if err != nil {
+		return model.Session{}, mapPgError(err)
+	}
+	if disabled {
+		return model.Session{}, ErrDisabled
+	}
+	return s, nil
+}
+
+func (r *Repository) DeleteSession(ctx context.Context, id string) error {
+	return r.execOne(ctx, "delete_session", "DELETE FROM sessions WHERE id = $1", id)
+}
+
+func (r *Repository) DeleteExpiredSessions(ctx context.Context, now time.Time) (int64, error) {
+	ctx, done := startQuery(ctx, "delete_expired_sessions")
+	defer done()
+	ctx, cancel := r.opts.queryContext(ctx)
+	defer cancel()
+
+	tag, err := r.pool.Exec(ctx, "DELETE FROM sessions WHERE expires_at <= $1", now.UTC())
+	if err != nil {
+		return 0, mapPgError(err)
+	}
+	return tag.RowsAffected(), nil
+}
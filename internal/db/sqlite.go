@@ -1,55 +1,315 @@
 package db
 
 import (
+	"context"
 	"database/sql"
+	"time"
 
 	_ "github.com/mattn/go-sqlite3"
 	"go.uber.org/zap"
 
+	"li-chat/internal/model"
 	"li-chat/pkg/logger"
 )
 
-func NewSQLite(path string) *sql.DB {
-	logger.Info("Opening SQLite database", zap.String("path", path))
-	db, err := sql.Open("sqlite3", path)
-	if err != nil {
-		logger.Error("Failed to open database", zap.Error(err))
-		logger.Warn("Database initialization failed, application may not function correctly")
-		panic(err)
-	}
-	logger.Debug("Database driver initialized", zap.String("path", path))
-	logger.Info("Database connection established successfully")
-
-	logger.Debug("Starting schema creation process")
-	schema := `
-	CREATE TABLE IF NOT EXISTS users (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		username TEXT UNIQUE NOT NULL,
-		password_hash TEXT NOT NULL
-	);
-
-	CREATE TABLE IF NOT EXISTS sessions (
-		id TEXT PRIMARY KEY,
-		user_id INTEGER NOT NULL,
-		expires_at DATETIME NOT NULL,
-		FOREIGN KEY(user_id) REFERENCES users(id)
-	);
-
-	CREATE TABLE IF NOT EXISTS messages (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		user_id INTEGER,
-		content TEXT NOT NULL,
-		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-		FOREIGN KEY(user_id) REFERENCES users(id)
-	);`
-
-	if _, err := db.Exec(schema); err != nil {
-		logger.Error("Failed to create database schema", zap.Error(err))
-		logger.Warn("Schema creation error - tables may be missing or corrupted")
-		panic(err)
-	}
-	logger.Debug("All tables created or already exist")
-	logger.Info("Database schema initialization completed successfully")
-
-	return db
+// SQLiteRepository is the single-file backend for small deployments that
+// don't want to run a Postgres server
+type SQLiteRepository struct {
+	db       *sql.DB
+	migrator *sqlMigrator
+	opts     Options
+}
+
+func NewSQLiteRepository(path string, opts Options) (*SQLiteRepository, error) {
+	log.Info("Opening SQLite database", zap.String("path", path))
+
+	// WAL lets readers proceed while a write is in flight; the busy timeout
+	// makes concurrent writers wait for the lock instead of failing
+	dsn := "file:" + path + "?_journal_mode=WAL&_busy_timeout=5000&_foreign_keys=on"
+	db, err := sql.Open("sqlite3", dsn)
+	if err != nil {
+		log.Error("Failed to open database", zap.Error(err))
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := db.PingContext(ctx); err != nil {
+		log.Error("Failed to ping database", zap.Error(err))
+		db.Close()
+		return nil, err
+	}
+	log.Debug("Database driver initialized", zap.String("path", path))
+
+	log.Info("SQLite repository initialized successfully")
+
+	return &SQLiteRepository{
+		db:       db,
+		migrator: &sqlMigrator{db: db, dialect: dialectSQLite},
+		opts:     opts,
+	}, nil
+}
+
+// Close checkpoints and closes the database
+func (r *SQLiteRepository) Close() error {
+	log.Info("Closing SQLite database")
+	return r.db.Close()
+}
+
+// Ping checks that the database file can still be opened and queried
+func (r *SQLiteRepository) Ping(ctx context.Context) error {
+	ctx, cancel := r.opts.queryContext(ctx)
+	defer cancel()
+	return r.db.PingContext(ctx)
+}
+
+func (r *SQLiteRepository) MigrateUp(ctx context.Context) (int, error) {
+	return r.migrator.Up(ctx)
+}
+
+func (r *SQLiteRepository) MigrateDown(ctx context.Context) error {
+	return r.migrator.Down(ctx)
+}
+
+func (r *SQLiteRepository) MigrationStatus(ctx context.Context) ([]MigrationStatus, error) {
+	return r.migrator.Status(ctx)
+}
+
+func (r *SQLiteRepository) CreateUser(ctx context.Context, username, passwordHash string) error {
+	ctx, done := startQuery(ctx, "create_user")
+	defer done()
+	ctx, cancel := r.opts.queryContext(ctx)
+	defer cancel()
+
+	_, err := r.db.ExecContext(ctx,
+		"INSERT INTO users(username, password_hash) VALUES (?, ?)",
+		username, passwordHash,
+	)
+	return userConflict(mapSQLiteError(err))
+}
+
+func (r *SQLiteRepository) GetUserForLogin(ctx context.Context, username string) (int64, string, error) {
+	ctx, done := startQuery(ctx, "get_user_for_login")
+	defer done()
+	ctx, cancel := r.opts.queryContext(ctx)
+	defer cancel()
+
+	var id int64
+	var hash string
+	var disabled bool
+
+	err := r.db.QueryRowContext(ctx,
+		"SELECT id, password_hash, disabled FROM users WHERE username = ?",
+		username,
+	).Scan(&id, &hash, &disabled)
+	if err == nil && disabled {
+		return 0, "", ErrDisabled
+	}
+
+	return id, hash, mapSQLiteError(err)
+}
+
+func (r *SQLiteRepository) GetUserByID(ctx context.Context, userID int64) (string, error) {
+	ctx, done := startQuery(ctx, "get_user_by_id")
+	defer done()
+	ctx, cancel := r.opts.queryContext(ctx)
+	defer cancel()
+
+	var username string
+	var disabled bool
+
+	err := r.db.QueryRowContext(ctx,
+		"SELECT username, disabled FROM users WHERE id = ?",
+		userID,
+	).Scan(&username, &disabled)
+	if err == nil && disabled {
+		return "", ErrDisabled
+	}
+
+	return username, mapSQLiteError(err)
+}
+
+func (r *SQLiteRepository) GetOrCreateUser(ctx context.Context, username string) (int64, error) {
+	ctx, done := startQuery(ctx, "get_or_create_user")
+	defer done()
+	log.DebugContext(ctx, "Getting or creating user", zap.String("username", username))
+
+	ctx, cancel := r.opts.queryContext(ctx)
+	defer cancel()
+
+	// Insert-if-absent then select keeps this race free across connections
+	_, err := r.db.ExecContext(ctx,
+		"INSERT INTO users(username, password_hash) VALUES (?, '') ON CONFLICT(username) DO NOTHING",
+		username,
+	)
+	if err != nil {
+		log.ErrorContext(ctx, "Failed to create user record", zap.String("username", username), zap.Error(err))
+		return 0, mapSQLiteError(err)
+	}
+
+	var id int64
+	err = r.db.QueryRowContext(ctx,
+		"SELECT id FROM users WHERE username = ?",
+		username,
+	).Scan(&id)
+	if err != nil {
+		log.ErrorContext(ctx, "Failed to query user from database", zap.String("username", username), zap.Error(err))
+		return 0, mapSQLiteError(err)
+	}
+	return id, nil
+}
+
+func (r *SQLiteRepository) SaveMessage(ctx context.Context, userID int64, content string) (int64, error) {
+	ctx, done := startQuery(ctx, "save_message")
+	defer done()
+	log.DebugContext(ctx, "Saving new message", zap.Int64("user_id", userID), logger.Content("content", content))
+
+	ctx, cancel := r.opts.queryContext(ctx)
+	defer cancel()
+
+	res, err := r.db.ExecContext(ctx,
+		"INSERT INTO messages(user_id, content) VALUES (?, ?)",
+		userID,
+		content,
+	)
+	if err != nil {
+		log.ErrorContext(ctx, "Failed to save message", zap.Int64("user_id", userID), zap.Error(err))
+		return 0, mapSQLiteError(err)
+	}
+	return res.LastInsertId()
+}
+
+func (r *SQLiteRepository) SaveMessageWithDeliveries(ctx context.Context, userID int64, content string, outbox func(id int64) ([]model.WebhookDelivery, error)) (int64, error) {
+	ctx, done := startQuery(ctx, "save_message_with_deliveries")
+	defer done()
+	log.DebugContext(ctx, "Saving new message with webhook deliveries", zap.Int64("user_id", userID), logger.Content("content", content))
+
+	ctx, cancel := r.opts.queryContext(ctx)
+	defer cancel()
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, mapSQLiteError(err)
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, "INSERT INTO messages(user_id, content) VALUES (?, ?)", userID, content)
+	if err != nil {
+		log.ErrorContext(ctx, "Failed to save message", zap.Int64("user_id", userID), zap.Error(err))
+		return 0, mapSQLiteError(err)
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	deliveries, err := outbox(id)
+	if err != nil {
+		return 0, err
+	}
+	for _, d := range deliveries {
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO webhook_deliveries(webhook_id, event, payload, next_attempt_at)
+			SELECT ?, ?, ?, ? WHERE EXISTS (SELECT 1 FROM webhooks WHERE id = ?)`,
+			d.WebhookID, d.Event, string(d.Payload), sqliteTime(d.NextAttemptAt), d.WebhookID,
+		)
+		if err != nil {
+			return 0, mapSQLiteError(err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, mapSQLiteError(err)
+	}
+	return id, nil
+}
+
+func (r *SQLiteRepository) GetMessages(ctx context.Context, limit int) ([]model.Message, error) {
+	ctx, done := startQuery(ctx, "get_messages")
+	defer done()
+	log.DebugContext(ctx, "[DB::MSG] Fetching message history", zap.Int("limit", limit))
+
+	ctx, cancel := r.opts.queryContext(ctx)
+	defer cancel()
+
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT m.id, u.username, m.content, m.created_at, u.bot
+		FROM messages m
+		JOIN users u ON u.id = m.user_id
+		ORDER BY m.created_at DESC, m.id DESC
+		LIMIT ?
+	`, limit)
+	if err != nil {
+		log.ErrorContext(ctx, "[DB::MSG] Failed to fetch messages", zap.Error(err))
+		return nil, mapSQLiteError(err)
+	}
+	defer rows.Close()
+
+	messages := []model.Message{}
+	for rows.Next() {
+		var id int64
+		var username, content string
+		var createdAt time.Time
+		var bot bool
+
+		if err := rows.Scan(&id, &username, &content, &createdAt, &bot); err != nil {
+			log.ErrorContext(ctx, "[DB::MSG] Failed to scan message row", zap.Error(err))
+			continue
+		}
+
+		messages = append(messages, model.Message{
+			ID:        id,
+			Username:  username,
+			Content:   content,
+			CreatedAt: createdAt.Format(time.RFC3339),
+			Bot:       bot,
+		})
+	}
+
+	if err = rows.Err(); err != nil {
+		log.ErrorContext(ctx, "[DB::MSG] Error iterating message rows", zap.Error(err))
+		return nil, mapSQLiteError(err)
+	}
+
+	// Rows come back newest first; history is rendered oldest first
+	for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
+		messages[i], messages[j] = messages[j], messages[i]
+	}
+
+	log.DebugContext(ctx, "[DB::MSG] Message history loaded", zap.Int("messages", len(messages)))
+	return messages, nil
+}
+
+func (r *SQLiteRepository) GetMessagesAfter(ctx context.Context, afterID int64, limit int) ([]model.Message, error) {
+	ctx, done := startQuery(ctx, "get_messages_after")
+	defer done()
+	log.DebugContext(ctx, "[DB::MSG] Fetching messages after id", zap.Int64("after_id", afterID), zap.Int("limit", limit))
+
+	ctx, cancel := r.opts.queryContext(ctx)
+	defer cancel()
+
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT m.id, u.username, m.content, m.created_at, u.bot
+		FROM messages m
+		JOIN users u ON u.id = m.user_id
+		WHERE m.id > ?
+		ORDER BY m.id
+		LIMIT ?
+	`, afterID, limit)
+	if err != nil {
+		log.ErrorContext(ctx, "[DB::MSG] Failed to fetch messages", zap.Error(err))
+		return nil, mapSQLiteError(err)
+	}
+	defer rows.Close()
+
+	messages := []model.Message{}
+	for rows.Next() {
+		var m model.Message
+		var createdAt time.Time
+		if err := rows.Scan(&m.ID, &m.Username, &m.Content, &createdAt, &m.Bot); err != nil {
+			return nil, mapSQLiteError(err)
+		}
+		m.CreatedAt = createdAt.Format(time.RFC3339)
+		messages = append(messages, m)
+	}
+	return messages, mapSQLiteError(rows.Err())
 }
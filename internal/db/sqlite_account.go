@@ -0,0 +1,139 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"li-chat/internal/model"
+)
+
+// sqliteTime formats t the way CURRENT_TIMESTAMP stores it, so text
+// comparisons against created_at order correctly
+func sqliteTime(t time.Time) string {
+	return t.UTC().Format("2006-01-02 15:04:05")
+}
+
+func (r *SQLiteRepository) ListUsers(ctx context.Context) ([]model.Account, error) {
+	ctx, done := startQuery(ctx, "list_users")
+	defer done()
+	ctx, cancel := r.opts.queryContext(ctx)
+	defer cancel()
+
+	rows, err := r.db.QueryContext(ctx, "SELECT id, username, role, disabled, bot FROM users ORDER BY id")
+	if err != nil {
+		return nil, mapSQLiteError(err)
+	}
+	defer rows.Close()
+
+	accounts := []model.Account{}
+	for rows.Next() {
+		var a model.Account
+		if err := rows.Scan(&a.ID, &a.Username, &a.Role, &a.Disabled, &a.Bot); err != nil {
+			return nil, mapSQLiteError(err)
+		}
+		accounts = append(accounts, a)
+	}
+	return accounts, mapSQLiteError(rows.Err())
+}
+
+func (r *SQLiteRepository) GetAccount(ctx context.Context, username string) (model.Account, error) {
+	ctx, done := startQuery(ctx, "get_account")
+	defer done()
+	ctx, cancel := r.opts.queryContext(ctx)
+	defer cancel()
+
+	var a model.Account
+	err := r.db.QueryRowContext(ctx,
+		"SELECT id, username, role, disabled, bot FROM users WHERE username = ?",
+		username,
+	).Scan(&a.ID, &a.Username, &a.Role, &a.Disabled, &a.Bot)
+	return a, mapSQLiteError(err)
+}
+
+func (r *SQLiteRepository) SetUserDisabled(ctx context.Context, username string, disabled bool) error {
+	return r.updateUser(ctx, "set_user_disabled", "UPDATE users SET disabled = ? WHERE username = ?", disabled, username)
+}
+
+func (r *SQLiteRepository) SetPassword(ctx context.Context, username, passwordHash string) error {
+	return r.updateUser(ctx, "set_password", "UPDATE users SET password_hash = ? WHERE username = ?", passwordHash, username)
+}
+
+func (r *SQLiteRepository) SetRole(ctx context.Context, username, role string) error {
+	return r.updateUser(ctx, "set_role", "UPDATE users SET role = ? WHERE username = ?", role, username)
+}
+
+// updateUser runs a single-row UPDATE whose last placeholder is the username
+func (r *SQLiteRepository) updateUser(ctx context.Context, op, query string, value any, username string) error {
+	ctx, done := startQuery(ctx, op)
+	defer done()
+	ctx, cancel := r.opts.queryContext(ctx)
+	defer cancel()
+
+	res, err := r.db.ExecContext(ctx, query, value, username)
+	if err != nil {
+		return mapSQLiteError(err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// ExportMessages streams rows from a single query, so it is bounded by the
+// caller's context rather than the per-statement QueryTimeout
+func (r *SQLiteRepository) ExportMessages(ctx context.Context, since time.Time, fn func(model.MessageRecord) error) error {
+	ctx, done := startQuery(ctx, "export_messages")
+	defer done()
+
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT m.id, m.user_id, u.username, m.content, m.created_at
+		FROM messages m
+		LEFT JOIN users u ON u.id = m.user_id
+		WHERE m.created_at >= ?
+		ORDER BY m.created_at, m.id
+	`, sqliteTime(since))
+	if err != nil {
+		return mapSQLiteError(err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var rec model.MessageRecord
+		var userID sql.NullInt64
+		var username sql.NullString
+		if err := rows.Scan(&rec.ID, &userID, &username, &rec.Content, &rec.CreatedAt); err != nil {
+			return mapSQLiteError(err)
+		}
+		rec.UserID = userID.Int64
+		rec.Username = username.String
+		if err := fn(rec); err != nil {
+			return err
+		}
+	}
+	return mapSQLiteError(rows.Err())
+}
+
+func (r *SQLiteRepository) PurgeMessages(ctx context.Context, before time.Time) (int64, error) {
+	ctx, done := startQuery(ctx, "purge_messages")
+	defer done()
+
+	res, err := r.db.ExecContext(ctx, "DELETE FROM messages WHERE created_at < ?", sqliteTime(before))
+	if err != nil {
+		return 0, mapSQLiteError(err)
+	}
+	return res.RowsAffected()
+}
+
+func (r *SQLiteRepository) CreateBot(ctx context.Context, username string) (int64, error) {
+	ctx, done := startQuery(ctx, "create_bot")
+	defer done()
+	ctx, cancel := r.opts.queryContext(ctx)
+	defer cancel()
+
+	res, err := r.db.ExecContext(ctx, "INSERT INTO users(username, password_hash, bot) VALUES (?, '', 1)", username)
+	if err != nil {
+		return 0, userConflict(mapSQLiteError(err))
+	}
+	return res.LastInsertId()
+}
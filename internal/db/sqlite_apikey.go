@@ -0,0 +1,68 @@
+package db
+
+import (
+	"context"
+	"strings"
+
+	"li-chat/internal/model"
+)
+
+func (r *SQLiteRepository) CreateAPIKey(ctx context.Context, key model.APIKey, keyHash string) (int64, error) {
+	ctx, done := startQuery(ctx, "create_api_key")
+	defer done()
+	ctx, cancel := r.opts.queryContext(ctx)
+	defer cancel()
+
+	res, err := r.db.ExecContext(ctx,
+		"INSERT INTO api_keys(user_id, name, prefix, key_hash, scopes) VALUES (?, ?, ?, ?, ?)",
+		key.UserID, key.Name, key.Prefix, keyHash, strings.Join(key.Scopes, ","),
+	)
+	if err != nil {
+		return 0, mapSQLiteError(err)
+	}
+	return res.LastInsertId()
+}
+
+func (r *SQLiteRepository) ListAPIKeys(ctx context.Context) ([]model.APIKey, error) {
+	ctx, done := startQuery(ctx, "list_api_keys")
+	defer done()
+	ctx, cancel := r.opts.queryContext(ctx)
+	defer cancel()
+
+	rows, err := r.db.QueryContext(ctx, apiKeyQuery+" ORDER BY k.id")
+	if err != nil {
+		return nil, mapSQLiteError(err)
+	}
+	defer rows.Close()
+
+	keys := []model.APIKey{}
+	for rows.Next() {
+		k, _, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, mapSQLiteError(err)
+		}
+		keys = append(keys, k)
+	}
+	return keys, mapSQLiteError(rows.Err())
+}
+
+func (r *SQLiteRepository) GetAPIKeyByHash(ctx context.Context, keyHash string) (model.APIKey, error) {
+	ctx, done := startQuery(ctx, "get_api_key")
+	defer done()
+	ctx, cancel := r.opts.queryContext(ctx)
+	defer cancel()
+
+	k, disabled, err := scanAPIKey(r.db.QueryRowContext(ctx, apiKeyQuery+" WHERE k.key_hash = ? AND k.revoked_at IS NULL", keyHash))
+	if err != nil {
+		return model.APIKey{}, mapSQLiteError(err)
+	}
+	if disabled {
+		return model.APIKey{}, ErrDisabled
+	}
+	return k, nil
+}
+
+func (r *SQLiteRepository) RevokeAPIKey(ctx context.Context, id int64) error {
+	return r.execOne(ctx, "revoke_api_key",
+		"UPDATE api_keys SET revoked_at = CURRENT_TIMESTAMP WHERE id = ? AND revoked_at IS NULL", id)
+}
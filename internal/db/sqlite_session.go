@@ -0,0 +1,63 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"li-chat/internal/model"
+)
+
+func (r *SQLiteRepository) CreateSession(ctx context.Context, userID int64, expiresAt time.Time) (model.Session, error) {
+	ctx, done := startQuery(ctx, "create_session")
+	defer done()
+	ctx, cancel := r.opts.queryContext(ctx)
+	defer cancel()
+
+	s := model.Session{ID: generateSessionID(), UserID: userID, ExpiresAt: expiresAt.UTC()}
+	_, err := r.db.ExecContext(ctx, "INSERT INTO sessions(id, user_id, expires_at) VALUES (?, ?, ?)", s.ID, s.UserID, sqliteTime(s.ExpiresAt))
+	if err != nil {
+		return model.Session{}, mapSQLiteError(err)
+	}
+	return s, nil
+}
+
+func (r *SQLiteRepository) GetSession(ctx context.Context, id string) (model.Session, error) {
+	ctx, done := startQuery(ctx, "get_session")
+	defer done()
+	ctx, cancel := r.opts.queryContext(ctx)
+	defer cancel()
+
+	var s model.Session
+	var disabled bool
+	err := r.db.QueryRowContext(ctx, `
+		SELECT s.id, s.user_id, u.username, s.expires_at, u.disabled
+		FROM sessions s
+		JOIN users u ON u.id = s.user_id
+		WHERE s.id = ? AND s.expires_at > ?`,
+		id, sqliteTime(time.Now()),
+	).Scan(&s.ID, &s.UserID, &s.Username, &s.ExpiresAt, &disabled)
+	if err != nil {
+		return model.Session{}, mapSQLiteError(err)
+	}
+	if disabled {
+		return model.Session{}, ErrDisabled
+	}
+	return s, nil
+}
+
+func (r *SQLiteRepository) DeleteSession(ctx context.Context, id string) error {
+	return r.execOne(ctx, "delete_session", "DELETE FROM sessions WHERE id = ?", id)
+}
+
+func (r *SQLiteRepository) DeleteExpiredSessions(ctx context.Context, now time.Time) (int64, error) {
+	ctx, done := startQuery(ctx, "delete_expired_sessions")
+	defer done()
+	ctx, cancel := r.opts.queryContext(ctx)
+	defer cancel()
+
+	res, err := r.db.ExecContext(ctx, "DELETE FROM sessions WHERE expires_at <= ?", sqliteTime(now))
+	if err != nil {
+		return 0, mapSQLiteError(err)
+	}
+	return res.RowsAffected()
+}
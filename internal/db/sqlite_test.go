@@ -0,0 +1,24 @@
+package db_test
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"li-chat/internal/db"
+	"li-chat/internal/db/dbtest"
+)
+
+func TestSQLiteContract(t *testing.T) {
+	dbtest.RunStoreContract(t, func(t *testing.T) db.Store {
+		r, err := db.NewSQLiteRepository(filepath.Join(t.TempDir(), "chat.db"), db.Options{})
+		if err != nil {
+			t.Fatalf("NewSQLiteRepository: %v", err)
+		}
+		t.Cleanup(func() { r.Close() })
+		if _, err := r.MigrateUp(context.Background()); err != nil {
+			t.Fatalf("MigrateUp: %v", err)
+		}
+		return r
+	})
+}
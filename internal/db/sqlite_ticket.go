@@ -0,0 +1,48 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"li-chat/internal/model"
+)
+
+func (r *SQLiteRepository) CreateTicket(ctx context.Context, t model.WSTicket, ticketHash string) error {
+	ctx, done := startQuery(ctx, "create_ticket")
+	defer done()
+	ctx, cancel := r.opts.queryContext(ctx)
+	defer cancel()
+
+	if _, err := r.db.ExecContext(ctx, "DELETE FROM ws_tickets WHERE expires_at <= ?", sqliteTime(time.Now())); err != nil {
+		return mapSQLiteError(err)
+	}
+	_, err := r.db.ExecContext(ctx,
+		"INSERT INTO ws_tickets(ticket_hash, "+ticketColumns+") VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		ticketHash, t.UserID, t.Username, ticketScopes(t), t.Bot, t.ClientIP, sqliteTime(t.ExpiresAt), sqliteTokenExpiry(t), ticketAPIKey(t),
+	)
+	return mapSQLiteError(err)
+}
+
+func (r *SQLiteRepository) RedeemTicket(ctx context.Context, ticketHash string, now time.Time) (model.WSTicket, error) {
+	ctx, done := startQuery(ctx, "redeem_ticket")
+	defer done()
+	ctx, cancel := r.opts.queryContext(ctx)
+	defer cancel()
+
+	t, err := scanTicket(r.db.QueryRowContext(ctx,
+		"DELETE FROM ws_tickets WHERE ticket_hash = ? AND expires_at > ? RETURNING "+ticketColumns,
+		ticketHash, sqliteTime(now)))
+	if err != nil {
+		return model.WSTicket{}, mapSQLiteError(err)
+	}
+	return t, nil
+}
+
+// sqliteTokenExpiry is ticketTokenExpiry in the format sqliteTime writes
+func sqliteTokenExpiry(t model.WSTicket) *string {
+	if t.TokenExpiresAt.IsZero() {
+		return nil
+	}
+	expiresAt := sqliteTime(t.TokenExpiresAt)
+	return &expiresAt
+}
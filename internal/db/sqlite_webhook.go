@@ -0,0 +1,242 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+
+	"li-chat/internal/model"
+)
+
+func (r *SQLiteRepository) CreateWebhook(ctx context.Context, hook model.Webhook) (int64, error) {
+	ctx, done := startQuery(ctx, "create_webhook")
+	defer done()
+	ctx, cancel := r.opts.queryContext(ctx)
+	defer cancel()
+
+	res, err := r.db.ExecContext(ctx,
+		"INSERT INTO webhooks(url, secret, events) VALUES (?, ?, ?)",
+		hook.URL, hook.Secret, strings.Join(hook.Events, ","),
+	)
+	if err != nil {
+		return 0, mapSQLiteError(err)
+	}
+	return res.LastInsertId()
+}
+
+func (r *SQLiteRepository) ListWebhooks(ctx context.Context) ([]model.Webhook, error) {
+	ctx, done := startQuery(ctx, "list_webhooks")
+	defer done()
+	ctx, cancel := r.opts.queryContext(ctx)
+	defer cancel()
+
+	rows, err := r.db.QueryContext(ctx, "SELECT id, url, secret, events, active, created_at FROM webhooks ORDER BY id")
+	if err != nil {
+		return nil, mapSQLiteError(err)
+	}
+	defer rows.Close()
+
+	hooks := []model.Webhook{}
+	for rows.Next() {
+		h, err := scanWebhook(rows)
+		if err != nil {
+			return nil, mapSQLiteError(err)
+		}
+		hooks = append(hooks, h)
+	}
+	return hooks, mapSQLiteError(rows.Err())
+}
+
+func (r *SQLiteRepository) GetWebhook(ctx context.Context, id int64) (model.Webhook, error) {
+	ctx, done := startQuery(ctx, "get_webhook")
+	defer done()
+	ctx, cancel := r.opts.queryContext(ctx)
+	defer cancel()
+
+	h, err := scanWebhook(r.db.QueryRowContext(ctx, "SELECT id, url, secret, events, active, created_at FROM webhooks WHERE id = ?", id))
+	return h, mapSQLiteError(err)
+}
+
+func (r *SQLiteRepository) SetWebhookActive(ctx context.Context, id int64, active bool) error {
+	return r.execOne(ctx, "set_webhook_active", "UPDATE webhooks SET active = ? WHERE id = ?", active, id)
+}
+
+func (r *SQLiteRepository) DeleteWebhook(ctx context.Context, id int64) error {
+	return r.execOne(ctx, "delete_webhook", "DELETE FROM webhooks WHERE id = ?", id)
+}
+
+func (r *SQLiteRepository) EnqueueDeliveries(ctx context.Context, deliveries []model.WebhookDelivery) error {
+	ctx, done := startQuery(ctx, "enqueue_deliveries")
+	defer done()
+	ctx, cancel := r.opts.queryContext(ctx)
+	defer cancel()
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return mapSQLiteError(err)
+	}
+	defer tx.Rollback()
+	for _, d := range deliveries {
+		_, err := tx.ExecContext(ctx,
+			"INSERT INTO webhook_deliveries(webhook_id, event, payload, next_attempt_at) VALUES (?, ?, ?, ?)",
+			d.WebhookID, d.Event, string(d.Payload), sqliteTime(d.NextAttemptAt),
+		)
+		if err != nil {
+			return mapSQLiteError(err)
+		}
+	}
+	return mapSQLiteError(tx.Commit())
+}
+
+// ClaimDeliveries needs no row locking: SQLite runs one write at a time
+func (r *SQLiteRepository) ClaimDeliveries(ctx context.Context, now, leaseUntil time.Time, limit int) ([]model.WebhookDelivery, error) {
+	ctx, done := startQuery(ctx, "claim_deliveries")
+	defer done()
+	ctx, cancel := r.opts.queryContext(ctx)
+	defer cancel()
+
+	rows, err := r.db.QueryContext(ctx, `
+		UPDATE webhook_deliveries SET next_attempt_at = ?
+		WHERE id IN (
+			SELECT d.id FROM webhook_deliveries d
+			JOIN webhooks w ON w.id = d.webhook_id
+			WHERE NOT d.dead AND w.active AND d.next_attempt_at <= ?
+			ORDER BY d.id
+			LIMIT ?
+		)
+		RETURNING `+deliveryColumns,
+		sqliteTime(leaseUntil), sqliteTime(now), limit)
+	if err != nil {
+		return nil, mapSQLiteError(err)
+	}
+	deliveries, err := scanDeliveries(rows)
+	if err != nil {
+		return nil, err
+	}
+	sortDeliveries(deliveries)
+	return deliveries, nil
+}
+
+func (r *SQLiteRepository) CompleteDelivery(ctx context.Context, id int64) error {
+	return r.execOne(ctx, "complete_delivery", "DELETE FROM webhook_deliveries WHERE id = ?", id)
+}
+
+func (r *SQLiteRepository) FailDelivery(ctx context.Context, id int64, lastError string, next time.Time) error {
+	if next.IsZero() {
+		return r.execOne(ctx, "fail_delivery",
+			"UPDATE webhook_deliveries SET attempts = attempts + 1, last_error = ?, dead = 1 WHERE id = ?",
+			lastError, id)
+	}
+	return r.execOne(ctx, "fail_delivery",
+		"UPDATE webhook_deliveries SET attempts = attempts + 1, last_error = ?, next_attempt_at = ? WHERE id = ?",
+		lastError, sqliteTime(next), id)
+}
+
+func (r *SQLiteRepository) DeadDeliveries(ctx context.Context, limit int) ([]model.WebhookDelivery, error) {
+	ctx, done := startQuery(ctx, "dead_deliveries")
+	defer done()
+	ctx, cancel := r.opts.queryContext(ctx)
+	defer cancel()
+
+	rows, err := r.db.QueryContext(ctx,
+		"SELECT "+deliveryColumns+" FROM webhook_deliveries WHERE dead ORDER BY id DESC LIMIT ?", limit)
+	if err != nil {
+		return nil, mapSQLiteError(err)
+	}
+	return scanDeliveries(rows)
+}
+
+func (r *SQLiteRepository) RetryDelivery(ctx context.Context, id int64, now time.Time) error {
+	return r.execOne(ctx, "retry_delivery",
+		"UPDATE webhook_deliveries SET dead = 0, attempts = 0, next_attempt_at = ? WHERE id = ? AND dead",
+		sqliteTime(now), id)
+}
+
+// scanDeliveries reads and closes rows
+func scanDeliveries(rows *sql.Rows) ([]model.WebhookDelivery, error) {
+	defer rows.Close()
+	deliveries := []model.WebhookDelivery{}
+	for rows.Next() {
+		d, err := scanDelivery(rows)
+		if err != nil {
+			return nil, mapSQLiteError(err)
+		}
+		deliveries = append(deliveries, d)
+	}
+	return deliveries, mapSQLiteError(rows.Err())
+}
+
+// execOne runs a statement that must affect a row, returning ErrNotFound
+// when it affects none
+func (r *SQLiteRepository) execOne(ctx context.Context, op, query string, args ...any) error {
+	ctx, done := startQuery(ctx, op)
+	defer done()
+	ctx, cancel := r.opts.queryContext(ctx)
+	defer cancel()
+
+	res, err := r.db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return mapSQLiteError(err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *SQLiteRepository) CreateIncomingWebhook(ctx context.Context, hook model.IncomingWebhook, tokenHash string) (int64, error) {
+	ctx, done := startQuery(ctx, "create_incoming_webhook")
+	defer done()
+	ctx, cancel := r.opts.queryContext(ctx)
+	defer cancel()
+
+	res, err := r.db.ExecContext(ctx,
+		"INSERT INTO incoming_webhooks(name, token_hash) VALUES (?, ?)",
+		hook.Name, tokenHash,
+	)
+	if err != nil {
+		return 0, mapSQLiteError(err)
+	}
+	return res.LastInsertId()
+}
+
+func (r *SQLiteRepository) ListIncomingWebhooks(ctx context.Context) ([]model.IncomingWebhook, error) {
+	ctx, done := startQuery(ctx, "list_incoming_webhooks")
+	defer done()
+	ctx, cancel := r.opts.queryContext(ctx)
+	defer cancel()
+
+	rows, err := r.db.QueryContext(ctx, "SELECT id, name, created_at FROM incoming_webhooks ORDER BY id")
+	if err != nil {
+		return nil, mapSQLiteError(err)
+	}
+	defer rows.Close()
+
+	hooks := []model.IncomingWebhook{}
+	for rows.Next() {
+		var h model.IncomingWebhook
+		if err := rows.Scan(&h.ID, &h.Name, &h.CreatedAt); err != nil {
+			return nil, mapSQLiteError(err)
+		}
+		hooks = append(hooks, h)
+	}
+	return hooks, mapSQLiteError(rows.Err())
+}
+
+func (r *SQLiteRepository) GetIncomingWebhookByToken(ctx context.Context, tokenHash string) (model.IncomingWebhook, error) {
+	ctx, done := startQuery(ctx, "get_incoming_webhook")
+	defer done()
+	ctx, cancel := r.opts.queryContext(ctx)
+	defer cancel()
+
+	var h model.IncomingWebhook
+	err := r.db.QueryRowContext(ctx,
+		"SELECT id, name, created_at FROM incoming_webhooks WHERE token_hash = ?", tokenHash,
+	).Scan(&h.ID, &h.Name, &h.CreatedAt)
+	return h, mapSQLiteError(err)
+}
+
+func (r *SQLiteRepository) DeleteIncomingWebhook(ctx context.Context, id int64) error {
+	return r.execOne(ctx, "delete_incoming_webhook", "DELETE FROM incoming_webhooks WHERE id = ?", id)
+}
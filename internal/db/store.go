@@ -0,0 +1,190 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"li-chat/internal/model"
+)
+
+// UserStore manages user accounts and credentials
+type UserStore interface {
+	CreateUser(ctx context.Context, username, passwordHash string) error
+	// GetUserForLogin and GetUserByID return ErrDisabled for disabled accounts
+	GetUserForLogin(ctx context.Context, username string) (int64, string, error)
+	GetUserByID(ctx context.Context, userID int64) (string, error)
+	GetOrCreateUser(ctx context.Context, username string) (int64, error)
+}
+
+// AccountStore is the operator side of user management. Methods taking a
+// username return ErrNotFound when it doesn't exist.
+type AccountStore interface {
+	// ListUsers returns every account ordered by ID
+	ListUsers(ctx context.Context) ([]model.Account, error)
+	GetAccount(ctx context.Context, username string) (model.Account, error)
+	SetUserDisabled(ctx context.Context, username string, disabled bool) error
+	SetPassword(ctx context.Context, username, passwordHash string) error
+	SetRole(ctx context.Context, username, role string) error
+	// CreateBot adds a bot account, which has no password, and returns its
+	// ID; ErrUserExists if the name is taken
+	CreateBot(ctx context.Context, username string) (int64, error)
+}
+
+// MessageStore persists chat messages and serves history
+type MessageStore interface {
+	// SaveMessage stores a message and returns its ID
+	SaveMessage(ctx context.Context, userID int64, content string) (int64, error)
+	// SaveMessageWithDeliveries is SaveMessage that also queues the webhook
+	// deliveries outbox returns for the new ID, in the same transaction, so
+	// an event is never lost or sent for a message that wasn't saved.
+	// Deliveries for a webhook deleted in the meantime are left out.
+	// outbox runs inside the transaction, so it must not use the store.
+	SaveMessageWithDeliveries(ctx context.Context, userID int64, content string, outbox func(id int64) ([]model.WebhookDelivery, error)) (int64, error)
+	// GetMessages returns at most limit of the newest messages, oldest first
+	GetMessages(ctx context.Context, limit int) ([]model.Message, error)
+	// GetMessagesAfter returns at most limit of the messages with an ID
+	// greater than afterID, oldest first, so a client can page forward
+	GetMessagesAfter(ctx context.Context, afterID int64, limit int) ([]model.Message, error)
+}
+
+// MessageArchive bulk-reads and removes history for operators
+type MessageArchive interface {
+	// ExportMessages calls fn for every message created at or after since,
+	// oldest first, stopping at the first error fn returns
+	ExportMessages(ctx context.Context, since time.Time, fn func(model.MessageRecord) error) error
+	// PurgeMessages deletes messages created before before and reports how many
+	PurgeMessages(ctx context.Context, before time.Time) (int64, error)
+}
+
+// WebhookStore keeps outgoing webhook subscriptions and their delivery
+// outbox. Methods taking an ID return ErrNotFound when it doesn't exist.
+type WebhookStore interface {
+	// CreateWebhook stores hook, which starts active, and returns its ID
+	CreateWebhook(ctx context.Context, hook model.Webhook) (int64, error)
+	// ListWebhooks returns every webhook ordered by ID
+	ListWebhooks(ctx context.Context) ([]model.Webhook, error)
+	GetWebhook(ctx context.Context, id int64) (model.Webhook, error)
+	SetWebhookActive(ctx context.Context, id int64, active bool) error
+	// DeleteWebhook removes the webhook and everything queued for it
+	DeleteWebhook(ctx context.Context, id int64) error
+
+	// EnqueueDeliveries adds deliveries to the outbox, due at their
+	// NextAttemptAt
+	EnqueueDeliveries(ctx context.Context, deliveries []model.WebhookDelivery) error
+	// ClaimDeliveries returns at most limit deliveries that are due at now,
+	// oldest first, skipping dead ones and those of inactive webhooks. Their
+	// next attempt moves to leaseUntil, so no other worker takes them and a
+	// worker that dies mid-delivery only delays them.
+	ClaimDeliveries(ctx context.Context, now, leaseUntil time.Time, limit int) ([]model.WebhookDelivery, error)
+	// CompleteDelivery removes a delivered entry from the outbox
+	CompleteDelivery(ctx context.Context, id int64) error
+	// FailDelivery counts a failed attempt and schedules the next one at
+	// next; a zero next marks the delivery dead
+	FailDelivery(ctx context.Context, id int64, lastError string, next time.Time) error
+	// DeadDeliveries returns at most limit dead deliveries, newest first
+	DeadDeliveries(ctx context.Context, limit int) ([]model.WebhookDelivery, error)
+	// RetryDelivery makes a dead delivery due at now with its attempts
+	// reset. It returns ErrNotFound unless the delivery exists and is dead.
+	RetryDelivery(ctx context.Context, id int64, now time.Time) error
+}
+
+// IncomingWebhookStore keeps the tokens external systems post messages
+// with. Tokens are stored and looked up by hash only.
+type IncomingWebhookStore interface {
+	// CreateIncomingWebhook stores hook with tokenHash and returns its ID
+	CreateIncomingWebhook(ctx context.Context, hook model.IncomingWebhook, tokenHash string) (int64, error)
+	// ListIncomingWebhooks returns every incoming webhook ordered by ID
+	ListIncomingWebhooks(ctx context.Context) ([]model.IncomingWebhook, error)
+	// GetIncomingWebhookByToken returns ErrNotFound for an unknown token
+	GetIncomingWebhookByToken(ctx context.Context, tokenHash string) (model.IncomingWebhook, error)
+	// DeleteIncomingWebhook revokes the webhook; ErrNotFound if it doesn't
+	// exist
+	DeleteIncomingWebhook(ctx context.Context, id int64) error
+}
+
+// APIKeyStore keeps the API keys bots authenticate with. Keys are stored
+// and looked up by hash only.
+type APIKeyStore interface {
+	// CreateAPIKey stores key for key.UserID with keyHash and returns its ID
+	CreateAPIKey(ctx context.Context, key model.APIKey, keyHash string) (int64, error)
+	// ListAPIKeys returns every key, revoked ones included, ordered by ID
+	ListAPIKeys(ctx context.Context) ([]model.APIKey, error)
+	// GetAPIKeyByHash returns ErrNotFound for an unknown or revoked key and
+	// ErrDisabled when its account is disabled
+	GetAPIKeyByHash(ctx context.Context, keyHash string) (model.APIKey, error)
+	// RevokeAPIKey returns ErrNotFound unless the key exists and is still
+	// valid
+	RevokeAPIKey(ctx context.Context, id int64) error
+}
+
+// SessionStore keeps the server-side sessions behind browser cookies
+type SessionStore interface {
+	// CreateSession starts a session for userID with a new random ID
+	CreateSession(ctx context.Context, userID int64, expiresAt time.Time) (model.Session, error)
+	// GetSession returns ErrNotFound for an unknown or expired session and
+	// ErrDisabled when its account is disabled
+	GetSession(ctx context.Context, id string) (model.Session, error)
+	// DeleteSession returns ErrNotFound for an unknown session
+	DeleteSession(ctx context.Context, id string) error
+	// DeleteExpiredSessions removes sessions that ended before now and
+	// returns how many there were
+	DeleteExpiredSessions(ctx context.Context, now time.Time) (int64, error)
+}
+
+// TicketStore keeps the single-use tickets WebSocket clients connect
+// with. Tickets are stored and looked up by hash only.
+type TicketStore interface {
+	// CreateTicket stores t under ticketHash, dropping tickets that have
+	// expired
+	CreateTicket(ctx context.Context, t model.WSTicket, ticketHash string) error
+	// RedeemTicket deletes the ticket and returns it in one step, so only
+	// one caller can use it. It returns ErrNotFound for an unknown, used or
+	// expired ticket.
+	RedeemTicket(ctx context.Context, ticketHash string, now time.Time) (model.WSTicket, error)
+}
+
+// Store is the full storage surface the server depends on. Every backend
+// (pgx, SQLite, in-memory) implements it, reports failures with the sentinels
+// in errors.go and must pass dbtest.RunStoreContract.
+type Store interface {
+	UserStore
+	AccountStore
+	MessageStore
+	MessageArchive
+	WebhookStore
+	IncomingWebhookStore
+	APIKeyStore
+	SessionStore
+	TicketStore
+	// Close releases connections; the store must not be used afterwards
+	Close() error
+}
+
+// Pinger is implemented by backends that can check their connection
+type Pinger interface {
+	Ping(ctx context.Context) error
+}
+
+// Instrumented is implemented by backends with a connection pool worth
+// exporting as metrics
+type Instrumented interface {
+	Collector() prometheus.Collector
+}
+
+var (
+	_ Store = (*Repository)(nil)
+	_ Store = (*SQLiteRepository)(nil)
+	_ Store = (*MemoryStore)(nil)
+
+	_ Migrator = (*Repository)(nil)
+	_ Migrator = (*SQLiteRepository)(nil)
+
+	_ Pinger = (*Repository)(nil)
+	_ Pinger = (*SQLiteRepository)(nil)
+	_ Pinger = (*MemoryStore)(nil)
+
+	_ Instrumented = (*Repository)(nil)
+	_ Instrumented = (*SQLiteRepository)(nil)
+)
@@ -0,0 +1,88 @@
+package db
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"li-chat/internal/model"
+)
+
+// ticketColumns is the column list scanTicket expects
+const ticketColumns = "user_id, username, scopes, bot, client_ip, expires_at, token_expires_at, api_key_id"
+
+func scanTicket(row rowScanner) (model.WSTicket, error) {
+	var t model.WSTicket
+	var scopes *string
+	var tokenExpiresAt *time.Time
+	var apiKeyID *int64
+	err := row.Scan(&t.UserID, &t.Username, &scopes, &t.Bot, &t.ClientIP, &t.ExpiresAt, &tokenExpiresAt, &apiKeyID)
+	if scopes != nil {
+		t.Scopes = splitList(*scopes)
+	}
+	if tokenExpiresAt != nil {
+		t.TokenExpiresAt = *tokenExpiresAt
+	}
+	if apiKeyID != nil {
+		t.APIKeyID = *apiKeyID
+	}
+	return t, err
+}
+
+// ticketScopes is the scopes column for t: NULL when it has them all
+func ticketScopes(t model.WSTicket) *string {
+	if t.Scopes == nil {
+		return nil
+	}
+	scopes := strings.Join(t.Scopes, ",")
+	return &scopes
+}
+
+// ticketTokenExpiry is the token_expires_at column for t: NULL when the
+// credential doesn't expire
+func ticketTokenExpiry(t model.WSTicket) *time.Time {
+	if t.TokenExpiresAt.IsZero() {
+		return nil
+	}
+	expiresAt := t.TokenExpiresAt.UTC()
+	return &expiresAt
+}
+
+// ticketAPIKey is the api_key_id column for t: NULL for a login
+func ticketAPIKey(t model.WSTicket) *int64 {
+	if t.APIKeyID == 0 {
+		return nil
+	}
+	return &t.APIKeyID
+}
+
+func (r *Repository) CreateTicket(ctx context.Context, t model.WSTicket, ticketHash string) error {
+	ctx, done := startQuery(ctx, "create_ticket")
+	defer done()
+	ctx, cancel := r.opts.queryContext(ctx)
+	defer cancel()
+
+	if _, err := r.pool.Exec(ctx, "DELETE FROM ws_tickets WHERE expires_at <= $1", time.Now().UTC()); err != nil {
+		return mapPgError(err)
+	}
+	_, err := r.pool.Exec(ctx,
+		"INSERT INTO ws_tickets(ticket_hash, "+ticketColumns+") VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)",
+		ticketHash, t.UserID, t.Username, ticketScopes(t), t.Bot, t.ClientIP, t.ExpiresAt.UTC(), ticketTokenExpiry(t), ticketAPIKey(t),
+	)
+	return mapPgError(err)
+}
+
+func (r *Repository) RedeemTicket(ctx context.Context, ticketHash string, now time.Time) (model.WSTicket, error) {
+	ctx, done := startQuery(ctx, "redeem_ticket")
+	defer done()
+	ctx, cancel := r.opts.queryContext(ctx)
+	defer cancel()
+
+	t, err := scanTicket(r.pool.QueryRow(ctx,
+		"DELETE FROM ws_tickets WHERE ticket_hash = $1 AND expires_at > $2 RETURNING "+ticketColumns,
+		ticketHash, now.UTC()))
+	if err != nil {
+		return model.WSTicket{}, mapPgError(err)
+	}
+	return t, nil
+}
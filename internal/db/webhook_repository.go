@@ -0,0 +1,275 @@
+package db
+
+import (
+	"cmp"
+	"context"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+
+	"li-chat/internal/model"
+)
+
+// deliveryColumns is the column list scanDelivery expects
+const deliveryColumns = "id, webhook_id, event, payload, attempts, next_attempt_at, last_error, created_at"
+
+// rowScanner is satisfied by pgx.Row, pgx.Rows and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanDelivery(row rowScanner) (model.WebhookDelivery, error) {
+	var d model.WebhookDelivery
+	var payload string
+	err := row.Scan(&d.ID, &d.WebhookID, &d.Event, &payload, &d.Attempts, &d.NextAttemptAt, &d.LastError, &d.CreatedAt)
+	d.Payload = []byte(payload)
+	return d, err
+}
+
+func scanWebhook(row rowScanner) (model.Webhook, error) {
+	var h model.Webhook
+	var events string
+	err := row.Scan(&h.ID, &h.URL, &h.Secret, &events, &h.Active, &h.CreatedAt)
+	h.Events = splitList(events)
+	return h, err
+}
+
+// sortDeliveries puts claimed rows in ID order; RETURNING has none
+func sortDeliveries(deliveries []model.WebhookDelivery) {
+	slices.SortFunc(deliveries, func(a, b model.WebhookDelivery) int { return cmp.Compare(a.ID, b.ID) })
+}
+
+// splitList reads a comma-separated column such as webhooks.events
+func splitList(s string) []string {
+	if s == "" {
+		return []string{}
+	}
+	return strings.Split(s, ",")
+}
+
+func (r *Repository) CreateWebhook(ctx context.Context, hook model.Webhook) (int64, error) {
+	ctx, done := startQuery(ctx, "create_webhook")
+	defer done()
+	ctx, cancel := r.opts.queryContext(ctx)
+	defer cancel()
+
+	var id int64
+	err := r.pool.QueryRow(ctx,
+		"INSERT INTO webhooks(url, secret, events) VALUES ($1, $2, $3) RETURNING id",
+		hook.URL, hook.Secret, strings.Join(hook.Events, ","),
+	).Scan(&id)
+	return id, mapPgError(err)
+}
+
+func (r *Repository) ListWebhooks(ctx context.Context) ([]model.Webhook, error) {
+	ctx, done := startQuery(ctx, "list_webhooks")
+	defer done()
+	ctx, cancel := r.opts.queryContext(ctx)
+	defer cancel()
+
+	rows, err := r.pool.Query(ctx, "SELECT id, url, secret, events, active, created_at FROM webhooks ORDER BY id")
+	if err != nil {
+		return nil, mapPgError(err)
+	}
+	defer rows.Close()
+
+	hooks := []model.Webhook{}
+	for rows.Next() {
+		h, err := scanWebhook(rows)
+		if err != nil {
+			return nil, mapPgError(err)
+		}
+		hooks = append(hooks, h)
+	}
+	return hooks, mapPgError(rows.Err())
+}
+
+func (r *Repository) GetWebhook(ctx context.Context, id int64) (model.Webhook, error) {
+	ctx, done := startQuery(ctx, "get_webhook")
+	defer done()
+	ctx, cancel := r.opts.queryContext(ctx)
+	defer cancel()
+
+	h, err := scanWebhook(r.pool.QueryRow(ctx, "SELECT id, url, secret, events, active, created_at FROM webhooks WHERE id = $1", id))
+	return h, mapPgError(err)
+}
+
+func (r *Repository) SetWebhookActive(ctx context.Context, id int64, active bool) error {
+	return r.execOne(ctx, "set_webhook_active", "UPDATE webhooks SET active = $2 WHERE id = $1", id, active)
+}
+
+func (r *Repository) DeleteWebhook(ctx context.Context, id int64) error {
+	return r.execOne(ctx, "delete_webhook", "DELETE FROM webhooks WHERE id = $1", id)
+}
+
+func (r *Repository) EnqueueDeliveries(ctx context.Context, deliveries []model.WebhookDelivery) error {
+	ctx, done := startQuery(ctx, "enqueue_deliveries")
+	defer done()
+	ctx, cancel := r.opts.queryContext(ctx)
+	defer cancel()
+
+	batch := &pgx.Batch{}
+	for _, d := range deliveries {
+		batch.Queue(
+			"INSERT INTO webhook_deliveries(webhook_id, event, payload, next_attempt_at) VALUES ($1, $2, $3, $4)",
+			d.WebhookID, d.Event, string(d.Payload), d.NextAttemptAt.UTC(),
+		)
+	}
+	return mapPgError(r.pool.SendBatch(ctx, batch).Close())
+}
+
+func (r *Repository) ClaimDeliveries(ctx context.Context, now, leaseUntil time.Time, limit int) ([]model.WebhookDelivery, error) {
+	ctx, done := startQuery(ctx, "claim_deliveries")
+	defer done()
+	ctx, cancel := r.opts.queryContext(ctx)
+	defer cancel()
+
+	// SKIP LOCKED lets several servers share the outbox without waiting on
+	// each other's claims
+	rows, err := r.pool.Query(ctx, `
+		UPDATE webhook_deliveries SET next_attempt_at = $2
+		WHERE id IN (
+			SELECT d.id FROM webhook_deliveries d
+			JOIN webhooks w ON w.id = d.webhook_id
+			WHERE NOT d.dead AND w.active AND d.next_attempt_at <= $1
+			ORDER BY d.id
+			LIMIT $3
+			FOR UPDATE OF d SKIP LOCKED
+		)
+		RETURNING `+deliveryColumns,
+		now.UTC(), leaseUntil.UTC(), limit)
+	if err != nil {
+		return nil, mapPgError(err)
+	}
+	defer rows.Close()
+
+	deliveries := []model.WebhookDelivery{}
+	for rows.Next() {
+		d, err := scanDelivery(rows)
+		if err != nil {
+			return nil, mapPgError(err)
+		}
+		deliveries = append(deliveries, d)
+	}
+	sortDeliveries(deliveries)
+	return deliveries, mapPgError(rows.Err())
+}
+
+func (r *Repository) CompleteDelivery(ctx context.Context, id int64) error {
+	return r.execOne(ctx, "complete_delivery", "DELETE FROM webhook_deliveries WHERE id = $1", id)
+}
+
+func (r *Repository) FailDelivery(ctx context.Context, id int64, lastError string, next time.Time) error {
+	if next.IsZero() {
+		return r.execOne(ctx, "fail_delivery",
+			"UPDATE webhook_deliveries SET attempts = attempts + 1, last_error = $2, dead = TRUE WHERE id = $1",
+			id, lastError)
+	}
+	return r.execOne(ctx, "fail_delivery",
+		"UPDATE webhook_deliveries SET attempts = attempts + 1, last_error = $2, next_attempt_at = $3 WHERE id = $1",
+		id, lastError, next.UTC())
+}
+
+func (r *Repository) DeadDeliveries(ctx context.Context, limit int) ([]model.WebhookDelivery, error) {
+	ctx, done := startQuery(ctx, "dead_deliveries")
+	defer done()
+	ctx, cancel := r.opts.queryContext(ctx)
+	defer cancel()
+
+	rows, err := r.pool.Query(ctx,
+		"SELECT "+deliveryColumns+" FROM webhook_deliveries WHERE dead ORDER BY id DESC LIMIT $1", limit)
+	if err != nil {
+		return nil, mapPgError(err)
+	}
+	defer rows.Close()
+
+	deliveries := []model.WebhookDelivery{}
+	for rows.Next() {
+		d, err := scanDelivery(rows)
+		if err != nil {
+			return nil, mapPgError(err)
+		}
+		deliveries = append(deliveries, d)
+	}
+	return deliveries, mapPgError(rows.Err())
+}
+
+func (r *Repository) RetryDelivery(ctx context.Context, id int64, now time.Time) error {
+	return r.execOne(ctx, "retry_delivery",
+		"UPDATE webhook_deliveries SET dead = FALSE, attempts = 0, next_attempt_at = $2 WHERE id = $1 AND dead",
+		id, now.UTC())
+}
+
+// execOne runs a statement that must affect a row, returning ErrNotFound
+// when it affects none
+func (r *Repository) execOne(ctx context.Context, op, query string, args ...any) error {
+	ctx, done := startQuery(ctx, op)
+	defer done()
+	ctx, cancel := r.opts.queryContext(ctx)
+	defer cancel()
+
+	tag, err := r.pool.Exec(ctx, query, args...)
+	if err != nil {
+		return mapPgError(err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *Repository) CreateIncomingWebhook(ctx context.Context, hook model.IncomingWebhook, tokenHash string) (int64, error) {
+	ctx, done := startQuery(ctx, "create_incoming_webhook")
+	defer done()
+	ctx, cancel := r.opts.queryContext(ctx)
+	defer cancel()
+
+	var id int64
+	err := r.pool.QueryRow(ctx,
+		"INSERT INTO incoming_webhooks(name, token_hash) VALUES ($1, $2) RETURNING id",
+		hook.Name, tokenHash,
+	).Scan(&id)
+	return id, mapPgError(err)
+}
+
+func (r *Repository) ListIncomingWebhooks(ctx context.Context) ([]model.IncomingWebhook, error) {
+	ctx, done := startQuery(ctx, "list_incoming_webhooks")
+	defer done()
+	ctx, cancel := r.opts.queryContext(ctx)
+	defer cancel()
+
+	rows, err := r.pool.Query(ctx, "SELECT id, name, created_at FROM incoming_webhooks ORDER BY id")
+	if err != nil {
+		return nil, mapPgError(err)
+	}
+	defer rows.Close()
+
+	hooks := []model.IncomingWebhook{}
+	for rows.Next() {
+		var h model.IncomingWebhook
+		if err := rows.Scan(&h.ID, &h.Name, &h.CreatedAt); err != nil {
+			return nil, mapPgError(err)
+		}
+		hooks = append(hooks, h)
+	}
+	return hooks, mapPgError(rows.Err())
+}
+
+func (r *Repository) GetIncomingWebhookByToken(ctx context.Context, tokenHash string) (model.IncomingWebhook, error) {
+	ctx, done := startQuery(ctx, "get_incoming_webhook")
+	defer done()
+	ctx, cancel := r.opts.queryContext(ctx)
+	defer cancel()
+
+	var h model.IncomingWebhook
+	err := r.pool.QueryRow(ctx,
+		"SELECT id, name, created_at FROM incoming_webhooks WHERE token_hash = $1", tokenHash,
+	).Scan(&h.ID, &h.Name, &h.CreatedAt)
+	return h, mapPgError(err)
+}
+
+func (r *Repository) DeleteIncomingWebhook(ctx context.Context, id int64) error {
+	return r.execOne(ctx, "delete_incoming_webhook", "DELETE FROM incoming_webhooks WHERE id = $1", id)
+}
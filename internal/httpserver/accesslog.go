@@ -0,0 +1,161 @@
+package httpserver
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+
+	"li-chat/internal/metrics"
+	"li-chat/pkg/logger"
+)
+
+// RequestIDHeader carries the request ID in both directions
+const RequestIDHeader = "X-Request-ID"
+
+// requestInfo is filled in by inner handlers for the access log line, which
+// is written after they return and cannot see their contexts
+type requestInfo struct {
+	userID int64
+}
+
+type requestInfoKey struct{}
+
+// setRequestUser records the authenticated user for the access log
+func setRequestUser(ctx context.Context, userID int64) {
+	if info, ok := ctx.Value(requestInfoKey{}).(*requestInfo); ok {
+		info.userID = userID
+	}
+}
+
+// AccessLog assigns every request an ID, reusing a well-formed incoming
+// X-Request-ID so IDs can be followed across a proxy, echoes it in the
+// response and attaches it to the request context for logger.*Context.
+// One line is logged per request once it completes.
+func AccessLog(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		id := r.Header.Get(RequestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		w.Header().Set(RequestIDHeader, id)
+
+		info := &requestInfo{}
+		ctx := context.WithValue(r.Context(), requestInfoKey{}, info)
+		ctx = logger.WithContext(ctx, zap.String("request_id", id))
+
+		rec := &statusRecorder{ResponseWriter: w}
+		// ServeMux records the matched pattern on this request value
+		req := r.WithContext(ctx)
+		next.ServeHTTP(rec, req)
+
+		status := rec.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		latency := time.Since(start)
+		route := req.Pattern
+		if route == "" {
+			route = "unmatched"
+		}
+		span := trace.SpanFromContext(ctx)
+		span.SetName(r.Method + " " + route)
+		span.SetAttributes(attribute.String("http.route", route))
+		metrics.HTTPDuration.WithLabelValues(route, r.Method, strconv.Itoa(status)).Observe(latency.Seconds())
+		// Routes such as incoming webhooks carry a credential in the path
+		path := r.URL.Path
+		if token := req.PathValue("token"); token != "" {
+			path = strings.Replace(path, token, "[REDACTED]", 1)
+		}
+		fields := []zap.Field{
+			zap.String("method", r.Method),
+			zap.String("path", path),
+			zap.Int("status", status),
+			zap.Int64("bytes", rec.bytes),
+			zap.Duration("latency", latency),
+			zap.String("remote_addr", r.RemoteAddr),
+		}
+		if info.userID != 0 {
+			fields = append(fields, zap.Int64("user_id", info.userID))
+		}
+		logger.InfoContext(ctx, "HTTP request", fields...)
+	})
+}
+
+// validRequestID accepts short IDs of printable, non-space ASCII so a
+// client can't inject log-breaking content
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// statusRecorder captures the status code and body size. It passes
+// Hijack through so WebSocket upgrades keep working.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (s *statusRecorder) WriteHeader(code int) {
+	if s.status == 0 {
+		s.status = code
+	}
+	s.ResponseWriter.WriteHeader(code)
+}
+
+func (s *statusRecorder) Write(b []byte) (int, error) {
+	if s.status == 0 {
+		s.status = http.StatusOK
+	}
+	n, err := s.ResponseWriter.Write(b)
+	s.bytes += int64(n)
+	return n, err
+}
+
+func (s *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := s.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("response writer does not support hijacking")
+	}
+	if s.status == 0 {
+		s.status = http.StatusSwitchingProtocols
+	}
+	return hijacker.Hijack()
+}
+
+func (s *statusRecorder) Flush() {
+	if flusher, ok := s.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (s *statusRecorder) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
@@ -0,0 +1,145 @@
+package httpserver
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+
+	"go.uber.org/zap"
+
+	"li-chat/internal/auth"
+	"li-chat/internal/config"
+	"li-chat/internal/db"
+	"li-chat/internal/model"
+	"li-chat/pkg/logger"
+)
+
+// adminStore is what RequireAdmin needs from the db
+type adminStore interface {
+	db.AccountStore
+	authStore
+}
+
+// RequireAdmin is RequireScope(ScopeAdmin) restricted to the accounts in
+// admin.usernames and those given the admin role with `li-chat user set-role`
+func RequireAdmin(rt *config.Runtime, accounts adminStore, next http.HandlerFunc) http.HandlerFunc {
+	return RequireScope(accounts, auth.ScopeAdmin, func(w http.ResponseWriter, r *http.Request) {
+		identity, _ := auth.IdentityFromContext(r.Context())
+		if !isAdmin(r.Context(), rt, accounts, identity.Username) {
+			logger.WarnContext(r.Context(), "Admin endpoint refused", zap.String("username", identity.Username), zap.String("path", r.URL.Path))
+			auth.SendJSONResponse(w, http.StatusForbidden, auth.ErrorResponse("forbidden; admin only"))
+			return
+		}
+		next(w, r)
+	})
+}
+
+// RequireMetrics lets API keys with ScopeMetrics through, so a scraper
+// needn't hold an admin key, and logins only for admins
+func RequireMetrics(rt *config.Runtime, accounts adminStore, next http.HandlerFunc) http.HandlerFunc {
+	return RequireScope(accounts, auth.ScopeMetrics, func(w http.ResponseWriter, r *http.Request) {
+		identity, _ := auth.IdentityFromContext(r.Context())
+		if identity.Scopes == nil && !isAdmin(r.Context(), rt, accounts, identity.Username) {
+			auth.SendJSONResponse(w, http.StatusForbidden, auth.ErrorResponse("forbidden; use an API key with the metrics scope"))
+			return
+		}
+		next(w, r)
+	})
+}
+
+func isAdmin(ctx context.Context, rt *config.Runtime, accounts db.AccountStore, username string) bool {
+	if slices.Contains(rt.Current().Admin.Usernames, username) {
+		return true
+	}
+	account, err := accounts.GetAccount(ctx, username)
+	if err != nil {
+		if !errors.Is(err, db.ErrNotFound) {
+			logger.ErrorContext(ctx, "Failed to load account role", zap.String("username", username), zap.Error(err))
+		}
+		return false
+	}
+	return account.Role == model.RoleAdmin && !account.Disabled
+}
+
+type AdminHandler struct {
+	rt *config.Runtime
+}
+
+func NewAdminHandler(rt *config.Runtime) *AdminHandler {
+	return &AdminHandler{rt: rt}
+}
+
+type reloadResponse struct {
+	Message string `json:"message"`
+	config.ReloadResult
+}
+
+// Reload re-reads the configuration, the same as sending SIGHUP
+func (h *AdminHandler) Reload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		auth.SendJSONResponse(w, http.StatusMethodNotAllowed, auth.ErrorResponse("method not allowed"))
+		return
+	}
+
+	identity, _ := auth.IdentityFromContext(r.Context())
+	logger.InfoContext(r.Context(), "Configuration reload requested", zap.String("username", identity.Username))
+
+	result, err := h.rt.Reload()
+	if err != nil {
+		auth.SendJSONResponse(w, http.StatusBadRequest, auth.ErrorResponse(err.Error()))
+		return
+	}
+	auth.SendJSONResponse(w, http.StatusOK, reloadResponse{Message: "configuration reloaded", ReloadResult: result})
+}
+
+type logLevelRequest struct {
+	// Package selects a named logger; empty changes the default level
+	Package string `json:"package"`
+	// Level is debug, info, warn or error. With a package, empty removes
+	// its override.
+	Level string `json:"level"`
+}
+
+type logLevelResponse struct {
+	Level    string            `json:"level"`
+	Packages map[string]string `json:"packages"`
+}
+
+// LogLevel reports (GET) or changes (PUT) log levels without a restart.
+// Changes last until the next config reload or restart.
+func (h *AdminHandler) LogLevel(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		var req logLevelRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			auth.SendJSONResponse(w, http.StatusBadRequest, auth.ErrorResponse("invalid request body"))
+			return
+		}
+
+		var err error
+		switch {
+		case req.Package != "":
+			err = logger.SetPackageLevel(req.Package, req.Level)
+		default:
+			err = logger.SetLevel(req.Level)
+		}
+		if err != nil {
+			auth.SendJSONResponse(w, http.StatusBadRequest, auth.ErrorResponse("invalid level; use debug, info, warn or error"))
+			return
+		}
+
+		identity, _ := auth.IdentityFromContext(r.Context())
+		logger.InfoContext(r.Context(), "Log level changed",
+			zap.String("username", identity.Username),
+			zap.String("package", req.Package),
+			zap.String("level", req.Level))
+	default:
+		auth.SendJSONResponse(w, http.StatusMethodNotAllowed, auth.ErrorResponse("method not allowed"))
+		return
+	}
+
+	auth.SendJSONResponse(w, http.StatusOK, logLevelResponse{Level: logger.Level(), Packages: logger.PackageLevels()})
+}
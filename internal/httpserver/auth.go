@@ -1,9 +1,10 @@
 package httpserver
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"net/http"
-	"strings"
 
 	"li-chat/internal/auth"
 	"li-chat/internal/db"
@@ -11,10 +12,12 @@ import (
 )
 
 type AuthHandler struct {
-	repo *db.Repository
+	repo db.UserStore
+	// OnRegister, if set, is called after an account is created
+	OnRegister func(ctx context.Context, username string)
 }
 
-func NewAuthHandler(repo *db.Repository) *AuthHandler {
+func NewAuthHandler(repo db.UserStore) *AuthHandler {
 	return &AuthHandler{repo: repo}
 }
 
@@ -42,15 +45,14 @@ func (h *AuthHandler) Register(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = h.repo.CreateUser(c.Username, hash)
+	err = h.repo.CreateUser(r.Context(), c.Username, hash)
 	if err != nil {
-		if strings.Contains(err.Error(), "UNIQUE constraint failed") {
-			auth.SendJSONResponse(w, http.StatusConflict, auth.ErrorResponse("user with this username already exists"))
-			return
-		}
-		auth.SendJSONResponse(w, http.StatusInternalServerError, auth.ErrorResponse("failed to create user"))
+		sendStoreError(w, r, err, "failed to create user")
 		return
 	}
+	if h.OnRegister != nil {
+		h.OnRegister(r.Context(), c.Username)
+	}
 
 	response := auth.SuccessResponse(model.SuccessResponseStruct{
 		Username: c.Username,
@@ -75,7 +77,11 @@ func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	userID, hash, err := h.repo.GetUserForLogin(c.Username)
+	userID, hash, err := h.repo.GetUserForLogin(r.Context(), c.Username)
+	if err != nil && !errors.Is(err, db.ErrNotFound) {
+		sendStoreError(w, r, err, "server error")
+		return
+	}
 	if err != nil || auth.CheckPassword(hash, c.Password) != nil {
 		auth.SendJSONResponse(w, http.StatusUnauthorized, auth.ErrorResponse("invalid credentials"))
 		return
@@ -111,33 +117,20 @@ func (h *AuthHandler) Logout(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// JWT is stateless, logout is client-side (remove token from localStorage)
+	// JWT is stateless, logout is client-side; cookie sessions end with
+	// DELETE /session
 	auth.SendJSONResponse(w, http.StatusOK, auth.SuccessResponse(model.SuccessResponseStruct{Message: "logout success"}))
 }
 
 func (h *AuthHandler) WhoAmI(w http.ResponseWriter, r *http.Request) {
-	// Extract token from Authorization header
-	authHeader := r.Header.Get("Authorization")
-	if authHeader == "" {
+	identity, ok := auth.IdentityFromContext(r.Context())
+	if !ok {
 		auth.SendJSONResponse(w, http.StatusUnauthorized, auth.ErrorResponse("unauthorized; token missing?"))
 		return
 	}
 
-	// Bearer <token>
-	parts := strings.SplitN(authHeader, " ", 2)
-	if len(parts) != 2 || parts[0] != "Bearer" {
-		auth.SendJSONResponse(w, http.StatusUnauthorized, auth.ErrorResponse("unauthorized; not valid format?"))
-		return
-	}
-
-	claims, err := auth.ValidateToken(parts[1])
-	if err != nil {
-		auth.SendJSONResponse(w, http.StatusUnauthorized, auth.ErrorResponse("unauthorized; invalid token"))
-		return
-	}
-
 	response := auth.SuccessResponse(model.SuccessResponseStruct{
-		Username: claims.Username,
+		Username: identity.Username,
 		Message:  "here you are!",
 	})
 
@@ -177,9 +170,14 @@ func (h *AuthHandler) RefreshToken(w http.ResponseWriter, r *http.Request) {
 
 	// 3. Get user details needed for the new access token (e.g., username)
 	//    You might need to fetch this from your database using refreshClaims.UserID
-	username, err := h.repo.GetUserByID(refreshClaims.UserID) // Placeholder
+	username, err := h.repo.GetUserByID(r.Context(), refreshClaims.UserID)
+	if errors.Is(err, db.ErrNotFound) {
+		// The account was removed after the refresh token was issued
+		auth.SendJSONResponse(w, http.StatusUnauthorized, auth.ErrorResponse("user not found for refresh token."))
+		return
+	}
 	if err != nil {
-		auth.SendJSONResponse(w, http.StatusInternalServerError, auth.ErrorResponse("user not found for refresh token."))
+		sendStoreError(w, r, err, "failed to load user for refresh token.")
 		return
 	}
 
@@ -0,0 +1,167 @@
+package httpserver
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"go.uber.org/zap"
+
+	"li-chat/internal/auth"
+	"li-chat/internal/db"
+	"li-chat/internal/model"
+	"li-chat/internal/websocket"
+	"li-chat/pkg/logger"
+)
+
+// botStore is what BotHandler needs from the db
+type botStore interface {
+	db.AccountStore
+	db.APIKeyStore
+}
+
+// BotHandler manages bot accounts and the API keys they authenticate with.
+// Bots have no password; a key works as a bearer token wherever a JWT does,
+// limited to its scopes.
+type BotHandler struct {
+	store botStore
+	hub   *websocket.Hub
+}
+
+func NewBotHandler(store botStore, hub *websocket.Hub) *BotHandler {
+	return &BotHandler{store: store, hub: hub}
+}
+
+type createBotRequest struct {
+	Username string `json:"username"`
+}
+
+type createAPIKeyRequest struct {
+	// Username is the bot the key authenticates as
+	Username string `json:"username"`
+	// Name describes what the key is for
+	Name string `json:"name"`
+	// Scopes defaults to messages:read and messages:write
+	Scopes []string `json:"scopes"`
+}
+
+// CreateBot adds a bot account
+func (h *BotHandler) CreateBot(w http.ResponseWriter, r *http.Request) {
+	var req createBotRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		auth.SendJSONResponse(w, http.StatusBadRequest, auth.ErrorResponse("invalid request body"))
+		return
+	}
+	if req.Username == "" || strings.TrimSpace(req.Username) != req.Username {
+		auth.SendJSONResponse(w, http.StatusBadRequest, auth.ErrorResponse("username is required and cannot start or end with spaces"))
+		return
+	}
+	if _, err := h.store.CreateBot(r.Context(), req.Username); err != nil {
+		sendStoreError(w, r, err, "failed to create bot")
+		return
+	}
+	account, err := h.store.GetAccount(r.Context(), req.Username)
+	if err != nil {
+		sendStoreError(w, r, err, "failed to load bot")
+		return
+	}
+
+	identity, _ := auth.IdentityFromContext(r.Context())
+	logger.InfoContext(r.Context(), "Bot created", zap.String("username", identity.Username), zap.String("bot", account.Username))
+	auth.SendJSONResponse(w, http.StatusCreated, account)
+}
+
+// ListKeys serves every API key, revoked ones included, without the keys
+func (h *BotHandler) ListKeys(w http.ResponseWriter, r *http.Request) {
+	keys, err := h.store.ListAPIKeys(r.Context())
+	if err != nil {
+		sendStoreError(w, r, err, "failed to list API keys")
+		return
+	}
+	auth.SendJSONResponse(w, http.StatusOK, keys)
+}
+
+// CreateKey issues an API key for a bot. The response is the only time the
+// key is shown.
+func (h *BotHandler) CreateKey(w http.ResponseWriter, r *http.Request) {
+	var req createAPIKeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		auth.SendJSONResponse(w, http.StatusBadRequest, auth.ErrorResponse("invalid request body"))
+		return
+	}
+	if strings.TrimSpace(req.Name) == "" {
+		auth.SendJSONResponse(w, http.StatusBadRequest, auth.ErrorResponse("name is required"))
+		return
+	}
+	if len(req.Scopes) == 0 {
+		req.Scopes = []string{auth.ScopeRead, auth.ScopeWrite}
+	}
+	for _, scope := range req.Scopes {
+		if !slices.Contains(auth.Scopes, scope) {
+			auth.SendJSONResponse(w, http.StatusBadRequest, auth.ErrorResponse("unknown scope "+strconv.Quote(scope)+"; use messages:read, messages:write, admin or metrics"))
+			return
+		}
+	}
+
+	account, err := h.store.GetAccount(r.Context(), req.Username)
+	if errors.Is(err, db.ErrNotFound) {
+		auth.SendJSONResponse(w, http.StatusNotFound, auth.ErrorResponse("no such bot"))
+		return
+	}
+	if err != nil {
+		sendStoreError(w, r, err, "failed to load bot")
+		return
+	}
+	if !account.Bot {
+		auth.SendJSONResponse(w, http.StatusConflict, auth.ErrorResponse("API keys are only for bot accounts"))
+		return
+	}
+
+	key, prefix := auth.GenerateAPIKey()
+	created := model.APIKey{
+		UserID: account.ID,
+		Name:   req.Name,
+		Prefix: prefix,
+		Scopes: slices.Compact(slices.Sorted(slices.Values(req.Scopes))),
+	}
+	created.ID, err = h.store.CreateAPIKey(r.Context(), created, auth.HashToken(key))
+	if err != nil {
+		sendStoreError(w, r, err, "failed to create API key")
+		return
+	}
+	created, err = h.store.GetAPIKeyByHash(r.Context(), auth.HashToken(key))
+	if err != nil {
+		sendStoreError(w, r, err, "failed to load API key")
+		return
+	}
+	created.Key = key
+
+	identity, _ := auth.IdentityFromContext(r.Context())
+	logger.InfoContext(r.Context(), "API key created",
+		zap.String("username", identity.Username),
+		zap.Int64("api_key_id", created.ID),
+		zap.String("bot", created.Username),
+		zap.Strings("scopes", created.Scopes))
+	auth.SendJSONResponse(w, http.StatusCreated, created)
+}
+
+// RevokeKey stops an API key working at once, closing the WebSocket
+// connections opened with it. It stays listed.
+func (h *BotHandler) RevokeKey(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	if err := h.store.RevokeAPIKey(r.Context(), id); err != nil {
+		sendStoreError(w, r, err, "failed to revoke API key")
+		return
+	}
+	h.hub.DisconnectAPIKey(id)
+
+	identity, _ := auth.IdentityFromContext(r.Context())
+	logger.InfoContext(r.Context(), "API key revoked", zap.String("username", identity.Username), zap.Int64("api_key_id", id))
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,49 @@
+package httpserver
+
+import (
+	"net/http"
+	"strings"
+
+	"li-chat/internal/auth"
+	"li-chat/internal/config"
+)
+
+// corsMethods and corsHeaders are what the API's routes and clients use;
+// a preflight for anything else fails
+var (
+	corsMethods = strings.Join([]string{
+		http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete, http.MethodOptions,
+	}, ", ")
+	corsHeaders = strings.Join([]string{"Authorization", "Content-Type", auth.CSRFHeader, RequestIDHeader}, ", ")
+)
+
+// CORS lets browser apps on the configured origins call the API. The list
+// is read per request so a config reload takes effect immediately; with no
+// origins configured only same-origin requests work, as before.
+func CORS(rt *config.Runtime, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin != "" && originAllowed(rt.Current().Server.AllowedOrigins, origin) {
+			h := w.Header()
+			h.Set("Access-Control-Allow-Origin", origin)
+			h.Add("Vary", "Origin")
+			if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+				h.Set("Access-Control-Allow-Methods", corsMethods)
+				h.Set("Access-Control-Allow-Headers", corsHeaders)
+				h.Set("Access-Control-Max-Age", "600")
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func originAllowed(allowed []string, origin string) bool {
+	for _, a := range allowed {
+		if a == "*" || strings.EqualFold(strings.TrimSuffix(a, "/"), origin) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,54 @@
+package httpserver
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"go.uber.org/zap"
+
+	"li-chat/internal/auth"
+	"li-chat/internal/db"
+	"li-chat/pkg/logger"
+)
+
+// statusClientClosedRequest is nginx's status for a request the client
+// gave up on; nobody reads the response, but the access log shows why the
+// request ended
+const statusClientClosedRequest = 499
+
+// storeErrors maps db sentinel errors to client responses. Order matters:
+// the first match wins, so narrower errors come before the ones they wrap.
+var storeErrors = []struct {
+	target  error
+	status  int
+	message string
+}{
+	{db.ErrUserExists, http.StatusConflict, "user with this username already exists"},
+	{db.ErrConflict, http.StatusConflict, "request conflicts with existing data"},
+	{db.ErrNotFound, http.StatusNotFound, "not found"},
+	{db.ErrDisabled, http.StatusForbidden, "account disabled"},
+	{context.DeadlineExceeded, http.StatusServiceUnavailable, "database timed out, try again"},
+}
+
+// sendStoreError writes the response for an error returned by a db.Store.
+// Unrecognised errors become a 500 with the given fallback message so that
+// driver details never reach the client.
+func sendStoreError(w http.ResponseWriter, r *http.Request, err error, fallback string) {
+	// A client that disconnects mid-query cancels it; that's not a fault
+	if errors.Is(err, context.Canceled) {
+		logger.DebugContext(r.Context(), "Storage call cancelled by client", zap.Error(err))
+		w.WriteHeader(statusClientClosedRequest)
+		return
+	}
+
+	for _, m := range storeErrors {
+		if errors.Is(err, m.target) {
+			auth.SendJSONResponse(w, m.status, auth.ErrorResponse(m.message))
+			return
+		}
+	}
+
+	logger.ErrorContext(r.Context(), "Unhandled storage error", zap.Error(err))
+	auth.SendJSONResponse(w, http.StatusInternalServerError, auth.ErrorResponse(fallback))
+}
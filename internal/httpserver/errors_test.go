@@ -0,0 +1,54 @@
+package httpserver
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"li-chat/internal/db"
+	"li-chat/internal/model"
+)
+
+func TestSendStoreError(t *testing.T) {
+	tests := []struct {
+		name    string
+		err     error
+		status  int
+		message string
+	}{
+		{"not found", db.ErrNotFound, http.StatusNotFound, "not found"},
+		{"wrapped not found", fmt.Errorf("get user: %w", db.ErrNotFound), http.StatusNotFound, "not found"},
+		{"user exists", db.ErrUserExists, http.StatusConflict, "user with this username already exists"},
+		{"conflict", db.ErrConflict, http.StatusConflict, "request conflicts with existing data"},
+		{"disabled", db.ErrDisabled, http.StatusForbidden, "account disabled"},
+		{"timeout", fmt.Errorf("query: %w", context.DeadlineExceeded), http.StatusServiceUnavailable, "database timed out, try again"},
+		{"cancelled", fmt.Errorf("query: %w", context.Canceled), statusClientClosedRequest, ""},
+		{"driver error", errors.New("pq: connection reset by peer"), http.StatusInternalServerError, "fallback"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			sendStoreError(w, httptest.NewRequest(http.MethodGet, "/", nil), tt.err, "fallback")
+			if w.Code != tt.status {
+				t.Errorf("status = %d, want %d", w.Code, tt.status)
+			}
+			if tt.message == "" {
+				if w.Body.Len() != 0 {
+					t.Errorf("body = %q, want none", w.Body)
+				}
+				return
+			}
+			var body model.ErrorResponseStruct
+			if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+				t.Fatalf("decode body: %v", err)
+			}
+			if body.Error != tt.message {
+				t.Errorf("error = %q, want %q", body.Error, tt.message)
+			}
+		})
+	}
+}
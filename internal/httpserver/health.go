@@ -0,0 +1,162 @@
+package httpserver
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+
+	"li-chat/internal/auth"
+	"li-chat/internal/db"
+	"li-chat/internal/websocket"
+	"li-chat/pkg/logger"
+	"li-chat/pkg/version"
+)
+
+// checkTimeout bounds each readiness check so a hung dependency reports as
+// failed instead of hanging the probe
+const checkTimeout = 2 * time.Second
+
+// Health serves the liveness and readiness probes
+type Health struct {
+	hub     *websocket.Hub
+	repo    db.Store
+	started time.Time
+	// draining is set once shutdown begins so load balancers stop routing
+	// new clients here while existing ones finish
+	draining atomic.Bool
+}
+
+func NewHealth(hub *websocket.Hub, repo db.Store) *Health {
+	return &Health{hub: hub, repo: repo, started: time.Now()}
+}
+
+// SetDraining makes /readyz report not ready from now on
+func (h *Health) SetDraining() {
+	h.draining.Store(true)
+}
+
+type componentStatus struct {
+	Status    string  `json:"status"`
+	LatencyMS float64 `json:"latency_ms"`
+	Error     string  `json:"error,omitempty"`
+}
+
+type healthResponse struct {
+	Status     string                     `json:"status"`
+	Uptime     string                     `json:"uptime,omitempty"`
+	Version    *version.Info              `json:"version,omitempty"`
+	Components map[string]componentStatus `json:"components,omitempty"`
+}
+
+const (
+	statusOK          = "ok"
+	statusUnavailable = "unavailable"
+	statusSkipped     = "skipped"
+)
+
+// Live reports that the process is up and serving HTTP. It checks no
+// dependencies, so a database outage doesn't get the server restarted.
+func (h *Health) Live(w http.ResponseWriter, r *http.Request) {
+	auth.SendJSONResponse(w, http.StatusOK, healthResponse{
+		Status: statusOK,
+		Uptime: time.Since(h.started).Round(time.Second).String(),
+	})
+}
+
+// Ready reports whether this instance should receive traffic: the
+// database answers, the hub loop is responsive, the schema is current and
+// the server isn't shutting down
+func (h *Health) Ready(w http.ResponseWriter, r *http.Request) {
+	checks := map[string]func(context.Context) (string, error){
+		"database":   h.checkDatabase,
+		"hub":        h.checkHub,
+		"migrations": h.checkMigrations,
+	}
+
+	build := version.Get()
+	resp := healthResponse{Status: statusOK, Version: &build, Components: make(map[string]componentStatus, len(checks)+1)}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for name, check := range checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(r.Context(), checkTimeout)
+			defer cancel()
+
+			start := time.Now()
+			status, err := check(ctx)
+			result := componentStatus{Status: status, LatencyMS: float64(time.Since(start).Microseconds()) / 1000}
+			if err != nil {
+				result.Status = statusUnavailable
+				result.Error = err.Error()
+			}
+
+			mu.Lock()
+			resp.Components[name] = result
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	if h.draining.Load() {
+		resp.Components["server"] = componentStatus{Status: statusUnavailable, Error: "shutting down"}
+	} else {
+		resp.Components["server"] = componentStatus{Status: statusOK}
+	}
+
+	code := http.StatusOK
+	for name, c := range resp.Components {
+		if c.Status == statusUnavailable {
+			resp.Status = statusUnavailable
+			code = http.StatusServiceUnavailable
+			logger.DebugContext(r.Context(), "Readiness check failed", zap.String("component", name), zap.String("error", c.Error))
+		}
+	}
+	auth.SendJSONResponse(w, code, resp)
+}
+
+func (h *Health) checkDatabase(ctx context.Context) (string, error) {
+	pinger, ok := h.repo.(db.Pinger)
+	if !ok {
+		return statusSkipped, nil
+	}
+	return statusOK, pinger.Ping(ctx)
+}
+
+func (h *Health) checkHub(ctx context.Context) (string, error) {
+	return statusOK, h.hub.Ping(ctx)
+}
+
+// checkMigrations fails while the database schema is behind the binary
+func (h *Health) checkMigrations(ctx context.Context) (string, error) {
+	migrator, ok := h.repo.(db.Migrator)
+	if !ok {
+		return statusSkipped, nil
+	}
+	statuses, err := migrator.MigrationStatus(ctx)
+	if err != nil {
+		return statusOK, err
+	}
+	pending := 0
+	for _, s := range statuses {
+		if !s.Applied {
+			pending++
+		}
+	}
+	if pending > 0 {
+		return statusOK, fmt.Errorf("%d pending migration(s)", pending)
+	}
+	return statusOK, nil
+}
+
+// Version reports the running build so clients and operators can tell
+// which release is deployed
+func (h *Health) Version(w http.ResponseWriter, r *http.Request) {
+	auth.SendJSONResponse(w, http.StatusOK, version.Get())
+}
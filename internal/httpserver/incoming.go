@@ -0,0 +1,245 @@
+package httpserver
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"go.uber.org/zap"
+
+	"li-chat/internal/auth"
+	"li-chat/internal/config"
+	"li-chat/internal/db"
+	"li-chat/internal/model"
+	"li-chat/internal/websocket"
+	"li-chat/pkg/logger"
+)
+
+// incomingStore is what IncomingHandler needs from the db
+type incomingStore interface {
+	db.IncomingWebhookStore
+	db.UserStore
+	db.AccountStore
+}
+
+// IncomingHandler lets external systems such as CI post to the room over
+// HTTP at /hooks/{token}, and admins manage those URLs. The hub has a
+// single room, so every URL posts there.
+type IncomingHandler struct {
+	rt    *config.Runtime
+	hub   *websocket.Hub
+	store incomingStore
+}
+
+func NewIncomingHandler(rt *config.Runtime, hub *websocket.Hub, store incomingStore) *IncomingHandler {
+	return &IncomingHandler{rt: rt, hub: hub, store: store}
+}
+
+// incomingPayload is either the simple {"content": "..."} or a Slack
+// incoming webhook payload, of which text, blocks and attachments are
+// used. Slack's username and icon overrides are ignored: messages are
+// always from the webhook's integration user.
+type incomingPayload struct {
+	Content string `json:"content"`
+
+	Text   string `json:"text"`
+	Blocks []struct {
+		Text *struct {
+			Text string `json:"text"`
+		} `json:"text"`
+		Fields []struct {
+			Text string `json:"text"`
+		} `json:"fields"`
+	} `json:"blocks"`
+	Attachments []struct {
+		Fallback string `json:"fallback"`
+		Pretext  string `json:"pretext"`
+		Title    string `json:"title"`
+		Text     string `json:"text"`
+	} `json:"attachments"`
+}
+
+// message flattens the payload to chat text. As in Slack, text stands in
+// for the blocks when both are given.
+func (p incomingPayload) message() string {
+	if p.Content != "" {
+		return p.Content
+	}
+
+	var lines []string
+	add := func(s string) {
+		if s = strings.TrimSpace(s); s != "" {
+			lines = append(lines, s)
+		}
+	}
+	add(p.Text)
+	if p.Text == "" {
+		for _, b := range p.Blocks {
+			if b.Text != nil {
+				add(b.Text.Text)
+			}
+			for _, f := range b.Fields {
+				add(f.Text)
+			}
+		}
+	}
+	for _, a := range p.Attachments {
+		if a.Pretext == "" && a.Title == "" && a.Text == "" {
+			add(a.Fallback)
+			continue
+		}
+		add(a.Pretext)
+		add(a.Title)
+		add(a.Text)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Post saves and broadcasts one message as the webhook's integration user.
+// Slack senders may also use a form with the JSON in a payload field.
+// Messages aren't parsed for commands.
+func (h *IncomingHandler) Post(w http.ResponseWriter, r *http.Request) {
+	hook, err := h.store.GetIncomingWebhookByToken(r.Context(), auth.HashToken(r.PathValue("token")))
+	if err != nil {
+		if errors.Is(err, db.ErrNotFound) {
+			auth.SendJSONResponse(w, http.StatusNotFound, auth.ErrorResponse("unknown webhook"))
+			return
+		}
+		sendStoreError(w, r, err, "server error")
+		return
+	}
+	if account, err := h.store.GetAccount(r.Context(), hook.Name); err == nil && account.Disabled {
+		auth.SendJSONResponse(w, http.StatusForbidden, auth.ErrorResponse("integration user is disabled"))
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, h.rt.Current().WebSocket.MaxMessageBytes)
+	var p incomingPayload
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/x-www-form-urlencoded") {
+		// PostFormValue would hide a body over the limit as an empty form
+		if err = r.ParseForm(); err == nil {
+			err = json.Unmarshal([]byte(r.PostForm.Get("payload")), &p)
+		}
+	} else {
+		err = json.NewDecoder(r.Body).Decode(&p)
+	}
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		auth.SendJSONResponse(w, http.StatusRequestEntityTooLarge, auth.ErrorResponse("message is too large"))
+		return
+	}
+	if err != nil {
+		auth.SendJSONResponse(w, http.StatusBadRequest, auth.ErrorResponse("invalid request body"))
+		return
+	}
+	content := p.message()
+	if strings.TrimSpace(content) == "" {
+		auth.SendJSONResponse(w, http.StatusBadRequest, auth.ErrorResponse("content is required"))
+		return
+	}
+
+	logger.InfoContext(r.Context(), "Incoming webhook message", zap.Int64("incoming_webhook_id", hook.ID), zap.String("username", hook.Name))
+	msg, err := h.hub.Post(r.Context(), hook.Name, content)
+	if errors.Is(err, websocket.ErrMuted) {
+		auth.SendJSONResponse(w, http.StatusForbidden, auth.ErrorResponse(err.Error()))
+		return
+	}
+	if err != nil {
+		sendStoreError(w, r, err, "failed to post message")
+		return
+	}
+	auth.SendJSONResponse(w, http.StatusOK, msg)
+}
+
+type createIncomingRequest struct {
+	// Name is the integration user the messages come from
+	Name string `json:"name"`
+}
+
+type createIncomingResponse struct {
+	model.IncomingWebhook
+	// URL is relative to the server's address
+	URL string `json:"url"`
+}
+
+// List serves every incoming webhook, without tokens
+func (h *IncomingHandler) List(w http.ResponseWriter, r *http.Request) {
+	hooks, err := h.store.ListIncomingWebhooks(r.Context())
+	if err != nil {
+		sendStoreError(w, r, err, "failed to list incoming webhooks")
+		return
+	}
+	auth.SendJSONResponse(w, http.StatusOK, hooks)
+}
+
+// Create issues a URL posting as the named integration user. The user is
+// created now, as a bot, so nobody can register the name first. The name
+// can be an existing bot's but not a person's. The response is the only
+// time the token is shown.
+func (h *IncomingHandler) Create(w http.ResponseWriter, r *http.Request) {
+	var req createIncomingRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		auth.SendJSONResponse(w, http.StatusBadRequest, auth.ErrorResponse("invalid request body"))
+		return
+	}
+	if req.Name == "" || strings.TrimSpace(req.Name) != req.Name {
+		auth.SendJSONResponse(w, http.StatusBadRequest, auth.ErrorResponse("name is required and cannot start or end with spaces"))
+		return
+	}
+	_, err := h.store.CreateBot(r.Context(), req.Name)
+	switch {
+	case errors.Is(err, db.ErrUserExists):
+		// Reusing a bot is fine, but a person's account, with or without
+		// a password, must not start posting as a webhook
+		account, err := h.store.GetAccount(r.Context(), req.Name)
+		if err != nil {
+			sendStoreError(w, r, err, "server error")
+			return
+		}
+		if !account.Bot {
+			auth.SendJSONResponse(w, http.StatusConflict, auth.ErrorResponse("name belongs to a user account"))
+			return
+		}
+	case err != nil:
+		sendStoreError(w, r, err, "failed to create integration user")
+		return
+	}
+
+	token := auth.GenerateIncomingToken()
+	hook := model.IncomingWebhook{Name: req.Name}
+	hook.ID, err = h.store.CreateIncomingWebhook(r.Context(), hook, auth.HashToken(token))
+	if err != nil {
+		sendStoreError(w, r, err, "failed to create incoming webhook")
+		return
+	}
+	created, err := h.store.GetIncomingWebhookByToken(r.Context(), auth.HashToken(token))
+	if err != nil {
+		sendStoreError(w, r, err, "failed to load incoming webhook")
+		return
+	}
+	created.Token = token
+
+	identity, _ := auth.IdentityFromContext(r.Context())
+	logger.InfoContext(r.Context(), "Incoming webhook created",
+		zap.String("username", identity.Username),
+		zap.Int64("incoming_webhook_id", created.ID),
+		zap.String("name", created.Name))
+	auth.SendJSONResponse(w, http.StatusCreated, createIncomingResponse{IncomingWebhook: created, URL: "/hooks/" + token})
+}
+
+// Delete revokes an incoming webhook's URL
+func (h *IncomingHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	if err := h.store.DeleteIncomingWebhook(r.Context(), id); err != nil {
+		sendStoreError(w, r, err, "failed to delete incoming webhook")
+		return
+	}
+
+	identity, _ := auth.IdentityFromContext(r.Context())
+	logger.InfoContext(r.Context(), "Incoming webhook deleted", zap.String("username", identity.Username), zap.Int64("incoming_webhook_id", id))
+	w.WriteHeader(http.StatusNoContent)
+}
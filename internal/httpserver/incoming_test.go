@@ -0,0 +1,113 @@
+package httpserver
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"li-chat/internal/auth"
+	"li-chat/internal/config"
+	"li-chat/internal/db"
+)
+
+// createIncoming calls IncomingHandler.Create for name as an admin
+func createIncoming(h *IncomingHandler, name string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPost, "/api/admin/incoming-webhooks", strings.NewReader(`{"name":"`+name+`"}`))
+	r = r.WithContext(auth.WithIdentity(r.Context(), &auth.Identity{Username: "admin"}))
+	w := httptest.NewRecorder()
+	h.Create(w, r)
+	return w
+}
+
+func TestIncomingCreateName(t *testing.T) {
+	ctx := context.Background()
+	store := db.NewMemoryStore()
+	t.Cleanup(func() { store.Close() })
+	if err := store.CreateUser(ctx, "alice", "hash"); err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	// Created on first message, as for someone who only ever chatted
+	if _, err := store.GetOrCreateUser(ctx, "carol"); err != nil {
+		t.Fatalf("GetOrCreateUser: %v", err)
+	}
+	if _, err := store.CreateBot(ctx, "deploybot"); err != nil {
+		t.Fatalf("CreateBot: %v", err)
+	}
+	h := NewIncomingHandler(config.NewRuntime(config.Default(), nil), nil, store)
+
+	tests := []struct {
+		name string
+		want int
+	}{
+		{"alice", http.StatusConflict},
+		{"carol", http.StatusConflict},
+		{"deploybot", http.StatusCreated},
+		{"ci", http.StatusCreated},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := createIncoming(h, tt.name)
+			if w.Code != tt.want {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.want, w.Body)
+			}
+			account, err := store.GetAccount(ctx, tt.name)
+			if err != nil {
+				t.Fatalf("GetAccount: %v", err)
+			}
+			if account.Bot != (tt.want == http.StatusCreated) {
+				t.Errorf("account bot = %v after status %d", account.Bot, w.Code)
+			}
+			if w.Code != http.StatusCreated {
+				return
+			}
+			var resp createIncomingResponse
+			if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+				t.Fatalf("decode response: %v", err)
+			}
+			if !strings.HasPrefix(resp.URL, "/hooks/"+auth.IncomingTokenPrefix) {
+				t.Errorf("url %q doesn't end in a %s token", resp.URL, auth.IncomingTokenPrefix)
+			}
+		})
+	}
+}
+
+func TestIncomingPostTooLarge(t *testing.T) {
+	store := db.NewMemoryStore()
+	t.Cleanup(func() { store.Close() })
+	cfg := config.Default()
+	cfg.WebSocket.MaxMessageBytes = 64
+	h := NewIncomingHandler(config.NewRuntime(cfg, nil), nil, store)
+
+	w := createIncoming(h, "ci")
+	var hook createIncomingResponse
+	if err := json.NewDecoder(w.Body).Decode(&hook); err != nil {
+		t.Fatalf("create webhook: %d %s", w.Code, w.Body)
+	}
+	content := strings.Repeat("x", 100)
+	payload, _ := json.Marshal(map[string]string{"content": content})
+
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+	}{
+		{"json", "application/json", string(payload)},
+		{"form", "application/x-www-form-urlencoded", url.Values{"payload": {string(payload)}}.Encode()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, hook.URL, strings.NewReader(tt.body))
+			r.SetPathValue("token", strings.TrimPrefix(hook.URL, "/hooks/"))
+			r.Header.Set("Content-Type", tt.contentType)
+			w := httptest.NewRecorder()
+			h.Post(w, r)
+			if w.Code != http.StatusRequestEntityTooLarge {
+				t.Errorf("status = %d, want 413: %s", w.Code, w.Body)
+			}
+		})
+	}
+}
@@ -0,0 +1,238 @@
+package httpserver
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	"li-chat/internal/auth"
+	"li-chat/internal/db"
+	"li-chat/pkg/logger"
+)
+
+// tokenSource extracts a raw token from a request, returning "" when absent
+// and false when the credential is present but malformed
+type tokenSource func(r *http.Request) (string, bool)
+
+// bearerToken reads "Authorization: Bearer <token>"
+func bearerToken(r *http.Request) (string, bool) {
+	authHeader := r.Header.Get("Authorization")
+	if authHeader == "" {
+		return "", true
+	}
+
+	parts := strings.SplitN(authHeader, " ", 2)
+	if len(parts) != 2 || parts[0] != "Bearer" {
+		return "", false
+	}
+	return parts[1], true
+}
+
+// authStore is what the auth middleware needs from the db
+type authStore interface {
+	db.UserStore
+	db.APIKeyStore
+	db.SessionStore
+	db.TicketStore
+}
+
+// RequireAuth rejects requests without a valid bearer token or session
+// cookie and stores the caller's identity in the request context. The
+// token is a JWT from /login or a bot's API key.
+func RequireAuth(store authStore, next http.HandlerFunc) http.HandlerFunc {
+	return authMiddleware(store, next, false, bearerToken)
+}
+
+// RequireAuthWS is RequireAuth that also takes a ?ticket= from POST
+// /api/ws-ticket, since clients such as browsers can't set headers on a
+// WebSocket handshake. The ticket is redeemed here, so it works once.
+// Tokens and API keys are refused in the URL.
+func RequireAuthWS(store authStore, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		if query.Has("token") {
+			auth.SendJSONResponse(w, http.StatusUnauthorized, auth.ErrorResponse("unauthorized; tokens aren't accepted in the URL, connect with a ticket from POST /api/ws-ticket"))
+			return
+		}
+		ticket := query.Get("ticket")
+		if ticket == "" {
+			authMiddleware(store, next, false, bearerToken)(w, r)
+			return
+		}
+
+		t, err := store.RedeemTicket(r.Context(), auth.HashToken(ticket), time.Now())
+		switch {
+		case errors.Is(err, db.ErrNotFound):
+			auth.SendJSONResponse(w, http.StatusUnauthorized, auth.ErrorResponse("unauthorized; invalid, used or expired ticket"))
+			return
+		case err != nil:
+			sendStoreError(w, r, err, "server error")
+			return
+		}
+		if !t.TokenExpiresAt.IsZero() && !t.TokenExpiresAt.After(time.Now()) {
+			auth.SendJSONResponse(w, http.StatusUnauthorized, auth.ErrorResponse("unauthorized; the token the ticket was issued for has expired"))
+			return
+		}
+		if t.ClientIP != "" && t.ClientIP != remoteIP(r) {
+			logger.WarnContext(r.Context(), "WebSocket ticket redeemed from another address",
+				zap.String("username", t.Username),
+				zap.String("client_ip", t.ClientIP),
+				zap.String("remote_ip", remoteIP(r)))
+			auth.SendJSONResponse(w, http.StatusUnauthorized, auth.ErrorResponse("unauthorized; ticket was issued to another address"))
+			return
+		}
+		serveAs(&auth.Identity{UserID: t.UserID, Username: t.Username, Scopes: t.Scopes, Bot: t.Bot, ExpiresAt: t.TokenExpiresAt, APIKeyID: t.APIKeyID}, next, w, r)
+	}
+}
+
+// OptionalAuth attaches the identity when a token is present but lets
+// anonymous requests through. An invalid token is still rejected.
+func OptionalAuth(store authStore, next http.HandlerFunc) http.HandlerFunc {
+	return authMiddleware(store, next, true, bearerToken)
+}
+
+// RequireScope is RequireAuth for callers allowed scope. Only API keys
+// can lack one.
+func RequireScope(store authStore, scope string, next http.HandlerFunc) http.HandlerFunc {
+	return RequireAuth(store, func(w http.ResponseWriter, r *http.Request) {
+		identity, _ := auth.IdentityFromContext(r.Context())
+		if !identity.Allows(scope) {
+			auth.SendJSONResponse(w, http.StatusForbidden, auth.ErrorResponse("forbidden; API key lacks the "+scope+" scope"))
+			return
+		}
+		next(w, r)
+	})
+}
+
+func authMiddleware(store authStore, next http.HandlerFunc, optional bool, sources ...tokenSource) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var token string
+		for _, source := range sources {
+			t, ok := source(r)
+			if !ok {
+				auth.SendJSONResponse(w, http.StatusUnauthorized, auth.ErrorResponse("unauthorized; not valid format?"))
+				return
+			}
+			if t != "" {
+				token = t
+				break
+			}
+		}
+
+		var identity *auth.Identity
+		if token == "" {
+			cookie, err := r.Cookie(auth.SessionCookie)
+			if err != nil {
+				if optional {
+					next(w, r)
+					return
+				}
+				auth.SendJSONResponse(w, http.StatusUnauthorized, auth.ErrorResponse("unauthorized; token missing?"))
+				return
+			}
+			if identity = sessionIdentity(store, w, r, cookie.Value); identity == nil {
+				return
+			}
+		} else if auth.IsAPIKey(token) {
+			key, err := store.GetAPIKeyByHash(r.Context(), auth.HashToken(token))
+			switch {
+			case errors.Is(err, db.ErrNotFound):
+				auth.SendJSONResponse(w, http.StatusUnauthorized, auth.ErrorResponse("unauthorized; invalid or revoked API key"))
+				return
+			case errors.Is(err, db.ErrDisabled):
+				auth.SendJSONResponse(w, http.StatusForbidden, auth.ErrorResponse("account disabled"))
+				return
+			case err != nil:
+				sendStoreError(w, r, err, "server error")
+				return
+			}
+			identity = &auth.Identity{UserID: key.UserID, Username: key.Username, Scopes: key.Scopes, Bot: true, APIKeyID: key.ID}
+		} else {
+			claims, err := auth.ValidateToken(token)
+			if err == nil && claims.Username == "" {
+				err = errors.New("access token has no username")
+			}
+			if err != nil {
+				auth.SendJSONResponse(w, http.StatusUnauthorized, auth.ErrorResponse("unauthorized; invalid token"))
+				return
+			}
+			// Access tokens are stateless, so check that the account
+			// wasn't disabled or removed since this one was issued
+			_, err = store.GetUserByID(r.Context(), claims.UserID)
+			switch {
+			case errors.Is(err, db.ErrNotFound):
+				auth.SendJSONResponse(w, http.StatusUnauthorized, auth.ErrorResponse("unauthorized; user not found"))
+				return
+			case errors.Is(err, db.ErrDisabled):
+				auth.SendJSONResponse(w, http.StatusForbidden, auth.ErrorResponse("account disabled"))
+				return
+			case err != nil:
+				sendStoreError(w, r, err, "server error")
+				return
+			}
+			identity = &auth.Identity{UserID: claims.UserID, Username: claims.Username}
+			if claims.ExpiresAt != nil {
+				identity.ExpiresAt = claims.ExpiresAt.Time
+			}
+		}
+
+		serveAs(identity, next, w, r)
+	}
+}
+
+// serveAs calls next with identity attached to the request
+func serveAs(identity *auth.Identity, next http.HandlerFunc, w http.ResponseWriter, r *http.Request) {
+	ctx := auth.WithIdentity(r.Context(), identity)
+	ctx = logger.WithContext(ctx, zap.Int64("user_id", identity.UserID))
+	setRequestUser(ctx, identity.UserID)
+	next(w, r.WithContext(ctx))
+}
+
+// sessionIdentity authenticates by session cookie, answering the request
+// itself and returning nil when it can't. Browsers attach the cookie to
+// requests other sites make, so changes must carry the CSRF token and
+// WebSocket upgrades must come from this server's own pages.
+func sessionIdentity(store db.SessionStore, w http.ResponseWriter, r *http.Request, sessionID string) *auth.Identity {
+	session, err := store.GetSession(r.Context(), sessionID)
+	switch {
+	case errors.Is(err, db.ErrNotFound):
+		auth.SendJSONResponse(w, http.StatusUnauthorized, auth.ErrorResponse("unauthorized; session expired"))
+		return nil
+	case errors.Is(err, db.ErrDisabled):
+		auth.SendJSONResponse(w, http.StatusForbidden, auth.ErrorResponse("account disabled"))
+		return nil
+	case err != nil:
+		sendStoreError(w, r, err, "server error")
+		return nil
+	}
+
+	switch {
+	case isWebSocketUpgrade(r):
+		if !sameOrigin(r) {
+			auth.SendJSONResponse(w, http.StatusForbidden, auth.ErrorResponse("forbidden; a session cookie only works for WebSockets opened by this site"))
+			return nil
+		}
+	case r.Method != http.MethodGet && r.Method != http.MethodHead && r.Method != http.MethodOptions:
+		header := r.Header.Get(auth.CSRFHeader)
+		cookie, err := r.Cookie(auth.CSRFCookie)
+		if err != nil || header != cookie.Value || !auth.CheckCSRF(session.ID, header) {
+			auth.SendJSONResponse(w, http.StatusForbidden, auth.ErrorResponse("forbidden; missing or invalid CSRF token"))
+			return nil
+		}
+	}
+	return &auth.Identity{UserID: session.UserID, Username: session.Username, SessionID: session.ID, ExpiresAt: session.ExpiresAt}
+}
+
+func isWebSocketUpgrade(r *http.Request) bool {
+	return strings.EqualFold(r.Header.Get("Upgrade"), "websocket")
+}
+
+// sameOrigin reports whether the Origin header names the host r was sent to
+func sameOrigin(r *http.Request) bool {
+	u, err := url.Parse(r.Header.Get("Origin"))
+	return err == nil && u.Host != "" && strings.EqualFold(u.Host, r.Host)
+}
@@ -0,0 +1,375 @@
+package httpserver
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"li-chat/internal/auth"
+	"li-chat/internal/config"
+	"li-chat/internal/db"
+	"li-chat/internal/model"
+)
+
+// newAuthStore returns a memory store with the account alice
+func newAuthStore(t *testing.T) (*db.MemoryStore, int64) {
+	t.Helper()
+	store := db.NewMemoryStore()
+	t.Cleanup(func() { store.Close() })
+	ctx := context.Background()
+	if err := store.CreateUser(ctx, "alice", "unused-hash"); err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	userID, _, err := store.GetUserForLogin(ctx, "alice")
+	if err != nil {
+		t.Fatalf("GetUserForLogin: %v", err)
+	}
+	return store, userID
+}
+
+// newSession starts a session for userID and returns its ID
+func newSession(t *testing.T, store db.SessionStore, userID int64) string {
+	t.Helper()
+	session, err := store.CreateSession(context.Background(), userID, time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatalf("CreateSession: %v", err)
+	}
+	return session.ID
+}
+
+// whoami answers with the username of the authenticated caller
+func whoami(w http.ResponseWriter, r *http.Request) {
+	identity, _ := auth.IdentityFromContext(r.Context())
+	w.Write([]byte(identity.Username))
+}
+
+// withSession adds the session cookie, and the CSRF cookie and header
+// when they aren't ""
+func withSession(r *http.Request, sessionID, csrfCookie, csrfHeader string) *http.Request {
+	r.AddCookie(&http.Cookie{Name: auth.SessionCookie, Value: sessionID})
+	if csrfCookie != "" {
+		r.AddCookie(&http.Cookie{Name: auth.CSRFCookie, Value: csrfCookie})
+	}
+	if csrfHeader != "" {
+		r.Header.Set(auth.CSRFHeader, csrfHeader)
+	}
+	return r
+}
+
+func TestSessionCSRF(t *testing.T) {
+	store, userID := newAuthStore(t)
+	sessionID := newSession(t, store, userID)
+	csrf := auth.CSRFToken(sessionID)
+	other := auth.CSRFToken("sess_someoneelse")
+	handler := RequireAuth(store, whoami)
+
+	tests := []struct {
+		name   string
+		method string
+		cookie string
+		header string
+		want   int
+	}{
+		{"GET needs no token", http.MethodGet, "", "", http.StatusOK},
+		{"HEAD needs no token", http.MethodHead, "", "", http.StatusOK},
+		{"POST without token", http.MethodPost, "", "", http.StatusForbidden},
+		{"POST with cookie only", http.MethodPost, csrf, "", http.StatusForbidden},
+		{"POST with header only", http.MethodPost, "", csrf, http.StatusForbidden},
+		{"POST with mismatched header", http.MethodPost, csrf, other, http.StatusForbidden},
+		{"POST with another session's token", http.MethodPost, other, other, http.StatusForbidden},
+		{"POST with token", http.MethodPost, csrf, csrf, http.StatusOK},
+		{"PUT without token", http.MethodPut, "", "", http.StatusForbidden},
+		{"PATCH with token", http.MethodPatch, csrf, csrf, http.StatusOK},
+		{"DELETE without token", http.MethodDelete, "", "", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := withSession(httptest.NewRequest(tt.method, "/api/thing", nil), sessionID, tt.cookie, tt.header)
+			w := httptest.NewRecorder()
+			handler(w, r)
+			if w.Code != tt.want {
+				t.Errorf("status = %d, want %d: %s", w.Code, tt.want, w.Body)
+			}
+		})
+	}
+}
+
+func TestBearerSkipsCSRF(t *testing.T) {
+	store, userID := newAuthStore(t)
+	token, err := auth.GenerateToken(userID, "alice")
+	if err != nil {
+		t.Fatalf("GenerateToken: %v", err)
+	}
+
+	r := httptest.NewRequest(http.MethodPost, "/api/thing", nil)
+	r.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	RequireAuth(store, whoami)(w, r)
+	if w.Code != http.StatusOK || w.Body.String() != "alice" {
+		t.Errorf("got %d %q, want 200 alice", w.Code, w.Body)
+	}
+}
+
+func TestSessionWebSocketOrigin(t *testing.T) {
+	store, userID := newAuthStore(t)
+	sessionID := newSession(t, store, userID)
+	handler := RequireAuthWS(store, whoami)
+
+	tests := []struct {
+		name   string
+		origin string
+		want   int
+	}{
+		{"same origin", "http://chat.example.com", http.StatusOK},
+		{"same origin, other case", "http://Chat.Example.com", http.StatusOK},
+		{"other site", "http://evil.example.net", http.StatusForbidden},
+		{"other port", "http://chat.example.com:8443", http.StatusForbidden},
+		{"no origin", "", http.StatusForbidden},
+		{"opaque origin", "null", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := withSession(httptest.NewRequest(http.MethodGet, "http://chat.example.com/ws", nil), sessionID, "", "")
+			r.Header.Set("Connection", "Upgrade")
+			r.Header.Set("Upgrade", "websocket")
+			if tt.origin != "" {
+				r.Header.Set("Origin", tt.origin)
+			}
+			w := httptest.NewRecorder()
+			handler(w, r)
+			if w.Code != tt.want {
+				t.Errorf("status = %d, want %d: %s", w.Code, tt.want, w.Body)
+			}
+		})
+	}
+}
+
+func TestSessionLogout(t *testing.T) {
+	store, userID := newAuthStore(t)
+	sessionID := newSession(t, store, userID)
+	csrf := auth.CSRFToken(sessionID)
+	logout := RequireAuth(store, NewSessionHandler(config.NewRuntime(config.Default(), nil), store).Delete)
+	get := func() int {
+		w := httptest.NewRecorder()
+		RequireAuth(store, whoami)(w, withSession(httptest.NewRequest(http.MethodGet, "/api/thing", nil), sessionID, "", ""))
+		return w.Code
+	}
+
+	w := httptest.NewRecorder()
+	logout(w, withSession(httptest.NewRequest(http.MethodDelete, "/session", nil), sessionID, "", ""))
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("logout without CSRF token: status = %d, want 403", w.Code)
+	}
+	if code := get(); code != http.StatusOK {
+		t.Fatalf("session after refused logout: status = %d, want 200", code)
+	}
+
+	w = httptest.NewRecorder()
+	logout(w, withSession(httptest.NewRequest(http.MethodDelete, "/session", nil), sessionID, csrf, csrf))
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("logout: status = %d, want 204: %s", w.Code, w.Body)
+	}
+	for _, c := range w.Result().Cookies() {
+		if (c.Name == auth.SessionCookie || c.Name == auth.CSRFCookie) && (c.Value != "" || c.MaxAge >= 0) {
+			t.Errorf("cookie %s not cleared: %+v", c.Name, c)
+		}
+	}
+	if code := get(); code != http.StatusUnauthorized {
+		t.Errorf("session after logout: status = %d, want 401", code)
+	}
+}
+
+func TestSessionRevoked(t *testing.T) {
+	tests := []struct {
+		name   string
+		revoke func(t *testing.T, store *db.MemoryStore, sessionID string)
+		want   int
+	}{
+		{"deleted", func(t *testing.T, store *db.MemoryStore, sessionID string) {
+			if err := store.DeleteSession(context.Background(), sessionID); err != nil {
+				t.Fatalf("DeleteSession: %v", err)
+			}
+		}, http.StatusUnauthorized},
+		{"expired", func(t *testing.T, store *db.MemoryStore, sessionID string) {
+			if _, err := store.DeleteExpiredSessions(context.Background(), time.Now().Add(2*time.Hour)); err != nil {
+				t.Fatalf("DeleteExpiredSessions: %v", err)
+			}
+		}, http.StatusUnauthorized},
+		{"account disabled", func(t *testing.T, store *db.MemoryStore, sessionID string) {
+			if err := store.SetUserDisabled(context.Background(), "alice", true); err != nil {
+				t.Fatalf("SetUserDisabled: %v", err)
+			}
+		}, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store, userID := newAuthStore(t)
+			sessionID := newSession(t, store, userID)
+			tt.revoke(t, store, sessionID)
+
+			w := httptest.NewRecorder()
+			RequireAuth(store, whoami)(w, withSession(httptest.NewRequest(http.MethodGet, "/api/thing", nil), sessionID, "", ""))
+			if w.Code != tt.want {
+				t.Errorf("status = %d, want %d: %s", w.Code, tt.want, w.Body)
+			}
+		})
+	}
+}
+
+// issueTicket has TicketHandler mint a ticket for alice's bearer token,
+// as requested from remoteAddr
+func issueTicket(t *testing.T, rt *config.Runtime, store *db.MemoryStore, userID int64, remoteAddr string) ticketResponse {
+	t.Helper()
+	token, err := auth.GenerateToken(userID, "alice")
+	if err != nil {
+		t.Fatalf("GenerateToken: %v", err)
+	}
+	r := httptest.NewRequest(http.MethodPost, "/api/ws-ticket", nil)
+	r.RemoteAddr = remoteAddr
+	r.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	RequireAuth(store, NewTicketHandler(rt, store).Create)(w, r)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("POST /api/ws-ticket: status = %d: %s", w.Code, w.Body)
+	}
+	var resp ticketResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("decode ticket: %v", err)
+	}
+	return resp
+}
+
+// connectWS sends an upgrade request for /ws with query through
+// RequireAuthWS from remoteAddr
+func connectWS(store *db.MemoryStore, query, remoteAddr string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodGet, "/ws?"+query, nil)
+	r.RemoteAddr = remoteAddr
+	r.Header.Set("Connection", "Upgrade")
+	r.Header.Set("Upgrade", "websocket")
+	w := httptest.NewRecorder()
+	RequireAuthWS(store, whoami)(w, r)
+	return w
+}
+
+func TestTicketSingleUse(t *testing.T) {
+	store, userID := newAuthStore(t)
+	rt := config.NewRuntime(config.Default(), nil)
+	ticket := issueTicket(t, rt, store, userID, "192.0.2.1:1000")
+
+	if !strings.HasPrefix(ticket.Ticket, auth.TicketPrefix) {
+		t.Errorf("ticket %q lacks the %s prefix", ticket.Ticket, auth.TicketPrefix)
+	}
+	if left := time.Until(ticket.ExpiresAt); left <= 0 || left > 30*time.Second {
+		t.Errorf("ticket expires in %v, want within 30s", left)
+	}
+
+	w := connectWS(store, "ticket="+ticket.Ticket, "192.0.2.1:1001")
+	if w.Code != http.StatusOK || w.Body.String() != "alice" {
+		t.Fatalf("first use: got %d %q, want 200 alice", w.Code, w.Body)
+	}
+	if w := connectWS(store, "ticket="+ticket.Ticket, "192.0.2.1:1001"); w.Code != http.StatusUnauthorized {
+		t.Errorf("second use: status = %d, want 401", w.Code)
+	}
+}
+
+func TestTicketRefused(t *testing.T) {
+	store, userID := newAuthStore(t)
+	ctx := context.Background()
+	token, err := auth.GenerateToken(userID, "alice")
+	if err != nil {
+		t.Fatalf("GenerateToken: %v", err)
+	}
+	// stored adds a ticket directly, as though minted at some other time
+	stored := func(t *testing.T, ticket model.WSTicket) string {
+		t.Helper()
+		ticket.UserID, ticket.Username = userID, "alice"
+		raw := auth.GenerateTicket()
+		if err := store.CreateTicket(ctx, ticket, auth.HashToken(raw)); err != nil {
+			t.Fatalf("CreateTicket: %v", err)
+		}
+		return raw
+	}
+
+	tests := []struct {
+		name  string
+		query func(t *testing.T) string
+	}{
+		{"token in the URL", func(t *testing.T) string { return "token=" + token }},
+		{"token next to a ticket", func(t *testing.T) string {
+			return "token=" + token + "&ticket=" + stored(t, model.WSTicket{ExpiresAt: time.Now().Add(auth.TicketTTL)})
+		}},
+		{"unknown ticket", func(t *testing.T) string { return "ticket=" + auth.GenerateTicket() }},
+		{"expired ticket", func(t *testing.T) string {
+			return "ticket=" + stored(t, model.WSTicket{ExpiresAt: time.Now().Add(-time.Second)})
+		}},
+		{"expired token behind the ticket", func(t *testing.T) string {
+			return "ticket=" + stored(t, model.WSTicket{ExpiresAt: time.Now().Add(auth.TicketTTL), TokenExpiresAt: time.Now().Add(-time.Second)})
+		}},
+		{"raw ticket hash", func(t *testing.T) string {
+			raw := stored(t, model.WSTicket{ExpiresAt: time.Now().Add(auth.TicketTTL)})
+			return "ticket=" + auth.HashToken(raw)
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := connectWS(store, tt.query(t), "192.0.2.1:1000")
+			if w.Code != http.StatusUnauthorized {
+				t.Errorf("status = %d, want 401: %s", w.Code, w.Body)
+			}
+		})
+	}
+}
+
+func TestTicketBindIP(t *testing.T) {
+	tests := []struct {
+		name   string
+		bind   bool
+		redeem string
+		want   int
+	}{
+		{"bound, same address", true, "192.0.2.1:2000", http.StatusOK},
+		{"bound, other address", true, "198.51.100.7:1000", http.StatusUnauthorized},
+		{"unbound, other address", false, "198.51.100.7:1000", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store, userID := newAuthStore(t)
+			cfg := config.Default()
+			cfg.WebSocket.TicketBindIP = tt.bind
+			ticket := issueTicket(t, config.NewRuntime(cfg, nil), store, userID, "192.0.2.1:1000")
+
+			w := connectWS(store, "ticket="+ticket.Ticket, tt.redeem)
+			if w.Code != tt.want {
+				t.Errorf("status = %d, want %d: %s", w.Code, tt.want, w.Body)
+			}
+		})
+	}
+}
+
+func TestRefreshTokenRefused(t *testing.T) {
+	store, userID := newAuthStore(t)
+	refresh, err := auth.GenerateRefreshToken(userID)
+	if err != nil {
+		t.Fatalf("GenerateRefreshToken: %v", err)
+	}
+	nameless, err := auth.GenerateToken(userID, "")
+	if err != nil {
+		t.Fatalf("GenerateToken: %v", err)
+	}
+
+	for name, token := range map[string]string{"refresh token": refresh, "access token without a username": nameless} {
+		t.Run(name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/api/thing", nil)
+			r.Header.Set("Authorization", "Bearer "+token)
+			w := httptest.NewRecorder()
+			RequireAuth(store, whoami)(w, r)
+			if w.Code != http.StatusUnauthorized {
+				t.Errorf("status = %d, want 401", w.Code)
+			}
+		})
+	}
+}
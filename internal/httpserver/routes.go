@@ -3,68 +3,125 @@ package httpserver
 import (
 	"encoding/json"
 	"net/http"
-	"strings"
+	"strconv"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
 
 	"li-chat/internal/auth"
+	"li-chat/internal/config"
 	"li-chat/internal/db"
+	"li-chat/internal/metrics"
+	"li-chat/internal/model"
+	"li-chat/internal/webhook"
 	"li-chat/internal/websocket"
 )
 
-func NewRouter(hub *websocket.Hub, repo *db.Repository) http.Handler {
+func NewRouter(rt *config.Runtime, hub *websocket.Hub, repo db.Store, health *Health, dispatcher *webhook.Dispatcher) http.Handler {
 	mux := http.NewServeMux()
 	authHandler := NewAuthHandler(repo)
+	authHandler.OnRegister = dispatcher.UserJoined
+	adminHandler := NewAdminHandler(rt)
+	webhookHandler := NewWebhookHandler(repo, dispatcher)
+	incomingHandler := NewIncomingHandler(rt, hub, repo)
+	botHandler := NewBotHandler(repo, hub)
+	sessionHandler := NewSessionHandler(rt, repo)
+	ticketHandler := NewTicketHandler(rt, repo)
 
-	mux.HandleFunc("/ws", websocket.HandleWS(hub, repo))
+	mux.HandleFunc("/ws", RequireAuthWS(repo, websocket.HandleWS(hub, repo)))
 
 	mux.HandleFunc("/health", func(w http.ResponseWriter, _ *http.Request) {
 		w.WriteHeader(http.StatusOK)
 		w.Write([]byte("OK"))
 	})
 
-	mux.HandleFunc("/register", authHandler.Register)
+	mux.HandleFunc("GET /healthz", health.Live)
+	mux.HandleFunc("GET /readyz", health.Ready)
+	mux.HandleFunc("GET /api/version", health.Version)
+
+	mux.HandleFunc("/register", func(w http.ResponseWriter, r *http.Request) {
+		if !rt.Current().Features.Registration {
+			auth.SendJSONResponse(w, http.StatusForbidden, auth.ErrorResponse("registration is disabled"))
+			return
+		}
+		authHandler.Register(w, r)
+	})
 	mux.HandleFunc("/login", authHandler.Login)
-	mux.HandleFunc("/logout", authHandler.Logout)
-	mux.HandleFunc("/whoami", authHandler.WhoAmI)
+	mux.HandleFunc("/logout", OptionalAuth(repo, authHandler.Logout))
+	mux.HandleFunc("/whoami", RequireAuth(repo, authHandler.WhoAmI))
 	mux.HandleFunc("/refresh-token", authHandler.RefreshToken)
-	// mux.HandleFunc("/messages", authHandler.LoadMessage())
+	mux.HandleFunc("POST /session", sessionHandler.Create)
+	mux.HandleFunc("DELETE /session", RequireAuth(repo, sessionHandler.Delete))
+	mux.HandleFunc("POST /api/ws-ticket", RequireScope(repo, auth.ScopeRead, ticketHandler.Create))
+	mux.HandleFunc("/messages", RequireScope(repo, auth.ScopeRead, getMessages(repo)))
+	// The token in the path is the credential
+	mux.HandleFunc("POST /hooks/{token}", incomingHandler.Post)
+
+	// Metrics reveal traffic and user counts, so Prometheus scrapes with
+	// a bot's API key limited to the metrics scope
+	metricsHandler := metrics.Handler()
+	requireMetrics := RequireMetrics(rt, repo, metricsHandler.ServeHTTP)
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		if !rt.Current().Metrics.Enabled {
+			http.NotFound(w, r)
+			return
+		}
+		requireMetrics(w, r)
+	})
+
+	mux.HandleFunc("/api/admin/reload", RequireAdmin(rt, repo, adminHandler.Reload))
+	mux.HandleFunc("/api/admin/log-level", RequireAdmin(rt, repo, adminHandler.LogLevel))
+	mux.HandleFunc("GET /api/admin/webhooks", RequireAdmin(rt, repo, webhookHandler.List))
+	mux.HandleFunc("POST /api/admin/webhooks", RequireAdmin(rt, repo, webhookHandler.Create))
+	mux.HandleFunc("GET /api/admin/webhooks/{id}", RequireAdmin(rt, repo, webhookHandler.Get))
+	mux.HandleFunc("PATCH /api/admin/webhooks/{id}", RequireAdmin(rt, repo, webhookHandler.Update))
+	mux.HandleFunc("DELETE /api/admin/webhooks/{id}", RequireAdmin(rt, repo, webhookHandler.Delete))
+	mux.HandleFunc("POST /api/admin/webhooks/{id}/ping", RequireAdmin(rt, repo, webhookHandler.Ping))
+	mux.HandleFunc("GET /api/admin/webhooks/dead-letters", RequireAdmin(rt, repo, webhookHandler.DeadLetters))
+	mux.HandleFunc("POST /api/admin/webhooks/dead-letters/{id}/retry", RequireAdmin(rt, repo, webhookHandler.Retry))
+	mux.HandleFunc("GET /api/admin/incoming-webhooks", RequireAdmin(rt, repo, incomingHandler.List))
+	mux.HandleFunc("POST /api/admin/incoming-webhooks", RequireAdmin(rt, repo, incomingHandler.Create))
+	mux.HandleFunc("DELETE /api/admin/incoming-webhooks/{id}", RequireAdmin(rt, repo, incomingHandler.Delete))
+	mux.HandleFunc("POST /api/admin/bots", RequireAdmin(rt, repo, botHandler.CreateBot))
+	mux.HandleFunc("GET /api/admin/api-keys", RequireAdmin(rt, repo, botHandler.ListKeys))
+	mux.HandleFunc("POST /api/admin/api-keys", RequireAdmin(rt, repo, botHandler.CreateKey))
+	mux.HandleFunc("DELETE /api/admin/api-keys/{id}", RequireAdmin(rt, repo, botHandler.RevokeKey))
 
 	// Serve embedded web assets properly
 	webFS := getWebFS()
 	mux.Handle("/", http.FileServer(http.FS(webFS)))
 
-	return mux
+	// otelhttp starts the server span and extracts incoming trace context;
+	// AccessLog renames it after the route once the mux has matched
+	return otelhttp.NewHandler(AccessLog(CORS(rt, mux)), "http.request")
 }
 
-func getMessages(repo *db.Repository) http.HandlerFunc {
+// historyLimit caps one page of GET /messages
+const historyLimit = 100
+
+// getMessages serves the newest messages, or with ?after=ID the ones that
+// followed it, for clients catching up after a reconnect. A full page means
+// there may be more.
+func getMessages(repo db.MessageStore) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodGet {
 			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 			return
 		}
 
-		// Verify JWT token
-		authHeader := r.Header.Get("Authorization")
-		if authHeader == "" {
-			http.Error(w, "unauthorized", http.StatusUnauthorized)
-			return
-		}
-
-		parts := strings.SplitN(authHeader, " ", 2)
-		if len(parts) != 2 || parts[0] != "Bearer" {
-			http.Error(w, "unauthorized", http.StatusUnauthorized)
-			return
-		}
-
-		_, err := auth.ValidateToken(parts[1])
-		if err != nil {
-			http.Error(w, "unauthorized", http.StatusUnauthorized)
-			return
+		var messages []model.Message
+		var err error
+		if raw := r.URL.Query().Get("after"); raw != "" {
+			after, perr := strconv.ParseInt(raw, 10, 64)
+			if perr != nil || after < 0 {
+				auth.SendJSONResponse(w, http.StatusBadRequest, auth.ErrorResponse("after must be a message id"))
+				return
+			}
+			messages, err = repo.GetMessagesAfter(r.Context(), after, historyLimit)
+		} else {
+			messages, err = repo.GetMessages(r.Context(), historyLimit)
 		}
-
-		// Fetch messages (limit to last 100)
-		messages, err := repo.GetMessages(100)
 		if err != nil {
-			http.Error(w, "server error", http.StatusInternalServerError)
+			sendStoreError(w, r, err, "server error")
 			return
 		}
 
@@ -0,0 +1,96 @@
+package httpserver
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"li-chat/internal/auth"
+	"li-chat/internal/config"
+	"li-chat/internal/db"
+	"li-chat/internal/model"
+)
+
+// newAPIKey issues a key with scopes for a new bot called name
+func newAPIKey(t *testing.T, store *db.MemoryStore, name string, scopes ...string) string {
+	t.Helper()
+	ctx := context.Background()
+	botID, err := store.CreateBot(ctx, name)
+	if err != nil {
+		t.Fatalf("CreateBot: %v", err)
+	}
+	key, prefix := auth.GenerateAPIKey()
+	if _, err := store.CreateAPIKey(ctx, model.APIKey{UserID: botID, Name: name, Prefix: prefix, Scopes: scopes}, auth.HashToken(key)); err != nil {
+		t.Fatalf("CreateAPIKey: %v", err)
+	}
+	return key
+}
+
+func TestMetricsAccess(t *testing.T) {
+	store, userID := newAuthStore(t)
+	login, err := auth.GenerateToken(userID, "alice")
+	if err != nil {
+		t.Fatalf("GenerateToken: %v", err)
+	}
+	if err := store.CreateUser(context.Background(), "root", "unused-hash"); err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	rootID, _, err := store.GetUserForLogin(context.Background(), "root")
+	if err != nil {
+		t.Fatalf("GetUserForLogin: %v", err)
+	}
+	adminLogin, err := auth.GenerateToken(rootID, "root")
+	if err != nil {
+		t.Fatalf("GenerateToken: %v", err)
+	}
+	metricsKey := newAPIKey(t, store, "prometheus", auth.ScopeMetrics)
+	readKey := newAPIKey(t, store, "reader", auth.ScopeRead)
+	adminKey := newAPIKey(t, store, "ops", auth.ScopeAdmin)
+	if err := store.SetRole(context.Background(), "ops", model.RoleAdmin); err != nil {
+		t.Fatalf("SetRole: %v", err)
+	}
+
+	router := func(enabled bool) http.Handler {
+		cfg := config.Default()
+		cfg.Metrics.Enabled = enabled
+		cfg.Admin.Usernames = []string{"root"}
+		return NewRouter(config.NewRuntime(cfg, nil), nil, store, nil, nil)
+	}
+	tests := []struct {
+		name    string
+		enabled bool
+		token   string
+		want    int
+	}{
+		{"disabled", false, metricsKey, http.StatusNotFound},
+		{"no credential", true, "", http.StatusUnauthorized},
+		{"key without the scope", true, readKey, http.StatusForbidden},
+		{"admin key without the scope", true, adminKey, http.StatusForbidden},
+		{"login", true, login, http.StatusForbidden},
+		{"admin login", true, adminLogin, http.StatusOK},
+		{"metrics key", true, metricsKey, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+			if tt.token != "" {
+				r.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			w := httptest.NewRecorder()
+			router(tt.enabled).ServeHTTP(w, r)
+			if w.Code != tt.want {
+				t.Errorf("status = %d, want %d: %s", w.Code, tt.want, w.Body)
+			}
+		})
+	}
+
+	// The metrics key opens nothing else
+	r := httptest.NewRequest(http.MethodGet, "/messages", nil)
+	r.Header.Set("Authorization", "Bearer "+metricsKey)
+	w := httptest.NewRecorder()
+	router(true).ServeHTTP(w, r)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("GET /messages with the metrics key: status = %d, want 403", w.Code)
+	}
+}
@@ -2,6 +2,7 @@ package httpserver
 
 import (
 	"context"
+	"net"
 	"net/http"
 
 	"li-chat/internal/config"
@@ -12,17 +13,23 @@ import (
 
 type Server struct {
 	httpServer *http.Server
+	// cancelBase aborts every in-flight request context, used once the
+	// graceful part of Shutdown has run out of time
+	cancelBase context.CancelFunc
 }
 
 func New(cfg *config.Config, handler http.Handler) *Server {
-	logger.Debug("Creating HTTP server", zap.String("address", cfg.Port))
+	logger.Debug("Creating HTTP server", zap.String("address", cfg.Server.Addr))
+	baseCtx, cancelBase := context.WithCancel(context.Background())
 	return &Server{
 		httpServer: &http.Server{
-			Addr:         cfg.Port,
+			Addr:         cfg.Server.Addr,
 			Handler:      handler,
-			ReadTimeout:  cfg.ReadTimeout,
-			WriteTimeout: cfg.WriteTimeout,
+			ReadTimeout:  cfg.Server.ReadTimeout,
+			WriteTimeout: cfg.Server.WriteTimeout,
+			BaseContext:  func(net.Listener) context.Context { return baseCtx },
 		},
+		cancelBase: cancelBase,
 	}
 }
 
@@ -38,6 +45,9 @@ func (s *Server) Start() error {
 func (s *Server) Shutdown(ctx context.Context) error {
 	logger.Info("Initiating graceful server shutdown")
 	err := s.httpServer.Shutdown(ctx)
+	// Anything still running past the deadline has its context cancelled so
+	// pending database queries return instead of holding the process open
+	s.cancelBase()
 	if err != nil {
 		logger.Error("Error during server shutdown", zap.Error(err))
 	} else {
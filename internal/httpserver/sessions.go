@@ -0,0 +1,139 @@
+package httpserver
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	"li-chat/internal/auth"
+	"li-chat/internal/config"
+	"li-chat/internal/db"
+	"li-chat/pkg/logger"
+)
+
+// sessionStore is what SessionHandler needs from the db
+type sessionStore interface {
+	db.UserStore
+	db.SessionStore
+}
+
+// SessionHandler signs browsers in and out with cookie sessions, so the
+// web UI never holds a token script could read. API clients use /login.
+type SessionHandler struct {
+	rt    *config.Runtime
+	store sessionStore
+}
+
+func NewSessionHandler(rt *config.Runtime, store sessionStore) *SessionHandler {
+	return &SessionHandler{rt: rt, store: store}
+}
+
+type sessionResponse struct {
+	Username string `json:"username"`
+	// CSRFToken is also in the readable CSRF cookie; send it in the
+	// X-CSRF-Token header with every request that changes something
+	CSRFToken string    `json:"csrf_token"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// Create checks a username and password and starts a session, set as an
+// HttpOnly cookie alongside the CSRF cookie
+func (h *SessionHandler) Create(w http.ResponseWriter, r *http.Request) {
+	var c credentials
+	if err := json.NewDecoder(r.Body).Decode(&c); err != nil {
+		auth.SendJSONResponse(w, http.StatusBadRequest, auth.ErrorResponse("invalid request body"))
+		return
+	}
+
+	userID, hash, err := h.store.GetUserForLogin(r.Context(), c.Username)
+	if err != nil && !errors.Is(err, db.ErrNotFound) {
+		sendStoreError(w, r, err, "server error")
+		return
+	}
+	if err != nil || auth.CheckPassword(hash, c.Password) != nil {
+		auth.SendJSONResponse(w, http.StatusUnauthorized, auth.ErrorResponse("invalid credentials"))
+		return
+	}
+
+	now := time.Now()
+	session, err := h.store.CreateSession(r.Context(), userID, now.Add(h.rt.Current().Sessions.TTL))
+	if err != nil {
+		sendStoreError(w, r, err, "failed to start session")
+		return
+	}
+	// Sweeping here keeps the table small without a job of its own
+	if _, err := h.store.DeleteExpiredSessions(r.Context(), now); err != nil {
+		logger.WarnContext(r.Context(), "Failed to delete expired sessions", zap.Error(err))
+	}
+
+	h.setCookies(w, r, session.ID, session.ExpiresAt)
+	logger.InfoContext(r.Context(), "Session started", zap.String("username", c.Username), zap.Int64("user_id", userID))
+	auth.SendJSONResponse(w, http.StatusOK, sessionResponse{
+		Username:  c.Username,
+		CSRFToken: auth.CSRFToken(session.ID),
+		ExpiresAt: session.ExpiresAt,
+	})
+}
+
+// Delete ends the caller's session and clears its cookies. It needs the
+// CSRF token, so other sites can't sign the user out.
+func (h *SessionHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	identity, _ := auth.IdentityFromContext(r.Context())
+	if identity.SessionID == "" {
+		auth.SendJSONResponse(w, http.StatusBadRequest, auth.ErrorResponse("not signed in with a session cookie"))
+		return
+	}
+	if err := h.store.DeleteSession(r.Context(), identity.SessionID); err != nil && !errors.Is(err, db.ErrNotFound) {
+		sendStoreError(w, r, err, "failed to end session")
+		return
+	}
+
+	h.setCookies(w, r, "", time.Unix(0, 0))
+	logger.InfoContext(r.Context(), "Session ended", zap.String("username", identity.Username))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// setCookies sets the session and CSRF cookies, or with an expiry in the
+// past clears them
+func (h *SessionHandler) setCookies(w http.ResponseWriter, r *http.Request, sessionID string, expires time.Time) {
+	secure := h.rt.Current().Sessions.SecureCookies || secureRequest(r)
+	maxAge := int(time.Until(expires).Seconds())
+	csrf := ""
+	if sessionID != "" {
+		csrf = auth.CSRFToken(sessionID)
+	} else {
+		maxAge = -1
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     auth.SessionCookie,
+		Value:    sessionID,
+		Path:     "/",
+		Expires:  expires,
+		MaxAge:   maxAge,
+		Secure:   secure,
+		HttpOnly: true,
+		// Lax still sends it when following a link to the chat; the CSRF
+		// check covers the rest
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.SetCookie(w, &http.Cookie{
+		Name:     auth.CSRFCookie,
+		Value:    csrf,
+		Path:     "/",
+		Expires:  expires,
+		MaxAge:   maxAge,
+		Secure:   secure,
+		SameSite: http.SameSiteStrictMode,
+	})
+}
+
+// secureRequest reports whether r came over HTTPS, to this server or to a
+// proxy in front of it
+func secureRequest(r *http.Request) bool {
+	return r.TLS != nil || strings.EqualFold(r.Header.Get("X-Forwarded-Proto"), "https")
+}
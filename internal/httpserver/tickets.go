@@ -0,0 +1,69 @@
+package httpserver
+
+import (
+	"net"
+	"net/http"
+	"time"
+
+	"go.uber.org/zap"
+
+	"li-chat/internal/auth"
+	"li-chat/internal/config"
+	"li-chat/internal/db"
+	"li-chat/internal/model"
+	"li-chat/pkg/logger"
+)
+
+// TicketHandler mints the tickets WebSocket clients connect with, so that
+// no long-lived credential ends up in a URL, where proxies and access logs
+// would keep it
+type TicketHandler struct {
+	rt    *config.Runtime
+	store db.TicketStore
+}
+
+func NewTicketHandler(rt *config.Runtime, store db.TicketStore) *TicketHandler {
+	return &TicketHandler{rt: rt, store: store}
+}
+
+type ticketResponse struct {
+	Ticket    string    `json:"ticket"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// Create issues a ticket for one connection to /ws?ticket=..., carrying
+// the caller's identity and scopes. It works once, within auth.TicketTTL.
+func (h *TicketHandler) Create(w http.ResponseWriter, r *http.Request) {
+	identity, _ := auth.IdentityFromContext(r.Context())
+	t := model.WSTicket{
+		UserID:    identity.UserID,
+		Username:  identity.Username,
+		Scopes:    identity.Scopes,
+		Bot:       identity.Bot,
+		ExpiresAt: time.Now().Add(auth.TicketTTL).UTC(),
+		// The connection is only authorized as long as the credential
+		// behind the ticket
+		TokenExpiresAt: identity.ExpiresAt,
+		APIKeyID:       identity.APIKeyID,
+	}
+	if h.rt.Current().WebSocket.TicketBindIP {
+		t.ClientIP = remoteIP(r)
+	}
+
+	ticket := auth.GenerateTicket()
+	if err := h.store.CreateTicket(r.Context(), t, auth.HashToken(ticket)); err != nil {
+		sendStoreError(w, r, err, "failed to create ticket")
+		return
+	}
+	logger.DebugContext(r.Context(), "WebSocket ticket issued", zap.String("username", identity.Username), zap.String("client_ip", t.ClientIP))
+	auth.SendJSONResponse(w, http.StatusCreated, ticketResponse{Ticket: ticket, ExpiresAt: t.ExpiresAt})
+}
+
+// remoteIP is the address of r's peer, without the port
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
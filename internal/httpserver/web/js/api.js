@@ -2,20 +2,23 @@ import { CONFIG } from "./config.js";
 import { logout } from "./auth.js";
 
 export async function apiFetch(url, options = {}) {
-  const token = localStorage.getItem("token");
-
   const headers = {
     "Content-Type": "application/json",
     ...(options.headers || {}),
   };
 
-  if (token) {
-    headers["Authorization"] = `Bearer ${token}`;
+  // The session cookie goes along by itself; changes also need the CSRF
+  // token from the cookie next to it
+  const method = (options.method || "GET").toUpperCase();
+  if (method !== "GET" && method !== "HEAD") {
+    const match = document.cookie.match(/(?:^|;\s*)lichat_csrf=([^;]*)/);
+    headers["X-CSRF-Token"] = match ? decodeURIComponent(match[1]) : "";
   }
 
   const res = await fetch(CONFIG.API_BASE + url, {
     ...options,
     headers,
+    credentials: "same-origin",
   });
 
   if (res.status === 401) {
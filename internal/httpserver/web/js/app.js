@@ -1,11 +1,7 @@
-import { renderLogin } from './auth.js';
 import { renderChat } from './chat.js';
 
 document.addEventListener('DOMContentLoaded', () => {
-  const token = localStorage.getItem('token');
-  if (token) {
-    renderChat(); // Uses token to fetch /whoami
-  } else {
-    renderLogin();
-  }
+  // The session cookie is HttpOnly, so ask the server whether there is one;
+  // renderChat falls back to the login page if not
+  renderChat();
 });
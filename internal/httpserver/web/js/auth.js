@@ -53,7 +53,8 @@ async function handleLogin() {
   loginBtn.textContent = "Logging in...";
 
   try {
-    const res = await fetch('/login', {
+    // The session comes back as an HttpOnly cookie script can't read
+    const res = await fetch('/session', {
       method: 'POST',
       headers: { 'Content-Type': 'application/json' },
       body: JSON.stringify({ username, password })
@@ -63,9 +64,8 @@ async function handleLogin() {
     let data;
     try { data = JSON.parse(text); } catch { throw new Error(text); }
 
-    if (!res.ok) throw new Error(data.message || 'Login failed');
+    if (!res.ok) throw new Error(data.error || 'Login failed');
 
-    localStorage.setItem('token', data.token);
     renderChat();
   } catch (err) {
     showError(err.message || "Network error");
@@ -96,7 +96,6 @@ async function handleRegister() {
 
     if (!res.ok) throw new Error(data.message || 'Registration failed');
 
-    localStorage.setItem('token', data.token || '');
     handleLogin();
   } catch (err) {
     showError(err.message || "Network error");
@@ -105,21 +104,29 @@ async function handleRegister() {
   }
 }
 
+// authFetch sends the session cookie, plus the CSRF token on anything but
+// a read, and goes back to the login page once the session has ended
 export async function authFetch(url, options = {}) {
-  const token = localStorage.getItem('token');
-  if (!token) throw new Error("No token");
-
-  const headers = { 'Content-Type': 'application/json', ...(options.headers || {}), 'Authorization': `Bearer ${token}` };
+  const method = (options.method || 'GET').toUpperCase();
+  const headers = { 'Content-Type': 'application/json', ...(options.headers || {}) };
+  if (method !== 'GET' && method !== 'HEAD') {
+    headers['X-CSRF-Token'] = csrfToken();
+  }
 
-  const res = await fetch(url, { ...options, headers });
+  const res = await fetch(url, { ...options, headers, credentials: 'same-origin' });
   if (res.status === 401) {
-    localStorage.removeItem('token');
     renderLogin();
     throw new Error("Session expired");
   }
   return res;
 }
 
+// csrfToken reads the cookie the server sets next to the session cookie
+function csrfToken() {
+  const match = document.cookie.match(/(?:^|;\s*)lichat_csrf=([^;]*)/);
+  return match ? decodeURIComponent(match[1]) : '';
+}
+
 function showError(message) {
   const el = document.getElementById('errorMsg');
   if (el) {
@@ -1,8 +1,16 @@
-import { authFetch } from './auth.js';
+import { authFetch, renderLogin } from './auth.js';
 
 let ws = null;
 let currentUser = null;
 let messageBuffer = [];
+// Server version from the first welcome frame; a different one after a
+// reconnect means the server was upgraded and this page may be stale
+let serverVersion = null;
+
+// Close code the server uses when it restarts
+const CLOSE_SERVICE_RESTART = 1012;
+// The account was disabled; reconnecting won't help
+const CLOSE_ACCOUNT_DISABLED = 4003;
 
 export async function renderChat() {
   const app = document.getElementById('app');
@@ -16,8 +24,7 @@ export async function renderChat() {
     currentUser = data.username;
   } catch (err) {
     console.error("Failed to get user:", err);
-    localStorage.removeItem('token');
-    location.reload();
+    renderLogin();
     return;
   }
   let seconds = 0;
@@ -111,11 +118,9 @@ async function loadMessageHistory() {
 }
 
 function connectWebSocket() {
-  const token = localStorage.getItem('token');
-  if (!token) return;
-
+  // The browser sends the session cookie with the upgrade request
   const protocol = location.protocol === 'https:' ? 'wss:' : 'ws:';
-  const wsUrl = `${protocol}//${location.host}/ws?token=${encodeURIComponent(token)}`;
+  const wsUrl = `${protocol}//${location.host}/ws`;
 
   ws = new WebSocket(wsUrl);
 
@@ -127,13 +132,34 @@ function connectWebSocket() {
   };
 
   ws.onmessage = e => {
-    try { displayMessage(JSON.parse(e.data)); } catch {}
+    let data;
+    try { data = JSON.parse(e.data); } catch { return; }
+    if (data.type === 'welcome') {
+      handleWelcome(data.server);
+      return;
+    }
+    // Command replies only this user sees; they aren't stored
+    if (data.type === 'notice') {
+      displayNotice(data.content);
+      return;
+    }
+    // Chat messages have no type. Token frames are for API clients: the
+    // session cookie can't be refreshed in-band, so when it lapses the
+    // server closes the socket and reconnecting shows the login page.
+    if (data.type) return;
+    displayMessage(data);
   };
 
-  ws.onclose = () => {
-    console.log("WebSocket closed. Reconnecting in 3s...");
+  ws.onclose = e => {
     updateConnectionStatus(false);
-    setTimeout(connectWebSocket, 3000);
+    if (e.code === CLOSE_ACCOUNT_DISABLED) {
+      displayNotice("Your account has been disabled.");
+      return;
+    }
+    // A restarting server is usually back quickly; otherwise back off a bit
+    const delay = e.code === CLOSE_SERVICE_RESTART ? 1000 : 3000;
+    console.log(`WebSocket closed (${e.code} ${e.reason}). Reconnecting in ${delay / 1000}s...`);
+    setTimeout(reconnectWebSocket, delay);
   };
 
   ws.onerror = err => {
@@ -142,6 +168,28 @@ function connectWebSocket() {
   };
 }
 
+// reconnectWebSocket checks the session first: a refused upgrade looks
+// the same as a network error, and authFetch shows the login page if the
+// session has ended
+async function reconnectWebSocket() {
+  try {
+    await authFetch('/whoami');
+  } catch (err) {
+    if (err.message === "Session expired") return;
+  }
+  connectWebSocket();
+}
+
+function handleWelcome(server) {
+  if (!server) return;
+  if (serverVersion && server.version !== serverVersion) {
+    console.log(`Server upgraded from ${serverVersion} to ${server.version}, reloading`);
+    location.reload();
+    return;
+  }
+  serverVersion = server.version;
+}
+
 function sendMessage() {
   const input = document.getElementById('messageInput');
   const codeCheckbox = document.getElementById('codeMode');
@@ -193,13 +241,13 @@ function displayMessage(message) {
   const formattedTime = `${hours}:${minutes}`;
 
   const div = document.createElement('div');
-  div.className = `message ${isOwn ? 'message-own' : 'message-other'}`;
+  div.className = `message ${isOwn ? 'message-own' : 'message-other'}${message.bot ? ' message-bot' : ''}`;
   div.innerHTML = `
     <img src="${avatar}" alt="${escapeHtml(message.username)}" class="message-avatar" />
     <div class="message-content">
       <div class="message-bubble">${formatMessage(message.content)}</div>
       <div class="message-meta">
-        <strong>${escapeHtml(message.username)}</strong>
+        <strong>${escapeHtml(message.username)}</strong>${message.bot ? '<span class="bot-badge">BOT</span>' : ''}
         <span class="timestamp">${formattedTime}</span>
       </div>
     </div>
@@ -209,6 +257,18 @@ function displayMessage(message) {
   container.scrollTop = container.scrollHeight;
 }
 
+function displayNotice(text) {
+  const container = document.getElementById('messagesContainer');
+  const emptyState = container.querySelector('.empty-state');
+  if (emptyState) emptyState.remove();
+
+  const div = document.createElement('div');
+  div.className = 'message-notice';
+  div.innerHTML = formatMessage(text);
+  container.appendChild(div);
+  container.scrollTop = container.scrollHeight;
+}
+
 function updateConnectionStatus(connected) {
   const status = document.getElementById('connectionStatus');
   if (!status) return;
@@ -225,8 +285,7 @@ function updateConnectionStatus(connected) {
 }
 
 async function handleLogout() {
-  try { await authFetch('/logout', { method: 'POST' }); } catch (_) {}
-  localStorage.removeItem('token');
+  try { await authFetch('/session', { method: 'DELETE' }); } catch (_) {}
   location.reload();
 }
 
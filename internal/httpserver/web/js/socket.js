@@ -5,11 +5,9 @@ let reconnectDelay = CONFIG.RECONNECT_BASE_DELAY;
 
 export function connectSocket(onMessage, onStatusChange) {
     console.log("connectSocket called");
-  const token = localStorage.getItem("token");
-  if (!token) return;
-
+  // The browser sends the session cookie with the upgrade request
   const protocol = location.protocol === "https:" ? "wss:" : "ws:";
-  const url = `${protocol}//${location.host}${CONFIG.WS_PATH}?token=${encodeURIComponent(token)}`;
+  const url = `${protocol}//${location.host}${CONFIG.WS_PATH}`;
 
   socket = new WebSocket(url);
 
@@ -0,0 +1,223 @@
+package httpserver
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"time"
+
+	"go.uber.org/zap"
+
+	"li-chat/internal/auth"
+	"li-chat/internal/db"
+	"li-chat/internal/model"
+	"li-chat/internal/webhook"
+	"li-chat/pkg/logger"
+)
+
+// deadLetterLimit caps one page of GET /api/admin/webhooks/dead-letters
+const deadLetterLimit = 100
+
+// WebhookHandler manages outgoing webhook subscriptions and their dead
+// letters
+type WebhookHandler struct {
+	store      db.WebhookStore
+	dispatcher *webhook.Dispatcher
+}
+
+func NewWebhookHandler(store db.WebhookStore, dispatcher *webhook.Dispatcher) *WebhookHandler {
+	return &WebhookHandler{store: store, dispatcher: dispatcher}
+}
+
+type createWebhookRequest struct {
+	URL string `json:"url"`
+	// Events defaults to all of them
+	Events []string `json:"events"`
+	// Secret is generated when empty
+	Secret string `json:"secret"`
+}
+
+type updateWebhookRequest struct {
+	Active *bool `json:"active"`
+}
+
+// List serves every webhook, without secrets
+func (h *WebhookHandler) List(w http.ResponseWriter, r *http.Request) {
+	hooks, err := h.store.ListWebhooks(r.Context())
+	if err != nil {
+		sendStoreError(w, r, err, "failed to list webhooks")
+		return
+	}
+	for i := range hooks {
+		hooks[i].Secret = ""
+	}
+	auth.SendJSONResponse(w, http.StatusOK, hooks)
+}
+
+// Create adds a webhook. The response is the only time its secret is shown.
+func (h *WebhookHandler) Create(w http.ResponseWriter, r *http.Request) {
+	var req createWebhookRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		auth.SendJSONResponse(w, http.StatusBadRequest, auth.ErrorResponse("invalid request body"))
+		return
+	}
+	u, err := url.Parse(req.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		auth.SendJSONResponse(w, http.StatusBadRequest, auth.ErrorResponse("url must be an http or https URL"))
+		return
+	}
+	if len(req.Events) == 0 {
+		req.Events = webhook.Events
+	}
+	for _, event := range req.Events {
+		if !slices.Contains(webhook.Events, event) {
+			auth.SendJSONResponse(w, http.StatusBadRequest, auth.ErrorResponse("unknown event "+strconv.Quote(event)+"; use message.created, user.joined or mention"))
+			return
+		}
+	}
+	if req.Secret == "" {
+		req.Secret = rand.Text()
+	} else if len(req.Secret) < 16 {
+		auth.SendJSONResponse(w, http.StatusBadRequest, auth.ErrorResponse("secret must be at least 16 characters"))
+		return
+	}
+
+	hook := model.Webhook{URL: req.URL, Secret: req.Secret, Events: slices.Compact(slices.Sorted(slices.Values(req.Events)))}
+	id, err := h.store.CreateWebhook(r.Context(), hook)
+	if err != nil {
+		sendStoreError(w, r, err, "failed to create webhook")
+		return
+	}
+	h.dispatcher.Invalidate()
+
+	created, err := h.store.GetWebhook(r.Context(), id)
+	if err != nil {
+		sendStoreError(w, r, err, "failed to load webhook")
+		return
+	}
+	identity, _ := auth.IdentityFromContext(r.Context())
+	logger.InfoContext(r.Context(), "Webhook created",
+		zap.String("username", identity.Username),
+		zap.Int64("webhook_id", id),
+		zap.String("url", u.Redacted()),
+		zap.Strings("events", created.Events))
+	auth.SendJSONResponse(w, http.StatusCreated, created)
+}
+
+// Get serves one webhook, without its secret
+func (h *WebhookHandler) Get(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	hook, err := h.store.GetWebhook(r.Context(), id)
+	if err != nil {
+		sendStoreError(w, r, err, "failed to load webhook")
+		return
+	}
+	hook.Secret = ""
+	auth.SendJSONResponse(w, http.StatusOK, hook)
+}
+
+// Update pauses or resumes a webhook. A paused webhook's queued deliveries
+// wait until it is resumed; new events aren't queued for it.
+func (h *WebhookHandler) Update(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	var req updateWebhookRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Active == nil {
+		auth.SendJSONResponse(w, http.StatusBadRequest, auth.ErrorResponse(`body must be {"active": true|false}`))
+		return
+	}
+	if err := h.store.SetWebhookActive(r.Context(), id, *req.Active); err != nil {
+		sendStoreError(w, r, err, "failed to update webhook")
+		return
+	}
+	h.dispatcher.Invalidate()
+	h.dispatcher.Wake()
+
+	identity, _ := auth.IdentityFromContext(r.Context())
+	logger.InfoContext(r.Context(), "Webhook updated",
+		zap.String("username", identity.Username),
+		zap.Int64("webhook_id", id),
+		zap.Bool("active", *req.Active))
+	h.Get(w, r)
+}
+
+// Delete removes a webhook along with its queued and dead deliveries
+func (h *WebhookHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	if err := h.store.DeleteWebhook(r.Context(), id); err != nil {
+		sendStoreError(w, r, err, "failed to delete webhook")
+		return
+	}
+	h.dispatcher.Invalidate()
+
+	identity, _ := auth.IdentityFromContext(r.Context())
+	logger.InfoContext(r.Context(), "Webhook deleted", zap.String("username", identity.Username), zap.Int64("webhook_id", id))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Ping queues a ping event for the webhook
+func (h *WebhookHandler) Ping(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	err := h.dispatcher.Ping(r.Context(), id)
+	if errors.Is(err, webhook.ErrInactive) {
+		auth.SendJSONResponse(w, http.StatusConflict, auth.ErrorResponse("webhook is paused; resume it first"))
+		return
+	}
+	if err != nil {
+		sendStoreError(w, r, err, "failed to queue ping")
+		return
+	}
+	auth.SendJSONResponse(w, http.StatusAccepted, map[string]string{"message": "ping queued"})
+}
+
+// DeadLetters serves the newest deliveries that ran out of attempts
+func (h *WebhookHandler) DeadLetters(w http.ResponseWriter, r *http.Request) {
+	deliveries, err := h.store.DeadDeliveries(r.Context(), deadLetterLimit)
+	if err != nil {
+		sendStoreError(w, r, err, "failed to list dead letters")
+		return
+	}
+	auth.SendJSONResponse(w, http.StatusOK, deliveries)
+}
+
+// Retry puts a dead letter back in the outbox with a fresh set of attempts
+func (h *WebhookHandler) Retry(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	if err := h.store.RetryDelivery(r.Context(), id, time.Now()); err != nil {
+		sendStoreError(w, r, err, "failed to retry delivery")
+		return
+	}
+	h.dispatcher.Wake()
+
+	identity, _ := auth.IdentityFromContext(r.Context())
+	logger.InfoContext(r.Context(), "Webhook dead letter retried", zap.String("username", identity.Username), zap.Int64("delivery_id", id))
+	auth.SendJSONResponse(w, http.StatusAccepted, map[string]string{"message": "delivery queued"})
+}
+
+// pathID reads the {id} wildcard, answering 400 when it isn't one
+func pathID(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil || id <= 0 {
+		auth.SendJSONResponse(w, http.StatusBadRequest, auth.ErrorResponse("invalid id"))
+		return 0, false
+	}
+	return id, true
+}
@@ -0,0 +1,126 @@
+// Package metrics defines the Prometheus collectors exported on /metrics.
+// Collectors are package-level so any package can record without having
+// them threaded through constructors.
+package metrics
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "lichat"
+
+// Reasons for MessagesDropped
+const (
+	DropSlowClient = "slow_client"
+	DropInvalid    = "invalid"
+	DropStoreError = "store_error"
+	DropMuted      = "muted"
+)
+
+// Results for WebhookDeliveries
+const (
+	WebhookDelivered = "delivered"
+	WebhookFailed    = "failed"
+	WebhookDead      = "dead"
+)
+
+var registry = prometheus.NewRegistry()
+
+var (
+	ConnectedClients = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "websocket_clients",
+		Help:      "WebSocket clients currently registered with the hub.",
+	})
+	// Rooms is 1 while the hub runs; all clients share a single room
+	Rooms = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "rooms",
+		Help:      "Chat rooms with a running hub.",
+	})
+
+	MessagesReceived = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "messages_received_total",
+		Help:      "Chat messages read from WebSocket clients.",
+	})
+	MessagesBroadcast = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "messages_broadcast_total",
+		Help:      "Chat messages persisted and fanned out to clients.",
+	})
+	MessagesDropped = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "messages_dropped_total",
+		Help:      "Messages not delivered, by reason. slow_client counts per recipient.",
+	}, []string{"reason"})
+	MessagesRateLimited = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "messages_rate_limited_total",
+		Help:      "Inbound messages discarded by the per-client rate limit.",
+	})
+
+	HTTPDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "HTTP request latency by route pattern, method and status.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route", "method", "status"})
+	QueryDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "db_query_duration_seconds",
+		Help:      "Store operation latency by operation.",
+		Buckets:   []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 5},
+	}, []string{"op"})
+	BroadcastDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "broadcast_duration_seconds",
+		Help:      "Time to queue one message for every connected client.",
+		Buckets:   []float64{.00001, .00005, .0001, .0005, .001, .005, .01, .05, .1},
+	})
+
+	WebhookDeliveries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "webhook_deliveries_total",
+		Help:      "Webhook delivery attempts by result. dead counts events that ran out of attempts.",
+	}, []string{"result"})
+)
+
+func init() {
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		ConnectedClients, Rooms,
+		MessagesReceived, MessagesBroadcast, MessagesDropped, MessagesRateLimited,
+		HTTPDuration, QueryDuration, BroadcastDuration,
+		WebhookDeliveries,
+	)
+}
+
+// Register adds collectors owned by other packages, such as connection pool
+// statistics
+func Register(cs ...prometheus.Collector) error {
+	for _, c := range cs {
+		if err := registry.Register(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ObserveQuery records a store operation started at start. Use it as
+//
+//	defer metrics.ObserveQuery("save_message", time.Now())
+func ObserveQuery(op string, start time.Time) {
+	QueryDuration.WithLabelValues(op).Observe(time.Since(start).Seconds())
+}
+
+// Handler serves the registry in the Prometheus text format
+func Handler() http.Handler {
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{Registry: registry})
+}
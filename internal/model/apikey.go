@@ -0,0 +1,17 @@
+package model
+
+import "time"
+
+// APIKey is a long-lived credential for a bot account. Key is only set
+// when the key is created; afterwards Prefix identifies it.
+type APIKey struct {
+	ID        int64      `json:"id"`
+	UserID    int64      `json:"user_id"`
+	Username  string     `json:"username"`
+	Name      string     `json:"name"`
+	Prefix    string     `json:"prefix"`
+	Key       string     `json:"key,omitempty"`
+	Scopes    []string   `json:"scopes"`
+	CreatedAt time.Time  `json:"created_at"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+}
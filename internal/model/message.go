@@ -1,7 +1,24 @@
 package model
 
+import "time"
+
 type Message struct {
+	// ID increases with every stored message; clients resume from the last
+	// one they saw with GET /messages?after=ID
+	ID        int64  `json:"id,omitempty"`
 	Username  string `json:"username"`
 	Content   string `json:"content"`
 	CreatedAt string `json:"created_at"`
+	// Bot is set for messages from bot accounts
+	Bot bool `json:"bot,omitempty"`
+}
+
+// MessageRecord is a stored message with its identifiers, used for exports.
+// UserID is zero and Username empty when the author was deleted.
+type MessageRecord struct {
+	ID        int64     `json:"id"`
+	UserID    int64     `json:"user_id,omitempty"`
+	Username  string    `json:"username,omitempty"`
+	Content   string    `json:"content"`
+	CreatedAt time.Time `json:"created_at"`
 }
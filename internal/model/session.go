@@ -2,8 +2,29 @@ package model
 
 import "time"
 
+// Session is a browser login, identified by the ID in its cookie
 type Session struct {
-	ID        string
-	UserID    int64
+	ID     string
+	UserID int64
+	// Username is filled in by GetSession
+	Username  string
 	ExpiresAt time.Time
 }
+
+// WSTicket lets one WebSocket upgrade authenticate without putting a
+// long-lived credential in the URL
+type WSTicket struct {
+	UserID   int64
+	Username string
+	// Scopes is nil for a ticket minted with a login, which has them all
+	Scopes []string
+	Bot    bool
+	// ClientIP is empty unless the ticket only works from that address
+	ClientIP  string
+	ExpiresAt time.Time
+	// TokenExpiresAt is when the credential the ticket was minted with
+	// lapses, and with it the connection; zero for API keys
+	TokenExpiresAt time.Time
+	// APIKeyID is the key the ticket was minted with, zero for a login
+	APIKeyID int64
+}
@@ -7,6 +7,22 @@ type User struct {
 	Username string
 }
 
+// Roles a user account can hold
+const (
+	RoleUser  = "user"
+	RoleAdmin = "admin"
+)
+
+// Account is a user as operators see it
+type Account struct {
+	ID       int64  `json:"id"`
+	Username string `json:"username"`
+	Role     string `json:"role"`
+	Disabled bool   `json:"disabled"`
+	// Bot accounts have no password and sign in with API keys
+	Bot bool `json:"bot"`
+}
+
 // Response structs for consistency
 type SuccessResponseStruct struct {
 	Token        string    `json:"token,omitempty"`
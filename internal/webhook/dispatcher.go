@@ -0,0 +1,270 @@
+// Package webhook delivers chat events to external HTTP receivers. Events
+// are written to an outbox in the database first and POSTed from there, so
+// they survive a restart and are retried until the receiver accepts them.
+//
+// Every delivery is a JSON body
+//
+//	{"event": "message.created", "timestamp": "...", "data": {...}}
+//
+// signed with the webhook's secret: X-LiChat-Signature is "sha256=" and the
+// hex HMAC-SHA256 of X-LiChat-Timestamp, a dot, and the body. Receivers
+// should check it with Verify and reject stale timestamps. Deliveries can
+// arrive out of order, and more than once if an answer is lost;
+// X-LiChat-Delivery identifies repeats.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"li-chat/internal/db"
+	"li-chat/internal/metrics"
+	"li-chat/internal/model"
+	"li-chat/pkg/logger"
+	"li-chat/pkg/version"
+)
+
+// log is the package logger; its level can be set apart from the rest
+// with log.packages=webhook=<level>
+var log = logger.Named("webhook")
+
+// Headers sent with every delivery
+const (
+	HeaderEvent     = "X-LiChat-Event"
+	HeaderDelivery  = "X-LiChat-Delivery"
+	HeaderTimestamp = "X-LiChat-Timestamp"
+	HeaderSignature = "X-LiChat-Signature"
+)
+
+const (
+	// batchSize is how many deliveries are claimed and sent at once
+	batchSize  = 20
+	firstRetry = 10 * time.Second
+	maxRetry   = time.Hour
+	// hooksTTL bounds how long another server's subscription changes take
+	// to be seen; changes made through this one apply at once
+	hooksTTL = time.Minute
+	// maxErrorLen keeps a chatty receiver's error out of the outbox
+	maxErrorLen = 500
+)
+
+type Options struct {
+	// MaxAttempts is how many times an event is tried before it becomes a
+	// dead letter
+	MaxAttempts int
+	// Timeout bounds one attempt, from connecting to reading the status
+	Timeout time.Duration
+	// PollInterval is how often the outbox is checked for retries that
+	// have come due
+	PollInterval time.Duration
+}
+
+// Dispatcher queues events for the webhooks subscribed to them and sends
+// them from the outbox. Several servers can share one database; each
+// delivery is claimed by one of them at a time.
+type Dispatcher struct {
+	store  db.Store
+	opts   Options
+	client *http.Client
+	wake   chan struct{}
+
+	mu     sync.Mutex
+	hooks  []model.Webhook
+	loaded time.Time
+}
+
+func NewDispatcher(store db.Store, opts Options) *Dispatcher {
+	return &Dispatcher{
+		store: store,
+		opts:  opts,
+		client: &http.Client{
+			Timeout: opts.Timeout,
+			// A redirect is answered like any other failure; the URL
+			// should be fixed instead
+			CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+		},
+		wake: make(chan struct{}, 1),
+	}
+}
+
+// Run sends due deliveries until ctx ends. Events queued by this process
+// go out at once; retries and events queued elsewhere within PollInterval.
+func (d *Dispatcher) Run(ctx context.Context) {
+	log.Info("Webhook dispatcher started",
+		zap.Int("max_attempts", d.opts.MaxAttempts),
+		zap.Duration("timeout", d.opts.Timeout),
+		zap.Duration("poll_interval", d.opts.PollInterval))
+	ticker := time.NewTicker(d.opts.PollInterval)
+	defer ticker.Stop()
+	for {
+		d.flush(ctx)
+		select {
+		case <-ctx.Done():
+			log.Info("Webhook dispatcher stopped")
+			return
+		case <-ticker.C:
+		case <-d.wake:
+		}
+	}
+}
+
+// Wake makes Run check the outbox now rather than at the next poll
+func (d *Dispatcher) Wake() {
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Invalidate drops the cached subscriptions after they were changed
+func (d *Dispatcher) Invalidate() {
+	d.mu.Lock()
+	d.hooks = nil
+	d.mu.Unlock()
+}
+
+// flush sends every due delivery, a batch at a time
+func (d *Dispatcher) flush(ctx context.Context) {
+	for ctx.Err() == nil {
+		now := time.Now()
+		batch, err := d.store.ClaimDeliveries(ctx, now, now.Add(d.lease()), batchSize)
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Error("Failed to claim webhook deliveries", zap.Error(err))
+			}
+			return
+		}
+
+		var wg sync.WaitGroup
+		for _, del := range batch {
+			wg.Go(func() { d.attempt(ctx, del) })
+		}
+		wg.Wait()
+
+		if len(batch) < batchSize {
+			return
+		}
+	}
+}
+
+// lease is how long a claimed delivery is hidden from other claims. It
+// outlasts any attempt, so a delivery isn't sent twice at once, and a
+// server that dies mid-attempt leaves it to be retried when it runs out.
+func (d *Dispatcher) lease() time.Duration {
+	return d.opts.Timeout + time.Minute
+}
+
+// attempt sends del once and records the outcome
+func (d *Dispatcher) attempt(ctx context.Context, del model.WebhookDelivery) {
+	hook, err := d.store.GetWebhook(ctx, del.WebhookID)
+	if err != nil {
+		// A deleted webhook takes its deliveries with it
+		if !errors.Is(err, db.ErrNotFound) && ctx.Err() == nil {
+			log.Error("Failed to load webhook for delivery", zap.Int64("delivery_id", del.ID), zap.Error(err))
+		}
+		return
+	}
+
+	fields := []zap.Field{
+		zap.Int64("delivery_id", del.ID),
+		zap.Int64("webhook_id", hook.ID),
+		zap.String("event", del.Event),
+		zap.Int("attempt", del.Attempts+1),
+	}
+	err = d.post(ctx, hook, del)
+	if err != nil && ctx.Err() != nil {
+		// Shutting down: the lease runs out and another run retries it
+		return
+	}
+	// The outcome is recorded even if ctx ends now, so an accepted event
+	// isn't sent again
+	store := context.WithoutCancel(ctx)
+
+	if err == nil {
+		metrics.WebhookDeliveries.WithLabelValues(metrics.WebhookDelivered).Inc()
+		log.Debug("Webhook delivered", fields...)
+		if err := d.store.CompleteDelivery(store, del.ID); err != nil && !errors.Is(err, db.ErrNotFound) {
+			log.Error("Failed to remove delivered webhook event; it will be sent again", append(fields, zap.Error(err))...)
+		}
+		return
+	}
+
+	reason := err.Error()
+	if len(reason) > maxErrorLen {
+		reason = reason[:maxErrorLen]
+	}
+	var next time.Time
+	if del.Attempts+1 < d.opts.MaxAttempts {
+		next = time.Now().Add(backoff(del.Attempts + 1))
+		metrics.WebhookDeliveries.WithLabelValues(metrics.WebhookFailed).Inc()
+		log.Info("Webhook delivery failed, will retry", append(fields, zap.Time("retry_at", next), zap.Error(err))...)
+	} else {
+		metrics.WebhookDeliveries.WithLabelValues(metrics.WebhookDead).Inc()
+		log.Warn("Webhook delivery failed for the last time, moved to dead letters", append(fields, zap.Error(err))...)
+	}
+	if err := d.store.FailDelivery(store, del.ID, reason, next); err != nil && !errors.Is(err, db.ErrNotFound) {
+		log.Error("Failed to record webhook delivery failure", append(fields, zap.Error(err))...)
+	}
+}
+
+// backoff is the wait before attempt+1: 10s doubling up to an hour
+func backoff(attempt int) time.Duration {
+	if attempt > 10 {
+		return maxRetry
+	}
+	return min(firstRetry<<(attempt-1), maxRetry)
+}
+
+// post makes one delivery; any answer but 2xx is a failure
+func (d *Dispatcher) post(ctx context.Context, hook model.Webhook, del model.WebhookDelivery) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, hook.URL, bytes.NewReader(del.Payload))
+	if err != nil {
+		return err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "li-chat-webhook/"+version.Get().Version)
+	req.Header.Set(HeaderEvent, del.Event)
+	req.Header.Set(HeaderDelivery, strconv.FormatInt(del.ID, 10))
+	req.Header.Set(HeaderTimestamp, timestamp)
+	req.Header.Set(HeaderSignature, Sign(hook.Secret, timestamp, del.Payload))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	// Reading a little of the body lets the connection be reused
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("receiver answered %s", resp.Status)
+	}
+	return nil
+}
+
+// Sign is the X-LiChat-Signature value for body sent at timestamp
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify reports whether signature is Sign(secret, timestamp, body), in
+// constant time
+func Verify(secret, timestamp, signature string, body []byte) bool {
+	return hmac.Equal([]byte(signature), []byte(Sign(secret, timestamp, body)))
+}
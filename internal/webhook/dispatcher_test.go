@@ -0,0 +1,142 @@
+package webhook
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"li-chat/internal/db"
+	"li-chat/internal/model"
+)
+
+func TestSignVerify(t *testing.T) {
+	const secret, timestamp = "whsec", "1700000000"
+	body := []byte(`{"event":"message.created"}`)
+	signature := Sign(secret, timestamp, body)
+	if !Verify(secret, timestamp, signature, body) {
+		t.Fatalf("Verify rejected its own signature %q", signature)
+	}
+
+	tests := []struct {
+		name                         string
+		secret, timestamp, signature string
+		body                         string
+	}{
+		{"body", secret, timestamp, signature, `{"event":"message.deleted"}`},
+		{"timestamp", secret, "1700000001", signature, string(body)},
+		{"secret", "other", timestamp, signature, string(body)},
+		{"signature", secret, timestamp, signature[:len(signature)-1] + "0", string(body)},
+		{"unprefixed signature", secret, timestamp, signature[len("sha256="):], string(body)},
+		{"empty signature", secret, timestamp, "", string(body)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if Verify(tt.secret, tt.timestamp, tt.signature, []byte(tt.body)) {
+				t.Error("Verify accepted a tampered delivery")
+			}
+		})
+	}
+}
+
+func TestDispatcherRetries(t *testing.T) {
+	const maxAttempts = 4
+	ctx := context.Background()
+	store := db.NewMemoryStore()
+	t.Cleanup(func() { store.Close() })
+
+	var hits atomic.Int32
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		body, _ := io.ReadAll(r.Body)
+		if !Verify("whsec", r.Header.Get(HeaderTimestamp), r.Header.Get(HeaderSignature), body) {
+			t.Errorf("delivery %s has a bad signature", r.Header.Get(HeaderDelivery))
+		}
+		http.Error(w, "down for maintenance", http.StatusServiceUnavailable)
+	}))
+	t.Cleanup(receiver.Close)
+
+	hookID, err := store.CreateWebhook(ctx, model.Webhook{URL: receiver.URL, Secret: "whsec", Events: []string{"message.created"}})
+	if err != nil {
+		t.Fatalf("CreateWebhook: %v", err)
+	}
+	err = store.EnqueueDeliveries(ctx, []model.WebhookDelivery{{
+		WebhookID: hookID, Event: "message.created", Payload: []byte(`{"event":"message.created"}`), NextAttemptAt: time.Now(),
+	}})
+	if err != nil {
+		t.Fatalf("EnqueueDeliveries: %v", err)
+	}
+
+	d := NewDispatcher(store, Options{MaxAttempts: maxAttempts, Timeout: time.Second, PollInterval: time.Minute})
+	claim := func(at time.Time) []model.WebhookDelivery {
+		t.Helper()
+		batch, err := store.ClaimDeliveries(ctx, at, at.Add(d.lease()), batchSize)
+		if err != nil {
+			t.Fatalf("ClaimDeliveries: %v", err)
+		}
+		return batch
+	}
+
+	due := time.Now()
+	var lastWait time.Duration
+	for attempt := 1; attempt <= maxAttempts; attempt++ {
+		batch := claim(due)
+		if len(batch) != 1 {
+			t.Fatalf("attempt %d: claimed %d deliveries, want 1", attempt, len(batch))
+		}
+		if batch[0].Attempts != attempt-1 {
+			t.Fatalf("attempt %d: delivery has %d attempts, want %d", attempt, batch[0].Attempts, attempt-1)
+		}
+		start := time.Now()
+		d.attempt(ctx, batch[0])
+		if attempt == maxAttempts {
+			break
+		}
+
+		wait := backoff(attempt)
+		if wait <= lastWait {
+			t.Errorf("attempt %d: backoff %v doesn't exceed the previous %v", attempt, wait, lastWait)
+		}
+		lastWait = wait
+		if early := claim(start.Add(wait - time.Second)); len(early) != 0 {
+			t.Fatalf("attempt %d: delivery due again before the %v backoff", attempt, wait)
+		}
+		due = time.Now().Add(wait + time.Second)
+	}
+
+	if got := hits.Load(); got != maxAttempts {
+		t.Errorf("receiver got %d deliveries, want %d", got, maxAttempts)
+	}
+	if late := claim(time.Now().Add(24 * time.Hour)); len(late) != 0 {
+		t.Errorf("delivery still claimable after %d attempts", maxAttempts)
+	}
+	dead, err := store.DeadDeliveries(ctx, 10)
+	if err != nil {
+		t.Fatalf("DeadDeliveries: %v", err)
+	}
+	if len(dead) != 1 || dead[0].Attempts != maxAttempts || dead[0].LastError == "" {
+		t.Errorf("dead letters = %+v, want one with %d attempts and an error", dead, maxAttempts)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		attempt int
+		want    time.Duration
+	}{
+		{1, 10 * time.Second},
+		{2, 20 * time.Second},
+		{3, 40 * time.Second},
+		{9, 2560 * time.Second},
+		{10, time.Hour},
+		{50, time.Hour},
+	}
+	for _, tt := range tests {
+		if got := backoff(tt.attempt); got != tt.want {
+			t.Errorf("backoff(%d) = %v, want %v", tt.attempt, got, tt.want)
+		}
+	}
+}
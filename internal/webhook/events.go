@@ -0,0 +1,214 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	"li-chat/internal/db"
+	"li-chat/internal/model"
+)
+
+// Events a webhook can subscribe to
+const (
+	EventMessageCreated = "message.created"
+	// EventUserJoined is sent when someone registers an account
+	EventUserJoined = "user.joined"
+	// EventMention is sent once for every existing user a message names
+	// with @username, other than its author
+	EventMention = "mention"
+	// EventPing is only sent on request, to test a receiver
+	EventPing = "ping"
+)
+
+// Events lists what a webhook may subscribe to
+var Events = []string{EventMessageCreated, EventUserJoined, EventMention}
+
+// ErrInactive is returned by Ping for a paused webhook, whose deliveries
+// wait until it is resumed
+var ErrInactive = errors.New("webhook is paused")
+
+// maxMentions bounds the account lookups one message can cause
+const maxMentions = 10
+
+var mentionPattern = regexp.MustCompile(`(?:^|\s)@([\pL\pN_.-]+)`)
+
+type envelope struct {
+	Event     string    `json:"event"`
+	Timestamp time.Time `json:"timestamp"`
+	Data      any       `json:"data"`
+}
+
+type userData struct {
+	Username string `json:"username"`
+}
+
+type mentionData struct {
+	// Username is who was mentioned
+	Username string        `json:"username"`
+	Message  model.Message `json:"message"`
+}
+
+// Emit queues event for every active webhook subscribed to it
+func (d *Dispatcher) Emit(ctx context.Context, event string, data any) error {
+	hooks, err := d.subscribers(ctx, event)
+	if err != nil || len(hooks) == 0 {
+		return err
+	}
+	return d.enqueue(ctx, event, data, hookIDs(hooks)...)
+}
+
+// Ping queues a ping event for webhook id whatever it subscribes to, to
+// check that the receiver is reachable and verifies signatures
+func (d *Dispatcher) Ping(ctx context.Context, id int64) error {
+	hook, err := d.store.GetWebhook(ctx, id)
+	if err != nil {
+		return err
+	}
+	if !hook.Active {
+		return ErrInactive
+	}
+	return d.enqueue(ctx, EventPing, map[string]int64{"webhook_id": id}, id)
+}
+
+func (d *Dispatcher) enqueue(ctx context.Context, event string, data any, hookIDs ...int64) error {
+	deliveries, err := newDeliveries(event, data, hookIDs)
+	if err != nil {
+		return err
+	}
+	if err := d.store.EnqueueDeliveries(ctx, deliveries); err != nil {
+		if errors.Is(err, db.ErrConflict) {
+			// A webhook was deleted by another server since it was cached
+			d.Invalidate()
+		}
+		return err
+	}
+	d.Wake()
+	return nil
+}
+
+// newDeliveries are event, due now, for each of hookIDs
+func newDeliveries(event string, data any, hookIDs []int64) ([]model.WebhookDelivery, error) {
+	now := time.Now()
+	payload, err := json.Marshal(envelope{Event: event, Timestamp: now.UTC(), Data: data})
+	if err != nil {
+		return nil, err
+	}
+	deliveries := make([]model.WebhookDelivery, len(hookIDs))
+	for i, id := range hookIDs {
+		deliveries[i] = model.WebhookDelivery{WebhookID: id, Event: event, Payload: payload, NextAttemptAt: now}
+	}
+	return deliveries, nil
+}
+
+func hookIDs(hooks []model.Webhook) []int64 {
+	ids := make([]int64, len(hooks))
+	for i, h := range hooks {
+		ids[i] = h.ID
+	}
+	return ids
+}
+
+// subscribers lists the active webhooks for event
+func (d *Dispatcher) subscribers(ctx context.Context, event string) ([]model.Webhook, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.hooks == nil || time.Since(d.loaded) > hooksTTL {
+		hooks, err := d.store.ListWebhooks(ctx)
+		if err != nil {
+			return nil, err
+		}
+		d.hooks, d.loaded = hooks, time.Now()
+	}
+
+	var subscribed []model.Webhook
+	for _, h := range d.hooks {
+		if h.Active && slices.Contains(h.Events, event) {
+			subscribed = append(subscribed, h)
+		}
+	}
+	return subscribed, nil
+}
+
+// MessageDeliveries prepares message.created for msg and a mention for
+// each active user it names, for websocket.Hub.Post to save along with
+// msg. Subscriptions and accounts are looked up now; the returned func
+// only builds the deliveries once msg has an ID, so it can run inside the
+// transaction that saves it.
+func (d *Dispatcher) MessageDeliveries(ctx context.Context, msg model.Message) (func(id int64) ([]model.WebhookDelivery, error), error) {
+	created, err := d.subscribers(ctx, EventMessageCreated)
+	if err != nil {
+		return nil, err
+	}
+	mentionHooks, err := d.subscribers(ctx, EventMention)
+	if err != nil {
+		return nil, err
+	}
+
+	// Skip the account lookups when nobody is listening
+	var mentioned []string
+	if len(mentionHooks) > 0 {
+		for _, name := range mentions(msg.Content) {
+			if name == msg.Username {
+				continue
+			}
+			account, err := d.store.GetAccount(ctx, name)
+			if errors.Is(err, db.ErrNotFound) {
+				continue
+			}
+			if err != nil {
+				return nil, fmt.Errorf("look up mentioned user %q: %w", name, err)
+			}
+			if !account.Disabled {
+				mentioned = append(mentioned, name)
+			}
+		}
+	}
+
+	return func(id int64) ([]model.WebhookDelivery, error) {
+		msg.ID = id
+		deliveries, err := newDeliveries(EventMessageCreated, msg, hookIDs(created))
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range mentioned {
+			more, err := newDeliveries(EventMention, mentionData{Username: name, Message: msg}, hookIDs(mentionHooks))
+			if err != nil {
+				return nil, err
+			}
+			deliveries = append(deliveries, more...)
+		}
+		return deliveries, nil
+	}, nil
+}
+
+// UserJoined emits user.joined for a newly registered account
+func (d *Dispatcher) UserJoined(ctx context.Context, username string) {
+	if err := d.Emit(ctx, EventUserJoined, userData{Username: username}); err != nil {
+		log.ErrorContext(ctx, "Failed to queue webhook event", zap.String("event", EventUserJoined), zap.Error(err))
+	}
+}
+
+// mentions lists the distinct @names in content, up to maxMentions.
+// Trailing punctuation is not part of a name: "thanks @bob." names bob.
+func mentions(content string) []string {
+	var names []string
+	for _, m := range mentionPattern.FindAllStringSubmatch(content, -1) {
+		name := strings.TrimRight(m[1], ".-")
+		if name == "" || slices.Contains(names, name) {
+			continue
+		}
+		names = append(names, name)
+		if len(names) == maxMentions {
+			break
+		}
+	}
+	return names
+}
@@ -0,0 +1,175 @@
+package websocket
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"go.uber.org/zap"
+
+	"li-chat/internal/db"
+	"li-chat/internal/model"
+)
+
+// subscriberBuffer is how many messages a subscriber may fall behind
+// before new ones are dropped for it
+const subscriberBuffer = 64
+
+// subscriber receives message events on a goroutine of its own
+type subscriber struct {
+	name   string
+	events chan event
+	stop   chan struct{}
+}
+
+type event struct {
+	ctx context.Context
+	msg model.Message
+}
+
+type subscribers struct {
+	mu   sync.Mutex
+	list []*subscriber
+}
+
+// Subscribe calls fn with every message posted from now on, one at a time
+// and in order. A subscriber that falls too far behind misses messages
+// rather than holding up the room, so anything that must see every message,
+// like webhooks, belongs in the Outbox instead. name identifies it in logs.
+// The returned func unsubscribes.
+func (h *Hub) Subscribe(name string, fn func(ctx context.Context, msg model.Message)) (unsubscribe func()) {
+	s := &subscriber{name: name, events: make(chan event, subscriberBuffer), stop: make(chan struct{})}
+	h.subs.mu.Lock()
+	h.subs.list = append(h.subs.list, s)
+	h.subs.mu.Unlock()
+	log.Info("Message subscriber added", zap.String("subscriber", name))
+
+	go func() {
+		for {
+			select {
+			case ev := <-s.events:
+				fn(ev.ctx, ev.msg)
+			case <-s.stop:
+				return
+			case <-h.done:
+				return
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			h.subs.mu.Lock()
+			for i, other := range h.subs.list {
+				if other == s {
+					h.subs.list = append(h.subs.list[:i], h.subs.list[i+1:]...)
+					break
+				}
+			}
+			h.subs.mu.Unlock()
+			close(s.stop)
+		})
+	}
+}
+
+// publish hands msg to every subscriber without waiting for any of them
+func (h *Hub) publish(ctx context.Context, msg model.Message) {
+	// Subscribers outlive the sender's connection
+	ctx = context.WithoutCancel(ctx)
+	h.subs.mu.Lock()
+	defer h.subs.mu.Unlock()
+	for _, s := range h.subs.list {
+		select {
+		case s.events <- event{ctx: ctx, msg: msg}:
+		default:
+			log.WarnContext(ctx, "Subscriber is behind, message dropped for it", zap.String("subscriber", s.name))
+		}
+	}
+}
+
+// ErrNotBot is returned by a Bot's Post when its name belongs to a
+// person's account, whom it must not post as
+var ErrNotBot = errors.New("name belongs to a person's account")
+
+// Bot is an in-process participant. It posts as its own bot account,
+// which is created on first use, and can add commands and follow the room.
+type Bot struct {
+	Name string
+	hub  *Hub
+
+	// hasAccount is set once the bot account is known to exist
+	accountMu  sync.Mutex
+	hasAccount bool
+}
+
+// NewBot returns a bot that posts as name
+func (h *Hub) NewBot(name string) *Bot {
+	return &Bot{Name: name, hub: h}
+}
+
+// Command registers cmd. Public replies are posted as the bot unless the
+// reply says otherwise. The command fails instead when the bot has no
+// account of its own.
+func (b *Bot) Command(cmd Command) error {
+	run := cmd.Run
+	cmd.Run = func(ctx context.Context, call Call) (Reply, error) {
+		reply, err := run(ctx, call)
+		if reply.Public && reply.From == "" {
+			reply.From = b.Name
+		}
+		if err == nil && reply.Public && reply.From == b.Name {
+			if err := b.ensureAccount(ctx); err != nil {
+				return Reply{}, err
+			}
+		}
+		return reply, err
+	}
+	return b.hub.RegisterCommand(cmd)
+}
+
+// OnMessage calls fn with every message posted by someone other than the
+// bot itself, as Subscribe does
+func (b *Bot) OnMessage(fn func(ctx context.Context, msg model.Message)) (unsubscribe func()) {
+	return b.hub.Subscribe(b.Name, func(ctx context.Context, msg model.Message) {
+		if msg.Username != b.Name {
+			fn(ctx, msg)
+		}
+	})
+}
+
+// Post sends content to the room as the bot, failing with ErrNotBot if a
+// person has the bot's name
+func (b *Bot) Post(ctx context.Context, content string) (model.Message, error) {
+	if err := b.ensureAccount(ctx); err != nil {
+		return model.Message{}, err
+	}
+	return b.hub.Post(ctx, b.Name, content)
+}
+
+// ensureAccount creates the bot's account so its messages are flagged as
+// a bot's. An existing bot account with the name is reused; a person's is
+// refused with ErrNotBot, so the bot can't speak for them. A failed
+// attempt, e.g. while the database is down, is retried next time.
+func (b *Bot) ensureAccount(ctx context.Context) error {
+	b.accountMu.Lock()
+	defer b.accountMu.Unlock()
+	if b.hasAccount {
+		return nil
+	}
+	_, err := b.hub.repo.CreateBot(ctx, b.Name)
+	if errors.Is(err, db.ErrUserExists) {
+		var account model.Account
+		account, err = b.hub.repo.GetAccount(ctx, b.Name)
+		if err == nil && !account.Bot {
+			err = fmt.Errorf("bot %s: %w", b.Name, ErrNotBot)
+		}
+	}
+	if err != nil {
+		log.WarnContext(ctx, "Failed to set up bot account, not posting", zap.String("bot", b.Name), zap.Error(err))
+		return err
+	}
+	b.hasAccount = true
+	return nil
+}
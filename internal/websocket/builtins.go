@@ -0,0 +1,162 @@
+package websocket
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrMuted is returned by Post for a user an admin has muted
+var ErrMuted = errors.New("you are muted")
+
+const defaultMute = 10 * time.Minute
+
+// room is state the built-in commands keep for the hub's single room. It
+// lives in memory, so a restart clears the topic and lifts every mute.
+type room struct {
+	mu    sync.Mutex
+	topic string
+	muted map[string]time.Time
+}
+
+// mutedFor reports how long username stays muted, or 0
+func (r *room) mutedFor(username string) time.Duration {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	until, ok := r.muted[username]
+	if !ok {
+		return 0
+	}
+	left := time.Until(until)
+	if left <= 0 {
+		delete(r.muted, username)
+		return 0
+	}
+	return left
+}
+
+func mutedError(left time.Duration) error {
+	return fmt.Errorf("%w for another %s", ErrMuted, left.Round(time.Second))
+}
+
+func (h *Hub) registerBuiltins() {
+	for _, cmd := range []Command{
+		{Name: "help", Usage: "/help", Help: "list the commands", Run: h.help},
+		{Name: "me", Usage: "/me ACTION", Help: "say what you are doing, e.g. /me waves", Run: me},
+		{Name: "topic", Usage: "/topic [TEXT]", Help: "show the room topic, or set it", Run: h.setTopic},
+		{Name: "roll", Usage: "/roll [NdM]", Help: "roll up to 20 dice for everyone to see, 1d6 by default", Run: roll},
+		{Name: "mute", Usage: "/mute NAME [DURATION]", Help: "stop NAME posting, for 10m unless given e.g. 1h", AdminOnly: true, Run: h.mute},
+		{Name: "unmute", Usage: "/unmute NAME", Help: "let NAME post again", AdminOnly: true, Run: h.unmute},
+	} {
+		if err := h.RegisterCommand(cmd); err != nil {
+			panic(err)
+		}
+	}
+}
+
+func (h *Hub) help(_ context.Context, call Call) (Reply, error) {
+	var b strings.Builder
+	b.WriteString("Commands:")
+	for _, cmd := range h.Commands() {
+		if cmd.AdminOnly && !call.Admin {
+			continue
+		}
+		fmt.Fprintf(&b, "\n%s — %s", cmd.Usage, cmd.Help)
+	}
+	b.WriteString("\nStart a message with // to send a literal /")
+	return Reply{Content: b.String()}, nil
+}
+
+func me(_ context.Context, call Call) (Reply, error) {
+	if call.Args == "" {
+		return Reply{}, ErrUsage
+	}
+	return Reply{Content: "* " + call.Username + " " + call.Args, Public: true}, nil
+}
+
+func (h *Hub) setTopic(_ context.Context, call Call) (Reply, error) {
+	// The announcement would be refused, so don't change the topic either
+	if left := h.room.mutedFor(call.Username); left > 0 && call.Args != "" {
+		return Reply{}, mutedError(left)
+	}
+	h.room.mu.Lock()
+	defer h.room.mu.Unlock()
+	if call.Args == "" {
+		if h.room.topic == "" {
+			return Reply{Content: "No topic is set"}, nil
+		}
+		return Reply{Content: "Topic: " + h.room.topic}, nil
+	}
+	h.room.topic = call.Args
+	return Reply{Content: "* " + call.Username + " set the topic to: " + call.Args, Public: true}, nil
+}
+
+// maxDice bounds /roll so one message can't be made arbitrarily long
+const maxDice = 20
+
+func roll(_ context.Context, call Call) (Reply, error) {
+	n, sides := 1, 6
+	if call.Args != "" {
+		a, b, ok := strings.Cut(strings.ToLower(call.Args), "d")
+		var errN, errSides error
+		if a != "" {
+			n, errN = strconv.Atoi(a)
+		}
+		sides, errSides = strconv.Atoi(b)
+		if !ok || errN != nil || errSides != nil || n < 1 || n > maxDice || sides < 2 || sides > 1000 {
+			return Reply{}, ErrUsage
+		}
+	}
+
+	rolls := make([]string, n)
+	total := 0
+	for i := range rolls {
+		r := rand.IntN(sides) + 1
+		total += r
+		rolls[i] = strconv.Itoa(r)
+	}
+	result := strconv.Itoa(total)
+	if n > 1 {
+		result = strings.Join(rolls, " + ") + " = " + result
+	}
+	return Reply{Content: fmt.Sprintf("🎲 %s rolled %dd%d: %s", call.Username, n, sides, result), Public: true}, nil
+}
+
+func (h *Hub) mute(_ context.Context, call Call) (Reply, error) {
+	fields := strings.Fields(call.Args)
+	if len(fields) == 0 || len(fields) > 2 {
+		return Reply{}, ErrUsage
+	}
+	d := defaultMute
+	if len(fields) == 2 {
+		var err error
+		if d, err = time.ParseDuration(fields[1]); err != nil || d <= 0 {
+			return Reply{}, ErrUsage
+		}
+	}
+
+	h.room.mu.Lock()
+	h.room.muted[fields[0]] = time.Now().Add(d)
+	h.room.mu.Unlock()
+	return Reply{Content: fmt.Sprintf("%s is muted for %s", fields[0], d)}, nil
+}
+
+func (h *Hub) unmute(_ context.Context, call Call) (Reply, error) {
+	name := strings.TrimSpace(call.Args)
+	if name == "" || strings.Contains(name, " ") {
+		return Reply{}, ErrUsage
+	}
+	h.room.mu.Lock()
+	_, ok := h.room.muted[name]
+	delete(h.room.muted, name)
+	h.room.mu.Unlock()
+	if !ok {
+		return Reply{Content: name + " wasn't muted"}, nil
+	}
+	return Reply{Content: name + " can post again"}, nil
+}
@@ -1,49 +1,90 @@
 package websocket
 
 import (
+	"context"
 	"encoding/json"
 	"time"
 
 	"github.com/gorilla/websocket"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
+	"golang.org/x/time/rate"
 
+	"li-chat/internal/metrics"
 	"li-chat/pkg/logger"
 )
 
 type Client struct {
+	// ctx is cancelled when the connection ends or the hub shuts down, which
+	// aborts any database work still running on this client's behalf
+	ctx      context.Context
+	cancel   context.CancelFunc
 	hub      *Hub
 	conn     *websocket.Conn
 	send     chan []byte
 	userID   int64
 	username string
+	// canPost is false for API keys without the messages:write scope
+	canPost bool
+	// apiKeyID is the key the connection authenticated with, zero for a
+	// login
+	apiKeyID int64
+	// expiresAt is when the credential the connection opened with lapses,
+	// zero if it doesn't. Refreshes reach writePump through renew.
+	expiresAt time.Time
+	renew     chan time.Time
+	// limiter is only touched by readPump
+	limiter *rate.Limiter
+	// closeCode and closeReason are set by the hub just before it closes
+	// send, and read by writePump once it sees the channel closed
+	closeCode   int
+	closeReason string
 }
 
-const (
-	writeWait  = 10 * time.Second
-	pongWait   = 60 * time.Second
-	pingPeriod = (pongWait * 9) / 10
-)
+// Limits bounds what a single connection may do
+type Limits struct {
+	MaxMessageBytes int64
+	SendBuffer      int
+	WriteWait       time.Duration
+	PongWait        time.Duration
+	PingPeriod      time.Duration
+	// RecheckInterval is how often open connections are checked for a
+	// disabled account or revoked API key; zero never checks
+	RecheckInterval time.Duration
+}
 
 type IncomingMessage struct {
+	// Type is empty for chat messages
+	Type     string `json:"type"`
 	Username string `json:"username"`
 	Content  string `json:"content"`
+	// AccessToken is only set on token.refresh frames
+	AccessToken string `json:"access_token"`
 }
 
 func (c *Client) readPump() {
-	logger.Info("Read pump started for user", zap.String("username", c.username), zap.Int64("user_id", c.userID))
-	logger.Debug("Setting up read deadline and handlers")
+	log.InfoContext(c.ctx, "Read pump started for user", zap.String("username", c.username))
+	log.DebugContext(c.ctx, "Setting up read deadline and handlers")
 
+	defer c.hub.pumps.Done()
 	defer func() {
-		logger.Debug("Cleaning up - unregistering client and closing connection")
-		c.hub.unregister <- c
+		log.DebugContext(c.ctx, "Cleaning up - unregistering client and closing connection")
+		c.cancel()
+		select {
+		case c.hub.unregister <- c:
+		case <-c.hub.done:
+		}
 		c.conn.Close()
-		logger.Info("Read pump ended for user", zap.String("username", c.username), zap.Int64("user_id", c.userID))
+		log.InfoContext(c.ctx, "Read pump ended for user", zap.String("username", c.username))
 	}()
 
-	c.conn.SetReadDeadline(time.Now().Add(pongWait))
+	limits := c.hub.limits
+	c.conn.SetReadLimit(limits.MaxMessageBytes)
+	c.conn.SetReadDeadline(time.Now().Add(limits.PongWait))
 	c.conn.SetPongHandler(func(string) error {
-		logger.Debug("Pong message received from user", zap.String("username", c.username))
-		c.conn.SetReadDeadline(time.Now().Add(pongWait))
+		log.DebugContext(c.ctx, "Pong message received from user", zap.String("username", c.username))
+		c.conn.SetReadDeadline(time.Now().Add(limits.PongWait))
 		return nil
 	})
 
@@ -51,70 +92,155 @@ func (c *Client) readPump() {
 		_, data, err := c.conn.ReadMessage()
 		if err != nil {
 			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
-				logger.Error("WebSocket error from user", zap.String("username", c.username), zap.Int64("user_id", c.userID), zap.Error(err))
-				logger.Warn("Unexpected connection close")
+				log.ErrorContext(c.ctx, "WebSocket error from user", zap.String("username", c.username), zap.Error(err))
+				log.WarnContext(c.ctx, "Unexpected connection close")
 			} else {
-				logger.Debug("WebSocket connection closed normally", zap.String("username", c.username), zap.Int64("user_id", c.userID))
+				log.DebugContext(c.ctx, "WebSocket connection closed normally", zap.String("username", c.username))
 			}
 			break
 		}
 
-		logger.Debug("Raw WebSocket message received", zap.String("username", c.username), zap.Int("size", len(data)))
+		log.DebugContext(c.ctx, "Raw WebSocket message received", zap.String("username", c.username), zap.Int("size", len(data)))
+		metrics.MessagesReceived.Inc()
+
+		if !c.allow() {
+			metrics.MessagesRateLimited.Inc()
+			log.WarnContext(c.ctx, "Rate limit exceeded, message dropped", zap.String("username", c.username))
+			continue
+		}
 
 		var msg IncomingMessage
 		if err := json.Unmarshal(data, &msg); err != nil {
-			logger.Warn("Failed to unmarshal message from user", zap.String("username", c.username), zap.Error(err))
+			log.WarnContext(c.ctx, "Failed to unmarshal message from user", zap.String("username", c.username), zap.Error(err))
+			metrics.MessagesDropped.WithLabelValues(metrics.DropInvalid).Inc()
 			continue
 		}
 
-		logger.Debug("Message parsed successfully", zap.String("username", msg.Username), zap.Int("content_length", len(msg.Content)))
-		logger.Debug("Forwarding message to hub handler")
-		c.hub.handleMessage(msg)
+		switch msg.Type {
+		case "":
+			log.DebugContext(c.ctx, "Message parsed successfully", zap.String("username", msg.Username), logger.Content("content", msg.Content))
+			log.DebugContext(c.ctx, "Forwarding message to hub handler")
+			c.handleFrame(msg)
+		case frameTokenRefresh:
+			c.refreshToken(msg.AccessToken)
+		default:
+			log.WarnContext(c.ctx, "Unknown frame type from user", zap.String("username", c.username), zap.String("type", msg.Type))
+			metrics.MessagesDropped.WithLabelValues(metrics.DropInvalid).Inc()
+		}
 	}
 }
 
-func (c *Client) writePump() {
-	logger.Info("Write pump started for user", zap.String("username", c.username), zap.Int64("user_id", c.userID))
-	logger.Debug("Setting up ping ticker", zap.Duration("period", pingPeriod))
+// handleFrame passes one message to the hub under its own trace. The
+// connection outlives the upgrade request's span, so each frame starts a
+// new root linked back to it rather than a child of it.
+func (c *Client) handleFrame(msg IncomingMessage) {
+	ctx, span := tracer.Start(c.ctx, "websocket.message",
+		trace.WithNewRoot(),
+		trace.WithLinks(trace.LinkFromContext(c.ctx)),
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(attribute.Int64("user.id", c.userID)))
+	defer span.End()
+
+	c.hub.handleMessage(ctx, c, msg)
+}
+
+// allow applies the hub's current rate limit, adopting any change made by
+// a config reload since the last message
+func (c *Client) allow() bool {
+	limit, burst := c.hub.policy.Load().limit()
+	if c.limiter.Limit() != limit || c.limiter.Burst() != burst {
+		c.limiter.SetLimit(limit)
+		c.limiter.SetBurst(burst)
+	}
+	return c.limiter.Allow()
+}
 
-	ticker := time.NewTicker(pingPeriod)
+func (c *Client) writePump() {
+	log.InfoContext(c.ctx, "Write pump started for user", zap.String("username", c.username))
+	limits := c.hub.limits
+	log.DebugContext(c.ctx, "Setting up ping ticker", zap.Duration("period", limits.PingPeriod))
+
+	ticker := time.NewTicker(limits.PingPeriod)
+	var token tokenTimers
+	token.reset(c.expiresAt)
+	defer c.hub.pumps.Done()
 	defer func() {
-		logger.Debug("Cleaning up - stopping ticker and closing connection")
+		log.DebugContext(c.ctx, "Cleaning up - stopping ticker and closing connection")
 		ticker.Stop()
+		token.stop()
 		c.conn.Close()
-		logger.Info("Write pump ended for user", zap.String("username", c.username), zap.Int64("user_id", c.userID))
+		log.InfoContext(c.ctx, "Write pump ended for user", zap.String("username", c.username))
 	}()
 
 	for {
 		select {
 		case message, ok := <-c.send:
-			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			c.conn.SetWriteDeadline(time.Now().Add(limits.WriteWait))
 			if !ok {
-				logger.Debug("Send channel closed by hub, sending close message")
-				if err := c.conn.WriteMessage(websocket.CloseMessage, []byte{}); err != nil {
-					logger.Error("Failed to send close message", zap.Error(err))
+				log.DebugContext(c.ctx, "Send channel closed by hub, sending close message")
+				if err := c.conn.WriteMessage(websocket.CloseMessage, c.closeMessage()); err != nil {
+					log.ErrorContext(c.ctx, "Failed to send close message", zap.Error(err))
+				}
+				log.InfoContext(c.ctx, "Connection closing initiated for user", zap.String("username", c.username))
+				// Give the peer a moment to answer the close frame so the
+				// connection ends cleanly rather than with a reset
+				select {
+				case <-c.ctx.Done():
+				case <-time.After(limits.WriteWait):
 				}
-				logger.Info("Connection closing initiated for user", zap.String("username", c.username))
 				return
 			}
 
-			logger.Debug("Sending message to client", zap.String("username", c.username), zap.Int("message_size", len(message)))
+			log.DebugContext(c.ctx, "Sending message to client", zap.String("username", c.username), zap.Int("message_size", len(message)))
 			if err := c.conn.WriteMessage(websocket.TextMessage, message); err != nil {
-				logger.Error("Failed to write message to user", zap.String("username", c.username), zap.Error(err))
-				logger.Warn("Connection error - terminating write pump")
+				log.ErrorContext(c.ctx, "Failed to write message to user", zap.String("username", c.username), zap.Error(err))
+				log.WarnContext(c.ctx, "Connection error - terminating write pump")
 				return
 			}
-			logger.Debug("Message sent successfully to user", zap.String("username", c.username))
+			log.DebugContext(c.ctx, "Message sent successfully to user", zap.String("username", c.username))
 
 		case <-ticker.C:
-			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
-			logger.Debug("Sending ping message to user", zap.String("username", c.username))
+			c.conn.SetWriteDeadline(time.Now().Add(limits.WriteWait))
+			log.DebugContext(c.ctx, "Sending ping message to user", zap.String("username", c.username))
 			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
-				logger.Error("Failed to write ping message to user", zap.String("username", c.username), zap.Error(err))
-				logger.Warn("Ping send failed - terminating connection")
+				log.ErrorContext(c.ctx, "Failed to write ping message to user", zap.String("username", c.username), zap.Error(err))
+				log.WarnContext(c.ctx, "Ping send failed - terminating connection")
+				return
+			}
+			log.DebugContext(c.ctx, "Ping message sent to user", zap.String("username", c.username))
+
+		case <-token.warnC():
+			log.DebugContext(c.ctx, "Access token expiring, asking user to refresh", zap.String("username", c.username), zap.Time("expires_at", token.expiresAt))
+			if !c.writeFrame(tokenFrame{Type: frameTokenExpiring, ExpiresAt: token.expiresAt}) {
+				return
+			}
+
+		case <-token.lapseC():
+			log.InfoContext(c.ctx, "Access token expired, disconnecting user", zap.String("username", c.username))
+			// Run closes send, which ends this loop with the close frame
+			c.hub.disconnect(c, CloseTokenExpired, "access token expired, reconnect with a fresh one")
+
+		case expiresAt := <-c.renew:
+			token.reset(expiresAt)
+			if !c.writeFrame(tokenFrame{Type: frameTokenRefreshed, ExpiresAt: expiresAt}) {
 				return
 			}
-			logger.Debug("Ping message sent to user", zap.String("username", c.username))
 		}
 	}
 }
+
+// writeFrame writes v as a text frame from writePump, reporting whether
+// the connection is still usable
+func (c *Client) writeFrame(v any) bool {
+	data, err := json.Marshal(v)
+	if err != nil {
+		log.ErrorContext(c.ctx, "Error marshaling frame", zap.Error(err))
+		return true
+	}
+	c.conn.SetWriteDeadline(time.Now().Add(c.hub.limits.WriteWait))
+	if err := c.conn.WriteMessage(websocket.TextMessage, data); err != nil {
+		log.ErrorContext(c.ctx, "Failed to write frame to user", zap.String("username", c.username), zap.Error(err))
+		return false
+	}
+	return true
+}
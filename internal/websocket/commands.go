@@ -0,0 +1,202 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"li-chat/internal/db"
+	"li-chat/internal/model"
+)
+
+// Command is a slash command. Messages starting with "/" are run as
+// commands instead of being stored; "//" escapes a literal slash.
+type Command struct {
+	// Name is what follows the slash: lower case letters, digits, - and _
+	Name string
+	// Usage shows the arguments, e.g. "/roll [NdM]"
+	Usage string
+	// Help is one line for /help
+	Help string
+	// AdminOnly refuses the command to users who aren't admins, the same
+	// set allowed to call /api/admin endpoints
+	AdminOnly bool
+	Run       CommandFunc
+}
+
+// CommandFunc runs a command. An error is shown to the caller only;
+// wrap ErrUsage to have the command's usage line shown instead.
+type CommandFunc func(ctx context.Context, call Call) (Reply, error)
+
+// Call is one invocation of a command
+type Call struct {
+	Name     string
+	Args     string
+	UserID   int64
+	Username string
+	Admin    bool
+}
+
+// Reply is what a command answers with. Empty content sends nothing.
+type Reply struct {
+	Content string
+	// Public stores the reply and shows it to everyone; otherwise only the
+	// caller sees it and nothing is stored
+	Public bool
+	// From is who a public reply is posted as; empty means the caller
+	From string
+}
+
+var (
+	ErrCommandExists = errors.New("command already registered")
+	ErrUsage         = errors.New("usage")
+)
+
+var commandName = regexp.MustCompile(`^[a-z][a-z0-9_-]*$`)
+
+// commandSet is the hub's registry, shared by the built-ins and bots
+type commandSet struct {
+	mu       sync.RWMutex
+	commands map[string]Command
+}
+
+// RegisterCommand adds a command. It is safe to call while the hub runs.
+func (h *Hub) RegisterCommand(cmd Command) error {
+	if !commandName.MatchString(cmd.Name) || cmd.Run == nil {
+		return fmt.Errorf("invalid command %q", cmd.Name)
+	}
+	h.commands.mu.Lock()
+	defer h.commands.mu.Unlock()
+	if _, ok := h.commands.commands[cmd.Name]; ok {
+		return fmt.Errorf("/%s: %w", cmd.Name, ErrCommandExists)
+	}
+	h.commands.commands[cmd.Name] = cmd
+	log.Info("Command registered", zap.String("command", cmd.Name))
+	return nil
+}
+
+// Commands lists the registered commands by name
+func (h *Hub) Commands() []Command {
+	h.commands.mu.RLock()
+	defer h.commands.mu.RUnlock()
+	list := make([]Command, 0, len(h.commands.commands))
+	for _, cmd := range h.commands.commands {
+		list = append(list, cmd)
+	}
+	slices.SortFunc(list, func(a, b Command) int { return strings.Compare(a.Name, b.Name) })
+	return list
+}
+
+// parseCommand splits "/name args". ok is false for anything else,
+// including "//" escapes.
+func parseCommand(content string) (name, args string, ok bool) {
+	if !strings.HasPrefix(content, "/") || strings.HasPrefix(content, "//") {
+		return "", "", false
+	}
+	name, args, _ = strings.Cut(content[1:], " ")
+	return strings.ToLower(name), strings.TrimSpace(args), true
+}
+
+// runCommand handles a slash command from c. Replies go to c alone or,
+// when public, through Post like any other message.
+func (h *Hub) runCommand(ctx context.Context, c *Client, name, args string) {
+	ctx, span := tracer.Start(ctx, "hub.command")
+	defer span.End()
+
+	h.commands.mu.RLock()
+	cmd, ok := h.commands.commands[name]
+	h.commands.mu.RUnlock()
+	if !ok {
+		log.DebugContext(ctx, "Unknown command", zap.String("username", c.username), zap.String("command", name))
+		h.notify(ctx, c, fmt.Sprintf("Unknown command /%s; try /help", name))
+		return
+	}
+
+	call := Call{Name: name, Args: args, UserID: c.userID, Username: c.username, Admin: h.isAdmin(ctx, c.username)}
+	if cmd.AdminOnly && !call.Admin {
+		log.WarnContext(ctx, "Admin command refused", zap.String("username", c.username), zap.String("command", name))
+		h.notify(ctx, c, fmt.Sprintf("/%s is for admins only", name))
+		return
+	}
+
+	log.InfoContext(ctx, "Running command", zap.String("username", c.username), zap.String("command", name))
+	reply, err := cmd.Run(ctx, call)
+	switch {
+	case errors.Is(err, ErrUsage):
+		h.notify(ctx, c, "Usage: "+cmd.Usage)
+		return
+	case err != nil:
+		log.WarnContext(ctx, "Command failed", zap.String("command", name), zap.Error(err))
+		h.notify(ctx, c, err.Error())
+		return
+	case reply.Content == "":
+		return
+	case !reply.Public:
+		h.notify(ctx, c, reply.Content)
+		return
+	}
+
+	from := reply.From
+	if from == "" {
+		from = c.username
+	}
+	if _, err := h.Post(ctx, from, reply.Content); err != nil {
+		h.notify(ctx, c, postError(err))
+	}
+}
+
+// postError is what the sender is told when Post fails
+func postError(err error) string {
+	if errors.Is(err, ErrMuted) {
+		return err.Error()
+	}
+	return "Message not sent; try again"
+}
+
+// noticeFrame is a reply only its recipient sees. It has no ID and isn't
+// stored, so it is gone after a reconnect.
+type noticeFrame struct {
+	Type      string `json:"type"`
+	Content   string `json:"content"`
+	CreatedAt string `json:"created_at"`
+}
+
+// notify sends content to c alone, through Run, which owns c.send
+func (h *Hub) notify(ctx context.Context, c *Client, content string) {
+	data, _ := json.Marshal(noticeFrame{Type: "notice", Content: content, CreatedAt: time.Now().UTC().Format(time.RFC3339)})
+	select {
+	case h.direct <- directed{client: c, data: data}:
+	case <-h.done:
+	case <-ctx.Done():
+	}
+}
+
+// directed is a frame for one client
+type directed struct {
+	client *Client
+	data   []byte
+}
+
+// isAdmin matches httpserver.RequireAdmin: listed in the config or given
+// the admin role, and not disabled
+func (h *Hub) isAdmin(ctx context.Context, username string) bool {
+	if slices.Contains(h.policy.Load().Admins, username) {
+		return true
+	}
+	account, err := h.repo.GetAccount(ctx, username)
+	if err != nil {
+		if !errors.Is(err, db.ErrNotFound) {
+			log.ErrorContext(ctx, "Failed to load account role", zap.String("username", username), zap.Error(err))
+		}
+		return false
+	}
+	return account.Role == model.RoleAdmin && !account.Disabled
+}
@@ -0,0 +1,231 @@
+package websocket
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"li-chat/internal/auth"
+	"li-chat/internal/db"
+	"li-chat/internal/model"
+)
+
+func TestParseCommand(t *testing.T) {
+	tests := []struct {
+		content string
+		name    string
+		args    string
+		ok      bool
+	}{
+		{"/help", "help", "", true},
+		{"/ROLL 2d6", "roll", "2d6", true},
+		{"/me   waves at everyone  ", "me", "waves at everyone", true},
+		{"/mute bob 1h", "mute", "bob 1h", true},
+		{"/", "", "", true},
+		{"//shrug", "", "", false},
+		{"// not a command", "", "", false},
+		{"hello /help", "", "", false},
+		{" /help", "", "", false},
+		{"", "", "", false},
+	}
+	for _, tt := range tests {
+		name, args, ok := parseCommand(tt.content)
+		if name != tt.name || args != tt.args || ok != tt.ok {
+			t.Errorf("parseCommand(%q) = %q, %q, %v; want %q, %q, %v", tt.content, name, args, ok, tt.name, tt.args, tt.ok)
+		}
+	}
+}
+
+var rollResult = regexp.MustCompile(`^🎲 alice rolled (\d+)d(\d+): (.+)$`)
+
+func TestRoll(t *testing.T) {
+	tests := []struct {
+		args  string
+		n     int
+		sides int
+	}{
+		{"", 1, 6},
+		{"2d6", 2, 6},
+		{"d20", 1, 20},
+		{"3D8", 3, 8},
+		{"20d1000", 20, 1000},
+		{"1d2", 1, 2},
+		// zero n means usage
+		{"0d6", 0, 0},
+		{"21d6", 0, 0},
+		{"1d1", 0, 0},
+		{"1d1001", 0, 0},
+		{"-1d6", 0, 0},
+		{"2d", 0, 0},
+		{"2x6", 0, 0},
+		{"two", 0, 0},
+		{"2d6 extra", 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.args, func(t *testing.T) {
+			reply, err := roll(context.Background(), Call{Name: "roll", Args: tt.args, Username: "alice"})
+			if tt.n == 0 {
+				if !errors.Is(err, ErrUsage) {
+					t.Errorf("roll(%q) = %+v, %v; want ErrUsage", tt.args, reply, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("roll(%q): %v", tt.args, err)
+			}
+			if !reply.Public {
+				t.Error("roll reply isn't public")
+			}
+			m := rollResult.FindStringSubmatch(reply.Content)
+			if m == nil || m[1] != strconv.Itoa(tt.n) || m[2] != strconv.Itoa(tt.sides) {
+				t.Fatalf("roll(%q) = %q, want %dd%d", tt.args, reply.Content, tt.n, tt.sides)
+			}
+
+			dice, total := strings.Split(m[3], " + "), m[3]
+			if tt.n > 1 {
+				var last string
+				dice[len(dice)-1], last, _ = strings.Cut(dice[len(dice)-1], " = ")
+				total = last
+			}
+			if len(dice) != tt.n {
+				t.Fatalf("roll(%q) shows %d dice, want %d", tt.args, len(dice), tt.n)
+			}
+			sum := 0
+			for _, d := range dice {
+				v, err := strconv.Atoi(d)
+				if err != nil || v < 1 || v > tt.sides {
+					t.Errorf("roll(%q) has die %q outside 1..%d", tt.args, d, tt.sides)
+				}
+				sum += v
+			}
+			if total != strconv.Itoa(sum) {
+				t.Errorf("roll(%q) total %s, want %d", tt.args, total, sum)
+			}
+		})
+	}
+}
+
+// command sends content from conn and returns the notice it gets back
+func command(t *testing.T, conn *websocket.Conn, content string) string {
+	t.Helper()
+	say(t, conn, content)
+	f := readFrame(t, conn)
+	if f.Type != "notice" {
+		t.Fatalf("%s: frame = %+v, want a notice", content, f)
+	}
+	return f.Content
+}
+
+func TestAdminCommands(t *testing.T) {
+	ctx := context.Background()
+	store := db.NewMemoryStore()
+	h := startHub(t, store, testLimits)
+	h.SetPolicy(Policy{Admins: []string{"root"}})
+	connect := func(username string) *websocket.Conn {
+		return dial(t, h, store, &auth.Identity{UserID: newUser(t, store, username), Username: username})
+	}
+	carol := connect("carol")
+	root := connect("root")
+	ops := connect("ops")
+	if err := store.SetRole(ctx, "ops", model.RoleAdmin); err != nil {
+		t.Fatalf("SetRole: %v", err)
+	}
+	newUser(t, store, "bob")
+
+	if got := command(t, carol, "/mute bob"); got != "/mute is for admins only" {
+		t.Errorf("/mute from a user: %q", got)
+	}
+	if got := command(t, carol, "/unmute bob"); got != "/unmute is for admins only" {
+		t.Errorf("/unmute from a user: %q", got)
+	}
+	if h.room.mutedFor("bob") != 0 {
+		t.Fatal("a user's /mute took effect")
+	}
+
+	tests := []struct {
+		conn    *websocket.Conn
+		content string
+		want    string
+		muted   bool
+	}{
+		{root, "/mute bob", "bob is muted for 10m0s", true},
+		{root, "/unmute bob", "bob can post again", false},
+		{root, "/unmute bob", "bob wasn't muted", false},
+		{ops, "/mute bob 1h", "bob is muted for 1h0m0s", true},
+		{ops, "/unmute bob", "bob can post again", false},
+		{root, "/mute bob soon", "Usage: /mute NAME [DURATION]", false},
+		{root, "/mute bob -1m", "Usage: /mute NAME [DURATION]", false},
+		{root, "/mute", "Usage: /mute NAME [DURATION]", false},
+		{root, "/unmute bob carol", "Usage: /unmute NAME", false},
+	}
+	for _, tt := range tests {
+		if got := command(t, tt.conn, tt.content); got != tt.want {
+			t.Errorf("%s: %q, want %q", tt.content, got, tt.want)
+		}
+		if muted := h.room.mutedFor("bob") > 0; muted != tt.muted {
+			t.Errorf("after %s: bob muted = %v, want %v", tt.content, muted, tt.muted)
+		}
+	}
+
+	command(t, root, "/mute bob")
+	if _, err := h.Post(ctx, "bob", "hello"); !errors.Is(err, ErrMuted) {
+		t.Errorf("Post as muted bob = %v, want ErrMuted", err)
+	}
+}
+
+func TestSlashEscape(t *testing.T) {
+	store := db.NewMemoryStore()
+	h := startHub(t, store, testLimits)
+	conn := dial(t, h, store, &auth.Identity{UserID: newUser(t, store, "alice"), Username: "alice"})
+
+	say(t, conn, "//shrug")
+	if f := readFrame(t, conn); f.Type != "" || f.Content != "/shrug" {
+		t.Errorf("frame = %+v, want the message /shrug", f)
+	}
+	if got := command(t, conn, "/nosuch"); got != "Unknown command /nosuch; try /help" {
+		t.Errorf("unknown command: %q", got)
+	}
+}
+
+func TestBotNotBot(t *testing.T) {
+	ctx := context.Background()
+	store := db.NewMemoryStore()
+	h := startHub(t, store, testLimits)
+	newUser(t, store, "alice")
+
+	if _, err := h.NewBot("alice").Post(ctx, "I am alice"); !errors.Is(err, ErrNotBot) {
+		t.Errorf("Post as a person's name = %v, want ErrNotBot", err)
+	}
+	msg, err := h.NewBot("helper").Post(ctx, "hello")
+	if err != nil {
+		t.Fatalf("Post as a new bot: %v", err)
+	}
+	if !msg.Bot {
+		t.Error("bot message isn't flagged as a bot's")
+	}
+	messages, err := store.GetMessages(ctx, 10)
+	if err != nil {
+		t.Fatalf("GetMessages: %v", err)
+	}
+	if len(messages) != 1 || messages[0].Username != "helper" {
+		t.Errorf("messages = %+v, want only helper's", messages)
+	}
+
+	// A public reply from a command is refused the same way
+	err = h.NewBot("alice").Command(Command{Name: "deploy", Usage: "/deploy", Run: func(context.Context, Call) (Reply, error) {
+		return Reply{Content: "deploying", Public: true}, nil
+	}})
+	if err != nil {
+		t.Fatalf("Command: %v", err)
+	}
+	conn := dial(t, h, store, &auth.Identity{UserID: newUser(t, store, "carol"), Username: "carol", ExpiresAt: time.Now().Add(time.Hour)})
+	if got := command(t, conn, "/deploy"); !strings.Contains(got, ErrNotBot.Error()) {
+		t.Errorf("/deploy: %q, want the ErrNotBot error", got)
+	}
+}
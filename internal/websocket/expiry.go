@@ -0,0 +1,146 @@
+package websocket
+
+import (
+	"errors"
+	"time"
+
+	"go.uber.org/zap"
+
+	"li-chat/internal/auth"
+	"li-chat/internal/db"
+)
+
+// Close codes from the range reserved for applications
+const (
+	// CloseTokenExpired ends a connection whose credential lapsed without a
+	// token.refresh. Clients should reconnect with a fresh token.
+	CloseTokenExpired = 4001
+	// CloseKeyRevoked ends a connection whose API key was revoked;
+	// reconnecting won't help
+	CloseKeyRevoked = 4002
+	// CloseAccountDisabled ends a connection whose account was disabled or
+	// removed; reconnecting won't help
+	CloseAccountDisabled = 4003
+)
+
+// Frame types about the connection's access token. The server sends
+// token.expiring ahead of the expiry; the client answers with
+// {"type": "token.refresh", "access_token": "..."} carrying a fresh token
+// for the same user, which token.refreshed confirms.
+const (
+	frameTokenExpiring  = "token.expiring"
+	frameTokenRefresh   = "token.refresh"
+	frameTokenRefreshed = "token.refreshed"
+)
+
+// expiringLead is how long before its token lapses a client is warned, or
+// half the time left when that is shorter
+const expiringLead = 5 * time.Minute
+
+type tokenFrame struct {
+	Type      string    `json:"type"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// tokenTimers tell writePump when to warn about the token and when it
+// lapses. Their channels are nil for credentials that don't expire.
+type tokenTimers struct {
+	expiresAt time.Time
+	warn      *time.Timer
+	lapse     *time.Timer
+}
+
+func (t *tokenTimers) reset(expiresAt time.Time) {
+	t.stop()
+	t.expiresAt = expiresAt
+	if expiresAt.IsZero() {
+		return
+	}
+	left := time.Until(expiresAt)
+	t.warn = time.NewTimer(left - min(expiringLead, left/2))
+	t.lapse = time.NewTimer(left)
+}
+
+func (t *tokenTimers) stop() {
+	if t.warn != nil {
+		t.warn.Stop()
+		t.lapse.Stop()
+	}
+	t.warn, t.lapse = nil, nil
+}
+
+func (t *tokenTimers) warnC() <-chan time.Time {
+	if t.warn == nil {
+		return nil
+	}
+	return t.warn.C
+}
+
+func (t *tokenTimers) lapseC() <-chan time.Time {
+	if t.lapse == nil {
+		return nil
+	}
+	return t.lapse.C
+}
+
+// refreshToken extends the connection to a fresh access token's expiry.
+// The token must be an access token, not a refresh token, for the same
+// user, whose account is checked again, so a disabled or removed account
+// is cut off at its next refresh if not before.
+func (c *Client) refreshToken(token string) {
+	if c.expiresAt.IsZero() {
+		c.hub.notify(c.ctx, c, "This connection doesn't expire; there is no token to refresh.")
+		return
+	}
+	// ValidateToken refuses refresh tokens, which would otherwise stretch
+	// the connection to the refresh token's lifetime
+	claims, err := auth.ValidateToken(token)
+	if err == nil && (claims.UserID != c.userID || claims.ExpiresAt == nil) {
+		err = errors.New("token is for another user or doesn't expire")
+	}
+	if err != nil {
+		log.WarnContext(c.ctx, "Token refresh rejected", zap.String("username", c.username), zap.Error(err))
+		c.hub.notify(c.ctx, c, "Token refresh rejected: not a valid access token for "+c.username+".")
+		return
+	}
+	if _, err := c.hub.repo.GetUserByID(c.ctx, c.userID); err != nil {
+		if errors.Is(err, db.ErrDisabled) {
+			log.WarnContext(c.ctx, "Token refresh refused for disabled account, disconnecting", zap.String("username", c.username))
+			c.hub.disconnect(c, CloseAccountDisabled, "account disabled")
+			return
+		}
+		if errors.Is(err, db.ErrNotFound) {
+			log.WarnContext(c.ctx, "Token refresh refused for removed account, disconnecting", zap.String("username", c.username))
+			c.hub.disconnect(c, CloseAccountDisabled, "account removed")
+			return
+		}
+		log.ErrorContext(c.ctx, "Failed to check account on token refresh", zap.String("username", c.username), zap.Error(err))
+		c.hub.notify(c.ctx, c, "Token refresh failed; try again.")
+		return
+	}
+
+	log.DebugContext(c.ctx, "Token refreshed", zap.String("username", c.username), zap.Time("expires_at", claims.ExpiresAt.Time))
+	// Only readPump sends, so after emptying the slot this can't block
+	select {
+	case <-c.renew:
+	default:
+	}
+	c.renew <- claims.ExpiresAt.Time
+}
+
+// kicked is a client Run should disconnect with a close code
+type kicked struct {
+	client *Client
+	code   int
+	reason string
+}
+
+// disconnect has Run close c's connection with code once its queued
+// frames are written
+func (h *Hub) disconnect(c *Client, code int, reason string) {
+	select {
+	case h.kick <- kicked{client: c, code: code, reason: reason}:
+	case <-h.done:
+	case <-c.ctx.Done():
+	}
+}
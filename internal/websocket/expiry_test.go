@@ -0,0 +1,154 @@
+package websocket
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"li-chat/internal/auth"
+	"li-chat/internal/db"
+)
+
+func TestTokenRefresh(t *testing.T) {
+	store := db.NewMemoryStore()
+	h := startHub(t, store, testLimits)
+	alice := newUser(t, store, "alice")
+	expiresAt := time.Now().Add(time.Second)
+	conn := dial(t, h, store, &auth.Identity{UserID: alice, Username: "alice", ExpiresAt: expiresAt})
+
+	if f := readFrame(t, conn); f.Type != frameTokenExpiring || !f.ExpiresAt.Equal(expiresAt) {
+		t.Fatalf("frame = %+v, want %s for %v", f, frameTokenExpiring, expiresAt)
+	}
+	token, err := auth.GenerateToken(alice, "alice")
+	if err != nil {
+		t.Fatalf("GenerateToken: %v", err)
+	}
+	claims, err := auth.ValidateToken(token)
+	if err != nil {
+		t.Fatalf("ValidateToken: %v", err)
+	}
+	if err := conn.WriteJSON(IncomingMessage{Type: frameTokenRefresh, AccessToken: token}); err != nil {
+		t.Fatalf("write refresh: %v", err)
+	}
+	if f := readFrame(t, conn); f.Type != frameTokenRefreshed || !f.ExpiresAt.Equal(claims.ExpiresAt.Time) {
+		t.Fatalf("frame = %+v, want %s for %v", f, frameTokenRefreshed, claims.ExpiresAt.Time)
+	}
+
+	// The old expiry passes without the connection closing
+	time.Sleep(time.Until(expiresAt) + 200*time.Millisecond)
+	say(t, conn, "still here")
+	if f := readFrame(t, conn); f.Content != "still here" {
+		t.Errorf("frame = %+v, want the message back", f)
+	}
+}
+
+func TestTokenRefreshRejected(t *testing.T) {
+	store := db.NewMemoryStore()
+	h := startHub(t, store, testLimits)
+	alice := newUser(t, store, "alice")
+	bob := newUser(t, store, "bob")
+	refresh, err := auth.GenerateRefreshToken(alice)
+	if err != nil {
+		t.Fatalf("GenerateRefreshToken: %v", err)
+	}
+	other, err := auth.GenerateToken(bob, "bob")
+	if err != nil {
+		t.Fatalf("GenerateToken: %v", err)
+	}
+
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{"refresh token", refresh},
+		{"another user's token", other},
+		{"garbage", "not-a-token"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn := dial(t, h, store, &auth.Identity{UserID: alice, Username: "alice", ExpiresAt: time.Now().Add(time.Hour)})
+			if err := conn.WriteJSON(IncomingMessage{Type: frameTokenRefresh, AccessToken: tt.token}); err != nil {
+				t.Fatalf("write refresh: %v", err)
+			}
+			if f := readFrame(t, conn); f.Type != "notice" || !strings.HasPrefix(f.Content, "Token refresh rejected") {
+				t.Errorf("frame = %+v, want a rejection notice", f)
+			}
+		})
+	}
+}
+
+func TestTokenRefreshAccountGone(t *testing.T) {
+	tests := []struct {
+		name    string
+		disable bool
+		reason  string
+	}{
+		{"disabled", true, "account disabled"},
+		{"removed", false, "account removed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := db.NewMemoryStore()
+			h := startHub(t, store, testLimits)
+			// The memory store can't delete accounts; an ID it never issued
+			// looks the same to the hub
+			userID := int64(999)
+			if tt.disable {
+				userID = newUser(t, store, "alice")
+			}
+			conn := dial(t, h, store, &auth.Identity{UserID: userID, Username: "alice", ExpiresAt: time.Now().Add(time.Hour)})
+			if tt.disable {
+				if err := store.SetUserDisabled(context.Background(), "alice", true); err != nil {
+					t.Fatalf("SetUserDisabled: %v", err)
+				}
+			}
+
+			token, err := auth.GenerateToken(userID, "alice")
+			if err != nil {
+				t.Fatalf("GenerateToken: %v", err)
+			}
+			if err := conn.WriteJSON(IncomingMessage{Type: frameTokenRefresh, AccessToken: token}); err != nil {
+				t.Fatalf("write refresh: %v", err)
+			}
+			if ce := readClose(t, conn); ce.Code != CloseAccountDisabled || ce.Text != tt.reason {
+				t.Errorf("close = %d %q, want %d %q", ce.Code, ce.Text, CloseAccountDisabled, tt.reason)
+			}
+		})
+	}
+}
+
+func TestTokenExpiredCloses(t *testing.T) {
+	store := db.NewMemoryStore()
+	h := startHub(t, store, testLimits)
+	alice := newUser(t, store, "alice")
+	conn := dial(t, h, store, &auth.Identity{UserID: alice, Username: "alice", ExpiresAt: time.Now().Add(300 * time.Millisecond)})
+
+	if f := readFrame(t, conn); f.Type != frameTokenExpiring {
+		t.Fatalf("frame = %+v, want %s", f, frameTokenExpiring)
+	}
+	if ce := readClose(t, conn); ce.Code != CloseTokenExpired {
+		t.Errorf("close = %d %q, want %d", ce.Code, ce.Text, CloseTokenExpired)
+	}
+}
+
+func TestTokenRefreshWithoutExpiry(t *testing.T) {
+	store := db.NewMemoryStore()
+	h := startHub(t, store, testLimits)
+	botID, err := store.CreateBot(context.Background(), "deploybot")
+	if err != nil {
+		t.Fatalf("CreateBot: %v", err)
+	}
+	conn := dial(t, h, store, &auth.Identity{UserID: botID, Username: "deploybot", Bot: true, APIKeyID: 1, Scopes: []string{auth.ScopeRead}})
+
+	token, err := auth.GenerateToken(botID, "deploybot")
+	if err != nil {
+		t.Fatalf("GenerateToken: %v", err)
+	}
+	if err := conn.WriteJSON(IncomingMessage{Type: frameTokenRefresh, AccessToken: token}); err != nil {
+		t.Fatalf("write refresh: %v", err)
+	}
+	if f := readFrame(t, conn); f.Type != "notice" || !strings.Contains(f.Content, "doesn't expire") {
+		t.Errorf("frame = %+v, want a notice that the connection doesn't expire", f)
+	}
+}
@@ -1,50 +1,51 @@
 package websocket
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"net/http"
-	"strings"
+	"sync"
+	"time"
 
 	"github.com/gorilla/websocket"
+	"go.uber.org/zap"
+	"golang.org/x/time/rate"
 
 	"li-chat/internal/auth"
 	"li-chat/internal/db"
+	"li-chat/pkg/logger"
+	"li-chat/pkg/version"
 )
 
-var upgrader = websocket.Upgrader{
-	CheckOrigin: func(r *http.Request) bool {
-		return true // tighten this in production
-	},
-}
+func HandleWS(hub *Hub, repo db.UserStore) http.HandlerFunc {
+	upgrader := websocket.Upgrader{
+		CheckOrigin: func(r *http.Request) bool {
+			return hub.policy.Load().allowOrigin(r)
+		},
+	}
 
-func HandleWS(hub *Hub, repo *db.Repository) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 
-		// AUTH CHECK - Extract JWT from Authorization header or query parameter
-		var token string
-
-		// Try Authorization header first
-		authHeader := r.Header.Get("Authorization")
-		if authHeader != "" {
-			parts := strings.SplitN(authHeader, " ", 2)
-			if len(parts) == 2 && parts[0] == "Bearer" {
-				token = parts[1]
-			}
-		}
-
-		// Fall back to query parameter
-		if token == "" {
-			token = r.URL.Query().Get("token")
+		// Identity is attached by the auth middleware wrapping this handler
+		identity, ok := auth.IdentityFromContext(r.Context())
+		if !ok {
+			auth.SendJSONResponse(w, http.StatusUnauthorized, auth.ErrorResponse("unauthorized; token missing?"))
+			return
 		}
 
-		if token == "" {
-			http.Error(w, "unauthorized", http.StatusUnauthorized)
+		if !identity.Allows(auth.ScopeRead) {
+			auth.SendJSONResponse(w, http.StatusForbidden, auth.ErrorResponse("forbidden; API key lacks the "+auth.ScopeRead+" scope"))
 			return
 		}
 
-		// Validate JWT token
-		claims, err := auth.ValidateToken(token)
-		if err != nil {
-			http.Error(w, "unauthorized", http.StatusUnauthorized)
+		// Access tokens are stateless, so check that the account wasn't
+		// disabled since this one was issued
+		if _, err := repo.GetUserByID(r.Context(), identity.UserID); errors.Is(err, db.ErrDisabled) {
+			log.WarnContext(r.Context(), "WebSocket refused for disabled account", zap.String("username", identity.Username))
+			auth.SendJSONResponse(w, http.StatusForbidden, auth.ErrorResponse("account disabled"))
 			return
 		}
 
@@ -53,17 +54,68 @@ func HandleWS(hub *Hub, repo *db.Repository) http.HandlerFunc {
 			return
 		}
 
+		// The request context ends as soon as this handler returns, so keep its
+		// values but give the connection its own cancellation
+		ctx, cancel := context.WithCancel(context.WithoutCancel(r.Context()))
+		ctx = logger.WithContext(ctx, zap.String("conn_id", newConnID()))
 		client := &Client{
+			ctx:      ctx,
+			cancel:   cancel,
 			hub:      hub,
 			conn:     conn,
-			send:     make(chan []byte, 256),
-			userID:   claims.UserID,
-			username: claims.Username,
+			send:     make(chan []byte, hub.limits.SendBuffer),
+			userID:   identity.UserID,
+			username: identity.Username,
+			canPost:  identity.Allows(auth.ScopeWrite),
+			apiKeyID: identity.APIKeyID,
+			// Checked again on every token.refresh, and the connection
+			// ends when it lapses
+			expiresAt: identity.ExpiresAt,
+			renew:     make(chan time.Time, 1),
 		}
+		limit, burst := hub.policy.Load().limit()
+		client.limiter = rate.NewLimiter(limit, burst)
+		client.send <- welcome()
 
-		hub.register <- client
+		// Counted before registering so Shutdown can't miss this client
+		hub.pumps.Add(2)
+		select {
+		case hub.register <- client:
+		case <-hub.done:
+			hub.pumps.Add(-2)
+			cancel()
+			conn.Close()
+			return
+		}
 
 		go client.writePump()
 		go client.readPump()
 	}
 }
+
+// newConnID tags every log line for one connection, alongside the
+// request ID of the upgrade request it came from
+func newConnID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// welcomeFrame is the first frame on every connection. Clients compare
+// server.version across reconnects to notice an upgrade and reload.
+type welcomeFrame struct {
+	Type   string       `json:"type"`
+	Server version.Info `json:"server"`
+}
+
+var (
+	welcomeOnce sync.Once
+	welcomeData []byte
+)
+
+func welcome() []byte {
+	welcomeOnce.Do(func() {
+		welcomeData, _ = json.Marshal(welcomeFrame{Type: "welcome", Server: version.Get()})
+	})
+	return welcomeData
+}
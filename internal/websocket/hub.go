@@ -1,119 +1,362 @@
 package websocket
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/gorilla/websocket"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
 
+	"li-chat/internal/auth"
 	"li-chat/internal/db"
+	"li-chat/internal/metrics"
 	"li-chat/internal/model"
 	"li-chat/pkg/logger"
 )
 
+// log is the package logger; its level can be set apart from the rest
+// with log.packages=websocket=<level>
+var log = logger.Named("websocket")
+
+var tracer = otel.Tracer("li-chat/internal/websocket")
+
 type Hub struct {
 	clients    map[*Client]bool
 	register   chan *Client
 	unregister chan *Client
-	repo       *db.Repository
+	broadcast  chan outbound
+	direct     chan directed
+	kick       chan kicked
+	evict      chan eviction
+	roster     chan chan []*Client
+	done       chan struct{}
+	ping       chan chan struct{}
+	drain      chan chan struct{}
+	repo       db.Store
+	limits     Limits
+	policy     atomic.Pointer[Policy]
+	commands   commandSet
+	room       room
+	subs       subscribers
+	outbox     Outbox
+	// pumps counts running read and write pumps so Shutdown can wait for
+	// connections to finish
+	pumps sync.WaitGroup
+}
+
+// outbound is a persisted message waiting to be fanned out by Run
+type outbound struct {
+	ctx      context.Context
+	data     []byte
+	username string
 }
 
-func NewHub(repo *db.Repository) *Hub {
-	logger.Debug("Initializing WebSocket hub")
-	return &Hub{
+func NewHub(repo db.Store, limits Limits) *Hub {
+	log.Debug("Initializing WebSocket hub")
+	h := &Hub{
 		clients:    make(map[*Client]bool),
 		register:   make(chan *Client),
 		unregister: make(chan *Client),
+		broadcast:  make(chan outbound),
+		direct:     make(chan directed),
+		kick:       make(chan kicked),
+		evict:      make(chan eviction),
+		roster:     make(chan chan []*Client),
+		done:       make(chan struct{}),
+		ping:       make(chan chan struct{}),
+		drain:      make(chan chan struct{}),
 		repo:       repo,
+		limits:     limits,
+		commands:   commandSet{commands: map[string]Command{}},
+		room:       room{muted: map[string]time.Time{}},
 	}
+	h.SetPolicy(Policy{})
+	h.registerBuiltins()
+	return h
 }
 
-func (h *Hub) Run() {
-	logger.Info("WebSocket hub started and running")
-	logger.Debug("Hub event loop initialized")
+// Run is the hub event loop. When ctx is cancelled it cancels every
+// client's context, so in-flight queries are abandoned, and returns.
+func (h *Hub) Run(ctx context.Context) {
+	log.Info("WebSocket hub started and running")
+	log.Debug("Hub event loop initialized")
+	defer close(h.done)
+	if h.limits.RecheckInterval > 0 {
+		go h.recheckEvery(ctx, h.limits.RecheckInterval)
+	}
+	metrics.Rooms.Set(1)
+	defer metrics.Rooms.Set(0)
 
+	draining := false
 	for {
 		select {
+		case <-ctx.Done():
+			log.Info("WebSocket hub stopping", zap.Int("clients", len(h.clients)))
+			for c := range h.clients {
+				c.cancel()
+			}
+			return
+
+		case reply := <-h.ping:
+			close(reply)
+
+		case reply := <-h.drain:
+			draining = true
+			log.Info("WebSocket hub draining", zap.Int("clients", len(h.clients)))
+			for c := range h.clients {
+				delete(h.clients, c)
+				c.closeWith(websocket.CloseServiceRestart, restartReason)
+			}
+			metrics.ConnectedClients.Set(0)
+			close(reply)
+
+		case out := <-h.broadcast:
+			h.fanOut(out)
+
+		case d := <-h.direct:
+			if !h.clients[d.client] {
+				continue
+			}
+			select {
+			case d.client.send <- d.data:
+			default:
+				metrics.MessagesDropped.WithLabelValues(metrics.DropSlowClient).Inc()
+				log.WarnContext(d.client.ctx, "Failed to send notice to client", zap.String("username", d.client.username))
+			}
+
+		case k := <-h.kick:
+			if !h.clients[k.client] {
+				continue
+			}
+			delete(h.clients, k.client)
+			metrics.ConnectedClients.Set(float64(len(h.clients)))
+			k.client.closeWith(k.code, k.reason)
+
+		case e := <-h.evict:
+			for c := range h.clients {
+				if e.match(c) {
+					delete(h.clients, c)
+					c.closeWith(e.code, e.reason)
+				}
+			}
+			metrics.ConnectedClients.Set(float64(len(h.clients)))
+
+		case reply := <-h.roster:
+			clients := make([]*Client, 0, len(h.clients))
+			for c := range h.clients {
+				clients = append(clients, c)
+			}
+			reply <- clients
+
 		case c := <-h.register:
+			if draining {
+				c.closeWith(websocket.CloseServiceRestart, restartReason)
+				continue
+			}
 			h.clients[c] = true
-			logger.Debug("Client registered", zap.String("username", c.username), zap.Int64("user_id", c.userID))
-			logger.Info("Connected clients updated", zap.Int("count", len(h.clients)))
+			metrics.ConnectedClients.Set(float64(len(h.clients)))
+			log.DebugContext(c.ctx, "Client registered", zap.String("username", c.username))
+			log.Info("Connected clients updated", zap.Int("count", len(h.clients)))
 
 		case c := <-h.unregister:
 			if _, ok := h.clients[c]; ok {
 				delete(h.clients, c)
-				logger.Debug("Client unregistered", zap.String("username", c.username), zap.Int64("user_id", c.userID))
-				logger.Info("Connected clients updated", zap.Int("count", len(h.clients)))
-			} else {
-				logger.Warn("Attempted to unregister non-existent client")
+				metrics.ConnectedClients.Set(float64(len(h.clients)))
+				log.DebugContext(c.ctx, "Client unregistered", zap.String("username", c.username))
+				log.Info("Connected clients updated", zap.Int("count", len(h.clients)))
+			} else if c.closeCode == 0 {
+				// Clients the hub closed itself are already gone
+				log.Warn("Attempted to unregister non-existent client")
 			}
 		}
 	}
 }
 
-func (h *Hub) handleMessage(msg IncomingMessage) {
-	logger.Info("Handling incoming message", zap.String("username", msg.Username))
-	logger.Debug("Message details", zap.Int("content_length", len(msg.Content)))
+// ErrHubStopped is returned by Ping once Run has returned
+var ErrHubStopped = errors.New("hub is not running")
+
+// Ping round-trips through the event loop, proving it is running and not
+// stuck. It fails when ctx ends first or the hub has stopped.
+func (h *Hub) Ping(ctx context.Context) error {
+	reply := make(chan struct{})
+	select {
+	case h.ping <- reply:
+	case <-h.done:
+		return ErrHubStopped
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	select {
+	case <-reply:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// handleMessage runs a slash command or posts the message for c
+func (h *Hub) handleMessage(ctx context.Context, c *Client, msg IncomingMessage) {
+	ctx, span := tracer.Start(ctx, "hub.handleMessage")
+	defer span.End()
+
+	log.InfoContext(ctx, "Handling incoming message", zap.String("username", msg.Username))
+	log.DebugContext(ctx, "Message details", logger.Content("content", msg.Content))
 
 	if msg.Username == "" {
-		logger.Warn("Empty username in message")
+		log.WarnContext(ctx, "Empty username in message")
+		metrics.MessagesDropped.WithLabelValues(metrics.DropInvalid).Inc()
 		return
 	}
 
 	if msg.Content == "" {
-		logger.Warn("Empty content in message", zap.String("username", msg.Username))
+		log.WarnContext(ctx, "Empty content in message", zap.String("username", msg.Username))
+		metrics.MessagesDropped.WithLabelValues(metrics.DropInvalid).Inc()
 		return
 	}
 
-	logger.Debug("Getting or creating user", zap.String("username", msg.Username))
-	userID, err := h.repo.GetOrCreateUser(msg.Username)
-	if err != nil {
-		logger.Error("Error getting or creating user", zap.String("username", msg.Username), zap.Error(err))
-		logger.Warn("Message discarded due to user operation failure")
+	// Commands can post too
+	if !c.canPost {
+		h.notify(ctx, c, "This API key can't post messages; it lacks the "+auth.ScopeWrite+" scope.")
 		return
 	}
 
-	logger.Debug("Saving message for user", zap.String("username", msg.Username), zap.Int64("user_id", userID))
-	err = h.repo.SaveMessage(userID, msg.Content)
-	if err != nil {
-		logger.Error("Error saving message", zap.String("username", msg.Username), zap.Error(err))
-		logger.Warn("Message save failed - broadcast cancelled")
+	// Commands run as the authenticated user and are never stored
+	if name, args, ok := parseCommand(msg.Content); ok {
+		h.runCommand(ctx, c, name, args)
 		return
 	}
-	logger.Debug("Message persisted successfully")
+	if strings.HasPrefix(msg.Content, "//") {
+		msg.Content = msg.Content[1:]
+	}
+
+	// The frame's username is only checked for presence: messages are from
+	// the authenticated user, so nobody can pass as someone else or a bot
+	if _, err := h.Post(ctx, c.username, msg.Content); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "post message")
+		h.notify(ctx, c, postError(err))
+	}
+}
+
+// Post stores content as a message from username, creating the user if
+// needed, and broadcasts it. Every chat message takes this path, whether
+// it came from a client, a command or a bot.
+func (h *Hub) Post(ctx context.Context, username, content string) (model.Message, error) {
+	ctx, span := tracer.Start(ctx, "hub.post")
+	defer span.End()
+
+	if left := h.room.mutedFor(username); left > 0 {
+		log.InfoContext(ctx, "Message from muted user dropped", zap.String("username", username))
+		metrics.MessagesDropped.WithLabelValues(metrics.DropMuted).Inc()
+		return model.Message{}, mutedError(left)
+	}
+
+	if censored, changed := h.policy.Load().censor(content); changed {
+		log.InfoContext(ctx, "Blocked words masked in message", zap.String("username", username))
+		content = censored
+	}
 
-	logger.Debug("Preparing message broadcast", zap.Int("client_count", len(h.clients)))
+	log.DebugContext(ctx, "Getting or creating user", zap.String("username", username))
+	// The account says whether this is a bot; names seen for the first
+	// time are people
+	var userID int64
+	account, err := h.repo.GetAccount(ctx, username)
+	if errors.Is(err, db.ErrNotFound) {
+		userID, err = h.repo.GetOrCreateUser(ctx, username)
+	} else {
+		userID = account.ID
+	}
+	if err != nil {
+		log.ErrorContext(ctx, "Error getting or creating user", zap.String("username", username), zap.Error(err))
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "get or create user")
+		log.WarnContext(ctx, "Message discarded due to user operation failure")
+		metrics.MessagesDropped.WithLabelValues(metrics.DropStoreError).Inc()
+		return model.Message{}, err
+	}
+
+	// Same shape as GET /messages, so clients treat live and history alike
 	out := model.Message{
-		Username:  msg.Username,
-		Content:   msg.Content,
-		CreatedAt: time.Now().Format("15:04"),
+		Username:  username,
+		Content:   content,
+		CreatedAt: time.Now().UTC().Format(time.RFC3339),
+		Bot:       account.Bot,
+	}
+
+	log.DebugContext(ctx, "Saving message for user", zap.String("username", username), zap.Int64("user_id", userID))
+	out.ID, err = h.saveMessage(ctx, userID, out)
+	if err != nil {
+		log.ErrorContext(ctx, "Error saving message", zap.String("username", username), zap.Error(err))
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "save message")
+		log.WarnContext(ctx, "Message save failed - broadcast cancelled")
+		metrics.MessagesDropped.WithLabelValues(metrics.DropStoreError).Inc()
+		return model.Message{}, err
 	}
+	log.DebugContext(ctx, "Message persisted successfully")
 
 	data, err := json.Marshal(out)
 	if err != nil {
-		logger.Error("Error marshaling message", zap.Error(err))
-		logger.Warn("Broadcast cancelled due to JSON marshaling error")
-		return
+		log.ErrorContext(ctx, "Error marshaling message", zap.Error(err))
+		log.WarnContext(ctx, "Broadcast cancelled due to JSON marshaling error")
+		return out, nil
+	}
+	log.DebugContext(ctx, "Message serialized successfully", zap.Int("payload_size", len(data)))
+
+	// Fan-out happens on the Run goroutine, which owns the client set
+	select {
+	case h.broadcast <- outbound{ctx: ctx, data: data, username: username}:
+	case <-h.done:
+		log.WarnContext(ctx, "Hub stopped, message saved but not broadcast")
 	}
-	logger.Debug("Message serialized successfully", zap.Int("payload_size", len(data)))
+	h.publish(ctx, out)
+	return out, nil
+}
+
+// fanOut queues a message for every client. It runs on the Run goroutine.
+func (h *Hub) fanOut(out outbound) {
+	ctx := out.ctx
+	log.DebugContext(ctx, "Preparing message broadcast", zap.Int("client_count", len(h.clients)))
 
 	var sentCount int
 	var failedCount int
 
+	// Checked once so a large room doesn't build per-recipient fields that
+	// are then thrown away
+	debug := log.Enabled(zapcore.DebugLevel)
+	_, span := tracer.Start(ctx, "hub.broadcast", trace.WithAttributes(attribute.Int("recipients", len(h.clients))))
+	start := time.Now()
 	for c := range h.clients {
 		select {
-		case c.send <- data:
+		case c.send <- out.data:
 			sentCount++
-			logger.Debug("Message sent to client", zap.String("username", c.username))
+			if debug {
+				log.DebugContext(ctx, "Message sent to client", zap.String("username", c.username))
+			}
 		default:
 			failedCount++
-			logger.Warn("Failed to send message to client", zap.String("username", c.username))
+			metrics.MessagesDropped.WithLabelValues(metrics.DropSlowClient).Inc()
+			log.WarnContext(ctx, "Failed to send message to client", zap.String("username", c.username))
 		}
 	}
+	metrics.BroadcastDuration.Observe(time.Since(start).Seconds())
+	span.SetAttributes(attribute.Int("failed", failedCount))
+	span.End()
+	metrics.MessagesBroadcast.Inc()
 
-	logger.Info("Message broadcasted", zap.String("username", msg.Username), zap.Int("sent", sentCount), zap.Int("failed", failedCount), zap.Int("total_clients", len(h.clients)))
+	log.InfoContext(ctx, "Message broadcasted", zap.String("username", out.username), zap.Int("sent", sentCount), zap.Int("failed", failedCount), zap.Int("total_clients", len(h.clients)))
 
 	if failedCount > 0 && failedCount == len(h.clients) {
-		logger.Error("Broadcast failed for all connected clients")
+		log.ErrorContext(ctx, "Broadcast failed for all connected clients")
 	}
 }
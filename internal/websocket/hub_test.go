@@ -0,0 +1,124 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"li-chat/internal/auth"
+	"li-chat/internal/db"
+)
+
+// testLimits keep pings out of the way of the frames a test reads
+var testLimits = Limits{
+	MaxMessageBytes: 4096,
+	SendBuffer:      16,
+	WriteWait:       time.Second,
+	PongWait:        time.Minute,
+	PingPeriod:      30 * time.Second,
+}
+
+// frame is any frame the hub sends, decoded loosely
+type frame struct {
+	Type      string    `json:"type"`
+	Username  string    `json:"username"`
+	Content   string    `json:"content"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// startHub runs a hub on store until the test ends
+func startHub(t *testing.T, store db.Store, limits Limits) *Hub {
+	t.Helper()
+	h := NewHub(store, limits)
+	ctx, cancel := context.WithCancel(context.Background())
+	go h.Run(ctx)
+	t.Cleanup(func() {
+		cancel()
+		<-h.done
+	})
+	return h
+}
+
+// newUser adds a person's account and returns its ID
+func newUser(t *testing.T, store *db.MemoryStore, username string) int64 {
+	t.Helper()
+	ctx := context.Background()
+	if err := store.CreateUser(ctx, username, "unused-hash"); err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	userID, _, err := store.GetUserForLogin(ctx, username)
+	if err != nil {
+		t.Fatalf("GetUserForLogin: %v", err)
+	}
+	return userID
+}
+
+// dial connects to h as id, the way the auth middleware hands over an
+// upgrade request, and returns once the client is registered
+func dial(t *testing.T, h *Hub, store db.UserStore, id *auth.Identity) *websocket.Conn {
+	t.Helper()
+	handler := HandleWS(h, store)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handler(w, r.WithContext(auth.WithIdentity(r.Context(), id)))
+	}))
+	t.Cleanup(srv.Close)
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	// The welcome frame is written once the hub has the client
+	if f := readFrame(t, conn); f.Type != "welcome" {
+		t.Fatalf("first frame = %+v, want welcome", f)
+	}
+	return conn
+}
+
+// readFrame returns the next frame, failing the test after 5s
+func readFrame(t *testing.T, conn *websocket.Conn) frame {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, data, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatalf("read frame: %v", err)
+	}
+	var f frame
+	if err := json.Unmarshal(data, &f); err != nil {
+		t.Fatalf("decode frame %s: %v", data, err)
+	}
+	return f
+}
+
+// readClose skips frames until the server closes the connection and
+// returns its close frame
+func readClose(t *testing.T, conn *websocket.Conn) *websocket.CloseError {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		_, _, err := conn.ReadMessage()
+		if err == nil {
+			continue
+		}
+		var closeErr *websocket.CloseError
+		if !errors.As(err, &closeErr) {
+			t.Fatalf("connection ended without a close frame: %v", err)
+		}
+		return closeErr
+	}
+}
+
+// say sends content as a chat message
+func say(t *testing.T, conn *websocket.Conn, content string) {
+	t.Helper()
+	if err := conn.WriteJSON(IncomingMessage{Username: "ignored", Content: content}); err != nil {
+		t.Fatalf("write message: %v", err)
+	}
+}
@@ -0,0 +1,42 @@
+package websocket
+
+import (
+	"context"
+
+	"li-chat/internal/model"
+)
+
+// Outbox queues the webhook events a message causes in the transaction
+// that saves it, so none is lost the way a lagging subscriber loses them.
+// *webhook.Dispatcher implements it.
+type Outbox interface {
+	// MessageDeliveries is called before msg is saved; the func it returns
+	// runs inside the transaction once the ID is known
+	MessageDeliveries(ctx context.Context, msg model.Message) (func(id int64) ([]model.WebhookDelivery, error), error)
+	// Wake is called after a message and its deliveries are committed
+	Wake()
+}
+
+// SetOutbox makes every Post queue o's deliveries with the message. It
+// must be called before Run.
+func (h *Hub) SetOutbox(o Outbox) {
+	h.outbox = o
+}
+
+// saveMessage stores out as a message from userID, with its webhook
+// deliveries when an outbox is set, and returns the new ID
+func (h *Hub) saveMessage(ctx context.Context, userID int64, out model.Message) (int64, error) {
+	if h.outbox == nil {
+		return h.repo.SaveMessage(ctx, userID, out.Content)
+	}
+	deliveries, err := h.outbox.MessageDeliveries(ctx, out)
+	if err != nil {
+		return 0, err
+	}
+	id, err := h.repo.SaveMessageWithDeliveries(ctx, userID, out.Content, deliveries)
+	if err != nil {
+		return 0, err
+	}
+	h.outbox.Wake()
+	return id, nil
+}
@@ -0,0 +1,88 @@
+package websocket
+
+import (
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"golang.org/x/time/rate"
+)
+
+// Policy is the part of the hub's behaviour that can change while clients
+// stay connected. It is replaced as a whole by SetPolicy.
+type Policy struct {
+	// RateLimit is messages per second per client; zero disables limiting
+	RateLimit float64
+	RateBurst int
+	// AllowedOrigins gates the upgrade handshake; empty or "*" allows any
+	AllowedOrigins []string
+	BlockedWords   []string
+	// Admins may run admin-only commands, in addition to accounts with
+	// the admin role
+	Admins []string
+
+	blocked *regexp.Regexp
+}
+
+// compile prepares derived state so the hot path doesn't rebuild it
+func (p *Policy) compile() {
+	if len(p.BlockedWords) == 0 {
+		p.blocked = nil
+		return
+	}
+	quoted := make([]string, len(p.BlockedWords))
+	for i, w := range p.BlockedWords {
+		quoted[i] = regexp.QuoteMeta(w)
+	}
+	p.blocked = regexp.MustCompile(`(?i)\b(` + strings.Join(quoted, "|") + `)\b`)
+}
+
+// censor masks blocked words, keeping their length so the sentence shape survives
+func (p *Policy) censor(content string) (string, bool) {
+	if p.blocked == nil {
+		return content, false
+	}
+	changed := false
+	out := p.blocked.ReplaceAllStringFunc(content, func(w string) string {
+		changed = true
+		return strings.Repeat("*", len([]rune(w)))
+	})
+	return out, changed
+}
+
+// limit converts the policy into x/time/rate terms
+func (p *Policy) limit() (rate.Limit, int) {
+	if p.RateLimit <= 0 {
+		return rate.Inf, 0
+	}
+	return rate.Limit(p.RateLimit), p.RateBurst
+}
+
+// allowOrigin accepts requests without an Origin header (non-browser
+// clients), same-host origins and anything on the allowlist
+func (p *Policy) allowOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" || len(p.AllowedOrigins) == 0 {
+		return true
+	}
+	if u, err := url.Parse(origin); err == nil && strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+	for _, allowed := range p.AllowedOrigins {
+		if allowed == "*" || strings.EqualFold(strings.TrimSuffix(allowed, "/"), origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// SetPolicy atomically replaces the hub's runtime policy. Connected clients
+// pick up new rate limits on their next message.
+func (h *Hub) SetPolicy(p Policy) {
+	p.AllowedOrigins = append([]string(nil), p.AllowedOrigins...)
+	p.BlockedWords = append([]string(nil), p.BlockedWords...)
+	p.Admins = append([]string(nil), p.Admins...)
+	p.compile()
+	h.policy.Store(&p)
+}
@@ -0,0 +1,128 @@
+package websocket
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.uber.org/zap"
+
+	"li-chat/internal/db"
+)
+
+// eviction is a set of clients Run should disconnect with a close code
+type eviction struct {
+	match  func(c *Client) bool
+	code   int
+	reason string
+}
+
+// DisconnectUser closes every connection of userID with
+// CloseAccountDisabled, for an account that was just disabled
+func (h *Hub) DisconnectUser(userID int64) {
+	h.disconnectUser(userID, "account disabled")
+}
+
+func (h *Hub) disconnectUser(userID int64, reason string) {
+	h.sendEviction(eviction{
+		match:  func(c *Client) bool { return c.userID == userID },
+		code:   CloseAccountDisabled,
+		reason: reason,
+	})
+}
+
+// DisconnectAPIKey closes every connection opened with API key id with
+// CloseKeyRevoked, for a key that was just revoked
+func (h *Hub) DisconnectAPIKey(id int64) {
+	h.sendEviction(eviction{
+		match:  func(c *Client) bool { return c.apiKeyID == id },
+		code:   CloseKeyRevoked,
+		reason: "API key revoked",
+	})
+}
+
+func (h *Hub) sendEviction(e eviction) {
+	select {
+	case h.evict <- e:
+	case <-h.done:
+	}
+}
+
+// recheckEvery runs recheck until ctx ends or the hub stops
+func (h *Hub) recheckEvery(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-h.done:
+			return
+		case <-ticker.C:
+			h.recheck(ctx)
+		}
+	}
+}
+
+// recheck disconnects clients whose account was disabled or removed or
+// whose API key was revoked since they connected. Changes made through this server's
+// API disconnect at once; this catches those made elsewhere, such as with
+// `li-chat user disable` or on another server, and covers API keys, whose
+// connections never expire on their own.
+func (h *Hub) recheck(ctx context.Context) {
+	reply := make(chan []*Client, 1)
+	select {
+	case h.roster <- reply:
+	case <-h.done:
+		return
+	case <-ctx.Done():
+		return
+	}
+	clients := <-reply
+
+	users := map[int64]string{}
+	keys := map[int64]bool{}
+	for _, c := range clients {
+		users[c.userID] = c.username
+		if c.apiKeyID != 0 {
+			keys[c.apiKeyID] = true
+		}
+	}
+
+	for userID, username := range users {
+		_, err := h.repo.GetUserByID(ctx, userID)
+		switch {
+		case errors.Is(err, db.ErrDisabled):
+			log.WarnContext(ctx, "Account disabled while connected, disconnecting", zap.String("username", username))
+			h.DisconnectUser(userID)
+		case errors.Is(err, db.ErrNotFound):
+			// Left connected, the client's next message would recreate
+			// the account through GetOrCreateUser
+			log.WarnContext(ctx, "Account removed while connected, disconnecting", zap.String("username", username))
+			h.disconnectUser(userID, "account removed")
+		case err != nil && ctx.Err() == nil:
+			log.ErrorContext(ctx, "Failed to recheck connected account", zap.String("username", username), zap.Error(err))
+		}
+	}
+
+	if len(keys) == 0 {
+		return
+	}
+	all, err := h.repo.ListAPIKeys(ctx)
+	if err != nil {
+		if ctx.Err() == nil {
+			log.ErrorContext(ctx, "Failed to recheck API keys of open connections", zap.Error(err))
+		}
+		return
+	}
+	valid := map[int64]bool{}
+	for _, k := range all {
+		valid[k.ID] = k.RevokedAt == nil
+	}
+	for id := range keys {
+		if !valid[id] {
+			log.WarnContext(ctx, "API key revoked while connected, disconnecting", zap.Int64("api_key_id", id))
+			h.DisconnectAPIKey(id)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package websocket
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"li-chat/internal/auth"
+	"li-chat/internal/db"
+	"li-chat/internal/model"
+)
+
+func TestRecheck(t *testing.T) {
+	ctx := context.Background()
+	store := db.NewMemoryStore()
+	h := startHub(t, store, testLimits)
+
+	alice := newUser(t, store, "alice")
+	carol := newUser(t, store, "carol")
+	botID, err := store.CreateBot(ctx, "deploybot")
+	if err != nil {
+		t.Fatalf("CreateBot: %v", err)
+	}
+	key, prefix := auth.GenerateAPIKey()
+	keyID, err := store.CreateAPIKey(ctx, model.APIKey{UserID: botID, Name: "deploys", Prefix: prefix, Scopes: []string{auth.ScopeRead}}, auth.HashToken(key))
+	if err != nil {
+		t.Fatalf("CreateAPIKey: %v", err)
+	}
+
+	disabled := dial(t, h, store, &auth.Identity{UserID: alice, Username: "alice", ExpiresAt: time.Now().Add(time.Hour)})
+	// The memory store can't delete accounts; an ID it never issued looks
+	// the same to the hub
+	removed := dial(t, h, store, &auth.Identity{UserID: 999, Username: "dave", ExpiresAt: time.Now().Add(time.Hour)})
+	revoked := dial(t, h, store, &auth.Identity{UserID: botID, Username: "deploybot", Bot: true, APIKeyID: keyID, Scopes: []string{auth.ScopeRead}})
+	untouched := dial(t, h, store, &auth.Identity{UserID: carol, Username: "carol", ExpiresAt: time.Now().Add(time.Hour)})
+
+	if err := store.SetUserDisabled(ctx, "alice", true); err != nil {
+		t.Fatalf("SetUserDisabled: %v", err)
+	}
+	if err := store.RevokeAPIKey(ctx, keyID); err != nil {
+		t.Fatalf("RevokeAPIKey: %v", err)
+	}
+	h.recheck(ctx)
+
+	tests := []struct {
+		name   string
+		conn   *websocket.Conn
+		code   int
+		reason string
+	}{
+		{"disabled account", disabled, CloseAccountDisabled, "account disabled"},
+		{"removed account", removed, CloseAccountDisabled, "account removed"},
+		{"revoked key", revoked, CloseKeyRevoked, "API key revoked"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if ce := readClose(t, tt.conn); ce.Code != tt.code || ce.Text != tt.reason {
+				t.Errorf("close = %d %q, want %d %q", ce.Code, ce.Text, tt.code, tt.reason)
+			}
+		})
+	}
+
+	// Evictions go through Run in order, so anything for carol would
+	// arrive before her own message comes back
+	say(t, untouched, "still here")
+	if f := readFrame(t, untouched); f.Content != "still here" {
+		t.Errorf("frame = %+v, want the message back on the untouched connection", f)
+	}
+}
+
+func TestRecheckEvery(t *testing.T) {
+	store := db.NewMemoryStore()
+	limits := testLimits
+	limits.RecheckInterval = 50 * time.Millisecond
+	h := startHub(t, store, limits)
+
+	alice := newUser(t, store, "alice")
+	conn := dial(t, h, store, &auth.Identity{UserID: alice, Username: "alice", ExpiresAt: time.Now().Add(time.Hour)})
+	if err := store.SetUserDisabled(context.Background(), "alice", true); err != nil {
+		t.Fatalf("SetUserDisabled: %v", err)
+	}
+	if ce := readClose(t, conn); ce.Code != CloseAccountDisabled {
+		t.Errorf("close = %d %q, want %d", ce.Code, ce.Text, CloseAccountDisabled)
+	}
+}
@@ -0,0 +1,60 @@
+package websocket
+
+import (
+	"context"
+
+	"github.com/gorilla/websocket"
+	"go.uber.org/zap"
+)
+
+// restartReason is sent in the close frame on shutdown. Clients should
+// treat close code 1012 (service restart) as a cue to reconnect.
+const restartReason = "server restarting, reconnect"
+
+// closeWith ends the connection after every already-queued frame has been
+// written. It must only be called from the Run goroutine, which owns send.
+func (c *Client) closeWith(code int, reason string) {
+	c.closeCode = code
+	c.closeReason = reason
+	close(c.send)
+}
+
+// Shutdown stops the hub from taking new clients and sends every connected
+// client a service-restart close frame after its pending messages. It then
+// waits for the connections to wind down; a message a client was sending
+// at that moment is still persisted first. If ctx ends
+// before that, Shutdown returns its error and cancelling Run's context
+// aborts whatever is left.
+func (h *Hub) Shutdown(ctx context.Context) error {
+	reply := make(chan struct{})
+	select {
+	case h.drain <- reply:
+		<-reply
+	case <-h.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	finished := make(chan struct{})
+	go func() {
+		h.pumps.Wait()
+		close(finished)
+	}()
+	select {
+	case <-finished:
+		log.Info("All WebSocket connections closed")
+		return nil
+	case <-ctx.Done():
+		log.Warn("WebSocket connections still open at shutdown deadline", zap.Error(ctx.Err()))
+		return ctx.Err()
+	}
+}
+
+// closeMessage builds the close frame writePump sends when send is closed
+func (c *Client) closeMessage() []byte {
+	if c.closeCode == 0 {
+		return websocket.FormatCloseMessage(websocket.CloseNormalClosure, "")
+	}
+	return websocket.FormatCloseMessage(c.closeCode, c.closeReason)
+}
@@ -0,0 +1,97 @@
+package websocket
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"li-chat/internal/auth"
+	"li-chat/internal/db"
+)
+
+// holdingStore holds up every SaveMessage until release is closed, after
+// telling saving what is being saved
+type holdingStore struct {
+	*db.MemoryStore
+	saving  chan string
+	release chan struct{}
+}
+
+func newHoldingStore() *holdingStore {
+	return &holdingStore{MemoryStore: db.NewMemoryStore(), saving: make(chan string, 1), release: make(chan struct{})}
+}
+
+func (s *holdingStore) SaveMessage(ctx context.Context, userID int64, content string) (int64, error) {
+	s.saving <- content
+	<-s.release
+	return s.MemoryStore.SaveMessage(ctx, userID, content)
+}
+
+func TestShutdown(t *testing.T) {
+	ctx := context.Background()
+	store := newHoldingStore()
+	h := startHub(t, store, testLimits)
+	alice := dial(t, h, store, &auth.Identity{UserID: newUser(t, store.MemoryStore, "alice"), Username: "alice"})
+	bob := dial(t, h, store, &auth.Identity{UserID: newUser(t, store.MemoryStore, "bob"), Username: "bob"})
+
+	say(t, alice, "in flight")
+	<-store.saving
+	errc := make(chan error, 1)
+	go func() { errc <- h.Shutdown(ctx) }()
+	select {
+	case err := <-errc:
+		t.Fatalf("Shutdown returned %v while a message was being saved", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+	close(store.release)
+
+	select {
+	case err := <-errc:
+		if err != nil {
+			t.Fatalf("Shutdown: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Shutdown didn't return")
+	}
+	messages, err := store.GetMessages(ctx, 10)
+	if err != nil {
+		t.Fatalf("GetMessages: %v", err)
+	}
+	if len(messages) != 1 || messages[0].Content != "in flight" {
+		t.Errorf("messages = %+v, want the in-flight one saved", messages)
+	}
+	for name, conn := range map[string]*websocket.Conn{"alice": alice, "bob": bob} {
+		if ce := readClose(t, conn); ce.Code != websocket.CloseServiceRestart || ce.Text != restartReason {
+			t.Errorf("%s: close = %d %q, want %d %q", name, ce.Code, ce.Text, websocket.CloseServiceRestart, restartReason)
+		}
+	}
+
+	// Clients connecting while the server drains are sent away at once
+	late := dial(t, h, store, &auth.Identity{UserID: newUser(t, store.MemoryStore, "carol"), Username: "carol"})
+	if ce := readClose(t, late); ce.Code != websocket.CloseServiceRestart {
+		t.Errorf("client after shutdown: close = %d %q, want %d", ce.Code, ce.Text, websocket.CloseServiceRestart)
+	}
+}
+
+func TestShutdownDeadline(t *testing.T) {
+	store := newHoldingStore()
+	h := startHub(t, store, testLimits)
+	// Runs before the hub stops, so the held save can finish
+	t.Cleanup(func() { close(store.release) })
+	alice := dial(t, h, store, &auth.Identity{UserID: newUser(t, store.MemoryStore, "alice"), Username: "alice"})
+
+	say(t, alice, "never saved in time")
+	<-store.saving
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := h.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Shutdown = %v, want context.DeadlineExceeded", err)
+	}
+	if waited := time.Since(start); waited > time.Second {
+		t.Errorf("Shutdown returned %v after its deadline", waited)
+	}
+}
@@ -0,0 +1,291 @@
+// Package client is a Go SDK for the li-chat HTTP and WebSocket API.
+//
+// A Client holds one user's tokens and refreshes the access token before it
+// expires, or when the server rejects it, so callers never handle
+// /refresh-token themselves:
+//
+//	c, err := client.New("http://localhost:8080")
+//	if err := c.Login(ctx, "alice", password); err != nil { ... }
+//	history, err := c.Messages(ctx)
+//
+//	s := c.Connect(ctx)
+//	defer s.Close()
+//	s.Send("hello")
+//	for msg := range s.Messages() { ... }
+//
+// Messages starting with "/" are slash commands; their private replies
+// arrive as Messages with Notice set.
+//
+// Tokens can be saved with WithTokenHook and restored with WithTokens, so a
+// process restart doesn't need the password again. Bots skip Login and
+// authenticate with WithAPIKey instead.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// ErrSessionExpired means the refresh token is missing or was rejected;
+// the user has to log in again
+var ErrSessionExpired = errors.New("session expired, log in again")
+
+// ErrKeyRejected means the server refused the API key given to WithAPIKey,
+// usually because it was revoked
+var ErrKeyRejected = errors.New("API key rejected")
+
+// refreshMargin is how long before expiry the access token is replaced
+const refreshMargin = 30 * time.Second
+
+// Tokens are the credentials a Client holds for one user
+type Tokens struct {
+	Username     string `json:"username"`
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+// APIError is a non-2xx response. Message is the server's error text.
+type APIError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("%d %s: %s", e.StatusCode, http.StatusText(e.StatusCode), e.Message)
+}
+
+// statusCode returns the HTTP status of an *APIError in err's chain, or 0
+func statusCode(err error) int {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.StatusCode
+	}
+	return 0
+}
+
+// Client calls one li-chat server on behalf of one user. It is safe for
+// concurrent use.
+type Client struct {
+	base    *url.URL
+	http    *http.Client
+	dialer  *websocket.Dialer
+	onToken func(Tokens)
+
+	// apiKey replaces the access token when set; it never needs refreshing
+	apiKey string
+
+	mu     sync.Mutex
+	tokens Tokens
+	// refreshMu lets one refresh run at a time without holding mu over
+	// the request
+	refreshMu sync.Mutex
+}
+
+// Option configures a Client
+type Option func(*Client)
+
+// WithHTTPClient replaces the default client, which times out after 15s
+func WithHTTPClient(hc *http.Client) Option {
+	return func(c *Client) { c.http = hc }
+}
+
+// WithTokens starts the Client with a saved session instead of a login
+func WithTokens(t Tokens) Option {
+	return func(c *Client) { c.tokens = t }
+}
+
+// WithAPIKey authenticates as the bot username with an API key from
+// POST /api/admin/api-keys rather than a login
+func WithAPIKey(username, key string) Option {
+	return func(c *Client) {
+		c.tokens = Tokens{Username: username}
+		c.apiKey = key
+	}
+}
+
+// WithTokenHook is called with the new tokens after every login and
+// refresh, for callers that persist them
+func WithTokenHook(fn func(Tokens)) Option {
+	return func(c *Client) { c.onToken = fn }
+}
+
+// New returns a Client for the server at baseURL, e.g. https://chat.example.com
+func New(baseURL string, opts ...Option) (*Client, error) {
+	base, err := url.Parse(strings.TrimRight(baseURL, "/"))
+	if err != nil {
+		return nil, err
+	}
+	if base.Scheme != "http" && base.Scheme != "https" {
+		return nil, fmt.Errorf("client: base URL %q must be http:// or https://", baseURL)
+	}
+
+	c := &Client{
+		base: base,
+		http: &http.Client{Timeout: 15 * time.Second},
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	c.dialer = newDialer(c.http)
+	return c, nil
+}
+
+// Tokens returns the current credentials
+func (c *Client) Tokens() Tokens {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.tokens
+}
+
+// Username is the logged-in user or bot, or "" before Login
+func (c *Client) Username() string {
+	return c.Tokens().Username
+}
+
+func (c *Client) setTokens(t Tokens) {
+	c.mu.Lock()
+	c.tokens = t
+	c.mu.Unlock()
+	if c.onToken != nil {
+		c.onToken(t)
+	}
+}
+
+// Token returns an access token valid for at least another 30 seconds,
+// refreshing it first if needed
+func (c *Client) Token(ctx context.Context) (string, error) {
+	if c.apiKey != "" {
+		return c.apiKey, nil
+	}
+	token := c.Tokens().AccessToken
+	if token != "" && time.Until(tokenExpiry(token)) > refreshMargin {
+		return token, nil
+	}
+	return c.refresh(ctx, token)
+}
+
+// Refresh replaces the access token now
+func (c *Client) Refresh(ctx context.Context) error {
+	_, err := c.refresh(ctx, c.Tokens().AccessToken)
+	return err
+}
+
+// refresh exchanges the refresh token for a new access token, unless
+// another caller already replaced stale in the meantime
+func (c *Client) refresh(ctx context.Context, stale string) (string, error) {
+	if c.apiKey != "" {
+		return "", ErrKeyRejected
+	}
+	c.refreshMu.Lock()
+	defer c.refreshMu.Unlock()
+
+	t := c.Tokens()
+	if t.AccessToken != stale && t.AccessToken != "" {
+		return t.AccessToken, nil
+	}
+	if t.RefreshToken == "" {
+		return "", ErrSessionExpired
+	}
+
+	var resp struct {
+		AccessToken string `json:"access_token"`
+	}
+	err := c.do(ctx, http.MethodPost, "/refresh-token", "", map[string]string{"refresh_token": t.RefreshToken}, &resp)
+	if code := statusCode(err); code == http.StatusUnauthorized || code == http.StatusForbidden {
+		return "", fmt.Errorf("%w: %w", ErrSessionExpired, err)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	t.AccessToken = resp.AccessToken
+	c.setTokens(t)
+	return resp.AccessToken, nil
+}
+
+// authorized runs call with a valid access token, refreshing and retrying
+// once if the server rejects it anyway (e.g. after a secret rotation)
+func (c *Client) authorized(ctx context.Context, call func(token string) error) error {
+	token, err := c.Token(ctx)
+	if err != nil {
+		return err
+	}
+	err = call(token)
+	if statusCode(err) != http.StatusUnauthorized {
+		return err
+	}
+	if token, err = c.refresh(ctx, token); err != nil {
+		return err
+	}
+	return call(token)
+}
+
+// do sends in as JSON and decodes a 2xx response into out. Other statuses
+// become an *APIError.
+func (c *Client) do(ctx context.Context, method, path, token string, in, out any) error {
+	var body bytes.Buffer
+	if in != nil {
+		if err := json.NewEncoder(&body).Encode(in); err != nil {
+			return err
+		}
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.base.String()+path, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		var e struct {
+			Error string `json:"error"`
+		}
+		if json.NewDecoder(resp.Body).Decode(&e) != nil || e.Error == "" {
+			e.Error = http.StatusText(resp.StatusCode)
+		}
+		return &APIError{StatusCode: resp.StatusCode, Message: e.Error}
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// tokenExpiry reads the exp claim without verifying the signature; the
+// server does that. A token that can't be read counts as expired.
+func tokenExpiry(token string) time.Time {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return time.Time{}
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return time.Time{}
+	}
+	var claims struct {
+		Exp int64 `json:"exp"`
+	}
+	if json.Unmarshal(payload, &claims) != nil || claims.Exp == 0 {
+		return time.Time{}
+	}
+	return time.Unix(claims.Exp, 0)
+}
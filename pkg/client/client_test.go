@@ -0,0 +1,590 @@
+package client_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"li-chat/internal/auth"
+	"li-chat/internal/config"
+	"li-chat/internal/db"
+	"li-chat/internal/httpserver"
+	"li-chat/internal/model"
+	"li-chat/internal/webhook"
+	"li-chat/internal/websocket"
+	"li-chat/pkg/client"
+	"li-chat/pkg/logger"
+)
+
+const (
+	testSecret   = "client-test-secret-0123456789abcdef"
+	testPassword = "correct horse battery staple"
+)
+
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "li-chat-client-test")
+	if err != nil {
+		panic(err)
+	}
+	logger.Init(logger.Config{Level: "error", Filename: filepath.Join(dir, "test.log"), MaxSizeMB: 1})
+	auth.Configure(testSecret, time.Hour, 24*time.Hour)
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// testServer runs the real router over a memory store and counts the
+// requests each path receives
+type testServer struct {
+	*httptest.Server
+	repo db.Store
+	hub  *websocket.Hub
+
+	// offline makes POST /api/ws-ticket fail, holding sessions in
+	// Reconnecting
+	offline atomic.Bool
+
+	mu      sync.Mutex
+	hits    map[string]int
+	queries map[string][]string
+	conns   []net.Conn
+}
+
+func newTestServer(t *testing.T) *testServer {
+	t.Helper()
+	return newTestServerRechecking(t, 0)
+}
+
+// newTestServerRechecking checks open connections for disabled accounts
+// and revoked keys every interval
+func newTestServerRechecking(t *testing.T, interval time.Duration) *testServer {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	repo := db.NewMemoryStore()
+	hub := websocket.NewHub(repo, websocket.Limits{
+		MaxMessageBytes: 64 << 10,
+		SendBuffer:      256,
+		WriteWait:       time.Second,
+		PongWait:        time.Minute,
+		PingPeriod:      50 * time.Second,
+		RecheckInterval: interval,
+	})
+	hub.SetPolicy(websocket.Policy{RateLimit: 1000, RateBurst: 1000})
+	go hub.Run(ctx)
+
+	dispatcher := webhook.NewDispatcher(repo, webhook.Options{MaxAttempts: 1, Timeout: time.Second, PollInterval: time.Minute})
+	router := httpserver.NewRouter(config.NewRuntime(config.Default(), nil), hub, repo, httpserver.NewHealth(hub, repo), dispatcher)
+
+	s := &testServer{repo: repo, hub: hub, hits: map[string]int{}, queries: map[string][]string{}}
+	s.Server = httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		s.hits[r.URL.Path]++
+		s.queries[r.URL.Path] = append(s.queries[r.URL.Path], r.URL.RawQuery)
+		s.mu.Unlock()
+		if r.URL.Path == "/api/ws-ticket" && s.offline.Load() {
+			http.Error(w, `{"error":"offline"}`, http.StatusServiceUnavailable)
+			return
+		}
+		router.ServeHTTP(w, r)
+	}))
+	s.Listener = &trackingListener{Listener: s.Listener, s: s}
+	s.Start()
+	t.Cleanup(s.Close)
+	return s
+}
+
+// trackingListener remembers accepted connections so drop can cut them,
+// WebSockets included, which httptest forgets once hijacked
+type trackingListener struct {
+	net.Listener
+	s *testServer
+}
+
+func (l *trackingListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err == nil {
+		l.s.mu.Lock()
+		l.s.conns = append(l.s.conns, conn)
+		l.s.mu.Unlock()
+	}
+	return conn, err
+}
+
+// drop closes every connection the server has accepted
+func (s *testServer) drop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, conn := range s.conns {
+		conn.Close()
+	}
+	s.conns = nil
+}
+
+func (s *testServer) count(path string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.hits[path]
+}
+
+func (s *testServer) query(path string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.queries[path]...)
+}
+
+// login registers username and returns a Client logged in as them
+func (s *testServer) login(t *testing.T, username string) *client.Client {
+	t.Helper()
+	c, err := client.New(s.URL)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	ctx := context.Background()
+	if err := c.Register(ctx, username, testPassword); err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+	if err := c.Login(ctx, username, testPassword); err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+	return c
+}
+
+// signToken issues an access token for the same user as token that expires
+// at exp, signed with secret
+func signToken(t *testing.T, token, secret string, exp time.Time) string {
+	t.Helper()
+	claims, err := auth.ValidateToken(token)
+	if err != nil {
+		t.Fatalf("ValidateToken: %v", err)
+	}
+	claims.ExpiresAt = jwt.NewNumericDate(exp)
+	claims.IssuedAt = jwt.NewNumericDate(exp.Add(-time.Hour))
+	signed, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(secret))
+	if err != nil {
+		t.Fatalf("sign token: %v", err)
+	}
+	return signed
+}
+
+// waitState returns the first state s reaches that is want
+func waitState(t *testing.T, s *client.Session, want client.ConnState) client.State {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case st, ok := <-s.States():
+			if !ok {
+				t.Fatalf("states closed waiting for %v", want)
+			}
+			if st.State == want {
+				return st
+			}
+		case <-timeout:
+			t.Fatalf("timed out waiting for %v", want)
+		}
+	}
+}
+
+// receive returns the next n messages s delivers
+func receive(t *testing.T, s *client.Session, n int) []client.Message {
+	t.Helper()
+	var got []client.Message
+	timeout := time.After(5 * time.Second)
+	for len(got) < n {
+		select {
+		case m, ok := <-s.Messages():
+			if !ok {
+				t.Fatalf("messages closed after %d of %d: %v", len(got), n, s.Err())
+			}
+			got = append(got, m)
+		case <-timeout:
+			t.Fatalf("timed out after %d of %d messages", len(got), n)
+		}
+	}
+	return got
+}
+
+// expectQuiet fails if s delivers anything within a short wait
+func expectQuiet(t *testing.T, s *client.Session) {
+	t.Helper()
+	select {
+	case m := <-s.Messages():
+		t.Fatalf("unexpected message %d %q", m.ID, m.Content)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+func TestLogin(t *testing.T) {
+	s := newTestServer(t)
+	var saved []client.Tokens
+	c, err := client.New(s.URL+"/", client.WithTokenHook(func(tok client.Tokens) { saved = append(saved, tok) }))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	ctx := context.Background()
+
+	if err := c.Register(ctx, "alice", testPassword); err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+	err = c.Login(ctx, "alice", "wrong password")
+	var apiErr *client.APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("Login with a wrong password = %v, want a 401 APIError", err)
+	}
+	if err := c.Login(ctx, "alice", testPassword); err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+
+	tokens := c.Tokens()
+	if tokens.Username != "alice" || tokens.AccessToken == "" || tokens.RefreshToken == "" {
+		t.Fatalf("Tokens() = %+v after Login", tokens)
+	}
+	if len(saved) != 1 || saved[0] != tokens {
+		t.Fatalf("token hook got %+v, want the login tokens", saved)
+	}
+	if who, err := c.WhoAmI(ctx); err != nil || who != "alice" {
+		t.Fatalf("WhoAmI = %q, %v", who, err)
+	}
+	if n := s.count("/refresh-token"); n != 0 {
+		t.Fatalf("fresh token refreshed %d times", n)
+	}
+}
+
+func TestRefreshOnUnauthorized(t *testing.T) {
+	s := newTestServer(t)
+	ctx := context.Background()
+	c := s.login(t, "alice")
+
+	// Valid for hours as far as the client can tell, but the server
+	// rejects the signature, as after a secret rotation
+	tokens := c.Tokens()
+	rejected := signToken(t, tokens.AccessToken, "some-other-secret-0123456789abcdef", time.Now().Add(time.Hour))
+	tokens.AccessToken = rejected
+	c, err := client.New(s.URL, client.WithTokens(tokens))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	if who, err := c.WhoAmI(ctx); err != nil || who != "alice" {
+		t.Fatalf("WhoAmI = %q, %v", who, err)
+	}
+	if n := s.count("/whoami"); n != 2 {
+		t.Fatalf("/whoami called %d times, want the rejected call and one retry", n)
+	}
+	if n := s.count("/refresh-token"); n != 1 {
+		t.Fatalf("/refresh-token called %d times, want 1", n)
+	}
+	if c.Tokens().AccessToken == rejected {
+		t.Fatal("rejected access token kept after refresh")
+	}
+}
+
+func TestRefreshNearExpiry(t *testing.T) {
+	s := newTestServer(t)
+	ctx := context.Background()
+	c := s.login(t, "alice")
+
+	tokens := c.Tokens()
+	expiring := signToken(t, tokens.AccessToken, testSecret, time.Now().Add(10*time.Second))
+	tokens.AccessToken = expiring
+	c, err := client.New(s.URL, client.WithTokens(tokens))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	// Many callers find the token about to expire at once; one refreshes
+	// and the rest pick up its result
+	const callers = 8
+	got := make([]string, callers)
+	errs := make([]error, callers)
+	var wg sync.WaitGroup
+	for i := range callers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			got[i], errs[i] = c.Token(ctx)
+		}()
+	}
+	wg.Wait()
+
+	for i := range callers {
+		if errs[i] != nil {
+			t.Fatalf("Token: %v", errs[i])
+		}
+		if got[i] == expiring || got[i] != got[0] {
+			t.Fatalf("caller %d got %q, want the one refreshed token %q", i, got[i], got[0])
+		}
+	}
+	if n := s.count("/refresh-token"); n != 1 {
+		t.Fatalf("/refresh-token called %d times, want 1", n)
+	}
+
+	// Refresh replaces even a fresh token, after which requests use the
+	// new one without refreshing again
+	if err := c.Refresh(ctx); err != nil {
+		t.Fatalf("Refresh: %v", err)
+	}
+	if n := s.count("/refresh-token"); n != 2 {
+		t.Fatalf("/refresh-token called %d times after Refresh, want 2", n)
+	}
+	if who, err := c.WhoAmI(ctx); err != nil || who != "alice" {
+		t.Fatalf("WhoAmI = %q, %v", who, err)
+	}
+	if n := s.count("/refresh-token"); n != 2 {
+		t.Fatalf("/refresh-token called %d times, want no refresh for a fresh token", n)
+	}
+}
+
+func TestSessionConnectsWithTicket(t *testing.T) {
+	s := newTestServer(t)
+	ctx := context.Background()
+	alice := s.login(t, "alice")
+	bob := s.login(t, "bob")
+
+	if _, err := s.hub.Post(ctx, "bob", "before"); err != nil {
+		t.Fatalf("Post: %v", err)
+	}
+
+	as := alice.Connect(ctx)
+	defer as.Close()
+	waitState(t, as, client.Connected)
+	if got := receive(t, as, 1); got[0].Content != "before" || got[0].Username != "bob" {
+		t.Fatalf("history = %+v", got)
+	}
+
+	bs := bob.Connect(ctx, client.WithoutHistory())
+	defer bs.Close()
+	waitState(t, bs, client.Connected)
+	bs.Send("hello")
+	if got := receive(t, as, 1); got[0].Content != "hello" || got[0].Username != "bob" {
+		t.Fatalf("live message = %+v", got)
+	}
+
+	if n := s.count("/api/ws-ticket"); n != 2 {
+		t.Fatalf("/api/ws-ticket called %d times, want once per session", n)
+	}
+	for _, q := range s.query("/ws") {
+		if !strings.HasPrefix(q, "ticket="+auth.TicketPrefix) || strings.Contains(q, "token") {
+			t.Fatalf("/ws query %q, want only a ticket", q)
+		}
+	}
+}
+
+func TestSessionResumesAfterDrop(t *testing.T) {
+	s := newTestServer(t)
+	ctx := context.Background()
+	alice := s.login(t, "alice")
+
+	as := alice.Connect(ctx, client.WithoutHistory(), client.WithBackoff(10*time.Millisecond, 50*time.Millisecond))
+	defer as.Close()
+	waitState(t, as, client.Connected)
+
+	if _, err := s.hub.Post(ctx, "bob", "one"); err != nil {
+		t.Fatalf("Post: %v", err)
+	}
+	receive(t, as, 1)
+
+	// Keep the session offline while messages it must catch up on arrive
+	s.offline.Store(true)
+	s.drop()
+	waitState(t, as, client.Reconnecting)
+	var missed []int64
+	for _, content := range []string{"two", "three", "four"} {
+		m, err := s.hub.Post(ctx, "bob", content)
+		if err != nil {
+			t.Fatalf("Post: %v", err)
+		}
+		missed = append(missed, m.ID)
+	}
+
+	s.offline.Store(false)
+	waitState(t, as, client.Connected)
+	got := receive(t, as, len(missed))
+	for i, m := range got {
+		if m.ID != missed[i] {
+			t.Fatalf("caught up on %d at %d, want %d", m.ID, i, missed[i])
+		}
+	}
+	if as.LastID() != missed[len(missed)-1] {
+		t.Fatalf("LastID = %d, want %d", as.LastID(), missed[len(missed)-1])
+	}
+
+	resumed := false
+	for _, q := range s.query("/messages") {
+		resumed = resumed || strings.HasPrefix(q, "after=")
+	}
+	if !resumed {
+		t.Fatalf("/messages queries %q, want a MessagesAfter resume", s.query("/messages"))
+	}
+
+	m, err := s.hub.Post(ctx, "bob", "five")
+	if err != nil {
+		t.Fatalf("Post: %v", err)
+	}
+	if got := receive(t, as, 1); got[0].ID != m.ID {
+		t.Fatalf("live message %d, want %d", got[0].ID, m.ID)
+	}
+	expectQuiet(t, as)
+}
+
+func TestSessionStopsWhenDisabled(t *testing.T) {
+	s := newTestServer(t)
+	ctx := context.Background()
+	alice := s.login(t, "alice")
+
+	if err := s.repo.SetUserDisabled(ctx, "alice", true); err != nil {
+		t.Fatalf("SetUserDisabled: %v", err)
+	}
+	as := alice.Connect(ctx, client.WithoutHistory())
+	st := waitState(t, as, client.Closed)
+	if !errors.Is(st.Err, client.ErrDisabled) {
+		t.Fatalf("closed with %v, want ErrDisabled", st.Err)
+	}
+	<-as.Done()
+	if !errors.Is(as.Err(), client.ErrDisabled) {
+		t.Fatalf("Err() = %v, want ErrDisabled", as.Err())
+	}
+}
+
+func TestSessionStopsWhenExpired(t *testing.T) {
+	s := newTestServer(t)
+	ctx := context.Background()
+	s.login(t, "alice")
+
+	c, err := client.New(s.URL, client.WithTokens(client.Tokens{Username: "alice", RefreshToken: "not-a-refresh-token"}))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	if _, err := c.Messages(ctx); !errors.Is(err, client.ErrSessionExpired) {
+		t.Fatalf("Messages = %v, want ErrSessionExpired", err)
+	}
+
+	as := c.Connect(ctx)
+	st := waitState(t, as, client.Closed)
+	if !errors.Is(st.Err, client.ErrSessionExpired) {
+		t.Fatalf("closed with %v, want ErrSessionExpired", st.Err)
+	}
+	if !errors.Is(as.Err(), client.ErrSessionExpired) {
+		t.Fatalf("Err() = %v, want ErrSessionExpired", as.Err())
+	}
+	if n := s.count("/ws"); n != 0 {
+		t.Fatalf("/ws called %d times without a valid token", n)
+	}
+}
+
+// botKey creates bot name with an API key and returns a Client using it
+// and the key's ID
+func (s *testServer) botKey(t *testing.T, name string) (*client.Client, int64) {
+	t.Helper()
+	ctx := context.Background()
+	botID, err := s.repo.CreateBot(ctx, name)
+	if err != nil {
+		t.Fatalf("CreateBot: %v", err)
+	}
+	key, prefix := auth.GenerateAPIKey()
+	keyID, err := s.repo.CreateAPIKey(ctx, model.APIKey{UserID: botID, Name: "test", Prefix: prefix, Scopes: []string{auth.ScopeRead, auth.ScopeWrite}}, auth.HashToken(key))
+	if err != nil {
+		t.Fatalf("CreateAPIKey: %v", err)
+	}
+	c, err := client.New(s.URL, client.WithAPIKey(name, key))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	return c, keyID
+}
+
+func TestDisabledAccountLosesAccess(t *testing.T) {
+	s := newTestServerRechecking(t, 50*time.Millisecond)
+	ctx := context.Background()
+	alice := s.login(t, "alice")
+
+	as := alice.Connect(ctx, client.WithoutHistory())
+	defer as.Close()
+	waitState(t, as, client.Connected)
+
+	// As `li-chat user disable` does, without telling the server
+	if err := s.repo.SetUserDisabled(ctx, "alice", true); err != nil {
+		t.Fatalf("SetUserDisabled: %v", err)
+	}
+
+	_, err := alice.WhoAmI(ctx)
+	var apiErr *client.APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusForbidden {
+		t.Fatalf("WhoAmI with an issued token = %v, want 403", err)
+	}
+	st := waitState(t, as, client.Closed)
+	if !errors.Is(st.Err, client.ErrDisabled) {
+		t.Fatalf("closed with %v, want ErrDisabled", st.Err)
+	}
+}
+
+func TestRevokedKeyClosesSession(t *testing.T) {
+	s := newTestServer(t)
+	ctx := context.Background()
+	bot, keyID := s.botKey(t, "ci")
+	admin := s.login(t, "root")
+	if err := s.repo.SetRole(ctx, "root", model.RoleAdmin); err != nil {
+		t.Fatalf("SetRole: %v", err)
+	}
+
+	bs := bot.Connect(ctx, client.WithoutHistory())
+	defer bs.Close()
+	waitState(t, bs, client.Connected)
+
+	token, err := admin.Token(ctx)
+	if err != nil {
+		t.Fatalf("Token: %v", err)
+	}
+	req, _ := http.NewRequest(http.MethodDelete, s.URL+"/api/admin/api-keys/"+strconv.FormatInt(keyID, 10), nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("revoke: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("revoke = %d, want 204", resp.StatusCode)
+	}
+
+	// Without rechecks, only the revocation itself can have closed it
+	st := waitState(t, bs, client.Closed)
+	if !errors.Is(st.Err, client.ErrKeyRejected) {
+		t.Fatalf("closed with %v, want ErrKeyRejected", st.Err)
+	}
+	if _, err := bot.Messages(ctx); !errors.Is(err, client.ErrKeyRejected) {
+		t.Fatalf("Messages with a revoked key = %v, want ErrKeyRejected", err)
+	}
+}
+
+func TestRevokedKeyRecheck(t *testing.T) {
+	s := newTestServerRechecking(t, 50*time.Millisecond)
+	ctx := context.Background()
+	bot, keyID := s.botKey(t, "ci")
+
+	bs := bot.Connect(ctx, client.WithoutHistory())
+	defer bs.Close()
+	waitState(t, bs, client.Connected)
+
+	// Revoked elsewhere, e.g. on another server sharing the database
+	if err := s.repo.RevokeAPIKey(ctx, keyID); err != nil {
+		t.Fatalf("RevokeAPIKey: %v", err)
+	}
+	st := waitState(t, bs, client.Closed)
+	if !errors.Is(st.Err, client.ErrKeyRejected) {
+		t.Fatalf("closed with %v, want ErrKeyRejected", st.Err)
+	}
+}
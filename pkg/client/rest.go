@@ -0,0 +1,147 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+
+	"li-chat/pkg/version"
+)
+
+// Message is a chat message as the server stores and broadcasts it
+type Message struct {
+	// ID increases with every stored message; pass the last one seen to
+	// MessagesAfter or WithResumeFrom to pick up where you left off
+	ID       int64  `json:"id,omitempty"`
+	Username string `json:"username"`
+	Content  string `json:"content"`
+	// CreatedAt is RFC 3339 in UTC
+	CreatedAt string `json:"created_at"`
+	// Bot marks a message from a bot account, such as an integration
+	Bot bool `json:"bot,omitempty"`
+	// Notice marks a reply only this user sees, such as a slash command's
+	// output. Notices have no ID and aren't stored.
+	Notice bool `json:"-"`
+}
+
+// ServerInfo identifies the server build, from GET /api/version and the
+// welcome frame of every WebSocket connection
+type ServerInfo = version.Info
+
+// ReloadResult lists which settings a config reload changed, and which
+// need a restart
+type ReloadResult struct {
+	Applied []string `json:"applied"`
+	Ignored []string `json:"ignored"`
+}
+
+// LogLevels are the server's default and per-package log levels
+type LogLevels struct {
+	Level    string            `json:"level"`
+	Packages map[string]string `json:"packages"`
+}
+
+// HistoryPageSize is the most messages one Messages or MessagesAfter call
+// returns
+const HistoryPageSize = 100
+
+type credentials struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// Register creates an account. It does not log in.
+func (c *Client) Register(ctx context.Context, username, password string) error {
+	return c.do(ctx, http.MethodPost, "/register", "", credentials{username, password}, nil)
+}
+
+// Login starts a session for username, replacing any previous one
+func (c *Client) Login(ctx context.Context, username, password string) error {
+	var resp struct {
+		Token        string `json:"token"`
+		RefreshToken string `json:"refresh_token"`
+	}
+	if err := c.do(ctx, http.MethodPost, "/login", "", credentials{username, password}, &resp); err != nil {
+		return err
+	}
+	c.setTokens(Tokens{Username: username, AccessToken: resp.Token, RefreshToken: resp.RefreshToken})
+	return nil
+}
+
+// Logout tells the server and forgets the tokens
+func (c *Client) Logout(ctx context.Context) error {
+	err := c.do(ctx, http.MethodPost, "/logout", c.Tokens().AccessToken, nil, nil)
+	c.setTokens(Tokens{})
+	return err
+}
+
+// WhoAmI returns the username the server associates with the access token
+func (c *Client) WhoAmI(ctx context.Context) (string, error) {
+	var resp struct {
+		Username string `json:"username"`
+	}
+	err := c.authorized(ctx, func(token string) error {
+		return c.do(ctx, http.MethodGet, "/whoami", token, nil, &resp)
+	})
+	return resp.Username, err
+}
+
+// Messages returns the newest messages, oldest first
+func (c *Client) Messages(ctx context.Context) ([]Message, error) {
+	return c.messages(ctx, "/messages")
+}
+
+// MessagesAfter returns up to HistoryPageSize messages that followed the
+// one with ID afterID, oldest first. A full page means there may be more.
+func (c *Client) MessagesAfter(ctx context.Context, afterID int64) ([]Message, error) {
+	return c.messages(ctx, "/messages?after="+strconv.FormatInt(afterID, 10))
+}
+
+func (c *Client) messages(ctx context.Context, path string) ([]Message, error) {
+	var messages []Message
+	err := c.authorized(ctx, func(token string) error {
+		return c.do(ctx, http.MethodGet, path, token, nil, &messages)
+	})
+	return messages, err
+}
+
+// Version reports the server build; it needs no login
+func (c *Client) Version(ctx context.Context) (ServerInfo, error) {
+	var info ServerInfo
+	err := c.do(ctx, http.MethodGet, "/api/version", "", nil, &info)
+	return info, err
+}
+
+// Ready reports nil when the server's readiness probe passes
+func (c *Client) Ready(ctx context.Context) error {
+	return c.do(ctx, http.MethodGet, "/readyz", "", nil, nil)
+}
+
+// Reload makes the server re-read its configuration. Admin only.
+func (c *Client) Reload(ctx context.Context) (ReloadResult, error) {
+	var result ReloadResult
+	err := c.authorized(ctx, func(token string) error {
+		return c.do(ctx, http.MethodPost, "/api/admin/reload", token, nil, &result)
+	})
+	return result, err
+}
+
+// LogLevels returns the server's log levels. Admin only.
+func (c *Client) LogLevels(ctx context.Context) (LogLevels, error) {
+	var levels LogLevels
+	err := c.authorized(ctx, func(token string) error {
+		return c.do(ctx, http.MethodGet, "/api/admin/log-level", token, nil, &levels)
+	})
+	return levels, err
+}
+
+// SetLogLevel changes the default level, or one package's when pkg is not
+// empty; an empty level then removes the override. Admin only.
+func (c *Client) SetLogLevel(ctx context.Context, pkg, level string) (LogLevels, error) {
+	var levels LogLevels
+	body := map[string]string{"package": pkg, "level": level}
+	err := c.authorized(ctx, func(token string) error {
+		return c.do(ctx, http.MethodPut, "/api/admin/log-level", token, body, &levels)
+	})
+	return levels, err
+}
@@ -0,0 +1,552 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// ErrDisabled means the server refused the connection because the account
+// is disabled; reconnecting won't help
+var ErrDisabled = errors.New("account disabled")
+
+const (
+	// readTimeout must exceed the server's ping interval; each ping
+	// extends it
+	readTimeout = 90 * time.Second
+	writeWait   = 10 * time.Second
+	// renewLead is how long before the connection's access token expires
+	// the session renews it, unless the server's token.expiring frame
+	// prompted it sooner
+	renewLead = time.Minute
+)
+
+// Close codes the server ends a connection with besides the standard ones
+const (
+	// closeTokenExpired: the access token lapsed without a renewal
+	closeTokenExpired = 4001
+	// closeKeyRevoked: the API key was revoked
+	closeKeyRevoked = 4002
+	// closeAccountDisabled: the account was disabled
+	closeAccountDisabled = 4003
+)
+
+// ConnState is where a Session is in its connect/reconnect cycle
+type ConnState int
+
+const (
+	Connecting ConnState = iota
+	Connected
+	// Reconnecting is the wait before the next attempt; State.RetryAt says
+	// until when
+	Reconnecting
+	// Closed is final: Close was called, the context ended, or State.Err
+	// can't be fixed by reconnecting
+	Closed
+)
+
+func (s ConnState) String() string {
+	switch s {
+	case Connecting:
+		return "connecting"
+	case Connected:
+		return "connected"
+	case Reconnecting:
+		return "reconnecting"
+	case Closed:
+		return "closed"
+	}
+	return fmt.Sprintf("ConnState(%d)", int(s))
+}
+
+// State is a change in the connection
+type State struct {
+	State ConnState
+	// Server is the build from the latest welcome frame, nil until the
+	// first one arrives
+	Server *ServerInfo
+	// RetryAt is when the next attempt starts while Reconnecting
+	RetryAt time.Time
+	// Err is why the connection dropped, or for Closed why it gave up
+	Err error
+}
+
+// SessionOption configures a Session
+type SessionOption func(*Session)
+
+// WithResumeFrom makes the first connection load every message after
+// lastID, a page at a time, instead of only the newest page
+func WithResumeFrom(lastID int64) SessionOption {
+	return func(s *Session) { s.lastID = lastID }
+}
+
+// WithoutHistory skips loading history on the first connection; later
+// reconnects still fill the gap
+func WithoutHistory() SessionOption {
+	return func(s *Session) { s.skipHistory = true }
+}
+
+// WithBackoff sets the reconnect delay, which doubles from min up to max
+// with jitter. The default is 1s to 30s.
+func WithBackoff(min, max time.Duration) SessionOption {
+	return func(s *Session) { s.backoffMin, s.backoffMax = min, max }
+}
+
+// Session is a WebSocket connection that reconnects by itself, catching up
+// on messages it missed while it was down, and queues outgoing messages
+// while offline
+type Session struct {
+	c      *Client
+	cancel context.CancelFunc
+	done   chan struct{}
+
+	messages chan Message
+	states   chan State
+
+	backoffMin, backoffMax time.Duration
+	skipHistory            bool
+
+	mu     sync.Mutex
+	outbox []string
+	lastID int64
+	err    error
+	wake   chan struct{}
+	// token is the access token the connection is authorized with, empty
+	// for API keys, which don't expire
+	token string
+
+	// Only touched by run
+	server *ServerInfo
+	// caughtUp holds the IDs the latest catch-up delivered, so the same
+	// messages arriving live on the new connection aren't repeated
+	caughtUp map[int64]bool
+}
+
+// Connect starts a Session in the background. It runs until Close is
+// called, ctx ends, or the server rejects the user for good.
+func (c *Client) Connect(ctx context.Context, opts ...SessionOption) *Session {
+	ctx, cancel := context.WithCancel(ctx)
+	s := &Session{
+		c:          c,
+		cancel:     cancel,
+		done:       make(chan struct{}),
+		messages:   make(chan Message, HistoryPageSize),
+		states:     make(chan State, 1),
+		backoffMin: time.Second,
+		backoffMax: 30 * time.Second,
+		wake:       make(chan struct{}, 1),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	go s.run(ctx)
+	return s
+}
+
+// Messages delivers history and live messages in order. Nothing is
+// dropped: the session stops reading from the server until they are
+// received. It is closed when the session ends.
+func (s *Session) Messages() <-chan Message { return s.messages }
+
+// States delivers connection changes. Only the latest is kept if they
+// aren't received in time. It is closed after the Closed state.
+func (s *Session) States() <-chan State { return s.states }
+
+// Send queues content; it goes out as soon as a connection is up
+func (s *Session) Send(content string) {
+	s.mu.Lock()
+	s.outbox = append(s.outbox, content)
+	s.mu.Unlock()
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Queued reports how many messages are waiting for a connection
+func (s *Session) Queued() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.outbox)
+}
+
+// LastID is the highest message ID delivered so far; save it to resume
+// a later session with WithResumeFrom
+func (s *Session) LastID() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.lastID
+}
+
+// Close ends the session and waits for it to stop
+func (s *Session) Close() {
+	s.cancel()
+	<-s.done
+}
+
+// Done is closed when the session has stopped
+func (s *Session) Done() <-chan struct{} { return s.done }
+
+// Err is why the session stopped on its own, or nil after Close
+func (s *Session) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+func (s *Session) run(ctx context.Context) {
+	err := s.loop(ctx)
+	if ctx.Err() != nil {
+		err = nil
+	}
+	s.mu.Lock()
+	s.err = err
+	s.mu.Unlock()
+
+	s.setState(State{State: Closed, Server: s.server, Err: err})
+	close(s.states)
+	close(s.messages)
+	close(s.done)
+}
+
+// loop connects and reconnects until ctx ends or an error that retrying
+// can't fix
+func (s *Session) loop(ctx context.Context) error {
+	attempt := 0
+	for ctx.Err() == nil {
+		s.setState(State{State: Connecting, Server: s.server})
+		ws, token, err := s.dial(ctx)
+		if err == nil {
+			attempt = 0
+			s.setState(State{State: Connected, Server: s.server})
+			err = s.session(ctx, ws, token)
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if errors.Is(err, ErrSessionExpired) || errors.Is(err, ErrKeyRejected) || errors.Is(err, ErrDisabled) {
+			return err
+		}
+
+		delay := s.backoff(attempt)
+		attempt++
+		// A restarting server is usually back within a second, and an
+		// expired token only needs a fresh one
+		var closeErr *websocket.CloseError
+		if errors.As(err, &closeErr) && (closeErr.Code == websocket.CloseServiceRestart || closeErr.Code == closeTokenExpired) {
+			delay = s.backoffMin
+		}
+		s.setState(State{State: Reconnecting, Server: s.server, RetryAt: time.Now().Add(delay), Err: err})
+
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return ctx.Err()
+}
+
+// setState replaces any state the receiver hasn't picked up yet. Only run
+// sends, so the send never blocks.
+func (s *Session) setState(st State) {
+	select {
+	case <-s.states:
+	default:
+	}
+	s.states <- st
+}
+
+// backoff doubles from backoffMin up to backoffMax, with jitter so a
+// restarted server isn't hit by every client at once
+func (s *Session) backoff(attempt int) time.Duration {
+	d := s.backoffMax
+	if attempt < 16 && s.backoffMin<<attempt < s.backoffMax {
+		d = s.backoffMin << attempt
+	}
+	return d/2 + rand.N(d/2+1)
+}
+
+// dial connects and returns the token the connection is authorized with
+func (s *Session) dial(ctx context.Context) (*websocket.Conn, string, error) {
+	var ws *websocket.Conn
+	var used string
+	err := s.c.authorized(ctx, func(token string) error {
+		used = token
+		ticket, err := s.c.wsTicket(ctx, token)
+		if statusCode(err) == http.StatusForbidden {
+			return ErrDisabled
+		}
+		if err != nil {
+			return err
+		}
+		var resp *http.Response
+		ws, resp, err = s.c.dialer.DialContext(ctx, s.c.wsURL(ticket), nil)
+		if resp == nil || resp.StatusCode == http.StatusSwitchingProtocols {
+			return err
+		}
+		if resp.StatusCode == http.StatusForbidden {
+			return ErrDisabled
+		}
+		return &APIError{StatusCode: resp.StatusCode, Message: err.Error()}
+	})
+	return ws, used, err
+}
+
+// session catches up on missed history, then relays frames until the
+// connection drops. The server ends the connection when token lapses, so
+// the session renews it in-band beforehand.
+func (s *Session) session(ctx context.Context, ws *websocket.Conn, token string) error {
+	defer ws.Close()
+
+	// Unblock reads and deliveries when the session is closed
+	stop := context.AfterFunc(ctx, func() { ws.Close() })
+	defer stop()
+
+	if err := s.catchUp(ctx); err != nil {
+		return err
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	tokens := make(chan string, 1)
+	go s.writer(ws, done, tokens)
+
+	// The timer stands in for a token.expiring frame that never came
+	var renew *time.Timer
+	if expiresAt := tokenExpiry(token); s.c.apiKey == "" && !expiresAt.IsZero() {
+		s.mu.Lock()
+		s.token = token
+		s.mu.Unlock()
+		renew = time.AfterFunc(renewDelay(expiresAt), func() { s.renewToken(ctx, tokens) })
+		defer renew.Stop()
+	}
+
+	ws.SetReadDeadline(time.Now().Add(readTimeout))
+	ws.SetPingHandler(func(data string) error {
+		ws.SetReadDeadline(time.Now().Add(readTimeout))
+		return ws.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(writeWait))
+	})
+
+	for {
+		_, data, err := ws.ReadMessage()
+		if websocket.IsCloseError(err, closeAccountDisabled) {
+			return ErrDisabled
+		}
+		if websocket.IsCloseError(err, closeKeyRevoked) {
+			return ErrKeyRejected
+		}
+		if err != nil {
+			return err
+		}
+		var f struct {
+			Type   string      `json:"type"`
+			Server *ServerInfo `json:"server"`
+			// ExpiresAt is set on token frames
+			ExpiresAt time.Time `json:"expires_at"`
+			Message
+		}
+		if err := json.Unmarshal(data, &f); err != nil {
+			continue
+		}
+		switch {
+		case f.Type == "welcome" && f.Server != nil:
+			s.server = f.Server
+			s.setState(State{State: Connected, Server: s.server})
+		case f.Type == "token.expiring" && renew != nil:
+			go s.renewToken(ctx, tokens)
+		case f.Type == "token.refreshed" && renew != nil:
+			renew.Reset(renewDelay(f.ExpiresAt))
+		case f.Type == "notice":
+			if err := s.deliver(ctx, Message{Content: f.Content, CreatedAt: f.CreatedAt, Notice: true}); err != nil {
+				return err
+			}
+		case f.Type == "" && f.Content != "":
+			if s.caughtUp[f.ID] {
+				continue
+			}
+			if err := s.deliver(ctx, f.Message); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// catchUp loads the newest history on the first connection and everything
+// after lastID on later ones, a page at a time
+func (s *Session) catchUp(ctx context.Context) error {
+	s.caughtUp = map[int64]bool{}
+	lastID := s.LastID()
+	if lastID == 0 && s.skipHistory {
+		s.skipHistory = false
+		return nil
+	}
+	for {
+		var messages []Message
+		var err error
+		if lastID == 0 {
+			messages, err = s.c.Messages(ctx)
+		} else {
+			messages, err = s.c.MessagesAfter(ctx, lastID)
+		}
+		if err != nil {
+			return fmt.Errorf("load history: %w", err)
+		}
+		for _, m := range messages {
+			s.caughtUp[m.ID] = true
+			if err := s.deliver(ctx, m); err != nil {
+				return err
+			}
+		}
+		// The first load is only the newest page; resumes page until
+		// caught up
+		if lastID == 0 || len(messages) < HistoryPageSize {
+			return nil
+		}
+		lastID = messages[len(messages)-1].ID
+	}
+}
+
+// renewToken gets a fresh access token for the connection and queues it
+// for the writer. If that fails, the server closes the connection once the
+// token lapses and reconnecting reports why.
+func (s *Session) renewToken(ctx context.Context, tokens chan<- string) {
+	s.mu.Lock()
+	stale := s.token
+	s.mu.Unlock()
+	token, err := s.c.refresh(ctx, stale)
+	if err != nil {
+		return
+	}
+	select {
+	case tokens <- token:
+	default:
+		// A renewal is already waiting to be sent
+	}
+}
+
+// renewDelay is how long to wait before renewing a token that expires at
+// expiresAt: until renewLead before, or a quarter of the time left for
+// short-lived tokens
+func renewDelay(expiresAt time.Time) time.Duration {
+	left := time.Until(expiresAt)
+	return left - min(renewLead, left/4)
+}
+
+// deliver hands m to the receiver, waiting as long as it takes
+func (s *Session) deliver(ctx context.Context, m Message) error {
+	select {
+	case s.messages <- m:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	s.mu.Lock()
+	s.lastID = max(s.lastID, m.ID)
+	s.mu.Unlock()
+	return nil
+}
+
+// writer sends queued messages, and renewed tokens from tokens, until the
+// session ends. A message is only dropped from the queue once it was
+// written.
+func (s *Session) writer(ws *websocket.Conn, done <-chan struct{}, tokens <-chan string) {
+	username := s.c.Username()
+	// write reports whether the connection is still usable; if not, the
+	// read loop sees it broken and reconnects
+	write := func(v any) bool {
+		data, _ := json.Marshal(v)
+		ws.SetWriteDeadline(time.Now().Add(writeWait))
+		return ws.WriteMessage(websocket.TextMessage, data) == nil
+	}
+	refresh := func(token string) bool {
+		if !write(map[string]string{"type": "token.refresh", "access_token": token}) {
+			return false
+		}
+		s.mu.Lock()
+		s.token = token
+		s.mu.Unlock()
+		return true
+	}
+
+	for {
+		select {
+		case token := <-tokens:
+			if !refresh(token) {
+				return
+			}
+			continue
+		default:
+		}
+
+		s.mu.Lock()
+		var next string
+		pending := len(s.outbox) > 0
+		if pending {
+			next = s.outbox[0]
+		}
+		s.mu.Unlock()
+
+		if !pending {
+			select {
+			case <-s.wake:
+				continue
+			case token := <-tokens:
+				if !refresh(token) {
+					return
+				}
+				continue
+			case <-done:
+				return
+			}
+		}
+
+		if !write(map[string]string{"username": username, "content": next}) {
+			return
+		}
+		s.mu.Lock()
+		s.outbox = s.outbox[1:]
+		s.mu.Unlock()
+	}
+}
+
+// newDialer makes WebSocket connections the way hc makes requests: through
+// the same proxy and with the same TLS settings
+func newDialer(hc *http.Client) *websocket.Dialer {
+	d := &websocket.Dialer{
+		Proxy:            http.ProxyFromEnvironment,
+		HandshakeTimeout: 15 * time.Second,
+	}
+	if t, ok := hc.Transport.(*http.Transport); ok {
+		d.Proxy = t.Proxy
+		d.NetDialContext = t.DialContext
+		d.TLSClientConfig = t.TLSClientConfig
+	}
+	return d
+}
+
+// wsTicket asks for a single-use ticket to connect with, so the token
+// itself never appears in a URL
+func (c *Client) wsTicket(ctx context.Context, token string) (string, error) {
+	var resp struct {
+		Ticket string `json:"ticket"`
+	}
+	err := c.do(ctx, http.MethodPost, "/api/ws-ticket", token, nil, &resp)
+	return resp.Ticket, err
+}
+
+// wsURL is the WebSocket endpoint for ticket
+func (c *Client) wsURL(ticket string) string {
+	u := *c.base
+	u.Scheme = map[string]string{"http": "ws", "https": "wss"}[u.Scheme]
+	u.Path += "/ws"
+	u.RawQuery = url.Values{"ticket": {ticket}}.Encode()
+	return u.String()
+}
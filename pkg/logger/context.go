@@ -0,0 +1,97 @@
+package logger
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+type contextKey struct{}
+
+// WithContext returns a copy of ctx that carries fields in addition to any
+// it already had. The *Context logging calls add them to every line, so a
+// request ID attached once by middleware shows up in handler and
+// repository logs alike.
+func WithContext(ctx context.Context, fields ...zap.Field) context.Context {
+	existing := ContextFields(ctx)
+	merged := make([]zap.Field, 0, len(existing)+len(fields))
+	merged = append(merged, existing...)
+	merged = append(merged, fields...)
+	return context.WithValue(ctx, contextKey{}, merged)
+}
+
+// ContextFields returns the fields attached to ctx, if any
+func ContextFields(ctx context.Context) []zap.Field {
+	if ctx == nil {
+		return nil
+	}
+	fields, _ := ctx.Value(contextKey{}).([]zap.Field)
+	return fields
+}
+
+// withContextFields prepends the context fields, and the trace and span
+// IDs of any active span, to fields
+func withContextFields(ctx context.Context, fields []zap.Field) []zap.Field {
+	existing := ContextFields(ctx)
+	span := trace.SpanContextFromContext(ctx)
+	if len(existing) == 0 && !span.IsValid() {
+		return fields
+	}
+	merged := make([]zap.Field, 0, len(existing)+len(fields)+2)
+	merged = append(merged, existing...)
+	if span.IsValid() {
+		merged = append(merged,
+			zap.String("trace_id", span.TraceID().String()),
+			zap.String("span_id", span.SpanID().String()))
+	}
+	return append(merged, fields...)
+}
+
+func DebugContext(ctx context.Context, msg string, fields ...zap.Field) {
+	if out := current.Load(); out != nil {
+		out.root.Debug(msg, withContextFields(ctx, fields)...)
+	}
+}
+
+func InfoContext(ctx context.Context, msg string, fields ...zap.Field) {
+	if out := current.Load(); out != nil {
+		out.root.Info(msg, withContextFields(ctx, fields)...)
+	}
+}
+
+func WarnContext(ctx context.Context, msg string, fields ...zap.Field) {
+	if out := current.Load(); out != nil {
+		out.root.Warn(msg, withContextFields(ctx, fields)...)
+	}
+}
+
+func ErrorContext(ctx context.Context, msg string, fields ...zap.Field) {
+	if out := current.Load(); out != nil {
+		out.root.Error(msg, withContextFields(ctx, fields)...)
+	}
+}
+
+func (l *Logger) DebugContext(ctx context.Context, msg string, fields ...zap.Field) {
+	if zl := l.logger(); zl != nil {
+		zl.Debug(msg, withContextFields(ctx, fields)...)
+	}
+}
+
+func (l *Logger) InfoContext(ctx context.Context, msg string, fields ...zap.Field) {
+	if zl := l.logger(); zl != nil {
+		zl.Info(msg, withContextFields(ctx, fields)...)
+	}
+}
+
+func (l *Logger) WarnContext(ctx context.Context, msg string, fields ...zap.Field) {
+	if zl := l.logger(); zl != nil {
+		zl.Warn(msg, withContextFields(ctx, fields)...)
+	}
+}
+
+func (l *Logger) ErrorContext(ctx context.Context, msg string, fields ...zap.Field) {
+	if zl := l.logger(); zl != nil {
+		zl.Error(msg, withContextFields(ctx, fields)...)
+	}
+}
@@ -2,13 +2,31 @@ package logger
 
 import (
 	"os"
+	"sync"
+	"sync/atomic"
 
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 	"gopkg.in/natefinch/lumberjack.v2"
 )
 
-var log *zap.Logger
+// output is everything Init builds; package loggers derive their cores from it
+type output struct {
+	encoders []zapcore.Encoder
+	sinks    []zapcore.WriteSyncer
+	redact   *redactor
+	root     *zap.Logger
+}
+
+var (
+	current atomic.Pointer[output]
+	// level is the default minimum level for all output
+	level = zap.NewAtomicLevelAt(zapcore.InfoLevel)
+
+	// packageLevels overrides level for named loggers
+	packageMu     sync.RWMutex
+	packageLevels = map[string]zapcore.Level{}
+)
 
 type Config struct {
 	Level      string
@@ -17,6 +35,16 @@ type Config struct {
 	MaxBackups int
 	MaxAgeDays int
 	Compress   bool
+	// Format is the stdout encoding: "json" (default) or "console" for
+	// coloured, human-readable lines during development. The file is
+	// always JSON.
+	Format string
+	// Packages maps logger names to their own minimum level
+	Packages map[string]string
+	// RedactKeys are field keys masked in addition to DefaultRedactKeys
+	RedactKeys []string
+	// LogContent records chat text verbatim in Content fields
+	LogContent bool
 }
 
 func Init(cfg Config) {
@@ -33,71 +61,223 @@ func Init(cfg Config) {
 	encoderCfg.EncodeTime = zapcore.ISO8601TimeEncoder
 	encoderCfg.EncodeLevel = zapcore.LowercaseLevelEncoder
 
-	level := zapcore.InfoLevel
-	_ = level.Set(cfg.Level)
+	stdoutEncoder := zapcore.NewJSONEncoder(encoderCfg)
+	if cfg.Format == "console" {
+		consoleCfg := encoderCfg
+		consoleCfg.EncodeLevel = zapcore.CapitalColorLevelEncoder
+		consoleCfg.EncodeTime = zapcore.TimeEncoderOfLayout("15:04:05.000")
+		stdoutEncoder = zapcore.NewConsoleEncoder(consoleCfg)
+	}
 
-	core := zapcore.NewCore(
-		zapcore.NewJSONEncoder(encoderCfg),
-		zapcore.NewMultiWriteSyncer(
-			writer,
-			zapcore.AddSync(os.Stdout),
-		),
-		level,
-	)
+	_ = SetLevel(cfg.Level)
+	_ = SetPackageLevels(cfg.Packages)
+	SetContentLogging(cfg.LogContent)
 
-	log = zap.New(
-		core,
+	out := &output{
+		encoders: []zapcore.Encoder{zapcore.NewJSONEncoder(encoderCfg), stdoutEncoder},
+		sinks:    []zapcore.WriteSyncer{writer, zapcore.AddSync(os.Stdout)},
+		redact:   newRedactor(cfg.RedactKeys),
+	}
+	out.root = out.build("", level)
+	current.Store(out)
+}
+
+// build creates a logger whose cores share the configured sinks but filter
+// with the given level
+func (o *output) build(name string, enabler zapcore.LevelEnabler) *zap.Logger {
+	cores := make([]zapcore.Core, len(o.sinks))
+	for i := range o.sinks {
+		cores[i] = zapcore.NewCore(o.encoders[i], o.sinks[i], enabler)
+	}
+
+	l := zap.New(
+		redactCore{Core: zapcore.NewTee(cores...), r: o.redact},
 		zap.AddCaller(),
-		zap.AddCallerSkip(2), // IMPORTANT for correct caller
+		zap.AddCallerSkip(1), // report the caller of the wrappers below
 		zap.AddStacktrace(zapcore.ErrorLevel),
 	)
+	if name != "" {
+		l = l.Named(name)
+	}
+	return l
+}
+
+// SetLevel changes the default minimum level at runtime
+func SetLevel(name string) error {
+	l, err := zapcore.ParseLevel(name)
+	if err != nil {
+		return err
+	}
+	level.SetLevel(l)
+	return nil
+}
+
+// Level reports the default minimum level
+func Level() string {
+	return level.Level().String()
+}
+
+// SetPackageLevel overrides the level of one named logger. An empty level
+// removes the override so the logger follows the default again.
+func SetPackageLevel(pkg, name string) error {
+	packageMu.Lock()
+	defer packageMu.Unlock()
+
+	if name == "" {
+		delete(packageLevels, pkg)
+		return nil
+	}
+	l, err := zapcore.ParseLevel(name)
+	if err != nil {
+		return err
+	}
+	packageLevels[pkg] = l
+	return nil
+}
+
+// SetPackageLevels replaces every override at once
+func SetPackageLevels(levels map[string]string) error {
+	parsed := make(map[string]zapcore.Level, len(levels))
+	for pkg, name := range levels {
+		l, err := zapcore.ParseLevel(name)
+		if err != nil {
+			return err
+		}
+		parsed[pkg] = l
+	}
+
+	packageMu.Lock()
+	packageLevels = parsed
+	packageMu.Unlock()
+	return nil
+}
+
+// PackageLevels returns a copy of the overrides
+func PackageLevels() map[string]string {
+	packageMu.RLock()
+	defer packageMu.RUnlock()
+
+	out := make(map[string]string, len(packageLevels))
+	for pkg, l := range packageLevels {
+		out[pkg] = l.String()
+	}
+	return out
 }
 
 func Sync() {
-	if log != nil {
-		_ = log.Sync()
+	if out := current.Load(); out != nil {
+		_ = out.root.Sync()
 	}
 }
 
 // Structured logging methods for production-grade logging
 func Debug(msg string, fields ...zap.Field) {
-	if log == nil {
-		return
+	if out := current.Load(); out != nil {
+		out.root.Debug(msg, fields...)
 	}
-	log.Debug(msg, fields...)
 }
 
 func Info(msg string, fields ...zap.Field) {
-	if log == nil {
-		return
+	if out := current.Load(); out != nil {
+		out.root.Info(msg, fields...)
 	}
-	log.Info(msg, fields...)
 }
 
 func Warn(msg string, fields ...zap.Field) {
-	if log == nil {
-		return
+	if out := current.Load(); out != nil {
+		out.root.Warn(msg, fields...)
 	}
-	log.Warn(msg, fields...)
 }
 
 func Error(msg string, fields ...zap.Field) {
-	if log == nil {
-		return
+	if out := current.Load(); out != nil {
+		out.root.Error(msg, fields...)
 	}
-	log.Error(msg, fields...)
 }
 
 func Panic(msg string, fields ...zap.Field) {
-	if log == nil {
-		return
+	if out := current.Load(); out != nil {
+		out.root.Panic(msg, fields...)
 	}
-	log.Panic(msg, fields...)
 }
 
 func Fatal(msg string, fields ...zap.Field) {
-	if log == nil {
-		return
+	if out := current.Load(); out != nil {
+		out.root.Fatal(msg, fields...)
+	}
+}
+
+// Logger is a named logger for one package. Its level is the package
+// override when one is set, otherwise the default level, and both can be
+// changed while the process runs.
+//
+// Declare it once per package; it may be created before Init:
+//
+//	var log = logger.Named("websocket")
+type Logger struct {
+	name  string
+	cache atomic.Pointer[namedCache]
+}
+
+type namedCache struct {
+	out *output
+	zl  *zap.Logger
+}
+
+func Named(name string) *Logger {
+	return &Logger{name: name}
+}
+
+// Enabled reports whether lvl would be written. Use it to skip building
+// fields on hot paths.
+func (l *Logger) Enabled(lvl zapcore.Level) bool {
+	return l.enabled(lvl)
+}
+
+func (l *Logger) enabled(lvl zapcore.Level) bool {
+	packageMu.RLock()
+	override, ok := packageLevels[l.name]
+	packageMu.RUnlock()
+	if ok {
+		return lvl >= override
+	}
+	return level.Enabled(lvl)
+}
+
+// logger returns the underlying logger, rebuilding it if Init ran again
+func (l *Logger) logger() *zap.Logger {
+	out := current.Load()
+	if out == nil {
+		return nil
+	}
+	if c := l.cache.Load(); c != nil && c.out == out {
+		return c.zl
+	}
+	zl := out.build(l.name, zap.LevelEnablerFunc(l.enabled))
+	l.cache.Store(&namedCache{out: out, zl: zl})
+	return zl
+}
+
+func (l *Logger) Debug(msg string, fields ...zap.Field) {
+	if zl := l.logger(); zl != nil {
+		zl.Debug(msg, fields...)
+	}
+}
+
+func (l *Logger) Info(msg string, fields ...zap.Field) {
+	if zl := l.logger(); zl != nil {
+		zl.Info(msg, fields...)
+	}
+}
+
+func (l *Logger) Warn(msg string, fields ...zap.Field) {
+	if zl := l.logger(); zl != nil {
+		zl.Warn(msg, fields...)
+	}
+}
+
+func (l *Logger) Error(msg string, fields ...zap.Field) {
+	if zl := l.logger(); zl != nil {
+		zl.Error(msg, fields...)
 	}
-	log.Fatal(msg, fields...)
 }
@@ -0,0 +1,150 @@
+package logger
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync/atomic"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// Redacted replaces every masked value
+const Redacted = "[REDACTED]"
+
+// DefaultRedactKeys are always masked, whatever Config.RedactKeys adds
+var DefaultRedactKeys = []string{
+	"password", "password_hash", "token", "access_token", "refresh_token",
+	"authorization", "cookie", "set-cookie", "secret", "api_key",
+}
+
+// tokenPattern matches credentials anywhere in a string
+var tokenPattern = regexp.MustCompile(strings.Join([]string{
+	// JWTs and bearer credentials
+	`eyJ[A-Za-z0-9_-]{4,}\.[A-Za-z0-9_-]{4,}\.[A-Za-z0-9_-]{4,}`,
+	`(?i:bearer)\s+[A-Za-z0-9._~+/=-]+`,
+	// API keys; their short public prefix, e.g. lck_ABCD, stays readable
+	`lck_[A-Za-z0-9]{16,}`,
+	// Session IDs, the value of the session cookie
+	`sess_[A-Za-z0-9]{16,}`,
+	// WebSocket tickets, which travel in the URL as ?ticket=
+	`wst_[A-Za-z0-9]{16,}`,
+	// Incoming webhook tokens, the last segment of a /hooks/ URL
+	`whk_[A-Za-z0-9]{16,}`,
+}, "|"))
+
+// logContent lets Content write message text verbatim
+var logContent atomic.Bool
+
+// SetContentLogging switches full chat content in logs on or off. Keep it
+// off outside local debugging; chat messages are user data.
+func SetContentLogging(enabled bool) {
+	logContent.Store(enabled)
+}
+
+// Content logs chat text as a short SHA-256 prefix and its length, so the
+// same message can be correlated across lines without recording it. With
+// content logging enabled the text itself is included too.
+func Content(key, text string) zap.Field {
+	sum := sha256.Sum256([]byte(text))
+	verbatim := logContent.Load()
+	return zap.Object(key, zapcore.ObjectMarshalerFunc(func(enc zapcore.ObjectEncoder) error {
+		enc.AddString("sha256", hex.EncodeToString(sum[:6]))
+		enc.AddInt("length", len(text))
+		if verbatim {
+			enc.AddString("text", text)
+		}
+		return nil
+	}))
+}
+
+// redactor masks sensitive fields before they reach an encoder
+type redactor struct {
+	keys map[string]bool
+}
+
+func newRedactor(extra []string) *redactor {
+	r := &redactor{keys: make(map[string]bool)}
+	for _, k := range append(DefaultRedactKeys, extra...) {
+		r.keys[strings.ToLower(k)] = true
+	}
+	return r
+}
+
+// fields returns fields with secrets masked, copying only when needed.
+// Only string, error and fmt.Stringer values are inspected; anything
+// marshalled by zap.Object, or zap.Any of a struct, map or slice, is
+// written as is, so log credentials as top-level fields or not at all.
+func (r *redactor) fields(fields []zap.Field) []zap.Field {
+	var out []zap.Field
+	for i, f := range fields {
+		masked, changed := r.field(f)
+		if !changed {
+			if out != nil {
+				out = append(out, f)
+			}
+			continue
+		}
+		if out == nil {
+			out = make([]zap.Field, i, len(fields))
+			copy(out, fields[:i])
+		}
+		out = append(out, masked)
+	}
+	if out == nil {
+		return fields
+	}
+	return out
+}
+
+func (r *redactor) field(f zap.Field) (zap.Field, bool) {
+	if r.keys[strings.ToLower(f.Key)] {
+		return zap.String(f.Key, Redacted), true
+	}
+	switch f.Type {
+	case zapcore.StringType:
+		if tokenPattern.MatchString(f.String) {
+			return zap.String(f.Key, redactString(f.String)), true
+		}
+	case zapcore.ErrorType:
+		if err, ok := f.Interface.(error); ok && tokenPattern.MatchString(err.Error()) {
+			return zap.String(f.Key, redactString(err.Error())), true
+		}
+	case zapcore.StringerType:
+		if s, ok := f.Interface.(fmt.Stringer); ok {
+			if str := s.String(); tokenPattern.MatchString(str) {
+				return zap.String(f.Key, redactString(str)), true
+			}
+		}
+	}
+	return f, false
+}
+
+func redactString(s string) string {
+	return tokenPattern.ReplaceAllString(s, Redacted)
+}
+
+// redactCore applies a redactor to everything written through it
+type redactCore struct {
+	zapcore.Core
+	r *redactor
+}
+
+func (c redactCore) With(fields []zap.Field) zapcore.Core {
+	return redactCore{Core: c.Core.With(c.r.fields(fields)), r: c.r}
+}
+
+func (c redactCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(ent.Level) {
+		return ce.AddCore(ent, c)
+	}
+	return ce
+}
+
+func (c redactCore) Write(ent zapcore.Entry, fields []zap.Field) error {
+	ent.Message = redactString(ent.Message)
+	return c.Core.Write(ent, c.r.fields(fields))
+}
@@ -1 +1,91 @@
+// Package version reports which build of li-chat is running. Release
+// builds set the variables with the linker:
+//
+//	go build -ldflags "-X li-chat/pkg/version.Version=v1.4.0 \
+//	  -X li-chat/pkg/version.Commit=$(git rev-parse HEAD) \
+//	  -X li-chat/pkg/version.BuildDate=$(date -u +%Y-%m-%dT%H:%M:%SZ)" ./cmd/server
+//
+// Anything left empty is filled from the module and VCS data the Go
+// toolchain embeds, so plain `go build` and `go install` still report
+// something useful.
 package version
+
+import (
+	"runtime"
+	"runtime/debug"
+	"sync"
+)
+
+// Set via -ldflags -X
+var (
+	Version   string
+	Commit    string
+	BuildDate string
+)
+
+// Info describes the running binary
+type Info struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	BuildDate string `json:"build_date"`
+	GoVersion string `json:"go_version"`
+}
+
+var (
+	once sync.Once
+	info Info
+)
+
+// Get returns the build information, resolving fallbacks once
+func Get() Info {
+	once.Do(func() {
+		info = Info{
+			Version:   Version,
+			Commit:    Commit,
+			BuildDate: BuildDate,
+			GoVersion: runtime.Version(),
+		}
+
+		if bi, ok := debug.ReadBuildInfo(); ok {
+			if info.Version == "" && bi.Main.Version != "" && bi.Main.Version != "(devel)" {
+				info.Version = bi.Main.Version
+			}
+			var modified bool
+			for _, s := range bi.Settings {
+				switch s.Key {
+				case "vcs.revision":
+					if info.Commit == "" {
+						info.Commit = s.Value
+					}
+				case "vcs.time":
+					// The commit time, the closest thing to a build date
+					// the toolchain records
+					if info.BuildDate == "" {
+						info.BuildDate = s.Value
+					}
+				case "vcs.modified":
+					modified = s.Value == "true"
+				}
+			}
+			if modified && info.Commit != "" && Commit == "" {
+				info.Commit += "-dirty"
+			}
+		}
+
+		if info.Version == "" {
+			info.Version = "dev"
+		}
+		if info.Commit == "" {
+			info.Commit = "unknown"
+		}
+		if info.BuildDate == "" {
+			info.BuildDate = "unknown"
+		}
+	})
+	return info
+}
+
+// String formats the information for `li-chat version`
+func (i Info) String() string {
+	return "li-chat " + i.Version + " (commit " + i.Commit + ", built " + i.BuildDate + ", " + i.GoVersion + ")"
+}